@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/pldubouilh/gossa"
+)
+
+func main() {
+	cfg := gossa.DefaultConfig()
+	cfg.Flags(flag.CommandLine)
+	if flag.Parse(); len(flag.Args()) == 1 {
+		cfg.Root = flag.Args()[0]
+	} else if len(flag.Args()) == 0 && (cfg.S3Backend != "" || cfg.DAVBackend != "" || cfg.Mounts != "" || cfg.Tmpfs) {
+		// the bucket, remote folder, mounts or memory are shared
+	} else if len(flag.Args()) > 1 && gossa.IsClientCommand(flag.Arg(0)) {
+		if err := gossa.Client(cfg, flag.Args(), os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	} else {
+		fmt.Printf("\nusage: ./gossa [OPTIONS] ~/directory-to-share\n")
+		fmt.Printf("       ./gossa [OPTIONS] ls|get|put|rm|mv REMOTE_URL PATH...\n\n")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if err := gossa.Run(cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -1,3 +1,24 @@
 module github.com/pldubouilh/gossa
 
 go 1.23.0
+
+require (
+	github.com/alecthomas/chroma/v2 v2.14.0
+	github.com/andybalholm/brotli v1.1.1
+	github.com/fsnotify/fsnotify v1.8.0
+	github.com/klauspost/compress v1.17.11
+	github.com/pkg/sftp v1.13.7
+	github.com/yuin/goldmark v1.7.8
+	golang.org/x/crypto v0.31.0
+	golang.org/x/image v0.23.0
+	golang.org/x/net v0.33.0
+	golang.org/x/text v0.21.0
+	lukechampine.com/blake3 v1.3.0
+)
+
+require (
+	github.com/dlclark/regexp2 v1.11.0 // indirect
+	github.com/klauspost/cpuid/v2 v2.0.9 // indirect
+	github.com/kr/fs v0.1.0 // indirect
+	golang.org/x/sys v0.28.0 // indirect
+)
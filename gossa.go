@@ -1,55 +1,63 @@
-package main
+package gossa
 
 import (
-	"archive/zip"
-	"compress/gzip"
-	"crypto/md5"
-	"crypto/sha1"
-	"crypto/sha256"
-	"crypto/sha512"
+	"bytes"
+	"context"
 	_ "embed"
-	"encoding/hex"
 	"encoding/json"
 	"errors"
-	"flag"
 	"fmt"
-	"hash"
 	"html"
 	"html/template"
 	"io"
-	"io/fs"
 	"log"
+	"mime"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
-	"sort"
 	"strconv"
 	"strings"
 )
 
 type rowTemplate struct {
-	Name string
-	Href template.URL
-	Size string
-	Ext  string
+	Name  string
+	Href  template.URL
+	Size  string
+	Ext   string
+	Mtime string
+	Mode  string
+	Owner string
+	Perms bool
 }
 
 type pageTemplate struct {
 	Title       template.HTML
+	Crumbs      []crumb // the folders of Title, linked
 	ExtraPath   template.HTML
 	Ro          bool
-	RowsFiles   []rowTemplate
-	RowsFolders []rowTemplate
+	FolderSizes bool
+	Perms       bool
+	Live        bool
+	DiskFree    uint64
+	DiskInfo    string
+	Total       int
+	PrevPage    template.URL
+	NextPage    template.URL
+	Readme      template.HTML
+	Header      template.HTML
+	Footer      template.HTML
+	Lang        string
+	TitlePrefix string
+	Logo        template.URL
+	IconCSS     template.CSS      // of the icons given by -icons
+	T           map[string]string // the strings of the page, in Lang
 }
 
-var host = flag.String("h", "127.0.0.1", "host to listen to")
-var port = flag.String("p", "8001", "port to listen to")
-var extraPath = flag.String("prefix", "/", "url prefix at which gossa can be reached, e.g. /gossa/ (slashes of importance)")
-var symlinks = flag.Bool("symlinks", false, "follow symlinks \033[4mWARNING\033[0m: symlinks will by nature allow to escape the defined path (default: false)")
-var verb = flag.Bool("verb", false, "verbosity")
-var skipHidden = flag.Bool("k", true, "\nskip hidden files")
-var ro = flag.Bool("ro", false, "read only mode (no upload, rename, move, etc...)")
+// listFlushEvery is how many rows are rendered between flushes, so large listings stream
+const listFlushEvery = 256
 
 type rpcCall struct {
 	Call string   `json:"call"`
@@ -58,6 +66,13 @@ type rpcCall struct {
 
 var rootPath = ""
 var handler http.Handler
+var started bool
+
+// folders gossa keeps its own state in, never served nor listed
+var internalDirs []string
+
+// folders symlinks may lead to out of the root, resolved
+var allowedLinks []string
 
 func check(e error) {
 	if e != nil {
@@ -68,86 +83,332 @@ func check(e error) {
 func exitPath(w http.ResponseWriter, s ...interface{}) {
 	if r := recover(); r != nil {
 		log.Println("error", s, r)
-		w.WriteHeader(500)
-		w.Write([]byte("error"))
+		replyError(w, asError(r))
+	} else if *verb {
+		log.Println(s...)
+	}
+}
+
+// exitPage is exitPath for the pages browsers load, replying errors as a page
+func exitPage(w http.ResponseWriter, r *http.Request, s ...interface{}) {
+	if rec := recover(); rec != nil {
+		log.Println("error", s, rec)
+		replyErrorPage(w, r, asError(rec))
 	} else if *verb {
 		log.Println(s...)
 	}
 }
 
+// splitList splits a comma separated list, dropping empty items
+func splitList(s string) []string {
+	var out []string
+	for _, el := range strings.Split(s, ",") {
+		if el = strings.TrimSpace(el); el != "" {
+			out = append(out, el)
+		}
+	}
+	return out
+}
+
+// globMatch matches a slash separated relative path against a glob pattern.
+// "**" matches any number of path segments, and patterns without a slash
+// are matched against every segment of the path (e.g. "*.iso" matches "a/b.iso")
+func globMatch(pattern string, rel string) bool {
+	pattern = strings.Trim(pattern, "/")
+	segs := strings.Split(strings.Trim(rel, "/"), "/")
+	if !strings.Contains(pattern, "/") {
+		for _, seg := range segs {
+			if ok, _ := path.Match(pattern, seg); ok {
+				return true
+			}
+		}
+		return false
+	}
+	return globMatchSegs(strings.Split(pattern, "/"), segs)
+}
+
+func globMatchSegs(pat []string, segs []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			for i := 0; i <= len(segs); i++ {
+				if globMatchSegs(pat[1:], segs[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segs) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pat[0], segs[0]); !ok {
+			return false
+		}
+		pat, segs = pat[1:], segs[1:]
+	}
+	return len(segs) == 0
+}
+
+func globMatchAny(patterns []string, rel string) bool {
+	for _, p := range patterns {
+		if globMatch(p, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// isHidden tells if a slash separated path relative to the root is hidden:
+// one of its parts a dot file while skipping them, or matching -hide
+func isHidden(rel string) bool {
+	return *skipHidden && strings.Contains("/"+rel, "/.") || *hide != "" && globMatchAny(splitList(*hide), rel)
+}
+
+// isHiddenPath tells if a full path is hidden, matched from the root so
+// path globs of -hide such as backups/** apply wherever it's reached from
+func isHiddenPath(fullPath string) bool {
+	return isHidden(relPath(fullPath))
+}
+
+// linkAllowed tells if a symlink resolving to a full path may be followed out
+// of the root: any with -symlinks, else only into the folders of -symlinks-allow
+func linkAllowed(resolved string) bool {
+	return *symlinks || linkListed(resolved)
+}
+
+func linkListed(resolved string) bool {
+	for _, el := range allowedLinks {
+		if inside(el, resolved) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveLink evaluates the symlinks of a path, empty if it can't
+func resolveLink(fullPath string) string {
+	ls, err := linkStore()
+	if err != nil {
+		return ""
+	}
+	resolved, _ := ls.EvalSymlinks(fullPath)
+	return resolved
+}
+
+// inside tells if a path is a folder or below it
+func inside(dir string, p string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// isInternal tells if a full path belongs to gossa's own state
+func isInternal(fullPath string) bool {
+	for _, el := range internalDirs {
+		if fullPath == el || strings.HasPrefix(fullPath, el+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// changed is called once a path was written, moved or removed, so caches and the index catch up
+func changed(fullPath string) {
+	invalidateDu(fullPath)
+	invalidateListing(fullPath)
+	reindex(fullPath)
+}
+
+// unitSystems are the suffixes humanize picks from with -size-units, along with their step
+var unitSystems = map[string]struct {
+	step  float64
+	names [9]string
+}{
+	"short":  {1024, [9]string{"B", "k", "M", "G", "T", "P", "E", "Z", "Y"}},
+	"si":     {1000, [9]string{"B", "kB", "MB", "GB", "TB", "PB", "EB", "ZB", "YB"}},
+	"binary": {1024, [9]string{"B", "KiB", "MiB", "GiB", "TiB", "PiB", "EiB", "ZiB", "YiB"}},
+}
+
 func humanize(bytes int64) string {
+	units := unitSystems[*sizeUnits]
 	b := float64(bytes)
 	u := 0
 	for {
-		if b < 1024 {
-			return strconv.FormatFloat(b, 'f', 1, 64) + [9]string{"B", "k", "M", "G", "T", "P", "E", "Z", "Y"}[u]
+		if b < units.step || u == len(units.names)-1 {
+			return strconv.FormatFloat(b, 'f', *sizePrecision, 64) + units.names[u]
 		}
-		b = b / 1024
+		b = b / units.step
 		u++
 	}
 }
 
-func replyList(w http.ResponseWriter, r *http.Request, fullPath string, path string) {
-	files, err := os.ReadDir(fullPath)
+func checkSizeUnits() error {
+	if _, ok := unitSystems[*sizeUnits]; !ok {
+		return fmt.Errorf("invalid -size-units %s, expected short, si or binary", *sizeUnits)
+	}
+	if *sizePrecision < 0 || *sizePrecision > 9 {
+		return fmt.Errorf("invalid -size-precision %d, expected 0 to 9", *sizePrecision)
+	}
+	return nil
+}
+
+// offloadFile hands the actual transfer over to the front proxy
+func offloadFile(w http.ResponseWriter, fullPath string) {
+	rel, err := filepath.Rel(rootPath, fullPath)
 	check(err)
-	sort.Slice(files, func(i, j int) bool { return strings.ToLower(files[i].Name()) < strings.ToLower(files[j].Name()) })
+	switch *offload {
+	case "x-accel":
+		u := url.URL{Path: *offloadPrefix + filepath.ToSlash(rel)}
+		w.Header().Set("X-Accel-Redirect", u.EscapedPath())
+	case "x-sendfile":
+		w.Header().Set("X-Sendfile", fullPath)
+	default:
+		panic(errors.New("invalid offload mode " + *offload))
+	}
+}
+
+// flushListing pushes what was rendered so far to the client
+func flushListing(w http.ResponseWriter, out io.Writer) {
+	if f, ok := out.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// replyList renders a folder, streaming rows as they are formatted rather
+// than building the whole page first
+func replyList(w http.ResponseWriter, r *http.Request, fullPath string, path string, dirStat os.FileInfo) {
+	q := r.URL.Query()
+	stats, total, err := listDir(fullPath, q)
+	check(err)
+	replyEntries(w, r, fullPath, path, dirStat, stats, total)
+}
+
+// replyEntries renders the page of entries of a folder, or of a folder in an archive
+func replyEntries(w http.ResponseWriter, r *http.Request, fullPath string, path string, dirStat os.FileInfo, stats []os.FileInfo, total int) {
+	q := r.URL.Query()
+	format := listingFormat(r)
+	validator := newListingValidator(dirStat, r, format)
 
 	if !strings.HasSuffix(path, "/") {
 		path += "/"
 	}
 
 	title := "/" + strings.TrimPrefix(path, *extraPath)
-	p := pageTemplate{}
-	if path != *extraPath {
-		p.RowsFolders = append(p.RowsFolders, rowTemplate{"../", "../", "", "folder"})
+	offset, limit := pageBounds(q)
+	validator.addTotal(total)
+	for _, el := range stats {
+		validator.add(el)
+	}
+	readmePath, readmeInfo := readmeStat(fullPath)
+	if readmeInfo != nil && format != "json" {
+		validator.add(readmeInfo) // the readme may be on another page
 	}
-	p.ExtraPath = template.HTML(html.EscapeString(*extraPath))
-	p.Ro = *ro
-	p.Title = template.HTML(html.EscapeString(title))
 
-	for _, el := range files {
-		info, errInfo := el.Info()
-		el, err := os.Stat(fullPath + "/" + el.Name())
-		if err != nil || errInfo != nil {
-			log.Println("error - cant stat a file", err)
-			continue
-		}
+	if *cacheControlListings != "" {
+		w.Header().Set("Cache-Control", *cacheControlListings)
+	}
+	w.Header().Add("Vary", "Accept")
+	if format != "json" && *lang == "" {
+		w.Header().Add("Vary", "Accept-Language")
+	}
+	if validator.notModified(w, r) {
+		return
+	}
 
-		if *skipHidden && strings.HasPrefix(el.Name(), ".") {
-			continue // dont print hidden files if we're not allowed
-		}
-		if !*symlinks && info.Mode()&os.ModeSymlink != 0 {
-			continue // dont follow symlinks if we're not allowed
+	if format == "json" {
+		quoted, err := json.Marshal(title)
+		check(err)
+		w.Header().Set("Content-Type", "application/json")
+		out, done := encodeListing(w, r)
+		defer done()
+		// the shape of listing, written by hand so the entries can follow one at a time.
+		// The folder was read and sorted whole already, only the encoding is streamed
+		fmt.Fprintf(out, `{"path":%s,"total":%d,"offset":%d,"entries":[`, quoted, total, offset)
+		media := q.Get("media") == "1"
+		for i, el := range stats {
+			e := newListEntry(path, el)
+			if media && !el.IsDir() && (isAudio(el.Name()) || isVideo(el.Name())) {
+				e.Media = mediaInfoOf(filepath.Join(fullPath, el.Name()), el)
+			}
+			b, _ := json.Marshal(e)
+			if i > 0 {
+				out.Write([]byte(","))
+			}
+			out.Write(b)
+			if i%listFlushEvery == listFlushEvery-1 {
+				flushListing(w, out)
+			}
 		}
+		out.Write([]byte("]}"))
+		return
+	}
 
-		href := url.PathEscape(el.Name())
-		name := el.Name()
-
-		if el.IsDir() && strings.HasPrefix(href, "/") {
-			href = strings.Replace(href, "/", "", 1)
-		}
+	p := pageTemplate{}
+	p.ExtraPath = template.HTML(html.EscapeString(*extraPath))
+	p.Ro = *ro
+	p.FolderSizes = *folderSizes
+	p.Perms = *showPerms
+	p.Live = *liveOn
+	p.Header, p.Footer = headerHTML, footerHTML
+	p.Lang, p.T = pageLang(r)
+	p.TitlePrefix, p.Logo = *titlePrefix, logoURI
+	p.IconCSS = iconCSS
+	if free, total, err := storeSpace(fullPath); err == nil {
+		p.DiskFree = free
+		p.DiskInfo = fmt.Sprintf(p.T["diskInfo"], humanize(int64(free)), humanize(int64(total)))
+	}
+	p.Title = template.HTML(html.EscapeString(title))
+	p.Crumbs = breadcrumbs(title)
+	p.Total = total
+	p.PrevPage, p.NextPage = pageLinks(q, offset, limit, total)
+	if readmeInfo != nil {
+		p.Readme = renderReadme(readmePath)
+	}
 
-		if el.IsDir() {
-			row := rowTemplate{name + "/", template.URL(href), "", "folder"}
-			p.RowsFolders = append(p.RowsFolders, row)
-		} else {
-			sl := strings.Split(name, ".")
-			ext := strings.ToLower(sl[len(sl)-1])
-			row := rowTemplate{name, template.URL(href), humanize(el.Size()), ext}
-			p.RowsFiles = append(p.RowsFiles, row)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	out, done := encodeListing(w, r)
+	defer done()
+	tmpl := ui.Load().tmpl
+	tmpl.Execute(out, p)
+	if path != *extraPath {
+		tmpl.ExecuteTemplate(out, "row", rowTemplate{Name: "../", Href: "../", Ext: "folder"})
+	}
+	flushListing(w, out) // the page shows up while the rows are rendered
+
+	rendered := 0
+	for _, dirs := range []bool{true, false} { // folders first
+		for _, el := range stats {
+			if el.IsDir() != dirs {
+				continue
+			}
+			entry := newListEntry(path, el)
+			href := url.PathEscape(el.Name())
+			row := rowTemplate{Name: el.Name(), Href: template.URL(href), Mtime: entry.Modified, Mode: entry.Mode, Owner: entry.Owner, Perms: p.Perms}
+			if el.IsDir() {
+				row.Name, row.Ext = row.Name+"/", "folder"
+			} else {
+				sl := strings.Split(el.Name(), ".")
+				row.Size, row.Ext = entry.Human, iconOf(strings.ToLower(sl[len(sl)-1]))
+			}
+			if tmpl.ExecuteTemplate(out, "row", row) != nil {
+				return // client gone
+			}
+			if rendered++; rendered%listFlushEvery == 0 {
+				flushListing(w, out)
+			}
 		}
 	}
+	tmpl.ExecuteTemplate(out, "foot", p)
+}
 
-	if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
-		w.Header().Set("Content-Type", "text/html")
-		w.Header().Add("Content-Encoding", "gzip")
-		gz, err := gzip.NewWriterLevel(w, gzip.BestSpeed) // BestSpeed is Much Faster than default - base on a very unscientific local test, and only ~30% larger (compression remains still very effective, ~6x)
-		check(err)
-		defer gz.Close()
-		tmpl.Execute(gz, p)
-	} else {
-		tmpl.Execute(w, p)
+// hasIndex tells if a folder is to be served as its index.html, with -serve-index or -spa
+func hasIndex(r *http.Request, fullPath string) bool {
+	if !*serveIndex && !inSpa(relPath(fullPath)) || r.URL.Query().Has("list") || listingFormat(r) == "json" {
+		return false
 	}
+	stat, err := store.Stat(filepath.Join(fullPath, "index.html"))
+	return err == nil && stat.Mode().IsRegular()
 }
 
 func doContent(w http.ResponseWriter, r *http.Request) {
@@ -157,15 +418,51 @@ func doContent(w http.ResponseWriter, r *http.Request) {
 	}
 
 	path := html.UnescapeString(r.URL.Path)
-	defer exitPath(w, "get content", path)
+	defer exitPage(w, r, "get content", path)
 	fullPath := enforcePath(path)
-	stat, errStat := os.Stat(fullPath)
+	stat, errStat := store.Stat(fullPath)
+	if (errStat != nil || stat.Mode().IsRegular() && strings.HasSuffix(path, "/")) && serveArchive(w, r, fullPath, path) {
+		return
+	} else if errors.Is(errStat, os.ErrNotExist) && spaFallback(w, r, strings.TrimPrefix(path, *extraPath)) {
+		return
+	}
 	check(errStat)
 
-	if stat.IsDir() {
-		replyList(w, r, fullPath, path)
+	if r.URL.Query().Has("torrent") {
+		replyTorrent(w, r, fullPath, stat.IsDir())
+	} else if stat.IsDir() && r.URL.Query().Has("du") {
+		sizes, err := childrenUsage(fullPath)
+		check(err)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(sizes)
+	} else if stat.IsDir() && r.URL.Query().Has("feed") {
+		replyFeed(w, r, fullPath, path)
+	} else if stat.IsDir() && hasIndex(r, fullPath) {
+		handler.ServeHTTP(w, r) // the file server picks the index.html
+	} else if stat.IsDir() && r.Method == http.MethodHead {
+		replyDirHead(w, fullPath)
+	} else if stat.IsDir() {
+		replyList(w, r, fullPath, path, stat)
+	} else if r.URL.Query().Get("render") == "1" && isMarkdown(fullPath) {
+		replyMarkdown(w, r, fullPath, path, stat)
 	} else {
-		handler.ServeHTTP(w, r)
+		if r.URL.Query().Get("download") == "1" {
+			w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": stat.Name()}))
+		}
+		if algo := r.URL.Query().Get("hash"); algo != "" {
+			sum, err := fileSum(fullPath, algo)
+			check(err)
+			w.Write(sum)
+			return
+		}
+		if *offload != "" {
+			offloadFile(w, fullPath)
+			return
+		}
+		if *precompressed && servePrecompressed(w, r, fullPath) {
+			return
+		}
+		handler.ServeHTTP(rateLimit(w), r)
 	}
 }
 
@@ -175,144 +472,472 @@ func upload(w http.ResponseWriter, r *http.Request) {
 
 	path, err := url.PathUnescape(path)
 	check(err)
+	body := &bodyEnd{r: r.Body}
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{body, r.Body}
 	reader, err := r.MultipartReader()
 	check(err)
 	part, err := reader.NextPart()
 	if err != nil && err != io.EOF { // errs EOF when no more parts to process
 		check(err)
 	}
-	dst, err := os.Create(enforcePath(path))
-	check(err)
-	io.Copy(dst, part)
+	check(saveFile(r.Context(), enforcePath(path), unclosedPart{part, body}))
 	w.Write([]byte("ok"))
 }
 
+// bodyEnd tells if a request body was read to its end, rather than cut short
+type bodyEnd struct {
+	r     io.Reader
+	ended bool
+}
+
+func (b *bodyEnd) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err == io.EOF {
+		b.ended = true
+	}
+	return n, err
+}
+
+// unclosedPart reads a part up to the end of the body, whether or not the
+// closing boundary was sent, as uploads always have been. A body cut short
+// still fails, not to save truncated files
+type unclosedPart struct {
+	r    io.Reader
+	body *bodyEnd
+}
+
+func (p unclosedPart) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if err == io.ErrUnexpectedEOF && p.body.ended {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// saveFile writes an uploaded file, keeping the version it replaces if asked to.
+// A new file is dropped if ctx is done before it's complete
+func saveFile(ctx context.Context, fullPath string, src io.Reader) error {
+	defer changed(fullPath)
+	_, err := store.Lstat(fullPath)
+	isNew := errors.Is(err, os.ErrNotExist)
+	if err = keepVersion(fullPath); err != nil {
+		return err
+	}
+	dst, err := store.Create(fullPath)
+	if err != nil {
+		return err
+	}
+	_, err = copyBuffer(dst, ctxReader{ctx, src})
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil && isNew {
+		store.Remove(fullPath) // rather than a truncated file, e.g. once the disk is full
+	}
+	if err != nil {
+		return err
+	}
+	notify("upload", fullPath, "")
+	if isNew {
+		journalPush(journalEntry{op: "upload", dst: fullPath}) // overwrites cant be undone
+	}
+	return nil
+}
+
 func zipRPC(w http.ResponseWriter, r *http.Request) {
 	zipPath := r.URL.Query().Get("zipPath")
 	zipName := r.URL.Query().Get("zipName")
 	defer exitPath(w, "zip", zipPath)
+	excludes := splitList(*zipExclude)
+	for _, el := range r.URL.Query()["exclude"] {
+		excludes = append(excludes, splitList(el)...)
+	}
 	zipFullPath := enforcePath(zipPath)
-	_, err := os.Lstat(zipFullPath)
+	_, err := store.Lstat(zipFullPath)
 	check(err)
+	defer archiveSlot(w, r)()
+	capZip(r.Context(), zipFullPath, excludes)
 	w.Header().Add("Content-Disposition", "attachment; filename=\""+zipName+".zip\"")
-	zipWriter := zip.NewWriter(w)
-	defer zipWriter.Close()
+	if *zipCache != "" {
+		serveCachedZip(w, r, zipFullPath, excludes)
+	} else {
+		writeZip(r.Context(), rateLimit(w), zipFullPath, excludes)
+	}
+}
 
-	err = filepath.Walk(zipFullPath, func(path string, f fs.FileInfo, err error) error {
-		check(err)
-		if f.IsDir() {
-			return nil
+type rpcResult struct {
+	Ok     bool   `json:"ok"`
+	Result string `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+	Code   string `json:"code,omitempty"`
+}
+
+// minimum number of arguments of each call
+var rpcArity = map[string]int{
+	"mkdirp": 1, "mv": 2, "cp": 2, "rm": 1, "ln": 2, "touch": 1, "chmod": 2, "chown": 2,
+	"extract": 2, "extractProgress": 1, "stat": 1, "sum": 2, "du": 1, "find": 2, "dupes": 1,
+	"undo": 0, "trashList": 0, "trashRestore": 1, "trashPurge": 0, "versionsList": 1, "versionRestore": 2,
+}
+
+// runCall executes a single call, turning panics (e.g. invalid paths) into errors.
+// Long calls, copies and finds, stop once ctx is done
+func runCall(ctx context.Context, rpc rpcCall) (ret []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = asError(r)
 		}
+	}()
+	ret = []byte("ok")
+
+	arity, known := rpcArity[rpc.Call]
+	if !known {
+		return nil, badCall("unknown call %q", rpc.Call)
+	} else if len(rpc.Args) < arity {
+		return nil, badCall("%s expects %d arguments", rpc.Call, arity)
+	}
 
-		rel, err := filepath.Rel(zipFullPath, path)
-		check(err)
-		if *skipHidden && (strings.HasPrefix(rel, ".") || strings.HasPrefix(f.Name(), ".")) {
-			return nil // hidden files not allowed
+	switch rpc.Call {
+	case "mkdirp":
+		if err = store.MkdirAll(enforcePath(rpc.Args[0]), os.ModePerm); err == nil {
+			notify("mkdir", enforcePath(rpc.Args[0]), "")
 		}
-		if f.Mode()&os.ModeSymlink != 0 {
-			panic(errors.New("symlink not allowed in zip downloads")) // filepath.Walk doesnt support symlinks
+	case "mv":
+		src, dst := enforcePath(rpc.Args[0]), enforcePath(rpc.Args[1])
+		if _, err := store.Lstat(dst); err == nil && append(rpc.Args, "")[2] != "overwrite" {
+			check(fmt.Errorf("%s: %w", rpc.Args[1], os.ErrExist))
 		}
+		check(keepVersion(dst))
+		if err = moveAny(src, dst); err == nil {
+			journalPush(journalEntry{op: "mv", src: src, dst: dst})
+			notify("rename", src, dst)
+		}
+	case "cp":
+		err = copyPath(ctx, enforcePath(rpc.Args[0]), enforcePath(rpc.Args[1]))
+	case "rm":
+		if *trash {
+			var id string
+			if id, err = trashPut(enforcePath(rpc.Args[0])); err == nil {
+				journalPush(journalEntry{op: "rm", trashID: id})
+			}
+		} else {
+			err = store.RemoveAll(enforcePath(rpc.Args[0]))
+		}
+		if err == nil {
+			notify("delete", enforcePath(rpc.Args[0]), "")
+		}
+	case "versionsList":
+		ret, err = versionsListJSON(enforcePath(rpc.Args[0]))
+	case "versionRestore":
+		err = versionRestore(enforcePath(rpc.Args[0]), rpc.Args[1])
+	case "undo":
+		err = undo()
+	case "trashList":
+		ret, err = trashList()
+	case "trashRestore":
+		err = trashRestore(rpc.Args[0])
+	case "trashPurge":
+		err = trashPurge(append(rpc.Args, "")[0])
+	case "ln":
+		err = linkPath(enforcePath(rpc.Args[0]), enforcePath(rpc.Args[1]))
+	case "touch":
+		err = touchPath(enforcePath(rpc.Args[0]))
+	case "chmod":
+		err = chmodPath(enforcePath(rpc.Args[0]), rpc.Args[1])
+	case "chown":
+		err = chownPath(enforcePath(rpc.Args[0]), rpc.Args[1])
+	case "extract":
+		err = extract(enforcePath(rpc.Args[0]), enforcePath(rpc.Args[1]))
+	case "extractProgress":
+		ret, err = extractStatus(enforcePath(rpc.Args[0]))
+	case "stat":
+		ret, err = statPath(enforcePath(rpc.Args[0]))
+	case "sum":
+		ret, err = fileSum(enforcePath(rpc.Args[0]), rpc.Args[1])
+	case "du":
+		ret, err = childrenUsage(enforcePath(rpc.Args[0]))
+	case "dupes":
+		ret, err = dupes(ctx, enforcePath(rpc.Args[0]))
+	case "find":
+		ret, err = find(ctx, enforcePath(rpc.Args[0]), rpc.Args[1], append(rpc.Args, "")[2])
+	}
 
-		header, err := zip.FileInfoHeader(f)
-		check(err)
-		header.Name = filepath.ToSlash(rel) // make the paths consistent between OSes
-		header.Method = zip.Store
-		headerWriter, err := zipWriter.CreateHeader(header)
-		check(err)
-		file, err := os.Open(path)
-		check(err)
-		defer file.Close()
-		_, err = io.Copy(headerWriter, file)
-		check(err)
-		return nil
-	})
+	switch rpc.Call {
+	case "mkdirp", "mv", "cp", "rm", "extract", "touch", "ln":
+		for _, el := range rpc.Args {
+			changed(enforcePath(el))
+		}
+	case "chmod", "chown":
+		invalidateListing(enforcePath(rpc.Args[0]))
+	}
+	return ret, err
+}
 
+// rpcBatch runs a list of calls in order, and replies the outcome of each.
+// With ?stopOnError=true, calls following a failure are skipped.
+func rpcBatch(w http.ResponseWriter, r *http.Request, body []byte) {
+	var calls []rpcCall
+	check(json.Unmarshal(body, &calls))
+	stopOnError := r.URL.Query().Get("stopOnError") == "true"
+
+	results := []rpcResult{}
+	for _, call := range calls {
+		ret, err := runCall(r.Context(), call)
+		if err != nil {
+			log.Println("error", "rpc", call, err)
+			_, code := errorStatus(err)
+			results = append(results, rpcResult{Ok: false, Error: errorMessage(err), Code: code})
+			if stopOnError {
+				break
+			}
+		} else {
+			results = append(results, rpcResult{Ok: true, Result: string(ret)})
+		}
+	}
+
+	ret, err := json.Marshal(results)
 	check(err)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(ret)
 }
 
 func rpc(w http.ResponseWriter, r *http.Request) {
-	var err error
 	var rpc rpcCall
 	defer exitPath(w, "rpc", &rpc)
 	bodyBytes, err := io.ReadAll(r.Body)
 	check(err)
-	json.Unmarshal(bodyBytes, &rpc)
-	ret := []byte("ok")
-
-	switch rpc.Call {
-	case "mkdirp":
-		err = os.MkdirAll(enforcePath(rpc.Args[0]), os.ModePerm)
-	case "mv":
-		err = os.Rename(enforcePath(rpc.Args[0]), enforcePath(rpc.Args[1]))
-	case "rm":
-		err = os.RemoveAll(enforcePath(rpc.Args[0]))
-	case "sum":
-		file, err := os.Open(enforcePath(rpc.Args[0]))
-		check(err)
-		var hash hash.Hash
-		switch rpc.Args[1] {
-		case "md5":
-			hash = md5.New()
-		case "sha1":
-			hash = sha1.New()
-		case "sha256":
-			hash = sha256.New()
-		case "sha512":
-			hash = sha512.New()
-		}
-		_, err = io.Copy(hash, file)
-		check(err)
-		checksum := hash.Sum(nil)
-		ret = make([]byte, hex.EncodedLen(len(checksum)))
-		hex.Encode(ret, checksum)
+	if bytes.HasPrefix(bytes.TrimSpace(bodyBytes), []byte("[")) {
+		rpcBatch(w, r, bodyBytes)
+		return
 	}
 
+	check(json.Unmarshal(bodyBytes, &rpc))
+	ret, err := runCall(r.Context(), rpc)
 	check(err)
 	w.Write(ret)
 }
 
 func enforcePath(p string) string {
-	joined := filepath.Join(rootPath, strings.TrimPrefix(p, *extraPath))
+	fp, err := safePath(strings.TrimPrefix(p, *extraPath))
+	check(err)
+	return fp
+}
+
+// safePath resolves a path relative to the root, or errors with errInvalidPath
+func safePath(p string) (string, error) {
+	joined := filepath.Join(rootPath, p)
 	fp, err := filepath.Abs(joined)
-	sl, _ := filepath.EvalSymlinks(fp) // err skipped as it would error for unexistent files (RPC check). The actual behaviour is tested below
+	sl := resolveLink(fp) // empty for unexistent files (RPC check). The actual behaviour is tested below
 
 	// panic if we had a error getting absolute path,
 	// ... or if path doesnt contain the prefix path we expect,
 	// ... or if we're skipping hidden folders, and one is requested,
-	// ... or if we're skipping symlinks, path exists, and a symlink out of bound requested
-	if err != nil || !strings.HasPrefix(fp, rootPath) || *skipHidden && strings.Contains(p, "/.") || !*symlinks && len(sl) > 0 && !strings.HasPrefix(sl, rootPath) {
-		panic(errors.New("invalid path"))
+	// ... or if we're skipping symlinks, path exists, and a symlink out of bound and out of -symlinks-allow requested
+	// ... or if gossa's own state is requested
+	if err != nil || !strings.HasPrefix(fp, rootPath) || isHiddenPath(fp) || len(sl) > 0 && !strings.HasPrefix(sl, rootPath) && !linkAllowed(sl) || isInternal(fp) {
+		return "", errInvalidPath
 	}
 
-	return fp
+	return fp, nil
 }
 
-func main() {
-	if flag.Parse(); len(flag.Args()) == 1 {
-		rootPath = flag.Args()[0]
-	} else {
-		fmt.Printf("\nusage: ./gossa [OPTIONS] ~/directory-to-share\n\n")
-		flag.PrintDefaults()
-		os.Exit(1)
+// New sets gossa up to share cfg.Root, with its handler expecting requests
+// under cfg.Prefix, e.g. mounted as mux.Handle("/files/", h) with a Prefix of
+// /files/. The side servers configured (sftp, ftp and s3) are started too.
+// gossa keeps its state in package variables, so only one can be made per process
+func New(cfg Config) (h http.Handler, err error) {
+	if started {
+		return nil, errors.New("gossa: only one instance per process")
 	}
-
-	var err error
-	rootPath, err = filepath.Abs(rootPath)
+	if err = configStorage(&cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Root == "" {
+		return nil, errors.New("gossa: no folder to share")
+	}
+	// setup helpers check() their errors, undo closing what was opened before
+	var undo []func()
+	defer func() {
+		if r := recover(); r != nil {
+			h, err = nil, asError(r)
+			for _, el := range undo {
+				el()
+			}
+		}
+	}()
+	conf = cfg
+	if cfg.Storage != nil {
+		store = cfg.Storage
+	}
+	rootPath, err = filepath.Abs(cfg.Root)
 	check(err)
-	server := &http.Server{Addr: *host + ":" + *port, Handler: handler}
 
+	mux := http.NewServeMux()
 	if !*ro {
-		http.HandleFunc(*extraPath+"rpc", rpc)
-		http.HandleFunc(*extraPath+"post", upload)
+		mux.HandleFunc(*extraPath+"rpc", rpc)
+		mux.HandleFunc(*extraPath+"post", upload)
+	}
+	mux.HandleFunc(*extraPath+"zip", zipRPC)
+	mux.HandleFunc(*extraPath+"tar", tarRPC)
+	mux.HandleFunc(*extraPath+"api/file", fileAPI)
+	mux.HandleFunc(*extraPath+"api/tree", treeAPI)
+	mux.HandleFunc(*extraPath+"api/df", dfAPI)
+	mux.HandleFunc(*extraPath+"api/walk", walkAPI)
+	mux.HandleFunc(*extraPath+"api/delta", deltaAPI)
+	mux.HandleFunc(*extraPath+"thumb", thumbHandler)
+	mux.HandleFunc(*extraPath+"api/thumbs", thumbsAPI)
+	mux.HandleFunc(*extraPath+"img", imgHandler)
+	mux.HandleFunc(*extraPath+"api/exif", exifAPI)
+	mux.HandleFunc(*extraPath+"api/gallery", galleryAPI)
+	mux.HandleFunc(*extraPath+"api/timeline", timelineAPI)
+	mux.HandleFunc(*extraPath+"preview", previewHandler)
+	mux.HandleFunc(*extraPath+"api/text", textAPI)
+	mux.HandleFunc(*extraPath+"qr", qrHandler)
+	mux.HandleFunc(*extraPath+"api/v1/", apiV1)
+	if *dav {
+		mux.Handle(*extraPath+"dav/", davHandler())
+	}
+	if *indexOn {
+		mux.HandleFunc(*extraPath+"search", searchHandler)
+	}
+	if *hls {
+		mux.HandleFunc(*extraPath+"hls", hlsHandler)
+	}
+	if *liveOn {
+		mux.HandleFunc(*extraPath+"events", liveHandler)
+	}
+	mux.HandleFunc("/", doContent)
+	handler = http.StripPrefix(*extraPath, cacheHandler(mimeHandler(http.FileServer(httpStorage{}))))
+
+	// checks and loads the config first, before anything is started
+	if *mimeTypes != "" {
+		check(loadMimeTypes(*mimeTypes))
+	}
+	loadDispositions(*disposition)
+	loadCacheRules(*cacheControl)
+	check(sortListing(nil, "", "")) // validates -sort
+	check(checkSortLocale())
+	loadSumCache()
+	allowedLinks = nil
+	for _, el := range splitList(*symlinksAllow) {
+		dir, err := filepath.Abs(el)
+		check(err)
+		dir, err = filepath.EvalSymlinks(dir)
+		check(err)
+		allowedLinks = append(allowedLinks, dir)
+	}
+	if *logo != "" {
+		b, err := os.ReadFile(*logo)
+		check(err)
+		logoURI = dataURI(b)
 	}
-	http.HandleFunc(*extraPath+"zip", zipRPC)
-	http.HandleFunc("/", doContent)
-	handler = http.StripPrefix(*extraPath, http.FileServer(http.Dir(rootPath)))
+	headerHTML, err = injectFile(*headerFile)
+	check(err)
+	footerHTML, err = injectFile(*footerFile)
+	check(err)
+	check(checkLang())
+	check(checkSizeUnits())
+	check(loadIcons(*icons))
+	archiveLimitSetup()
+	ffmpegSetup()
+	if *hls {
+		hlsSetup()
+	}
+
+	// then the watchers and side servers, closed again if a later one fails
+	if *liveOn {
+		liveSetup()
+		undo = append(undo, func() { live.watcher.Close() })
+	}
+	if *theme != "" || *themeName != "" && *themeName != "dark" || *favicon != "" {
+		undo = append(undo, func() {
+			if themeWatch != nil {
+				themeWatch.Close()
+				themeWatch = nil
+			}
+		})
+		themeSetup()
+	}
+	if *listCacheTTL > 0 {
+		listCacheSetup()
+		undo = append(undo, func() {
+			if listWatcher != nil {
+				listWatcher.Close()
+				listWatcher = nil
+			}
+		})
+	}
+	for _, side := range []struct {
+		on    bool
+		serve func() (net.Listener, error)
+	}{{*sftpAddr != "", sftpServe}, {*ftpAddr != "", ftpServe}, {*s3Addr != "", s3Serve}} {
+		if side.on {
+			listener, err := side.serve()
+			check(err)
+			undo = append(undo, func() { listener.Close() })
+		}
+	}
+
+	// nothing fails past here, the workers are started
+	internalDirs = []string{cacheDir()}
+	if *indexOn {
+		internalDirs = append(internalDirs, indexFile(), indexFile()+".tmp")
+	}
+	if *versions > 0 {
+		internalDirs = append(internalDirs, filepath.Join(rootPath, versionsDirName))
+	}
+	if *trash {
+		internalDirs = append(internalDirs, trashDir())
+	}
+	if *s3Addr != "" {
+		internalDirs = append(internalDirs, s3UploadsDir())
+	}
+	if *sumCachePath != "" {
+		go sumCacheFlusher()
+	}
+	if *liveOn {
+		go liveWatcher()
+	}
+	if themeWatch != nil {
+		go themeWatcher(*theme)
+	}
+	if listWatcher != nil {
+		go listCacheWatcher()
+	}
+	if *hls {
+		go hlsJanitor()
+	}
+	if *indexOn {
+		go indexer()
+	}
+	if *webhooks != "" {
+		go webhookWorker()
+	}
+	if *trash && *trashRetention > 0 && !*ro {
+		go trashJanitor()
+	}
+	started = true // only once set up, so a bad config can be fixed and retried
+	return loadShed(mux), nil
+}
 
+// Run serves a gossa at cfg.Host:cfg.Port, until the server fails
+func Run(cfg Config) error {
+	h, err := New(cfg)
+	if err != nil {
+		return err
+	}
 	fmt.Printf("Gossa starting on directory %s\n", rootPath)
 	fmt.Printf("Verbose: %t, Symlinks: %t, Read-Only: %t, Hidden-Files Skipped: %t\n", *verb, *symlinks, *ro, *skipHidden)
 	fmt.Printf("Listening on http://%s:%s%s\n", *host, *port, *extraPath)
-	if err = server.ListenAndServe(); err != http.ErrServerClosed {
-		check(err)
+	ln, err := listen(*host + ":" + *port)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: h}
+	if err = server.Serve(ln); err != http.ErrServerClosed {
+		return err
 	}
+	return nil
 }
@@ -0,0 +1,151 @@
+package gossa
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// withQuery clones a request, overriding its query
+func withQuery(r *http.Request, q url.Values) *http.Request {
+	r2 := r.Clone(r.Context())
+	r2.URL.RawQuery = q.Encode()
+	return r2
+}
+
+// apiV1 is the versioned api, routing /api/v1/<resource>/<path> to the
+// handlers behind the ui. Its contract is served at /api/v1/openapi.json
+func apiV1(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, *extraPath+"api/v1/")
+	resource, path, _ := strings.Cut(rest, "/")
+	path = "/" + path
+	q := r.URL.Query()
+	writes := r.Method != http.MethodGet && r.Method != http.MethodHead
+	writes = writes && !(resource == "delta" && r.Method == http.MethodPost) // only reads the file
+	writes = writes && resource != "thumbs"
+
+	if writes && *ro {
+		replyError(w, fmt.Errorf("read only: %w", os.ErrPermission))
+		return
+	}
+
+	switch resource {
+	case "openapi.json":
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(strings.Replace(openapiJSON, "api_prefix_will_be_here", *extraPath+"api/v1", 1)))
+
+	case "list":
+		defer exitPath(w, "api list", path)
+		fullPath := enforcePath(path)
+		stat, err := store.Stat(fullPath)
+		check(err)
+		if !stat.IsDir() {
+			check(badCall("not a folder"))
+		}
+		q.Set("format", "json")
+		replyList(w, withQuery(r, q), fullPath, *extraPath+strings.TrimPrefix(path, "/"), stat)
+
+	case "files":
+		apiFiles(w, r, path)
+
+	case "rpc":
+		if r.Method != http.MethodPost {
+			replyError(w, badCall("rpc expects a POST"))
+			return
+		}
+		rpc(w, r)
+
+	case "zip":
+		q.Set("zipPath", path)
+		if q.Get("zipName") == "" {
+			q.Set("zipName", filepath.Base(path))
+		}
+		zipRPC(w, withQuery(r, q))
+
+	case "tar":
+		q.Set("path", path)
+		tarRPC(w, withQuery(r, q))
+
+	case "thumbs":
+		thumbsAPI(w, r)
+
+	case "file", "tree", "df", "walk", "delta", "thumb", "img", "exif", "gallery", "timeline", "preview", "text", "search":
+		if q.Get("path") == "" {
+			q.Set("path", path)
+		}
+		r = withQuery(r, q)
+		switch resource {
+		case "file":
+			fileAPI(w, r)
+		case "tree":
+			treeAPI(w, r)
+		case "df":
+			dfAPI(w, r)
+		case "walk":
+			walkAPI(w, r)
+		case "delta":
+			deltaAPI(w, r)
+		case "thumb":
+			thumbHandler(w, r)
+		case "img":
+			imgHandler(w, r)
+		case "exif":
+			exifAPI(w, r)
+		case "gallery":
+			galleryAPI(w, r)
+		case "timeline":
+			timelineAPI(w, r)
+		case "preview":
+			previewHandler(w, r)
+		case "text":
+			textAPI(w, r)
+		case "search":
+			if !*indexOn {
+				replyError(w, fmt.Errorf("search index disabled: %w", os.ErrNotExist))
+				return
+			}
+			searchHandler(w, r)
+		}
+
+	default:
+		replyError(w, fmt.Errorf("unknown api resource %s: %w", resource, os.ErrNotExist))
+	}
+}
+
+// apiFiles downloads, uploads (raw body) and deletes single files
+func apiFiles(w http.ResponseWriter, r *http.Request, path string) {
+	defer exitPath(w, "api files", r.Method, path)
+	fullPath := enforcePath(path)
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		stat, err := store.Stat(fullPath)
+		check(err)
+		if stat.IsDir() {
+			check(badCall("not a file, see list, zip and tar"))
+		}
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = *extraPath + strings.TrimPrefix(path, "/")
+		r2.URL.RawPath = ""
+		handler.ServeHTTP(rateLimit(w), r2)
+	case http.MethodPut:
+		if r.URL.Query().Get("overwrite") != "true" {
+			if _, err := store.Lstat(fullPath); err == nil {
+				check(fmt.Errorf("%s: %w", path, os.ErrExist))
+			}
+		}
+		check(saveFile(r.Context(), fullPath, r.Body))
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("ok"))
+	case http.MethodDelete:
+		_, err := runCall(r.Context(), rpcCall{Call: "rm", Args: []string{path}})
+		check(err)
+		w.Write([]byte("ok"))
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+		replyError(w, badCall("method not allowed"))
+	}
+}
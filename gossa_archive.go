@@ -0,0 +1,276 @@
+package gossa
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// archiveMember is a file or folder of an archive, browsed without extracting it
+type archiveMember struct {
+	name   string // slash separated, without leading slash
+	size   int64
+	mode   os.FileMode
+	mtime  time.Time
+	isDir  bool
+	offset int64     // of the content in the (uncompressed) tar stream
+	zf     *zip.File // of zip members
+}
+
+// archiveInfo presents an archive member as a file of a listing
+type archiveInfo struct{ m archiveMember }
+
+func (i archiveInfo) Name() string       { return path.Base(i.m.name) }
+func (i archiveInfo) Size() int64        { return i.m.size }
+func (i archiveInfo) Mode() os.FileMode  { return i.m.mode }
+func (i archiveInfo) ModTime() time.Time { return i.m.mtime }
+func (i archiveInfo) IsDir() bool        { return i.m.isDir }
+func (i archiveInfo) Sys() any           { return nil }
+
+// members of tars, by path, size and mtime, as listing one means reading it whole
+var tarIndexes = struct {
+	sync.Mutex
+	m map[string][]archiveMember
+}{m: map[string][]archiveMember{}}
+
+const tarIndexesMax = 16
+
+func isBrowsableArchive(name string) bool {
+	lower := strings.ToLower(name)
+	return strings.HasSuffix(lower, ".zip") || strings.HasSuffix(lower, ".tar") || strings.HasSuffix(lower, ".tar.gz") || strings.HasSuffix(lower, ".tgz")
+}
+
+// archiveOf finds the archive a path goes into, e.g. /a/b.zip/c/d.txt is
+// c/d.txt of /a/b.zip
+func archiveOf(fullPath string) (string, os.FileInfo, string, bool) {
+	for p := fullPath; strings.HasPrefix(p, rootPath+string(filepath.Separator)); p = filepath.Dir(p) {
+		if !isBrowsableArchive(p) {
+			continue
+		}
+		rel, _ := filepath.Rel(rootPath, p)
+		if _, err := safePath(rel); err != nil {
+			return "", nil, "", false
+		}
+		stat, err := store.Stat(p)
+		if err == nil && stat.Mode().IsRegular() {
+			inner, _ := filepath.Rel(p, fullPath)
+			return p, stat, strings.TrimPrefix(filepath.ToSlash(inner), "."), true
+		}
+	}
+	return "", nil, "", false
+}
+
+// cleanMember normalizes the name of a member, refusing the ones out of the archive
+func cleanMember(name string) (string, bool) {
+	clean := path.Clean("/" + name)
+	return strings.TrimPrefix(clean, "/"), clean != "/" && !strings.HasPrefix(name, "/") && !strings.Contains("/"+name+"/", "/../")
+}
+
+func zipMembers(zr *zip.Reader) []archiveMember {
+	var members []archiveMember
+	for _, f := range zr.File {
+		name, ok := cleanMember(f.Name)
+		if !ok || f.Mode()&os.ModeSymlink != 0 {
+			continue
+		}
+		members = append(members, archiveMember{name: name, size: int64(f.UncompressedSize64), mode: f.Mode(), mtime: f.Modified, isDir: f.FileInfo().IsDir(), zf: f})
+	}
+	return members
+}
+
+// countingReader counts what's read through it, telling where tar members start
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n += int64(n)
+	return n, err
+}
+
+// tarMembers indexes a tar, noting where the content of each member starts
+func tarMembers(archive string, stat os.FileInfo) ([]archiveMember, error) {
+	key := fmt.Sprintf("%s\x00%d\x00%d", archive, stat.Size(), stat.ModTime().UnixNano())
+	tarIndexes.Lock()
+	members, ok := tarIndexes.m[key]
+	tarIndexes.Unlock()
+	if ok {
+		return members, nil
+	}
+
+	f, err := store.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if members, err = readTarMembers(f, !strings.HasSuffix(strings.ToLower(archive), ".tar")); err != nil {
+		return nil, err
+	}
+
+	tarIndexes.Lock()
+	if len(tarIndexes.m) >= tarIndexesMax {
+		tarIndexes.m = map[string][]archiveMember{}
+	}
+	tarIndexes.m[key] = members
+	tarIndexes.Unlock()
+	return members, nil
+}
+
+// readTarMembers reads the members of a tar, gzipped or not, noting where
+// the content of each starts
+func readTarMembers(f File, gzipped bool) ([]archiveMember, error) {
+	var members []archiveMember
+	var tr *tar.Reader
+	var offset func() int64
+	if !gzipped {
+		tr = tar.NewReader(f) // seeks over the content of members
+		offset = func() int64 { n, _ := f.Seek(0, io.SeekCurrent); return n }
+	} else {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		c := &countingReader{r: gz}
+		tr = tar.NewReader(c)
+		offset = func() int64 { return c.n }
+	}
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		name, ok := cleanMember(h.Name)
+		if !ok || h.Typeflag != tar.TypeReg && h.Typeflag != tar.TypeDir {
+			continue // links, devices and friends are skipped
+		}
+		members = append(members, archiveMember{name: name, size: h.Size, mode: h.FileInfo().Mode(), mtime: h.ModTime, isDir: h.Typeflag == tar.TypeDir, offset: offset()})
+	}
+	return members, nil
+}
+
+// archiveChildren lists the members right below a folder of an archive,
+// including the folders only implied by the paths of members, but the hidden
+// ones, as if the archive were a folder
+func archiveChildren(archive string, members []archiveMember, dir string, mtime time.Time) ([]os.FileInfo, bool) {
+	prefix := ""
+	if dir != "" {
+		prefix = dir + "/"
+	}
+	found := dir == ""
+	seen := map[string]bool{}
+	var children []os.FileInfo
+	for _, m := range members {
+		if m.name == dir && m.isDir {
+			found = true
+		}
+		rest, ok := strings.CutPrefix(m.name, prefix)
+		if !ok || rest == "" {
+			continue
+		}
+		found = true
+		child, _, nested := strings.Cut(rest, "/")
+		if seen[child] || isHiddenPath(filepath.Join(archive, filepath.FromSlash(prefix+child))) {
+			continue
+		}
+		seen[child] = true
+		if nested || m.isDir {
+			m = archiveMember{name: prefix + child, mode: os.ModeDir | 0755, mtime: mtime, isDir: true}
+		}
+		children = append(children, archiveInfo{m})
+	}
+	return children, found
+}
+
+// serveArchive lists a folder of an archive, or streams one of its files out.
+// Reports false when the path doesn't go into an archive
+func serveArchive(w http.ResponseWriter, r *http.Request, fullPath string, urlPath string) bool {
+	archive, stat, inner, ok := archiveOf(fullPath)
+	if !ok {
+		return false
+	}
+
+	f, err := store.Open(archive)
+	check(err)
+	defer f.Close()
+	var members []archiveMember
+	if strings.HasSuffix(strings.ToLower(archive), ".zip") {
+		zr, err := zip.NewReader(f, stat.Size()) // only reads the central directory
+		check(err)
+		members = zipMembers(zr)
+	} else {
+		members, err = tarMembers(archive, stat)
+		check(err)
+	}
+
+	for _, m := range members {
+		if m.name != inner || m.isDir {
+			continue
+		}
+		w.Header().Set("Content-Type", mime.TypeByExtension(path.Ext(m.name)))
+		serveMember(w, r, f, m)
+		return true
+	}
+
+	children, found := archiveChildren(archive, members, inner, stat.ModTime())
+	if !found {
+		check(fmt.Errorf("%s in %s: %w", inner, filepath.Base(archive), os.ErrNotExist))
+	} else if !strings.HasSuffix(urlPath, "/") {
+		to := path.Base(urlPath) + "/"
+		if r.URL.RawQuery != "" {
+			to += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, to, http.StatusMovedPermanently)
+		return true
+	}
+	stats, total, err := listPage(children, r.URL.Query(), func(el os.FileInfo) (os.FileInfo, error) { return el, nil })
+	check(err)
+	replyEntries(w, r, fullPath, urlPath, stat, stats, total)
+	return true
+}
+
+// serveMember streams a member out of an archive, with ranges when it's
+// stored as is, in uncompressed tars and zip members stored without compression
+func serveMember(w http.ResponseWriter, r *http.Request, f File, m archiveMember) {
+	var src io.Reader
+	switch {
+	case m.zf != nil && m.zf.Method == zip.Store:
+		off, err := m.zf.DataOffset()
+		check(err)
+		http.ServeContent(w, r, "", m.mtime, io.NewSectionReader(f, off, m.size))
+		return
+	case m.zf != nil:
+		rc, err := m.zf.Open()
+		check(err)
+		defer rc.Close()
+		src = rc
+	case strings.HasSuffix(strings.ToLower(f.Name()), ".tar"):
+		http.ServeContent(w, r, "", m.mtime, io.NewSectionReader(f, m.offset, m.size))
+		return
+	default:
+		gz, err := gzip.NewReader(f)
+		check(err)
+		_, err = io.CopyN(io.Discard, gz, m.offset) // gzip can't seek, what's before is inflated and dropped
+		check(err)
+		src = gz
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(m.size, 10))
+	w.Header().Set("Last-Modified", m.mtime.UTC().Format(http.TimeFormat))
+	if r.Method != http.MethodHead {
+		copyBuffer(w, io.LimitReader(src, m.size))
+	}
+}
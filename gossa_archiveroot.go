@@ -0,0 +1,241 @@
+package gossa
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// archiveStorage serves the content of a zip or tar as the root, read only
+// and without extracting it. Names are below the path of the archive, as when
+// browsing one, e.g. /srv/data.zip/docs/a.txt
+type archiveStorage struct {
+	root    string
+	f       *os.File
+	size    int64
+	mtime   time.Time
+	gzipped bool // tar.gz rather than tar, when not a zip
+	members map[string]archiveMember
+	list    []archiveMember
+	dirs    map[string]bool // including the ones only implied by the paths of members
+}
+
+func newArchiveStorage(archive string) (*archiveStorage, error) {
+	f, err := os.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	s := &archiveStorage{root: archive, f: f, size: stat.Size(), mtime: stat.ModTime(), members: map[string]archiveMember{}, dirs: map[string]bool{"": true}}
+	if strings.HasSuffix(strings.ToLower(archive), ".zip") {
+		zr, err := zip.NewReader(f, s.size)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		s.list = zipMembers(zr)
+	} else {
+		s.gzipped = !strings.HasSuffix(strings.ToLower(archive), ".tar")
+		if s.list, err = readTarMembers(f, s.gzipped); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	for _, m := range s.list {
+		if m.isDir {
+			s.dirs[m.name] = true
+		} else {
+			s.members[m.name] = m
+		}
+		for dir := path.Dir(m.name); dir != "."; dir = path.Dir(dir) {
+			s.dirs[dir] = true
+		}
+	}
+	return s, nil
+}
+
+// member is the name of a path in the archive, empty for the root
+func (s *archiveStorage) member(op string, name string) (string, error) {
+	rel, err := filepath.Rel(s.root, name)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+	return strings.TrimPrefix(filepath.ToSlash(rel), "."), nil
+}
+
+func (s *archiveStorage) Stat(name string) (os.FileInfo, error) {
+	inner, err := s.member("stat", name)
+	if err != nil {
+		return nil, err
+	} else if m, ok := s.members[inner]; ok {
+		return archiveInfo{m}, nil
+	} else if s.dirs[inner] {
+		if inner == "" {
+			inner = filepath.Base(s.root)
+		}
+		return archiveInfo{archiveMember{name: inner, mode: os.ModeDir | 0555, mtime: s.mtime, isDir: true}}, nil
+	}
+	return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+}
+
+func (s *archiveStorage) Lstat(name string) (os.FileInfo, error) {
+	return s.Stat(name)
+}
+
+func (s *archiveStorage) ReadDir(name string) ([]os.DirEntry, error) {
+	inner, err := s.member("readdir", name)
+	if err != nil {
+		return nil, err
+	} else if !s.dirs[inner] {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: syscall.ENOTDIR}
+	}
+	children, _ := archiveChildren(s.root, s.list, inner, s.mtime)
+	entries := make([]os.DirEntry, len(children))
+	for i, el := range children {
+		entries[i] = fs.FileInfoToDirEntry(el)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (s *archiveStorage) Open(name string) (File, error) {
+	info, err := s.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	f := &archiveFile{s: s, name: name, info: info.(archiveInfo)}
+	f.rangedReader = rangedReader{get: f.get, size: info.Size()}
+	return f, nil
+}
+
+func (s *archiveStorage) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrPermission}
+	}
+	return s.Open(name)
+}
+
+func readOnly(op string, name string) error {
+	return &os.PathError{Op: op, Path: name, Err: os.ErrPermission}
+}
+
+func (s *archiveStorage) Create(name string) (File, error)          { return nil, readOnly("open", name) }
+func (s *archiveStorage) Mkdir(name string, perm os.FileMode) error { return readOnly("mkdir", name) }
+func (s *archiveStorage) MkdirAll(name string, perm os.FileMode) error {
+	return readOnly("mkdir", name)
+}
+func (s *archiveStorage) Rename(oldname string, newname string) error {
+	return readOnly("rename", oldname)
+}
+func (s *archiveStorage) Remove(name string) error                  { return readOnly("remove", name) }
+func (s *archiveStorage) RemoveAll(name string) error               { return readOnly("remove", name) }
+func (s *archiveStorage) Chmod(name string, mode os.FileMode) error { return readOnly("chmod", name) }
+func (s *archiveStorage) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return readOnly("chtimes", name)
+}
+
+// archiveFile reads a member, at random for the ones stored as is, else
+// inflating it again from its start whenever reads aren't sequential
+type archiveFile struct {
+	s    *archiveStorage
+	name string
+	info archiveInfo
+	rangedReader
+	dirLister
+}
+
+func (f *archiveFile) Name() string               { return f.name }
+func (f *archiveFile) Stat() (os.FileInfo, error) { return f.info, nil }
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func (f *archiveFile) get(off int64, end int64) (io.ReadCloser, error) {
+	m := f.info.m
+	switch {
+	case m.zf != nil && m.zf.Method == zip.Store:
+		start, err := m.zf.DataOffset()
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(io.NewSectionReader(f.s.f, start+off, m.size-off)), nil
+	case m.zf != nil:
+		rc, err := m.zf.Open()
+		if err != nil {
+			return nil, err
+		}
+		if _, err = io.CopyN(io.Discard, rc, off); err != nil {
+			rc.Close()
+			return nil, err
+		}
+		return rc, nil
+	case !f.s.gzipped:
+		return io.NopCloser(io.NewSectionReader(f.s.f, m.offset+off, m.size-off)), nil
+	}
+	gz, err := gzip.NewReader(io.NewSectionReader(f.s.f, 0, f.s.size))
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.CopyN(io.Discard, gz, m.offset+off); err != nil { // gzip can't seek, what's before is inflated and dropped
+		return nil, err
+	}
+	return readCloser{io.LimitReader(gz, m.size-off), gz}, nil
+}
+
+func (f *archiveFile) Read(p []byte) (int, error) {
+	if f.info.IsDir() {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: syscall.EISDIR}
+	}
+	return f.rangedReader.Read(p)
+}
+
+func (f *archiveFile) ReadAt(p []byte, off int64) (int, error) {
+	if f.info.IsDir() {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: syscall.EISDIR}
+	}
+	return f.rangedReader.ReadAt(p, off)
+}
+
+func (f *archiveFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += f.size
+	}
+	if offset < 0 {
+		return f.off, &os.PathError{Op: "seek", Path: f.name, Err: syscall.EINVAL}
+	}
+	f.off = offset
+	return offset, nil
+}
+
+func (f *archiveFile) Write(p []byte) (int, error)              { return 0, readOnly("write", f.name) }
+func (f *archiveFile) WriteAt(p []byte, off int64) (int, error) { return 0, readOnly("write", f.name) }
+func (f *archiveFile) Truncate(size int64) error                { return readOnly("truncate", f.name) }
+
+func (f *archiveFile) Close() error {
+	f.closeBody()
+	return nil
+}
+
+func (f *archiveFile) Readdir(count int) ([]os.FileInfo, error) {
+	if !f.info.IsDir() {
+		return nil, &os.PathError{Op: "readdir", Path: f.name, Err: syscall.ENOTDIR}
+	}
+	return f.readdir(func() ([]os.DirEntry, error) { return f.s.ReadDir(f.name) }, count)
+}
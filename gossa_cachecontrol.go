@@ -0,0 +1,42 @@
+package gossa
+
+import (
+	"net/http"
+	"strings"
+)
+
+type cacheRule struct {
+	pattern string
+	value   string
+}
+
+var cacheRules []cacheRule
+
+// loadCacheRules parses rules such as "*.iso=max-age=31536000, immutable;docs/**=no-cache"
+func loadCacheRules(list string) {
+	cacheRules = nil
+	for _, el := range strings.Split(list, ";") {
+		pattern, value, found := strings.Cut(el, "=")
+		if found && strings.TrimSpace(pattern) != "" {
+			cacheRules = append(cacheRules, cacheRule{strings.TrimSpace(pattern), strings.TrimSpace(value)})
+		}
+	}
+}
+
+// applyCacheControl sets the header of the first rule matching a path relative to the root
+func applyCacheControl(w http.ResponseWriter, rel string) {
+	for _, rule := range cacheRules {
+		if globMatch(rule.pattern, rel) {
+			w.Header().Set("Cache-Control", rule.value)
+			return
+		}
+	}
+}
+
+// cacheHandler applies the cache policy before handing over to the file server
+func cacheHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		applyCacheControl(w, r.URL.Path)
+		h.ServeHTTP(w, r)
+	})
+}
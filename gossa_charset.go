@@ -0,0 +1,143 @@
+package gossa
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	xunicode "golang.org/x/text/encoding/unicode"
+)
+
+// legacy multibyte charsets tried on files that aren't utf-8, before falling
+// back to latin-1, with the bytes starting their double byte characters
+var legacyCharsets = []struct {
+	name string
+	enc  encoding.Encoding
+	lead func(byte) bool
+}{
+	{"shift_jis", japanese.ShiftJIS, func(c byte) bool { return c >= 0x81 && c <= 0x9f || c >= 0xe0 && c <= 0xfc }},
+	{"gbk", simplifiedchinese.GBK, func(c byte) bool { return c >= 0x81 && c <= 0xfe }},
+}
+
+// plausiblePairs tells if the double byte characters of a text look like
+// cjk rather than latin-1 accents followed by ascii letters, the trail byte
+// of real ones being mostly past ascii
+func plausiblePairs(b []byte, lead func(byte) bool) bool {
+	pairs, asciiTrails := 0, 0
+	for i := 0; i < len(b); i++ {
+		if lead(b[i]) && i+1 < len(b) {
+			pairs++
+			if b[i+1] < 0x80 {
+				asciiTrails++
+			}
+			i++
+		}
+	}
+	return pairs > 0 && asciiTrails*10 < pairs*6
+}
+
+// cleanDecode decodes text, failing on invalid sequences and control characters
+func cleanDecode(enc encoding.Encoding, b []byte) ([]byte, bool) {
+	out, err := enc.NewDecoder().Bytes(b)
+	if err != nil || bytes.ContainsRune(out, utf8.RuneError) {
+		return nil, false
+	}
+	for _, r := range string(out) {
+		if unicode.IsControl(r) && r != '\n' && r != '\r' && r != '\t' && r != '\f' {
+			return nil, false
+		}
+	}
+	return out, true
+}
+
+// kanaShare is the share of kana in the non ascii characters of a text,
+// japanese having plenty where chinese has none
+func kanaShare(s []byte) float64 {
+	kana, wide := 0, 0
+	for _, r := range string(s) {
+		if r >= 0x80 {
+			wide++
+		}
+		if unicode.Is(unicode.Hiragana, r) || r >= 0x30a0 && r <= 0x30ff { // not the half width katakana chinese decodes to
+			kana++
+		}
+	}
+	return float64(kana) / float64(max(wide, 1))
+}
+
+// decodeText turns text into utf-8, from the charset given, else the one
+// detected from its bom or content. Returns the charset, empty for binary files
+func decodeText(b []byte, charset string) ([]byte, string, error) {
+	if charset != "" {
+		enc, err := htmlindex.Get(charset)
+		if err != nil {
+			return nil, "", badCall("unknown charset %s", charset)
+		}
+		name, _ := htmlindex.Name(enc)
+		out, err := enc.NewDecoder().Bytes(b)
+		return out, name, err
+	}
+
+	switch {
+	case bytes.HasPrefix(b, []byte{0xef, 0xbb, 0xbf}):
+		return b[3:], "utf-8", nil
+	case bytes.HasPrefix(b, []byte{0xff, 0xfe}), bytes.HasPrefix(b, []byte{0xfe, 0xff}):
+		out, err := xunicode.UTF16(xunicode.LittleEndian, xunicode.ExpectBOM).NewDecoder().Bytes(b[:len(b)&^1])
+		name := "utf-16le"
+		if b[0] == 0xfe {
+			name = "utf-16be"
+		}
+		return out, name, err
+	case bytes.IndexByte(b, 0) >= 0:
+		return nil, "", errNotText
+	case utf8.Valid(b):
+		return b, "utf-8", nil
+	}
+
+	var found []string
+	decoded := map[string][]byte{}
+	for _, el := range legacyCharsets {
+		if out, ok := cleanDecode(el.enc, b); ok && plausiblePairs(b, el.lead) {
+			found = append(found, el.name)
+			decoded[el.name] = out
+		}
+	}
+	switch {
+	case len(found) == 2 && kanaShare(decoded["shift_jis"]) < 0.05:
+		return decoded["gbk"], "gbk", nil // both make sense, but no kana hints at chinese
+	case len(found) > 0:
+		return decoded[found[0]], found[0], nil
+	}
+	out, ok := cleanDecode(charmap.Windows1252, b) // latin-1, and then some
+	if !ok {
+		return nil, "", errNotText
+	}
+	return out, "windows-1252", nil
+}
+
+// textAPI serves api/text?path=&charset=, the start of a text file in
+// utf-8, transcoded from its charset, detected unless given
+func textAPI(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	defer exitPath(w, "text", q.Get("path"))
+	fullPath := enforcePath(q.Get("path"))
+	stat, err := store.Stat(fullPath)
+	check(err)
+	if !stat.Mode().IsRegular() {
+		check(badCall("not a file"))
+	}
+	text, charset, cut, err := readPreview(fullPath, q.Get("charset"))
+	check(err)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Gossa-Charset", charset)
+	w.Header().Set("Gossa-Truncated", strconv.FormatBool(cut))
+	w.Header().Set("ETag", fileETag(stat))
+	http.ServeContent(w, r, "", stat.ModTime(), bytes.NewReader(text))
+}
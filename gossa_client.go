@@ -0,0 +1,167 @@
+package gossa
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+// the client subcommands, e.g. gossa ls http://host:8001/ /some/folder,
+// talking to a remote gossa over its versioned api
+var clientCommands = map[string]func(remote string, args []string, stdout io.Writer) error{
+	"ls":  clientLs,
+	"get": clientGet,
+	"put": clientPut,
+	"rm":  clientRm,
+	"mv":  clientMv,
+}
+
+const clientUsage = `usage: ./gossa [-token TOKEN] ls  REMOTE_URL PATH
+       ./gossa [-token TOKEN] get REMOTE_URL PATH [LOCAL|-]
+       ./gossa [-token TOKEN] put REMOTE_URL PATH [LOCAL|-]
+       ./gossa [-token TOKEN] rm  REMOTE_URL PATH
+       ./gossa [-token TOKEN] mv  REMOTE_URL SRC DST
+`
+
+// clientMain runs a client subcommand, args being the command then its arguments
+func clientMain(args []string, stdout io.Writer) error {
+	cmd := clientCommands[args[0]]
+	if cmd == nil || len(args) < 3 {
+		return errors.New(clientUsage)
+	}
+	return cmd(args[1], args[2:], stdout)
+}
+
+// IsClientCommand tells if a command line is a client subcommand, e.g. ls
+func IsClientCommand(name string) bool {
+	return clientCommands[name] != nil
+}
+
+// Client runs a client subcommand, args being the command then its
+// arguments, authenticating with cfg.Token
+func Client(cfg Config, args []string, stdout io.Writer) error {
+	conf.Token = cfg.Token
+	return clientMain(args, stdout)
+}
+
+// clientDo sends a request to the api of a remote gossa, turning error replies into errors
+func clientDo(method string, remote string, resource string, p string, query url.Values, body io.Reader) (*http.Response, error) {
+	base, err := url.Parse(remote)
+	if err != nil {
+		return nil, err
+	}
+	base.Path = strings.TrimSuffix(base.Path, "/") + "/api/v1/" + resource + path.Clean("/"+p)
+	base.RawQuery = query.Encode()
+	req, err := http.NewRequest(method, base.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if *clientToken != "" {
+		req.Header.Set("Authorization", "Bearer "+*clientToken)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 300 {
+		defer res.Body.Close()
+		var reply struct{ Error, Message string }
+		b, _ := io.ReadAll(io.LimitReader(res.Body, 1<<16))
+		if json.Unmarshal(b, &reply) != nil || reply.Message == "" {
+			reply.Message = strings.TrimSpace(string(b))
+		}
+		return nil, fmt.Errorf("%s %s: %s (%s)", method, p, reply.Message, res.Status)
+	}
+	return res, nil
+}
+
+func clientLs(remote string, args []string, stdout io.Writer) error {
+	res, err := clientDo(http.MethodGet, remote, "list", args[0], nil, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	var l listing
+	if err := json.NewDecoder(res.Body).Decode(&l); err != nil {
+		return err
+	}
+	for _, el := range l.Entries {
+		size, name := humanize(el.Size), el.Name
+		if el.Type == "dir" {
+			size, name = "-", name+"/"
+		}
+		fmt.Fprintf(stdout, "%9s  %s  %s\n", size, el.Mtime.Local().Format(*dateFormat), name)
+	}
+	return nil
+}
+
+// clientLocal is the local side of a transfer, defaulting to the base name of the remote path
+func clientLocal(args []string) string {
+	if len(args) > 1 {
+		return args[1]
+	}
+	return path.Base(path.Clean("/" + args[0]))
+}
+
+func clientGet(remote string, args []string, stdout io.Writer) error {
+	res, err := clientDo(http.MethodGet, remote, "files", args[0], nil, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	dst := stdout
+	if local := clientLocal(args); local != "-" {
+		f, err := os.Create(local)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		dst = f
+	}
+	_, err = io.Copy(dst, res.Body)
+	return err
+}
+
+func clientPut(remote string, args []string, stdout io.Writer) error {
+	src := io.Reader(os.Stdin)
+	if local := clientLocal(args); local != "-" {
+		f, err := os.Open(local)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		src = f
+	}
+	res, err := clientDo(http.MethodPut, remote, "files", args[0], url.Values{"overwrite": {"true"}}, src)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+func clientRm(remote string, args []string, stdout io.Writer) error {
+	res, err := clientDo(http.MethodDelete, remote, "files", args[0], nil, nil)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+func clientMv(remote string, args []string, stdout io.Writer) error {
+	if len(args) != 2 {
+		return errors.New(clientUsage)
+	}
+	call, _ := json.Marshal(rpcCall{Call: "mv", Args: []string{path.Clean("/" + args[0]), path.Clean("/" + args[1])}})
+	res, err := clientDo(http.MethodPost, remote, "rpc", "", nil, bytes.NewReader(call))
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
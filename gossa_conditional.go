@@ -0,0 +1,62 @@
+package gossa
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// listingValidator accumulates everything a listing depends on into a weak etag
+type listingValidator struct {
+	h       hash.Hash
+	lastMod time.Time
+}
+
+func newListingValidator(dir os.FileInfo, r *http.Request, format string) *listingValidator {
+	v := &listingValidator{h: sha1.New(), lastMod: dir.ModTime()}
+	lang := ""
+	if format != "json" {
+		lang, _ = pageLang(r)
+	}
+	fmt.Fprintf(v.h, "%s\x00%s\x00%t\x00%t\x00%s\x00%s\x00%s\x00%s\n", ui.Load().digest, *extraPath, *ro, *skipHidden, *hide, r.URL.RequestURI(), format, lang)
+	return v
+}
+
+func (v *listingValidator) add(el os.FileInfo) {
+	fmt.Fprintf(v.h, "%s\x00%d\x00%d\x00%t\n", el.Name(), el.Size(), el.ModTime().UnixNano(), el.IsDir())
+	if el.ModTime().After(v.lastMod) {
+		v.lastMod = el.ModTime()
+	}
+}
+
+// addTotal accounts for the entries out of the page listed
+func (v *listingValidator) addTotal(n int) {
+	fmt.Fprintf(v.h, "total %d\n", n)
+}
+
+// notModified sets the validators on the response, and replies 304 if the client copy is still fresh
+func (v *listingValidator) notModified(w http.ResponseWriter, r *http.Request) bool {
+	etag := `W/"` + hex.EncodeToString(v.h.Sum(nil)) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", v.lastMod.UTC().Format(http.TimeFormat))
+
+	fresh := false
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, el := range strings.Split(inm, ",") {
+			el = strings.TrimSpace(el)
+			fresh = fresh || el == "*" || strings.TrimPrefix(el, "W/") == strings.TrimPrefix(etag, "W/")
+		}
+	} else if ims, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil {
+		fresh = !v.lastMod.Truncate(time.Second).After(ims)
+	}
+
+	if fresh {
+		w.WriteHeader(http.StatusNotModified)
+	}
+	return fresh
+}
@@ -0,0 +1,368 @@
+package gossa
+
+import (
+	"flag"
+	"os"
+	"time"
+)
+
+// Config is how a gossa is set up, each field being the command line flag in
+// its comment, documented in its -help
+type Config struct {
+	Root                 string        // the folder shared
+	Storage              Storage       // where Root lives, the os when nil
+	Host                 string        // -h
+	Port                 string        // -p
+	Prefix               string        // -prefix
+	Symlinks             bool          // -symlinks
+	SymlinksAllow        string        // -symlinks-allow
+	Verbose              bool          // -verb
+	SkipHidden           bool          // -k
+	Hide                 string        // -hide
+	ReadOnly             bool          // -ro
+	ExtractMaxSize       int64         // -extract-max-size
+	ExtractMaxEntries    int64         // -extract-max-entries
+	MimeTypes            string        // -mime-types
+	Disposition          string        // -disposition
+	CacheControl         string        // -cache-control
+	CacheControlListings string        // -cache-control-listings
+	FolderSizes          bool          // -folder-sizes
+	DuCacheTTL           time.Duration // -du-cache-ttl
+	ListCache            time.Duration // -list-cache
+	Live                 bool          // -live
+	DownloadRate         int64         // -dl-rate
+	Offload              string        // -offload
+	OffloadPrefix        string        // -offload-prefix
+	Precompressed        bool          // -precompressed
+	SumCache             string        // -sum-cache
+	TorrentTrackers      string        // -torrent-trackers
+	Trash                bool          // -trash
+	TrashRetention       time.Duration // -trash-retention
+	Versions             int           // -versions
+	EditMaxSize          int64         // -edit-max-size
+	Webhooks             string        // -webhook
+	WebhookSecret        string        // -webhook-secret
+	FindMaxResults       int           // -find-max-results
+	DAV                  bool          // -dav
+	SFTPAddr             string        // -sftp
+	SFTPUsers            string        // -sftp-users
+	SFTPHostKey          string        // -sftp-host-key
+	FTPAddr              string        // -ftp
+	FTPPasvPorts         string        // -ftp-pasv-ports
+	FTPTLSCert           string        // -ftp-tls-cert
+	FTPTLSKey            string        // -ftp-tls-key
+	S3Addr               string        // -s3
+	S3Bucket             string        // -s3-bucket
+	S3AccessKey          string        // -s3-access-key
+	S3SecretKey          string        // -s3-secret-key
+	S3Backend            string        // -s3-backend
+	S3BackendAccessKey   string        // -s3-backend-access-key
+	S3BackendSecretKey   string        // -s3-backend-secret-key
+	S3BackendRegion      string        // -s3-backend-region
+	DAVBackend           string        // -dav-backend
+	DAVBackendUser       string        // -dav-backend-user
+	DAVBackendPassword   string        // -dav-backend-password
+	DAVBackendConns      int           // -dav-backend-conns
+	Mounts               string        // -mount
+	Overlay              string        // -overlay
+	Tmpfs                bool          // -tmpfs
+	TmpfsMaxSize         int64         // -tmpfs-max-size
+	FeedSize             int           // -feed-size
+	WalkMaxResults       int           // -walk-max-results
+	Token                string        // -token
+	FindTimeout          time.Duration // -find-timeout
+	Index                bool          // -index
+	IndexPath            string        // -index-path
+	IndexMaxSize         int64         // -index-max-size
+	IndexExts            string        // -index-exts
+	IndexWatch           bool          // -index-watch
+	Sort                 string        // -sort
+	DateFormat           string        // -date-format
+	ServeIndex           bool          // -serve-index
+	SPA                  string        // -spa
+	FFmpeg               string        // -ffmpeg
+	FFmpegTimeout        time.Duration // -ffmpeg-timeout
+	ImgMaxSize           int           // -img-max-size
+	FFmpegProcs          int           // -ffmpeg-procs
+	HLS                  bool          // -hls
+	HLSProfiles          string        // -hls-profiles
+	HLSSegment           time.Duration // -hls-segment
+	HLSIdle              time.Duration // -hls-idle
+	PreviewMaxSize       int64         // -preview-max-size
+	PreviewStyle         string        // -preview-style
+	Readme               string        // -readme
+	Perms                bool          // -perms
+	PageSize             int           // -page-size
+	ZipCache             string        // -zip-cache
+	ZipExclude           string        // -zip-exclude
+	ZipProcs             int           // -zip-procs
+	ZipCompress          bool          // -zip-compress
+	ZipQueue             time.Duration // -zip-queue
+	ZipMaxFiles          int64         // -zip-max-files
+	ZipMaxSize           int64         // -zip-max-size
+	ZipMaxDepth          int           // -zip-max-depth
+	MaxRequests          int           // -max-requests
+	MaxConns             int           // -max-conns
+	Theme                string        // -theme
+	ThemeReload          bool          // -theme-reload
+	ThemeName            string        // -theme-name
+	TitlePrefix          string        // -title-prefix
+	Logo                 string        // -logo
+	Favicon              string        // -favicon
+	HeaderFile           string        // -header-file
+	FooterFile           string        // -footer-file
+	Lang                 string        // -lang
+	SizeUnits            string        // -size-units
+	SizePrecision        int           // -size-precision
+	Icons                string        // -icons
+	SortLocale           string        // -sort-locale
+}
+
+// DefaultConfig is the config of a gossa started without flags
+func DefaultConfig() Config {
+	return Config{
+		Host:              "127.0.0.1",
+		Port:              "8001",
+		Prefix:            "/",
+		SkipHidden:        true,
+		ExtractMaxSize:    10 << 30,
+		ExtractMaxEntries: 100000,
+		DuCacheTTL:        30 * time.Second,
+		OffloadPrefix:     "/gossa-internal/",
+		Trash:             true,
+		TrashRetention:    30 * 24 * time.Hour,
+		EditMaxSize:       1 << 20,
+		FindMaxResults:    500,
+		S3Bucket:          "gossa",
+		S3BackendRegion:   "us-east-1",
+		DAVBackendConns:   16,
+		FeedSize:          50,
+		WalkMaxResults:    100000,
+		Token:             os.Getenv("GOSSA_TOKEN"),
+		FindTimeout:       5 * time.Second,
+		IndexMaxSize:      1 << 20,
+		IndexExts:         "txt,md,markdown,rst,org,tex,html,htm,xml,json,yaml,yml,toml,ini,conf,cfg,csv,log,css,js,ts,go,py,rb,rs,c,h,cpp,java,sh,sql",
+		Sort:              "name",
+		DateFormat:        "2006-01-02 15:04",
+		FFmpeg:            "ffmpeg",
+		FFmpegTimeout:     10 * time.Second,
+		ImgMaxSize:        4096,
+		FFmpegProcs:       2,
+		HLSProfiles:       "480p=854x480@1200k,720p=1280x720@3000k,1080p=1920x1080@6000k",
+		HLSSegment:        6 * time.Second,
+		HLSIdle:           5 * time.Minute,
+		PreviewMaxSize:    1 << 20,
+		PreviewStyle:      "github",
+		Readme:            "README.md",
+		ZipQueue:          30 * time.Second,
+		ThemeName:         "dark",
+		SizeUnits:         "short",
+		SizePrecision:     1,
+	}
+}
+
+// Flags registers the fields of c as command line flags, defaulting to their current values
+func (c *Config) Flags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Host, "h", c.Host, "host to listen to")
+	fs.StringVar(&c.Port, "p", c.Port, "port to listen to")
+	fs.StringVar(&c.Prefix, "prefix", c.Prefix, "url prefix at which gossa can be reached, e.g. /gossa/ (slashes of importance)")
+	fs.BoolVar(&c.Symlinks, "symlinks", c.Symlinks, "follow symlinks \033[4mWARNING\033[0m: symlinks will by nature allow to escape the defined path (default: false)")
+	fs.StringVar(&c.SymlinksAllow, "symlinks-allow", c.SymlinksAllow, "folders symlinks may lead to out of the path shared when not following all of them, e.g. /mnt/media,/srv/photos")
+	fs.BoolVar(&c.Verbose, "verb", c.Verbose, "verbosity")
+	fs.BoolVar(&c.SkipHidden, "k", c.SkipHidden, "\nskip hidden files")
+	fs.StringVar(&c.Hide, "hide", c.Hide, "globs of files and folders hidden as dot files are, e.g. *.tmp,Thumbs.db,~$*")
+	fs.BoolVar(&c.ReadOnly, "ro", c.ReadOnly, "read only mode (no upload, rename, move, etc...)")
+	fs.Int64Var(&c.ExtractMaxSize, "extract-max-size", c.ExtractMaxSize, "maximum total size in bytes unpacked by the extract rpc")
+	fs.Int64Var(&c.ExtractMaxEntries, "extract-max-entries", c.ExtractMaxEntries, "maximum number of entries unpacked by the extract rpc")
+	fs.StringVar(&c.MimeTypes, "mime-types", c.MimeTypes, "apache style .types file overriding content-types per extension")
+	fs.StringVar(&c.Disposition, "disposition", c.Disposition, "comma separated content-disposition per extension, e.g. \"html=attachment,mkv=inline\"")
+	fs.StringVar(&c.CacheControl, "cache-control", c.CacheControl, "semicolon separated cache-control per glob pattern, e.g. \"*.iso=max-age=31536000, immutable;*.html=no-cache\"")
+	fs.StringVar(&c.CacheControlListings, "cache-control-listings", c.CacheControlListings, "cache-control header of directory listings, e.g. no-store")
+	fs.BoolVar(&c.FolderSizes, "folder-sizes", c.FolderSizes, "show the recursive size of folders in listings, can be expensive on large trees")
+	fs.DurationVar(&c.DuCacheTTL, "du-cache-ttl", c.DuCacheTTL, "how long recursive folder sizes are cached")
+	fs.BoolVar(&c.Live, "live", c.Live, "push the changes of the folder viewed to the ui as they happen, over server-sent events at <prefix>events?path=")
+	fs.DurationVar(&c.ListCache, "list-cache", c.ListCache, "how long folder listings are cached, dropped early as gossa writes or inotify reports changes, e.g. 10m for slow disks (default: disabled)")
+	fs.Int64Var(&c.DownloadRate, "dl-rate", c.DownloadRate, "per download rate limit in bytes per second, applies to files and zips (default: unlimited)")
+	fs.StringVar(&c.Offload, "offload", c.Offload, "let the front proxy send files, either x-accel (nginx) or x-sendfile (apache, lighttpd)")
+	fs.StringVar(&c.OffloadPrefix, "offload-prefix", c.OffloadPrefix, "nginx internal location mapped to the shared directory, used with -offload=x-accel")
+	fs.BoolVar(&c.Precompressed, "precompressed", c.Precompressed, "serve precompressed siblings, e.g. foo.js.br or foo.js.gz for foo.js, to clients accepting them")
+	fs.StringVar(&c.SumCache, "sum-cache", c.SumCache, "file where computed checksums are persisted across restarts (default: memory only)")
+	fs.StringVar(&c.TorrentTrackers, "torrent-trackers", c.TorrentTrackers, "comma separated trackers announced in generated torrents (default: trackerless, webseed only)")
+	fs.BoolVar(&c.Trash, "trash", c.Trash, "move removed items to a trash folder, restorable until purged")
+	fs.DurationVar(&c.TrashRetention, "trash-retention", c.TrashRetention, "how long items are kept in the trash, 0 keeps them until purged manually")
+	fs.IntVar(&c.Versions, "versions", c.Versions, "number of previous versions kept when files are overwritten by uploads or moves, 0 disables versioning")
+	fs.Int64Var(&c.EditMaxSize, "edit-max-size", c.EditMaxSize, "max size in bytes of text files read and saved through api/file")
+	fs.StringVar(&c.Webhooks, "webhook", c.Webhooks, "comma separated urls notified with a json post on uploads, deletions, renames and new folders")
+	fs.StringVar(&c.WebhookSecret, "webhook-secret", c.WebhookSecret, "key signing webhook bodies, sent as a X-Gossa-Signature: sha256=<hmac> header")
+	fs.IntVar(&c.FindMaxResults, "find-max-results", c.FindMaxResults, "max number of results of the find rpc")
+	fs.BoolVar(&c.DAV, "dav", c.DAV, "serve the folder over webdav at <prefix>dav/, for native mounts from file managers and rclone")
+	fs.StringVar(&c.SFTPAddr, "sftp", c.SFTPAddr, "also serve the folder over sftp at this address, e.g. :2022 - requires -sftp-users")
+	fs.StringVar(&c.SFTPUsers, "sftp-users", c.SFTPUsers, "sftp accounts file, one user:bcrypt-hash[:home] per line as made by htpasswd -nB, homes relative to the folder shared")
+	fs.StringVar(&c.SFTPHostKey, "sftp-host-key", c.SFTPHostKey, "ssh private key of the sftp server, a new one is made at each start if unset")
+	fs.StringVar(&c.FTPAddr, "ftp", c.FTPAddr, "also serve the folder over ftp at this address, e.g. :2121 - accounts are the ones of -sftp-users")
+	fs.StringVar(&c.FTPPasvPorts, "ftp-pasv-ports", c.FTPPasvPorts, "port range of ftp passive data connections, e.g. 50000-50100 (default: any)")
+	fs.StringVar(&c.FTPTLSCert, "ftp-tls-cert", c.FTPTLSCert, "certificate enabling explicit ftps (AUTH TLS)")
+	fs.StringVar(&c.FTPTLSKey, "ftp-tls-key", c.FTPTLSKey, "private key of -ftp-tls-cert")
+	fs.StringVar(&c.S3Addr, "s3", c.S3Addr, "also serve the folder as a s3 bucket at this address, path style, e.g. :9000")
+	fs.StringVar(&c.S3Bucket, "s3-bucket", c.S3Bucket, "name of the bucket the folder is served as")
+	fs.StringVar(&c.S3AccessKey, "s3-access-key", c.S3AccessKey, "access key s3 requests must be signed with (sigv4), unsigned requests are accepted when unset")
+	fs.StringVar(&c.S3SecretKey, "s3-secret-key", c.S3SecretKey, "secret key of -s3-access-key")
+	fs.StringVar(&c.S3Backend, "s3-backend", c.S3Backend, "serve a s3 bucket rather than a folder, as http(s)://host:port/bucket[/prefix], e.g. https://minio.lan:9000/photos")
+	fs.StringVar(&c.S3BackendAccessKey, "s3-backend-access-key", c.S3BackendAccessKey, "access key requests to -s3-backend are signed with (sigv4), unsigned when unset")
+	fs.StringVar(&c.S3BackendSecretKey, "s3-backend-secret-key", c.S3BackendSecretKey, "secret key of -s3-backend-access-key")
+	fs.StringVar(&c.S3BackendRegion, "s3-backend-region", c.S3BackendRegion, "region of -s3-backend")
+	fs.StringVar(&c.DAVBackend, "dav-backend", c.DAVBackend, "serve a folder of another webdav server rather than a local one, as http(s)://host/path/, e.g. https://cloud.lan/remote.php/dav/files/me/")
+	fs.StringVar(&c.DAVBackendUser, "dav-backend-user", c.DAVBackendUser, "basic auth user of -dav-backend")
+	fs.StringVar(&c.DAVBackendPassword, "dav-backend-password", c.DAVBackendPassword, "basic auth password of -dav-backend, defaults to $GOSSA_DAV_BACKEND_PASSWORD")
+	fs.IntVar(&c.DAVBackendConns, "dav-backend-conns", c.DAVBackendConns, "idle connections kept open to -dav-backend")
+	fs.StringVar(&c.Overlay, "overlay", c.Overlay, "folder writes land in, laid over the folder shared, which then is never written to")
+	fs.BoolVar(&c.Tmpfs, "tmpfs", c.Tmpfs, "serve an empty folder kept in memory, gone on exit, rather than a local one")
+	fs.Int64Var(&c.TmpfsMaxSize, "tmpfs-max-size", c.TmpfsMaxSize, "max total size in bytes of the files of -tmpfs, 0 for no limit")
+	fs.StringVar(&c.Mounts, "mount", c.Mounts, "folders shown as top level folders, as name=/dir,name2=/dir2. without a folder to share, only these are")
+	fs.IntVar(&c.FeedSize, "feed-size", c.FeedSize, "number of recently modified files listed by ?feed=atom")
+	fs.IntVar(&c.WalkMaxResults, "walk-max-results", c.WalkMaxResults, "max number of files returned by api/walk")
+	fs.StringVar(&c.Token, "token", c.Token, "bearer token the ls/get/put/rm/mv client subcommands authenticate with, e.g. to a gossa behind an authenticating proxy (default: $GOSSA_TOKEN)")
+	fs.DurationVar(&c.FindTimeout, "find-timeout", c.FindTimeout, "max time spent walking folders by the find rpc")
+	fs.BoolVar(&c.Index, "index", c.Index, "index the content of text files in the background, searchable at /search?q=")
+	fs.StringVar(&c.IndexPath, "index-path", c.IndexPath, "file the search index is saved to (default: .gossa-index in the served directory)")
+	fs.Int64Var(&c.IndexMaxSize, "index-max-size", c.IndexMaxSize, "max size in bytes of indexed files")
+	fs.StringVar(&c.IndexExts, "index-exts", c.IndexExts, "comma separated extensions of indexed files")
+	fs.BoolVar(&c.IndexWatch, "index-watch", c.IndexWatch, "keep the search index updated from changes made outside of gossa, with inotify and the likes")
+	fs.StringVar(&c.Sort, "sort", c.Sort, "default sort of listings, one of name, natural, collate, size or mtime - overridden by ?sort=")
+	fs.StringVar(&c.SortLocale, "sort-locale", c.SortLocale, "language whose alphabet orders names with -sort=collate, e.g. sv or ja (default: the unicode root order)")
+	fs.StringVar(&c.DateFormat, "date-format", c.DateFormat, "go time layout of the modification dates shown in listings")
+	fs.BoolVar(&c.ServeIndex, "serve-index", c.ServeIndex, "serve the index.html of folders containing one instead of their listing, ?list still lists them")
+	fs.StringVar(&c.SPA, "spa", c.SPA, "folder holding a single page app, e.g. /app/: the missing paths below it are served its index.html, and the folder itself served as its index.html")
+	fs.StringVar(&c.FFmpeg, "ffmpeg", c.FFmpeg, "ffmpeg binary making video thumbnails, empty to disable")
+	fs.DurationVar(&c.FFmpegTimeout, "ffmpeg-timeout", c.FFmpegTimeout, "time after which an ffmpeg making a video thumbnail is killed")
+	fs.IntVar(&c.ImgMaxSize, "img-max-size", c.ImgMaxSize, "max width and height of the images resized by img?path=")
+	fs.IntVar(&c.FFmpegProcs, "ffmpeg-procs", c.FFmpegProcs, "max number of ffmpeg run at once")
+	fs.BoolVar(&c.HLS, "hls", c.HLS, "serve videos as hls with hls?path=, transcoded on the fly by ffmpeg so browsers can play any codec")
+	fs.StringVar(&c.HLSProfiles, "hls-profiles", c.HLSProfiles, "comma separated hls renditions, as name=WIDTHxHEIGHT@BITRATEk")
+	fs.DurationVar(&c.HLSSegment, "hls-segment", c.HLSSegment, "duration of the hls segments")
+	fs.DurationVar(&c.HLSIdle, "hls-idle", c.HLSIdle, "time after which the transcoded segments of an hls session not watched anymore are removed")
+	fs.Int64Var(&c.PreviewMaxSize, "preview-max-size", c.PreviewMaxSize, "max size in bytes of text files highlighted by preview?path=, larger ones are cut")
+	fs.StringVar(&c.PreviewStyle, "preview-style", c.PreviewStyle, "chroma style of the highlighted previews, e.g. monokai or dracula")
+	fs.StringVar(&c.Readme, "readme", c.Readme, "markdown file rendered above the listing of the folders containing one, empty to disable")
+	fs.BoolVar(&c.Perms, "perms", c.Perms, "show permissions and owner of entries in listings")
+	fs.IntVar(&c.PageSize, "page-size", c.PageSize, "default number of entries per listing page, 0 lists everything - overridden by ?limit=")
+	fs.StringVar(&c.ZipCache, "zip-cache", c.ZipCache, "directory where generated zips are kept, allowing resumed downloads of unchanged folders (default: disabled)")
+	fs.StringVar(&c.ZipExclude, "zip-exclude", c.ZipExclude, "comma separated glob patterns skipped in zip and tar downloads, e.g. \"node_modules/**,*.iso\"")
+	fs.BoolVar(&c.ZipCompress, "zip-compress", c.ZipCompress, "deflate the files of zip downloads, rather than storing them as is")
+	fs.IntVar(&c.ZipProcs, "zip-procs", c.ZipProcs, "max number of zip and tar downloads made at once, 0 for no limit")
+	fs.DurationVar(&c.ZipQueue, "zip-queue", c.ZipQueue, "how long zip and tar downloads wait for one of -zip-procs to be done, before replying 429")
+	fs.Int64Var(&c.ZipMaxFiles, "zip-max-files", c.ZipMaxFiles, "max number of files of zip and tar downloads, larger folders replied 413, 0 for no limit")
+	fs.Int64Var(&c.ZipMaxSize, "zip-max-size", c.ZipMaxSize, "max total size in bytes of the files of zip and tar downloads, 0 for no limit")
+	fs.IntVar(&c.ZipMaxDepth, "zip-max-depth", c.ZipMaxDepth, "max number of nested folders of zip and tar downloads, 0 for no limit")
+	fs.IntVar(&c.MaxRequests, "max-requests", c.MaxRequests, "max number of requests served at once, the others replied 503 right away, 0 for no limit")
+	fs.IntVar(&c.MaxConns, "max-conns", c.MaxConns, "max number of client connections open at once, the others replied 503 and closed, 0 for no limit")
+	fs.StringVar(&c.Theme, "theme", c.Theme, "folder whose ui.tmpl, style.css, script.js and favicon.svg replace the embedded ones (default: disabled)")
+	fs.StringVar(&c.ThemeName, "theme-name", c.ThemeName, "palette of the ui: dark, light, auto to follow the browser, or the name of a css file of -theme applied over style.css")
+	fs.StringVar(&c.TitlePrefix, "title-prefix", c.TitlePrefix, "prefix of the title of pages, e.g. \"Acme files - \"")
+	fs.StringVar(&c.Logo, "logo", c.Logo, "image shown above listings, e.g. a company logo (default: none)")
+	fs.StringVar(&c.Favicon, "favicon", c.Favicon, "icon of the pages, an svg, png or ico file (default: the gossa one)")
+	fs.BoolVar(&c.ThemeReload, "theme-reload", c.ThemeReload, "reload the files of -theme as they change, e.g. while working on a theme")
+	fs.StringVar(&c.HeaderFile, "header-file", c.HeaderFile, "html file shown above listings, e.g. a download policy, sanitized: only external scripts are kept (default: disabled)")
+	fs.StringVar(&c.Lang, "lang", c.Lang, "language of the ui, one of en, fr, de and es (default: from the browser's Accept-Language)")
+	fs.StringVar(&c.FooterFile, "footer-file", c.FooterFile, "html file shown below listings, e.g. contact info, sanitized as -header-file (default: disabled)")
+	fs.StringVar(&c.SizeUnits, "size-units", c.SizeUnits, "units of the sizes shown: short (1.5k, 1.5M, powers of 1024), si (1.5kB, powers of 1000) or binary (1.5KiB)")
+	fs.IntVar(&c.SizePrecision, "size-precision", c.SizePrecision, "decimals of the sizes shown")
+	fs.StringVar(&c.Icons, "icons", c.Icons, "json file mapping extensions to an icon of the ui or an image data uri, e.g. {\"dng\": \"jpg\", \"parquet\": \"csv\"} (default: none)")
+}
+
+// the config in use, read through the pointers below
+var conf = DefaultConfig()
+
+var host = &conf.Host
+var port = &conf.Port
+var extraPath = &conf.Prefix
+var symlinks = &conf.Symlinks
+var symlinksAllow = &conf.SymlinksAllow
+var verb = &conf.Verbose
+var skipHidden = &conf.SkipHidden
+var hide = &conf.Hide
+var ro = &conf.ReadOnly
+var extractMaxSize = &conf.ExtractMaxSize
+var extractMaxEntries = &conf.ExtractMaxEntries
+var mimeTypes = &conf.MimeTypes
+var disposition = &conf.Disposition
+var cacheControl = &conf.CacheControl
+var cacheControlListings = &conf.CacheControlListings
+var folderSizes = &conf.FolderSizes
+var duCacheTTL = &conf.DuCacheTTL
+var listCacheTTL = &conf.ListCache
+var liveOn = &conf.Live
+var dlRate = &conf.DownloadRate
+var offload = &conf.Offload
+var offloadPrefix = &conf.OffloadPrefix
+var precompressed = &conf.Precompressed
+var sumCachePath = &conf.SumCache
+var torrentTrackers = &conf.TorrentTrackers
+var trash = &conf.Trash
+var trashRetention = &conf.TrashRetention
+var versions = &conf.Versions
+var editMaxSize = &conf.EditMaxSize
+var webhooks = &conf.Webhooks
+var webhookSecret = &conf.WebhookSecret
+var findMaxResults = &conf.FindMaxResults
+var dav = &conf.DAV
+var sftpAddr = &conf.SFTPAddr
+var sftpUsers = &conf.SFTPUsers
+var sftpHostKey = &conf.SFTPHostKey
+var ftpAddr = &conf.FTPAddr
+var ftpPasvPorts = &conf.FTPPasvPorts
+var ftpTLSCert = &conf.FTPTLSCert
+var ftpTLSKey = &conf.FTPTLSKey
+var s3Addr = &conf.S3Addr
+var s3Bucket = &conf.S3Bucket
+var s3AccessKey = &conf.S3AccessKey
+var s3SecretKey = &conf.S3SecretKey
+var feedSize = &conf.FeedSize
+var walkMaxResults = &conf.WalkMaxResults
+var clientToken = &conf.Token
+var findTimeout = &conf.FindTimeout
+var indexOn = &conf.Index
+var indexPath = &conf.IndexPath
+var indexMaxSize = &conf.IndexMaxSize
+var indexExts = &conf.IndexExts
+var indexWatch = &conf.IndexWatch
+var sortDefault = &conf.Sort
+var dateFormat = &conf.DateFormat
+var serveIndex = &conf.ServeIndex
+var spa = &conf.SPA
+var ffmpeg = &conf.FFmpeg
+var ffmpegTimeout = &conf.FFmpegTimeout
+var imgMaxSize = &conf.ImgMaxSize
+var ffmpegProcs = &conf.FFmpegProcs
+var hls = &conf.HLS
+var hlsProfilesFlag = &conf.HLSProfiles
+var hlsSegment = &conf.HLSSegment
+var hlsIdle = &conf.HLSIdle
+var previewMaxSize = &conf.PreviewMaxSize
+var previewStyle = &conf.PreviewStyle
+var readme = &conf.Readme
+var showPerms = &conf.Perms
+var pageSize = &conf.PageSize
+var zipCache = &conf.ZipCache
+var zipExclude = &conf.ZipExclude
+var zipProcs = &conf.ZipProcs
+var zipCompress = &conf.ZipCompress
+var zipQueue = &conf.ZipQueue
+var zipMaxFiles = &conf.ZipMaxFiles
+var zipMaxSize = &conf.ZipMaxSize
+var zipMaxDepth = &conf.ZipMaxDepth
+var maxRequests = &conf.MaxRequests
+var maxConns = &conf.MaxConns
+var theme = &conf.Theme
+var themeReload = &conf.ThemeReload
+var themeName = &conf.ThemeName
+var titlePrefix = &conf.TitlePrefix
+var logo = &conf.Logo
+var favicon = &conf.Favicon
+var headerFile = &conf.HeaderFile
+var footerFile = &conf.FooterFile
+var lang = &conf.Lang
+var sizeUnits = &conf.SizeUnits
+var sizePrecision = &conf.SizePrecision
+var icons = &conf.Icons
+var sortLocale = &conf.SortLocale
@@ -0,0 +1,39 @@
+package gossa
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// copyBufSize is the size of the buffers transfers go through, larger than
+// the 32k io.Copy allocates for each
+const copyBufSize = 256 << 10
+
+var copyBufs = sync.Pool{New: func() any {
+	b := make([]byte, copyBufSize)
+	return &b
+}}
+
+// copyBuffer is io.Copy with a pooled buffer, sparing an allocation per
+// transfer. Readers and writers copying by themselves, e.g. a file sent to a
+// tcp connection with sendfile, still do
+func copyBuffer(dst io.Writer, src io.Reader) (int64, error) {
+	buf := copyBufs.Get().(*[]byte)
+	defer copyBufs.Put(buf)
+	return io.CopyBuffer(dst, src, *buf)
+}
+
+// ctxReader fails reads once its context is done, so transfers stop with the
+// client instead of reading on into a dead connection
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
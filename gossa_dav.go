@@ -0,0 +1,168 @@
+package gossa
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/net/webdav"
+)
+
+// davFS maps webdav onto the served folder, with the checks and side effects
+// of the rpc calls: read only, hidden and internal paths, trash, versions,
+// webhooks and caches
+type davFS struct{}
+
+// davFile hides what listings hide, and reports uploads once closed
+type davFile struct {
+	File
+	fullPath string
+	written  bool
+	isNew    bool
+}
+
+// davPath resolves a webdav path, the ones out of reach looking absent as in listings
+func davPath(name string) (string, error) {
+	fullPath, err := safePath(name)
+	if err != nil {
+		return "", &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist} // webdav only checks with os.IsNotExist
+	}
+	return fullPath, nil
+}
+
+func davWritable(name string) (string, error) {
+	if *ro {
+		return "", &os.PathError{Op: "write", Path: name, Err: os.ErrPermission}
+	}
+	return davPath(name)
+}
+
+func (davFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	fullPath, err := davWritable(name)
+	if err != nil {
+		return err
+	}
+	if err = store.Mkdir(fullPath, perm); err == nil {
+		notify("mkdir", fullPath, "")
+		changed(fullPath)
+	}
+	return err
+}
+
+func (davFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	writes := flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0
+	fullPath, err := davPath(name)
+	if writes {
+		fullPath, err = davWritable(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	_, err = store.Lstat(fullPath)
+	isNew := errors.Is(err, os.ErrNotExist)
+	if flag&os.O_TRUNC != 0 && !isNew {
+		if err := keepVersion(fullPath); err != nil {
+			return nil, err
+		}
+	}
+	f, err := store.OpenFile(fullPath, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &davFile{File: f, fullPath: fullPath, written: writes, isNew: isNew}, nil
+}
+
+func (davFS) RemoveAll(ctx context.Context, name string) error {
+	fullPath, err := davWritable(name)
+	if err != nil {
+		return err
+	} else if fullPath == rootPath {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrPermission}
+	}
+	return discard(fullPath)
+}
+
+func (davFS) Rename(ctx context.Context, oldName, newName string) error {
+	src, err := davWritable(oldName)
+	if err != nil {
+		return err
+	}
+	dst, err := davWritable(newName)
+	if err != nil {
+		return err
+	}
+	if err = keepVersion(dst); err != nil {
+		return err
+	}
+	if err = moveAny(src, dst); err == nil {
+		notify("rename", src, dst)
+		changed(src)
+		changed(dst)
+	}
+	return err
+}
+
+func (davFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	fullPath, err := davPath(name)
+	if err != nil {
+		return nil, err
+	}
+	return store.Stat(fullPath)
+}
+
+func (f *davFile) Readdir(count int) ([]fs.FileInfo, error) {
+	infos, err := f.File.Readdir(count)
+	visible := infos[:0]
+	for _, el := range infos {
+		if p := filepath.Join(f.fullPath, el.Name()); isHiddenPath(p) || isInternal(p) {
+			continue
+		}
+		if !*symlinks && el.Mode()&os.ModeSymlink != 0 {
+			continue
+		}
+		visible = append(visible, el)
+	}
+	return visible, err
+}
+
+func (f *davFile) Close() error {
+	err := f.File.Close()
+	if f.written && err == nil {
+		if f.isNew {
+			journalPush(journalEntry{op: "upload", dst: f.fullPath})
+		}
+		notify("upload", f.fullPath, "")
+		changed(f.fullPath)
+	}
+	return err
+}
+
+// davHandler serves the webdav mount at <prefix>dav/
+func davHandler() http.Handler {
+	h := &webdav.Handler{
+		Prefix:     *extraPath + "dav",
+		FileSystem: davFS{},
+		LockSystem: webdav.NewMemLS(),
+		Logger: func(r *http.Request, err error) {
+			if err != nil && *verb {
+				log.Println("dav", r.Method, r.URL.Path, err)
+			}
+		},
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if *ro {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND":
+			default:
+				replyError(w, fmt.Errorf("read only: %w", os.ErrPermission))
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
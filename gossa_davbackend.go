@@ -0,0 +1,444 @@
+package gossa
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const davPropfind = `<?xml version="1.0" encoding="utf-8"?><D:propfind xmlns:D="DAV:"><D:prop><D:resourcetype/><D:getcontentlength/><D:getlastmodified/></D:prop></D:propfind>`
+
+// davStorage serves a folder of another webdav server (nextcloud, sharepoint,
+// gossa -dav...), with basic auth, keeping its connections to it open
+type davStorage struct {
+	base   *url.URL // the folder served, its path ending with a slash
+	user   string
+	pass   string
+	client *http.Client
+}
+
+// newDavStorage parses a backend url as http(s)://[user:pass@]host[:port]/path/,
+// the credentials of the url, then $GOSSA_DAV_BACKEND_PASSWORD, standing in for unset ones
+func newDavStorage(raw string, user string, pass string, conns int) (*davStorage, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid webdav backend %s, expected e.g. https://cloud.lan/remote.php/dav/files/me/", raw)
+	}
+	if u.User != nil {
+		if user == "" {
+			user = u.User.Username()
+		}
+		if p, ok := u.User.Password(); ok && pass == "" {
+			pass = p
+		}
+	}
+	if pass == "" {
+		pass = os.Getenv("GOSSA_DAV_BACKEND_PASSWORD")
+	}
+	base := &url.URL{Scheme: u.Scheme, Host: u.Host, Path: strings.TrimSuffix(u.Path, "/") + "/"}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = conns // listings and zips fire many requests at the same host
+	client := &http.Client{
+		Transport:     transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }, // redirects would turn propfinds into gets
+	}
+	return &davStorage{base, user, pass, client}, nil
+}
+
+// path is the url path of a name, ending with a slash for folders
+func (s *davStorage) path(name string, dir bool) string {
+	rel, err := filepath.Rel(rootPath, name)
+	if err != nil || rel == "." {
+		return s.base.Path
+	}
+	p := s.base.Path + filepath.ToSlash(rel)
+	if dir {
+		p += "/"
+	}
+	return p
+}
+
+func (s *davStorage) url(p string) string {
+	u := *s.base
+	u.Path = p
+	return u.String()
+}
+
+// do sends a request, turning error replies into errors
+func (s *davStorage) do(method string, p string, body io.Reader, headers ...string) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.url(p), body)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	if n, err := strconv.ParseInt(req.Header.Get("Content-Length"), 10, 64); err == nil {
+		req.ContentLength = n // of bodies NewRequest can't tell the length of
+	}
+	if s.user != "" || s.pass != "" {
+		req.SetBasicAuth(s.user, s.pass)
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	} else if res.StatusCode < 300 {
+		return res, nil
+	}
+	res.Body.Close()
+	name := "/" + strings.TrimPrefix(p, s.base.Path)
+	op := strings.ToLower(method)
+	switch res.StatusCode {
+	case http.StatusNotFound, http.StatusConflict: // conflicts being missing parents
+		return nil, &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, &os.PathError{Op: op, Path: name, Err: os.ErrPermission}
+	case http.StatusMethodNotAllowed, http.StatusPreconditionFailed: // mkcol on something, or a move not overwriting
+		return nil, &os.PathError{Op: op, Path: name, Err: os.ErrExist}
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return nil, &os.PathError{Op: op, Path: name, Err: errMoved}
+	}
+	return nil, fmt.Errorf("webdav %s %s: %s", method, name, res.Status)
+}
+
+// errMoved is a folder requested without its trailing slash, by some servers
+var errMoved = errors.New("moved")
+
+type davMultistatus struct {
+	Responses []struct {
+		Href      string `xml:"href"`
+		Propstats []struct {
+			Status     string    `xml:"status"`
+			Collection *struct{} `xml:"prop>resourcetype>collection"`
+			Length     int64     `xml:"prop>getcontentlength"`
+			Modified   string    `xml:"prop>getlastmodified"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+// propfind lists a path, and its children at a depth of 1, keyed by their
+// unescaped url path without trailing slash
+func (s *davStorage) propfind(p string, depth string) (map[string]remoteInfo, error) {
+	res, err := s.do("PROPFIND", p, strings.NewReader(davPropfind), "Depth", depth, "Content-Type", "application/xml; charset=utf-8")
+	if errors.Is(err, errMoved) && !strings.HasSuffix(p, "/") {
+		res, err = s.do("PROPFIND", p+"/", strings.NewReader(davPropfind), "Depth", depth, "Content-Type", "application/xml; charset=utf-8")
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var ms davMultistatus
+	if err = xml.NewDecoder(res.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("webdav propfind %s: %w", p, err)
+	}
+	infos := make(map[string]remoteInfo, len(ms.Responses))
+	for _, el := range ms.Responses {
+		href, err := url.Parse(el.Href)
+		if err != nil {
+			continue
+		}
+		key := strings.TrimSuffix(href.Path, "/")
+		for _, ps := range el.Propstats {
+			if !strings.Contains(ps.Status, " 200") {
+				continue
+			}
+			mtime, _ := http.ParseTime(ps.Modified)
+			infos[key] = remoteInfo{path.Base(key), ps.Length, mtime, ps.Collection != nil}
+		}
+	}
+	return infos, nil
+}
+
+func (s *davStorage) Stat(name string) (os.FileInfo, error) {
+	p := strings.TrimSuffix(s.path(name, false), "/")
+	infos, err := s.propfind(p, "0")
+	if err != nil {
+		return nil, err
+	}
+	info, ok := infos[p]
+	if !ok && len(infos) == 1 { // servers replying hrefs of their own, e.g. cased differently
+		for _, el := range infos {
+			info, ok = el, true
+		}
+	}
+	if !ok {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	info.name = filepath.Base(name)
+	return info, nil
+}
+
+func (s *davStorage) Lstat(name string) (os.FileInfo, error) {
+	return s.Stat(name)
+}
+
+func (s *davStorage) ReadDir(name string) ([]os.DirEntry, error) {
+	p := s.path(name, true)
+	infos, err := s.propfind(p, "1")
+	if err != nil {
+		return nil, err
+	}
+	self, ok := infos[strings.TrimSuffix(p, "/")]
+	if ok && !self.dir {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: syscall.ENOTDIR}
+	}
+	var entries []os.DirEntry
+	for key, el := range infos {
+		if key != strings.TrimSuffix(p, "/") {
+			entries = append(entries, fs.FileInfoToDirEntry(el))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (s *davStorage) Open(name string) (File, error) {
+	info, err := s.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	f := &davBackendFile{s: s, name: name, info: info.(remoteInfo)}
+	f.rangedReader = rangedReader{get: f.get, size: f.info.size}
+	return f, nil
+}
+
+// OpenFile opens files for reading, or for writing them whole, as a single
+// put once closed: webdav has no partial writes
+func (s *davStorage) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return s.Open(name)
+	} else if flag&(os.O_RDWR|os.O_APPEND) != 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: errUnsupported}
+	}
+	info, err := s.Stat(name)
+	switch {
+	case err == nil && info.IsDir():
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	case err == nil && flag&os.O_EXCL != 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	case err == nil && flag&os.O_TRUNC == 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: errUnsupported}
+	case errors.Is(err, os.ErrNotExist) && flag&os.O_CREATE == 0:
+		return nil, err
+	case err != nil && !errors.Is(err, os.ErrNotExist):
+		return nil, err
+	}
+	spool, err := os.CreateTemp("", "gossa-dav-*")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(spool.Name()) // gone once closed
+	return &davBackendFile{s: s, name: name, info: remoteInfo{name: filepath.Base(name), mtime: time.Now()}, spool: spool}, nil
+}
+
+func (s *davStorage) Create(name string) (File, error) {
+	return s.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (s *davStorage) Mkdir(name string, perm os.FileMode) error {
+	res, err := s.do("MKCOL", s.path(name, true), nil)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+func (s *davStorage) MkdirAll(name string, perm os.FileMode) error {
+	info, err := s.Stat(name)
+	if err == nil && info.IsDir() {
+		return nil
+	} else if err == nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: syscall.ENOTDIR}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if parent := filepath.Dir(name); parent != name && strings.HasPrefix(parent, rootPath) {
+		if err = s.MkdirAll(parent, perm); err != nil {
+			return err
+		}
+	}
+	if err = s.Mkdir(name, perm); errors.Is(err, os.ErrExist) {
+		return nil // made meanwhile
+	}
+	return err
+}
+
+func (s *davStorage) delete(name string, dir bool) error {
+	res, err := s.do(http.MethodDelete, s.path(name, dir), nil)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+// Remove refuses non empty folders, as a webdav delete takes them whole
+func (s *davStorage) Remove(name string) error {
+	info, err := s.Stat(name)
+	if err != nil {
+		return err
+	} else if info.IsDir() {
+		entries, err := s.ReadDir(name)
+		if err != nil {
+			return err
+		} else if len(entries) > 0 {
+			return &os.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+		}
+	}
+	return s.delete(name, info.IsDir())
+}
+
+func (s *davStorage) RemoveAll(name string) error {
+	info, err := s.Stat(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return s.delete(name, info.IsDir())
+}
+
+func (s *davStorage) Rename(oldname string, newname string) error {
+	info, err := s.Stat(oldname)
+	if err != nil {
+		return err
+	}
+	res, err := s.do("MOVE", s.path(oldname, info.IsDir()), nil, "Destination", s.url(s.path(newname, info.IsDir())), "Overwrite", "T")
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+// Chtimes is a noop, files being dated by the server on their last write
+func (s *davStorage) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return nil
+}
+
+// Chmod is a noop, webdav having no permissions
+func (s *davStorage) Chmod(name string, mode os.FileMode) error {
+	return nil
+}
+
+// davBackendFile reads a file with ranged gets, or spools one to a temp
+// file to put it once closed, so servers get its length upfront
+type davBackendFile struct {
+	s    *davStorage
+	name string
+	info remoteInfo
+	rangedReader
+
+	spool   *os.File // when writing
+	written int64
+
+	dirLister
+}
+
+func (f *davBackendFile) Name() string               { return f.name }
+func (f *davBackendFile) Stat() (os.FileInfo, error) { return f.info, nil }
+
+func (f *davBackendFile) get(off int64, end int64) (io.ReadCloser, error) {
+	rng := fmt.Sprintf("bytes=%d-", off)
+	if end >= 0 {
+		rng += strconv.FormatInt(end, 10)
+	}
+	res, err := f.s.do(http.MethodGet, f.s.path(f.name, false), nil, "Range", rng)
+	if err != nil {
+		return nil, err
+	} else if off > 0 && res.StatusCode != http.StatusPartialContent {
+		res.Body.Close()
+		return nil, fmt.Errorf("webdav get %s: ranges unsupported by the server", f.name)
+	}
+	return res.Body, nil
+}
+
+func (f *davBackendFile) Read(p []byte) (int, error) {
+	if f.spool != nil || f.info.dir {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: errUnsupported}
+	}
+	return f.rangedReader.Read(p)
+}
+
+func (f *davBackendFile) ReadAt(p []byte, off int64) (int, error) {
+	if f.spool != nil || f.info.dir {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: errUnsupported}
+	}
+	return f.rangedReader.ReadAt(p, off)
+}
+
+func (f *davBackendFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += f.info.size
+	}
+	if offset < 0 || f.spool != nil && offset != f.written {
+		return f.off, &os.PathError{Op: "seek", Path: f.name, Err: errUnsupported}
+	}
+	f.off = offset
+	return offset, nil
+}
+
+func (f *davBackendFile) Write(p []byte) (int, error) {
+	if f.spool == nil {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: os.ErrPermission}
+	}
+	n, err := f.spool.Write(p)
+	f.written += int64(n)
+	f.off = f.written
+	return n, err
+}
+
+// WriteAt only writes sequentially, as sftp clients do
+func (f *davBackendFile) WriteAt(p []byte, off int64) (int, error) {
+	if off != f.written {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: errUnsupported}
+	}
+	return f.Write(p)
+}
+
+func (f *davBackendFile) Truncate(size int64) error {
+	if f.spool == nil || size != f.written {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: errUnsupported}
+	}
+	return nil
+}
+
+// Close puts the spooled file
+func (f *davBackendFile) Close() error {
+	f.closeBody()
+	if f.spool == nil {
+		return nil
+	}
+	spool := f.spool
+	f.spool = nil
+	defer spool.Close()
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	res, err := f.s.do(http.MethodPut, f.s.path(f.name, false), spool, "Content-Length", strconv.FormatInt(f.written, 10))
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+func (f *davBackendFile) Readdir(count int) ([]os.FileInfo, error) {
+	if !f.info.dir {
+		return nil, &os.PathError{Op: "readdir", Path: f.name, Err: syscall.ENOTDIR}
+	}
+	return f.readdir(func() ([]os.DirEntry, error) { return f.s.ReadDir(f.name) }, count)
+}
@@ -0,0 +1,358 @@
+package gossa
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"lukechampine.com/blake3"
+)
+
+// Delta sync, rsync style. A signature lists the weak (rolling) and strong
+// checksums of each block of a file. A patch rebuilds a file from the blocks
+// of a base file and literal data, and is encoded as
+//
+//	"GDLT" uint32 block size
+//	then ops, 'C' uint64 index uint32 count: copy count blocks of the base from index
+//	          'D' uint32 n, n bytes: literal data
+//	          'E': end, truncated patches being refused
+//
+// all integers big endian. The server applies patches to its files on PUT
+// api/delta, and on POST builds the patch turning the file of a client into its own.
+
+const deltaMagic = "GDLT"
+const deltaMaxLiteral = 64 << 10
+const deltaMinBlock, deltaMaxBlock = 512, 1 << 20
+const deltaMaxSignature = 64 << 20 // bytes of json, a signature being ~80 per block
+
+type deltaBlock struct {
+	Weak   uint32 `json:"weak"`
+	Strong string `json:"strong"`
+}
+
+type deltaSignature struct {
+	Size   int64        `json:"size"`
+	Block  int          `json:"block"`
+	Blocks []deltaBlock `json:"blocks"`
+}
+
+// rollsum is the rolling checksum of rsync, over a window of n bytes
+type rollsum struct {
+	a, b uint32
+	n    uint32
+}
+
+func (r *rollsum) add(c byte) {
+	r.a += uint32(c)
+	r.b += r.a
+	r.n++
+}
+
+func (r *rollsum) remove(c byte) {
+	r.a -= uint32(c)
+	r.b -= r.n * uint32(c)
+	r.n--
+}
+
+func (r *rollsum) sum() uint32 {
+	return r.b<<16 | r.a&0xffff
+}
+
+func weakSum(b []byte) uint32 {
+	var r rollsum
+	for _, c := range b {
+		r.add(c)
+	}
+	return r.sum()
+}
+
+func strongSum(b []byte) string {
+	sum := blake3.Sum256(b)
+	return hex.EncodeToString(sum[:16])
+}
+
+// deltaBlockSize defaults to about the square root of the size, as rsync does
+func deltaBlockSize(size int64, asked string) int {
+	if n, err := strconv.Atoi(asked); err == nil && n >= deltaMinBlock && n <= deltaMaxBlock {
+		return n
+	}
+	n := int(math.Sqrt(float64(size))) &^ 1023
+	return min(max(n, 4096), 1<<17)
+}
+
+func signature(src io.Reader, size int64, block int) (*deltaSignature, error) {
+	sig := &deltaSignature{Size: size, Block: block, Blocks: []deltaBlock{}}
+	buf := make([]byte, block)
+	for {
+		n, err := io.ReadFull(src, buf)
+		if n > 0 {
+			sig.Blocks = append(sig.Blocks, deltaBlock{weakSum(buf[:n]), strongSum(buf[:n])})
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return sig, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// deltaWriter encodes patch ops, merging consecutive copies
+type deltaWriter struct {
+	w           *bufio.Writer
+	literal     []byte
+	copyFrom    int64
+	copyCount   uint32
+	copyPending bool
+}
+
+func (d *deltaWriter) flushCopy() {
+	if d.copyPending {
+		d.w.WriteByte('C')
+		binary.Write(d.w, binary.BigEndian, uint64(d.copyFrom))
+		binary.Write(d.w, binary.BigEndian, d.copyCount)
+		d.copyPending = false
+	}
+}
+
+func (d *deltaWriter) flushLiteral() {
+	if len(d.literal) > 0 {
+		d.w.WriteByte('D')
+		binary.Write(d.w, binary.BigEndian, uint32(len(d.literal)))
+		d.w.Write(d.literal)
+		d.literal = d.literal[:0]
+	}
+}
+
+func (d *deltaWriter) copyBlock(index int64) {
+	d.flushLiteral()
+	if d.copyPending && d.copyFrom+int64(d.copyCount) == index {
+		d.copyCount++
+		return
+	}
+	d.flushCopy()
+	d.copyFrom, d.copyCount, d.copyPending = index, 1, true
+}
+
+func (d *deltaWriter) data(c byte) {
+	d.flushCopy()
+	if d.literal = append(d.literal, c); len(d.literal) == deltaMaxLiteral {
+		d.flushLiteral()
+	}
+}
+
+// checkSignature refuses the signatures a patch can't be built against, e.g.
+// blocks too large to be held in memory
+func checkSignature(sig *deltaSignature) error {
+	if sig.Block < deltaMinBlock || sig.Block > deltaMaxBlock {
+		return badCall("invalid block size")
+	}
+	return nil
+}
+
+// writeDelta writes the patch rebuilding src out of the file sig describes
+func writeDelta(sig *deltaSignature, src io.Reader, dst io.Writer) error {
+	if err := checkSignature(sig); err != nil {
+		return err
+	}
+	index := map[uint32][]int64{}
+	for i, el := range sig.Blocks {
+		index[el.Weak] = append(index[el.Weak], int64(i))
+	}
+	lastLen := int(sig.Size - int64(len(sig.Blocks)-1)*int64(sig.Block)) // the last block may be short
+
+	d := &deltaWriter{w: bufio.NewWriter(dst)}
+	d.w.WriteString(deltaMagic)
+	binary.Write(d.w, binary.BigEndian, uint32(sig.Block))
+	r := bufio.NewReader(src)
+	window := make([]byte, 0, 2*sig.Block) // bytes past window[start:] were sent as literals
+	start := 0
+	var roll rollsum
+	eof := false
+
+	for {
+		for !eof && len(window)-start < sig.Block {
+			c, err := r.ReadByte()
+			if err == io.EOF {
+				eof = true
+				break
+			} else if err != nil {
+				return err
+			}
+			window = append(window, c)
+			roll.add(c)
+		}
+		win := window[start:]
+		if len(win) == 0 {
+			break
+		}
+
+		matched := false
+		if len(win) == sig.Block || eof && len(win) == lastLen {
+			for _, i := range index[roll.sum()] {
+				blockLen := sig.Block
+				if i == int64(len(sig.Blocks)-1) {
+					blockLen = lastLen
+				}
+				if blockLen == len(win) && sig.Blocks[i].Strong == strongSum(win) {
+					d.copyBlock(i)
+					window, start, roll, matched = window[:0], 0, rollsum{}, true
+					break
+				}
+			}
+		}
+		if !matched {
+			d.data(win[0])
+			roll.remove(win[0])
+			if start++; start >= sig.Block { // keep the window from growing
+				window = append(window[:0], window[start:]...)
+				start = 0
+			}
+		}
+	}
+	d.flushLiteral()
+	d.flushCopy()
+	d.w.WriteByte('E')
+	return d.w.Flush()
+}
+
+// applyDelta rebuilds a file out of its base and a patch
+func applyDelta(base io.ReaderAt, patch io.Reader, dst io.Writer) error {
+	r := bufio.NewReader(patch)
+	head := make([]byte, 8)
+	if _, err := io.ReadFull(r, head); err != nil || string(head[:4]) != deltaMagic {
+		return badCall("not a delta patch")
+	}
+	block := int64(binary.BigEndian.Uint32(head[4:]))
+	for {
+		op, err := r.ReadByte()
+		if err != nil {
+			return badCall("truncated delta patch")
+		}
+		switch op {
+		case 'C':
+			var from uint64
+			var count uint32
+			if binary.Read(r, binary.BigEndian, &from) != nil || binary.Read(r, binary.BigEndian, &count) != nil {
+				return badCall("truncated delta patch")
+			}
+			n, err := copyBuffer(dst, io.NewSectionReader(base, int64(from)*block, int64(count)*block))
+			if err != nil {
+				return err
+			} else if n == 0 {
+				return badCall("delta patch copies past the end of the file")
+			}
+		case 'D':
+			var n uint32
+			if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+				return badCall("truncated delta patch")
+			}
+			if _, err := io.CopyN(dst, r, int64(n)); err != nil {
+				return badCall("truncated delta patch")
+			}
+		case 'E':
+			return nil
+		default:
+			return badCall("invalid delta op %q", op)
+		}
+	}
+}
+
+// deltaAPI serves api/delta?path=, GET replying the signature of a file,
+// PUT applying a patch to it, and POST with the signature of a client's copy
+// replying the patch to bring it up to date
+func deltaAPI(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	defer exitPath(w, "delta", r.Method, q.Get("path"))
+	fullPath := enforcePath(q.Get("path"))
+	stat, err := store.Stat(fullPath)
+	if r.Method != http.MethodPut || !errors.Is(err, os.ErrNotExist) {
+		check(err)
+		if !stat.Mode().IsRegular() {
+			check(badCall("not a file"))
+		}
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		f, err := store.Open(fullPath)
+		check(err)
+		defer f.Close()
+		sig, err := signature(bufio.NewReader(f), stat.Size(), deltaBlockSize(stat.Size(), q.Get("block")))
+		check(err)
+		b, err := json.Marshal(sig)
+		check(err)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", fileETag(stat))
+		w.Write(b)
+
+	case http.MethodPost:
+		var sig deltaSignature
+		check(json.NewDecoder(io.LimitReader(r.Body, deltaMaxSignature)).Decode(&sig))
+		check(checkSignature(&sig))
+		f, err := store.Open(fullPath)
+		check(err)
+		defer f.Close()
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("ETag", fileETag(stat))
+		// streamed, a patch failing halfway lacks its end op and is refused by clients
+		check(writeDelta(&sig, f, w))
+
+	case http.MethodPut:
+		if *ro {
+			check(fmt.Errorf("read only: %w", os.ErrPermission))
+		}
+		if match := r.Header.Get("If-Match"); match != "" && (stat == nil || match != fileETag(stat)) {
+			check(errChanged)
+		}
+		base := io.ReaderAt(bytes.NewReader(nil))
+		mode := os.FileMode(0644)
+		if stat != nil {
+			f, err := store.Open(fullPath)
+			check(err)
+			defer f.Close()
+			base, mode = f, stat.Mode().Perm()
+		}
+
+		tmp, err := createTemp(filepath.Dir(fullPath), ".gossa-delta-")
+		check(err)
+		defer store.Remove(tmp.Name())
+		out := bufio.NewWriter(tmp)
+		sum := blake3.New(32, nil)
+		err = applyDelta(base, r.Body, io.MultiWriter(out, sum))
+		if err == nil {
+			err = out.Flush()
+		}
+		if err == nil {
+			err = store.Chmod(tmp.Name(), mode)
+		}
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		check(err)
+		if want := q.Get("blake3"); want != "" && want != hex.EncodeToString(sum.Sum(nil)) {
+			check(badCall("patched file doesnt match its checksum"))
+		}
+		check(keepVersion(fullPath))
+		check(store.Rename(tmp.Name(), fullPath))
+		notify("upload", fullPath, "")
+		changed(fullPath)
+		stat, err = store.Stat(fullPath)
+		check(err)
+		w.Header().Set("ETag", fileETag(stat))
+		w.Write([]byte("ok"))
+
+	default:
+		w.Header().Set("Allow", "GET, POST, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
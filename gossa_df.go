@@ -0,0 +1,24 @@
+package gossa
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type diskUsage struct {
+	Free  uint64 `json:"free"`
+	Total uint64 `json:"total"`
+}
+
+// dfAPI answers api/df?path= with the free and total bytes where path lives
+func dfAPI(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	defer exitPath(w, "df", path)
+	free, total, err := storeSpace(enforcePath(path))
+	check(err)
+	b, err := json.Marshal(diskUsage{free, total})
+	check(err)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(b)
+}
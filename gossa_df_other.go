@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd)
+
+package gossa
+
+import "errors"
+
+// diskSpace isnt available on this platform
+func diskSpace(fullPath string) (uint64, uint64, error) {
+	return 0, 0, errors.New("disk space unavailable on this platform")
+}
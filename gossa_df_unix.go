@@ -0,0 +1,14 @@
+//go:build linux || darwin || freebsd
+
+package gossa
+
+import "syscall"
+
+// diskSpace returns the free and total bytes of the filesystem holding a path
+func diskSpace(fullPath string) (uint64, uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(fullPath, &st); err != nil {
+		return 0, 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), uint64(st.Blocks) * uint64(st.Bsize), nil
+}
@@ -0,0 +1,131 @@
+package gossa
+
+import (
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type dirUsage struct {
+	entries  int   // direct children
+	files    int   // files, recursively
+	size     int64 // bytes, recursively
+	lastMod  time.Time
+	computed time.Time
+}
+
+// recursive walks are expensive, results are kept for a little while
+var duCache = map[string]*dirUsage{}
+var duLock sync.Mutex
+
+// getDirUsage walks a folder, honoring the hidden files policy, and caches the result
+func getDirUsage(fullPath string) (*dirUsage, error) {
+	duLock.Lock()
+	cached := duCache[fullPath]
+	duLock.Unlock()
+	if cached != nil && time.Since(cached.computed) < *duCacheTTL {
+		return cached, nil
+	}
+
+	du := &dirUsage{}
+	err := walk(fullPath, func(path string, f fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != fullPath && (isHiddenPath(path) || isInternal(path)) {
+			if f.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil // hidden files not allowed
+		}
+		if filepath.Dir(path) == fullPath {
+			du.entries++
+		}
+		if f.ModTime().After(du.lastMod) {
+			du.lastMod = f.ModTime()
+		}
+		if f.Mode().IsRegular() {
+			du.files++
+			du.size += f.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	du.computed = time.Now()
+	duLock.Lock()
+	duCache[fullPath] = du
+	duLock.Unlock()
+	return du, nil
+}
+
+// replyDirHead describes a folder in headers only, so scripts can check it for changes cheaply
+func replyDirHead(w http.ResponseWriter, fullPath string) {
+	du, err := getDirUsage(fullPath)
+	check(err)
+	w.Header().Set("Gossa-Entries", strconv.Itoa(du.entries))
+	w.Header().Set("Gossa-Files", strconv.Itoa(du.files))
+	w.Header().Set("Gossa-Total-Size", strconv.FormatInt(du.size, 10))
+	w.Header().Set("Last-Modified", du.lastMod.UTC().Format(http.TimeFormat))
+}
+
+// invalidateDu drops cached usages of a path, of its parents and of its children
+func invalidateDu(fullPath string) {
+	duLock.Lock()
+	defer duLock.Unlock()
+	sep := string(filepath.Separator)
+	for key := range duCache {
+		if key == fullPath || strings.HasPrefix(fullPath, key+sep) || strings.HasPrefix(key, fullPath+sep) {
+			delete(duCache, key)
+		}
+	}
+}
+
+type duEntry struct {
+	Name  string `json:"name"`
+	IsDir bool   `json:"isDir"`
+	Size  int64  `json:"size"`
+	Files int    `json:"files"`
+	Human string `json:"human"`
+}
+
+// childrenUsage sizes the children of a folder, recursively for sub-folders
+func childrenUsage(fullPath string) ([]byte, error) {
+	entries, err := store.ReadDir(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	out := []duEntry{}
+	for _, el := range entries {
+		if p := filepath.Join(fullPath, el.Name()); isHiddenPath(p) || isInternal(p) {
+			continue // hidden files not allowed
+		}
+		if !*symlinks && el.Type()&os.ModeSymlink != 0 {
+			continue // dont follow symlinks if we're not allowed
+		}
+		child := filepath.Join(fullPath, el.Name())
+		stat, err := entryInfo(fullPath, el)
+		if err != nil {
+			continue
+		}
+		entry := duEntry{Name: el.Name(), IsDir: stat.IsDir(), Size: stat.Size(), Files: 1}
+		if stat.IsDir() {
+			du, err := getDirUsage(child)
+			if err != nil {
+				continue
+			}
+			entry.Size, entry.Files = du.size, du.files
+		}
+		entry.Human = humanize(entry.Size)
+		out = append(out, entry)
+	}
+	return json.Marshal(out)
+}
@@ -0,0 +1,86 @@
+package gossa
+
+import (
+	"context"
+	"encoding/json"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"sort"
+)
+
+type dupeGroup struct {
+	Size  int64    `json:"size"`
+	Sum   string   `json:"sum"`
+	Paths []string `json:"paths"`
+}
+
+type dupesResult struct {
+	Groups []dupeGroup `json:"groups"`
+	Wasted int64       `json:"wasted"` // bytes that could be freed keeping one file per group
+}
+
+// dupes groups identical files under fullPath. Only files sharing their size
+// with another are hashed, most wasteful groups come first. The scan stops once
+// ctx is done, and the sums computed are saved to -sum-cache as it finishes.
+func dupes(ctx context.Context, fullPath string) ([]byte, error) {
+	defer func() {
+		if err := saveSumCache(); err != nil {
+			log.Println("error - cant save sum cache", err)
+		}
+	}()
+	bySize := map[int64][]string{}
+	err := walk(fullPath, func(p string, f fs.FileInfo, err error) error {
+		if err != nil {
+			return nil // unreadable folders are skipped
+		} else if ctx.Err() != nil {
+			return ctx.Err()
+		} else if p != fullPath && (isHiddenPath(p) || isInternal(p)) {
+			if f.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if f.Mode().IsRegular() && f.Size() > 0 {
+			bySize[f.Size()] = append(bySize[f.Size()], p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := dupesResult{Groups: []dupeGroup{}}
+	for size, paths := range bySize {
+		if len(paths) < 2 {
+			continue
+		}
+		bySum := map[string][]string{}
+		for _, p := range paths {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			sum, err := fileSum(p, "blake3")
+			if err != nil {
+				continue // gone since
+			}
+			bySum[string(sum)] = append(bySum[string(sum)], relPath(p))
+		}
+		for sum, same := range bySum {
+			if len(same) > 1 {
+				sort.Strings(same)
+				res.Groups = append(res.Groups, dupeGroup{size, sum, same})
+				res.Wasted += size * int64(len(same)-1)
+			}
+		}
+	}
+
+	sort.Slice(res.Groups, func(i, j int) bool {
+		a, b := res.Groups[i], res.Groups[j]
+		if wa, wb := a.Size*int64(len(a.Paths)-1), b.Size*int64(len(b.Paths)-1); wa != wb {
+			return wa > wb
+		}
+		return a.Paths[0] < b.Paths[0]
+	})
+	return json.Marshal(res)
+}
@@ -0,0 +1,98 @@
+package gossa
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"unicode/utf8"
+)
+
+// fileETag identifies a version of a file from its size and mtime
+func fileETag(stat os.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, stat.Size(), stat.ModTime().UnixNano())
+}
+
+// fileAPI serves GET and PUT on api/file?path= for editing small text files.
+// PUT honors If-Match, and If-None-Match: * to only create new files.
+func fileAPI(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	defer exitPath(w, "file api", r.Method, path)
+	fullPath := enforcePath(path)
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		stat, err := store.Stat(fullPath)
+		check(err)
+		if !stat.Mode().IsRegular() {
+			replyError(w, badCall("not a file"))
+			return
+		} else if stat.Size() > *editMaxSize {
+			replyError(w, errTooLarge)
+			return
+		}
+		b, err := readFile(fullPath)
+		check(err)
+		if !utf8.Valid(b) {
+			replyError(w, errNotText)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("ETag", fileETag(stat))
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Write(b)
+
+	case http.MethodPut:
+		if *ro {
+			replyError(w, fmt.Errorf("read only: %w", os.ErrPermission))
+			return
+		}
+		stat, err := store.Stat(fullPath)
+		exists := err == nil
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			check(err)
+		} else if exists && !stat.Mode().IsRegular() {
+			replyError(w, badCall("not a file"))
+			return
+		}
+		match, noneMatch := r.Header.Get("If-Match"), r.Header.Get("If-None-Match")
+		if match != "" && (!exists || match != "*" && match != fileETag(stat)) || noneMatch == "*" && exists {
+			replyError(w, errChanged)
+			return
+		}
+
+		b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, *editMaxSize))
+		check(err)
+
+		// write aside then swap, so readers never see a half written file
+		mode := os.FileMode(0644)
+		if exists {
+			mode = stat.Mode().Perm()
+		}
+		tmp, err := createTemp(filepath.Dir(fullPath), ".gossa-edit-")
+		check(err)
+		defer store.Remove(tmp.Name())
+		_, err = tmp.Write(b)
+		if err == nil {
+			err = store.Chmod(tmp.Name(), mode)
+		}
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		check(err)
+		check(keepVersion(fullPath))
+		check(store.Rename(tmp.Name(), fullPath))
+		changed(fullPath)
+
+		stat, err = store.Stat(fullPath)
+		check(err)
+		w.Header().Set("ETag", fileETag(stat))
+		w.Write([]byte("ok"))
+
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -1,10 +1,14 @@
-package main
+package gossa
 
 import (
+	"crypto/sha1"
 	_ "embed"
 	"encoding/base64"
+	"fmt"
 	"html/template"
+	"net/http"
 	"strings"
+	"sync/atomic"
 )
 
 //go:embed ui/script.js
@@ -13,22 +17,70 @@ var scriptJs string
 //go:embed ui/style.css
 var styleCss string
 
+//go:embed ui/light.css
+var lightCss string
+
 //go:embed ui/favicon.svg
 var faviconSvg []byte
 
 //go:embed ui/ui.tmpl
 var uiTmpl string
 
-var tmpl *template.Template
+//go:embed ui/error.tmpl
+var errorTmpl string
+
+//go:embed ui/openapi.json
+var openapiJSON string
+
+// uiPage is the page template, along with what identifies it, so cached
+// listings are refreshed on upgrades and theme changes
+type uiPage struct {
+	tmpl    *template.Template
+	errTmpl *template.Template // the page of errors, in the same style
+	digest  string
+}
+
+// the page in use, swapped as a whole when a -theme is reloaded
+var ui atomic.Pointer[uiPage]
+
+// buildUI fills in the templates with their style, script and icon
+func buildUI(uiTmpl string, errorTmpl string, styleCss string, scriptJs string, favicon []byte) (*uiPage, error) {
+	fill := func(t string) string {
+		t = strings.Replace(t, "css_will_be_here", styleCss, 1)
+		t = strings.Replace(t, "js_will_be_here", scriptJs, 1)
+		t = strings.Replace(t, "favicon_will_be_here", base64.StdEncoding.EncodeToString(favicon), 2)
+		return strings.ReplaceAll(t, "favicon_type_will_be_here", imageType(favicon))
+	}
+	t := fill(uiTmpl)
+	tmpl, err := template.New("").Parse(t)
+	if err != nil {
+		return nil, err
+	}
+	errTmpl, err := template.New("").Parse(fill(errorTmpl))
+	if err != nil {
+		return nil, err
+	}
+	return &uiPage{tmpl: tmpl, errTmpl: errTmpl, digest: fmt.Sprintf("%x", sha1.Sum([]byte(t)))}, nil
+}
+
+// imageType is the type of an icon or logo, svg unless sniffed as another image
+func imageType(b []byte) string {
+	if t := http.DetectContentType(b); strings.HasPrefix(t, "image/") {
+		return t
+	}
+	return "image/svg+xml"
+}
+
+// dataURI inlines an image in the page
+func dataURI(b []byte) template.URL {
+	return template.URL("data:" + imageType(b) + ";base64," + base64.StdEncoding.EncodeToString(b))
+}
 
 // fill in template
 func init() {
-	var err error
-	t := strings.Replace(uiTmpl, "css_will_be_here", styleCss, 1)
-	t = strings.Replace(t, "js_will_be_here", scriptJs, 1)
-	t = strings.Replace(t, "favicon_will_be_here", base64.StdEncoding.EncodeToString(faviconSvg), 2)
-	tmpl, err = template.New("").Parse(t)
+	page, err := buildUI(uiTmpl, errorTmpl, styleCss, scriptJs, faviconSvg)
 	if err != nil {
 		panic(err)
 	}
+	ui.Store(page)
 }
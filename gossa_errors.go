@@ -0,0 +1,133 @@
+package gossa
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+)
+
+var errInvalidPath = errors.New("invalid path")
+var errTooLarge = errors.New("too large")
+var errChanged = errors.New("changed since last read")
+var errNotText = errors.New("not a text file")
+var errNotImage = errors.New("not an image")
+var errBusy = errors.New("too many archives being made, retry later")
+var errOverloaded = errors.New("too many requests being served, retry later")
+
+// statusClientClosed is nginx's status for requests the client left before the reply, only ever logged
+const statusClientClosed = 499
+
+// callError marks failures caused by the request itself, e.g. a missing argument
+type callError struct{ msg string }
+
+func (e *callError) Error() string { return e.msg }
+
+func badCall(format string, a ...any) error {
+	return &callError{fmt.Sprintf(format, a...)}
+}
+
+type apiError struct {
+	Code    string `json:"error"`
+	Message string `json:"message"`
+}
+
+// errorStatus maps an error to a http status, and a machine readable code
+func errorStatus(err error) (int, string) {
+	var callErr *callError
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &callErr), errors.As(err, &syntaxErr), errors.As(err, &typeErr):
+		return http.StatusBadRequest, "bad_request"
+	case errors.Is(err, errInvalidPath), errors.Is(err, os.ErrPermission):
+		return http.StatusForbidden, "forbidden"
+	case errors.Is(err, os.ErrNotExist):
+		return http.StatusNotFound, "not_found"
+	case errors.Is(err, os.ErrExist):
+		return http.StatusConflict, "conflict"
+	case errors.Is(err, errChanged):
+		return http.StatusPreconditionFailed, "precondition_failed"
+	case errors.Is(err, errExtractLimit), errors.Is(err, errArchiveLimit), errors.Is(err, errTooLarge), errors.As(err, &tooLarge):
+		return http.StatusRequestEntityTooLarge, "too_large"
+	case errors.Is(err, errNotText), errors.Is(err, errNotImage):
+		return http.StatusUnsupportedMediaType, "unsupported_media_type"
+	case errors.Is(err, syscall.ENOSPC), errors.Is(err, syscall.EDQUOT):
+		return http.StatusInsufficientStorage, "disk_full"
+	case errors.Is(err, errBusy):
+		return http.StatusTooManyRequests, "busy"
+	case errors.Is(err, errOverloaded):
+		return http.StatusServiceUnavailable, "overloaded"
+	case errors.Is(err, context.Canceled):
+		return statusClientClosed, "canceled"
+	}
+	return http.StatusInternalServerError, "internal"
+}
+
+// errorMessage describes an error without leaking where we're serving from
+func errorMessage(err error) string {
+	return strings.ReplaceAll(err.Error(), rootPath, "")
+}
+
+func replyError(w http.ResponseWriter, err error) {
+	status, code := errorStatus(err)
+	b, _ := json.Marshal(apiError{code, errorMessage(err)})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Del("Content-Disposition")
+	w.Header().Del("Content-Encoding")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+type errorTemplate struct {
+	Status      int
+	Text        string // what went wrong, in Lang
+	Message     string
+	Root        string
+	Lang        string
+	TitlePrefix string
+	Logo        template.URL
+	T           map[string]string
+}
+
+// wantsPage tells the page loads of browsers apart from scripts, which are replied json
+func wantsPage(r *http.Request) bool {
+	return listingFormat(r) == "html" && strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// replyErrorPage replies an error as a page in the style of the ui, with a
+// way back to the root, to browsers, and as json to the others
+func replyErrorPage(w http.ResponseWriter, r *http.Request, err error) {
+	status, _ := errorStatus(err)
+	if !wantsPage(r) || status == statusClientClosed {
+		replyError(w, err)
+		return
+	}
+	p := errorTemplate{Status: status, Message: errorMessage(err), Root: (&url.URL{Path: *extraPath}).EscapedPath()}
+	p.Lang, p.T = pageLang(r)
+	p.TitlePrefix, p.Logo = *titlePrefix, logoURI
+	if p.Text = p.T[fmt.Sprintf("error%d", status)]; p.Text == "" {
+		p.Text = strings.ToLower(http.StatusText(status))
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Add("Vary", "Accept, Accept-Language")
+	w.Header().Del("Content-Disposition")
+	w.Header().Del("Content-Encoding")
+	w.WriteHeader(status)
+	ui.Load().errTmpl.Execute(w, p)
+}
+
+// asError turns a recovered panic into an error
+func asError(r any) error {
+	if err, ok := r.(error); ok {
+		return err
+	}
+	return fmt.Errorf("%v", r)
+}
@@ -0,0 +1,311 @@
+package gossa
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"image"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type exifGPS struct {
+	Lat float64  `json:"lat"`
+	Lon float64  `json:"lon"`
+	Alt *float64 `json:"alt,omitempty"`
+}
+
+type exifInfo struct {
+	Taken       string   `json:"taken,omitempty"` // capture time, with its offset when recorded
+	Make        string   `json:"make,omitempty"`
+	Model       string   `json:"model,omitempty"`
+	Orientation int      `json:"orientation,omitempty"`
+	GPS         *exifGPS `json:"gps,omitempty"`
+}
+
+// tiff tags of interest, in IFD0, the exif IFD and the gps IFD
+const (
+	tagMake        = 0x010f
+	tagModel       = 0x0110
+	tagOrientation = 0x0112
+	tagDateTime    = 0x0132
+	tagExifIFD     = 0x8769
+	tagGPSIFD      = 0x8825
+	tagTakenAt     = 0x9003
+	tagTakenOffset = 0x9011
+	tagLatRef      = 1
+	tagLat         = 2
+	tagLonRef      = 3
+	tagLon         = 4
+	tagAltRef      = 5
+	tagAlt         = 6
+)
+
+var errNoExif = errors.New("no exif")
+
+// tiffTypeSizes are the sizes of the tiff field types, by type id
+var tiffTypeSizes = map[uint16]int64{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 7: 1, 9: 4, 10: 8}
+
+type tiffEntry struct {
+	typ   uint16
+	count uint32
+	raw   [4]byte // the value, or its offset when larger
+}
+
+// tiffReader reads the IFDs of a tiff structure, offsets being relative to its header
+type tiffReader struct {
+	r     io.ReaderAt
+	order binary.ByteOrder
+}
+
+func newTiffReader(r io.ReaderAt) (*tiffReader, error) {
+	head := make([]byte, 8)
+	if _, err := r.ReadAt(head, 0); err != nil {
+		return nil, errNoExif
+	}
+	t := &tiffReader{r: r}
+	switch string(head[:4]) {
+	case "II*\x00":
+		t.order = binary.LittleEndian
+	case "MM\x00*":
+		t.order = binary.BigEndian
+	default:
+		return nil, errNoExif
+	}
+	return t, nil
+}
+
+func (t *tiffReader) ifd(off int64) (map[uint16]tiffEntry, error) {
+	b := make([]byte, 2)
+	if _, err := t.r.ReadAt(b, off); err != nil {
+		return nil, err
+	}
+	n := int64(t.order.Uint16(b))
+	if n > 1000 {
+		return nil, errNoExif
+	}
+	b = make([]byte, n*12)
+	if _, err := t.r.ReadAt(b, off+2); err != nil {
+		return nil, err
+	}
+	entries := map[uint16]tiffEntry{}
+	for i := int64(0); i < n; i++ {
+		el := b[i*12:]
+		e := tiffEntry{typ: t.order.Uint16(el[2:]), count: t.order.Uint32(el[4:])}
+		copy(e.raw[:], el[8:12])
+		entries[t.order.Uint16(el)] = e
+	}
+	return entries, nil
+}
+
+func (t *tiffReader) value(e tiffEntry) []byte {
+	size := tiffTypeSizes[e.typ] * int64(e.count)
+	if size == 0 || size > 1<<16 {
+		return nil
+	} else if size <= 4 {
+		return e.raw[:size]
+	}
+	b := make([]byte, size)
+	if _, err := t.r.ReadAt(b, int64(t.order.Uint32(e.raw[:]))); err != nil {
+		return nil
+	}
+	return b
+}
+
+func (t *tiffReader) str(e tiffEntry) string {
+	return strings.TrimSpace(strings.TrimRight(string(t.value(e)), "\x00"))
+}
+
+func (t *tiffReader) uint(e tiffEntry) uint32 {
+	switch v := t.value(e); {
+	case e.typ == 3 && len(v) >= 2:
+		return uint32(t.order.Uint16(v))
+	case e.typ == 4 && len(v) >= 4:
+		return t.order.Uint32(v)
+	}
+	return 0
+}
+
+func (t *tiffReader) rationals(e tiffEntry) []float64 {
+	if e.typ != 5 {
+		return nil
+	}
+	v := t.value(e)
+	var out []float64
+	for i := 0; i+8 <= len(v); i += 8 {
+		num, den := t.order.Uint32(v[i:]), t.order.Uint32(v[i+4:])
+		if den == 0 {
+			return nil
+		}
+		out = append(out, float64(num)/float64(den))
+	}
+	return out
+}
+
+// degrees turns gps degrees, minutes, seconds into signed decimal degrees
+func degrees(dms []float64, ref string, negative string) (float64, bool) {
+	if len(dms) != 3 {
+		return 0, false
+	}
+	d := dms[0] + dms[1]/60 + dms[2]/3600
+	if ref == negative {
+		d = -d
+	}
+	return math.Round(d*1e7) / 1e7, true
+}
+
+// exifOffset finds where the exif tiff structure of a jpeg or tiff file starts
+func exifOffset(f io.Reader) (int64, error) {
+	r := bufio.NewReader(f)
+	head, err := r.Peek(4)
+	if err != nil {
+		return 0, errNoExif
+	} else if string(head) == "II*\x00" || string(head) == "MM\x00*" {
+		return 0, nil
+	} else if head[0] != 0xff || head[1] != 0xd8 {
+		return 0, errNoExif
+	}
+	r.Discard(2)
+	seg := make([]byte, 10)
+	for off := int64(2); ; {
+		if _, err := io.ReadFull(r, seg[:4]); err != nil || seg[0] != 0xff {
+			return 0, errNoExif
+		}
+		marker, length := seg[1], int64(binary.BigEndian.Uint16(seg[2:]))
+		if marker == 0xda || marker == 0xd9 || length < 2 {
+			return 0, errNoExif // image data reached
+		}
+		skip := length - 2
+		if marker == 0xe1 && length >= 8 {
+			if _, err := io.ReadFull(r, seg[4:10]); err != nil {
+				return 0, errNoExif
+			} else if string(seg[4:10]) == "Exif\x00\x00" {
+				return off + 10, nil
+			}
+			skip -= 6
+		}
+		if _, err := r.Discard(int(skip)); err != nil {
+			return 0, errNoExif
+		}
+		off += 2 + length
+	}
+}
+
+// readExif reads the capture date, camera, orientation and location of a photo
+func readExif(fullPath string) (*exifInfo, error) {
+	f, err := store.Open(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	off, err := exifOffset(f)
+	if err != nil {
+		return nil, err
+	}
+	t, err := newTiffReader(io.NewSectionReader(f, off, math.MaxInt64-off))
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, 4)
+	if _, err := t.r.ReadAt(b, 4); err != nil {
+		return nil, errNoExif
+	}
+	ifd0, err := t.ifd(int64(t.order.Uint32(b)))
+	if err != nil {
+		return nil, errNoExif
+	}
+
+	info := &exifInfo{Make: t.str(ifd0[tagMake]), Model: t.str(ifd0[tagModel]), Orientation: int(t.uint(ifd0[tagOrientation]))}
+	if info.Orientation > 8 {
+		info.Orientation = 0
+	}
+	taken, offset := t.str(ifd0[tagDateTime]), ""
+	if e, ok := ifd0[tagExifIFD]; ok {
+		if sub, err := t.ifd(int64(t.uint(e))); err == nil {
+			if s := t.str(sub[tagTakenAt]); s != "" {
+				taken, offset = s, t.str(sub[tagTakenOffset])
+			}
+		}
+	}
+	if when, err := time.Parse("2006:01:02 15:04:05", taken); err == nil {
+		info.Taken = when.Format("2006-01-02T15:04:05") + offset
+	}
+	if e, ok := ifd0[tagGPSIFD]; ok {
+		if gps, err := t.ifd(int64(t.uint(e))); err == nil {
+			lat, okLat := degrees(t.rationals(gps[tagLat]), t.str(gps[tagLatRef]), "S")
+			lon, okLon := degrees(t.rationals(gps[tagLon]), t.str(gps[tagLonRef]), "W")
+			if okLat && okLon {
+				info.GPS = &exifGPS{Lat: lat, Lon: lon}
+				if alt := t.rationals(gps[tagAlt]); len(alt) == 1 {
+					if ref := t.value(gps[tagAltRef]); len(ref) == 1 && ref[0] == 1 {
+						alt[0] = -alt[0] // below sea level
+					}
+					info.GPS.Alt = &alt[0]
+				}
+			}
+		}
+	}
+	return info, nil
+}
+
+// orientImage turns a decoded image the way its exif orientation says it's to be shown
+func orientImage(img image.Image, o int) image.Image {
+	if o < 2 || o > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	size := image.Pt(w, h)
+	if o >= 5 {
+		size = image.Pt(h, w)
+	}
+	dst := image.NewRGBA(image.Rectangle{Max: size})
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			var sx, sy int
+			switch o {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			dst.Set(x, y, img.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return dst
+}
+
+// exifAPI serves api/exif?path=, the exif metadata of a photo, empty when it has none
+func exifAPI(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	defer exitPath(w, "exif", q.Get("path"))
+	fullPath := enforcePath(q.Get("path"))
+	stat, err := store.Stat(fullPath)
+	check(err)
+	if !stat.Mode().IsRegular() {
+		check(badCall("not a file"))
+	}
+	info, err := readExif(fullPath)
+	if errors.Is(err, errNoExif) {
+		info, err = &exifInfo{}, nil
+	}
+	check(err)
+	b, err := json.Marshal(info)
+	check(err)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
@@ -0,0 +1,176 @@
+package gossa
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type extractProgress struct {
+	entries atomic.Int64
+	bytes   atomic.Int64
+	done    atomic.Bool
+}
+
+// ongoing and finished extractions, keyed by archive path
+var extractJobs sync.Map
+
+// how long the status of a finished extraction stays around
+var extractKeep = 10 * time.Minute
+
+var errExtractLimit = errors.New("archive exceeds extraction limits")
+
+// extractEntry writes a single archive member below dest, refusing any
+// path that would land outside of it (zip-slip)
+func extractEntry(dest string, name string, isDir bool, mode os.FileMode, src io.Reader, prog *extractProgress) error {
+	name = filepath.FromSlash(name)
+	target := filepath.Join(dest, name)
+	if target != dest && !strings.HasPrefix(target, dest+string(filepath.Separator)) {
+		return fmt.Errorf("%w in archive: %s", errInvalidPath, name)
+	}
+	if isHiddenPath(target) || isInternal(target) {
+		return nil // hidden entries not allowed
+	}
+
+	if prog.entries.Add(1) > *extractMaxEntries {
+		return errExtractLimit
+	}
+	if isDir {
+		return store.MkdirAll(target, os.ModePerm)
+	}
+
+	err := store.MkdirAll(filepath.Dir(target), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	dst, err := store.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm()|0600)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	// read one byte past the budget so oversized archives are caught, whatever their headers claim
+	left := *extractMaxSize - prog.bytes.Load()
+	n, err := copyBuffer(dst, io.LimitReader(src, left+1))
+	prog.bytes.Add(n)
+	if err == nil && n > left {
+		err = errExtractLimit
+	}
+	return err
+}
+
+func extractZip(archive string, dest string, prog *extractProgress) error {
+	file, err := store.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	zr, err := zip.NewReader(file, stat.Size())
+	if err != nil {
+		return err
+	}
+
+	for _, f := range zr.File {
+		if f.Mode()&os.ModeSymlink != 0 {
+			continue // links could point anywhere, skip them
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = extractEntry(dest, f.Name, f.FileInfo().IsDir(), f.Mode(), rc, prog)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractTar(archive string, dest string, gzipped bool, prog *extractProgress) error {
+	file, err := store.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var src io.Reader = file
+	if gzipped {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		src = gz
+	}
+
+	tr := tar.NewReader(src)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if h.Typeflag != tar.TypeReg && h.Typeflag != tar.TypeDir {
+			continue // links, devices and friends are skipped
+		}
+		err = extractEntry(dest, h.Name, h.Typeflag == tar.TypeDir, h.FileInfo().Mode(), tr, prog)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// extract unpacks a .zip, .tar or .tar.gz archive into dest
+func extract(archive string, dest string) error {
+	prog := &extractProgress{}
+	extractJobs.Store(archive, prog)
+	defer func() {
+		prog.done.Store(true)
+		time.AfterFunc(extractKeep, func() { extractJobs.CompareAndDelete(archive, prog) }) // unless extracted again since
+	}()
+
+	if _, err := store.Stat(archive); err != nil {
+		return err // before dest is made, not to leave it empty
+	}
+	err := store.MkdirAll(dest, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	lower := strings.ToLower(archive)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return extractZip(archive, dest, prog)
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return extractTar(archive, dest, true, prog)
+	case strings.HasSuffix(lower, ".tar"):
+		return extractTar(archive, dest, false, prog)
+	}
+	return badCall("unsupported archive format")
+}
+
+// extractStatus reports the progress of the last extraction of an archive
+func extractStatus(archive string) ([]byte, error) {
+	prog, ok := extractJobs.Load(archive)
+	if !ok {
+		return nil, fmt.Errorf("no extraction for %s: %w", archive, os.ErrNotExist)
+	}
+	p := prog.(*extractProgress)
+	return json.Marshal(map[string]any{"entries": p.entries.Load(), "bytes": p.bytes.Load(), "done": p.done.Load()})
+}
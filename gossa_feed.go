@@ -0,0 +1,91 @@
+package gossa
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type atomLink struct {
+	Href   string `xml:"href,attr"`
+	Rel    string `xml:"rel,attr,omitempty"`
+	Type   string `xml:"type,attr,omitempty"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title   string     `xml:"title"`
+	ID      string     `xml:"id"`
+	Updated string     `xml:"updated"`
+	Links   []atomLink `xml:"link"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Author  string      `xml:"author>name"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+// publicURL is the absolute url of a path on this server, as seen by the client
+func publicURL(r *http.Request, path string) *url.URL {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return &url.URL{Scheme: scheme, Host: r.Host, Path: path}
+}
+
+// replyFeed answers ?feed=atom on folders with the files most recently
+// modified below, linked as enclosures so podcast clients can fetch them
+func replyFeed(w http.ResponseWriter, r *http.Request, fullPath string, path string) {
+	if format := r.URL.Query().Get("feed"); format != "atom" {
+		check(badCall("unsupported feed format %s", format))
+	}
+	files, err := walkFiles(fullPath, 0, "", *walkMaxResults)
+	check(err)
+	sort.SliceStable(files.Entries, func(i, j int) bool { return files.Entries[i].Mtime.After(files.Entries[j].Mtime) })
+	if len(files.Entries) > *feedSize {
+		files.Entries = files.Entries[:*feedSize]
+	}
+
+	if !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+	self := publicURL(r, path)
+	feed := atomFeed{
+		Title:  "/" + strings.TrimPrefix(path, *extraPath),
+		ID:     self.String(),
+		Author: "gossa",
+		Links:  []atomLink{{Href: self.String() + "?feed=atom", Rel: "self"}, {Href: self.String()}},
+	}
+	base := strings.TrimSuffix(relPath(fullPath), "/") + "/"
+	updated := time.Unix(0, 0)
+	for _, el := range files.Entries {
+		link := publicURL(r, *extraPath+strings.TrimPrefix(el.Path, "/")).String()
+		mime := guessMime(filepath.Join(rootPath, filepath.FromSlash(el.Path)))
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:   strings.TrimPrefix(el.Path, base),
+			ID:      link,
+			Updated: el.Mtime.UTC().Format(time.RFC3339),
+			Links:   []atomLink{{Href: link}, {Href: link, Rel: "enclosure", Type: mime, Length: el.Size}},
+		})
+		if el.Mtime.After(updated) {
+			updated = el.Mtime
+		}
+	}
+	feed.Updated = updated.UTC().Format(time.RFC3339)
+
+	b, err := xml.Marshal(feed)
+	check(err)
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	w.Write(b)
+}
@@ -0,0 +1,93 @@
+package gossa
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type findHit struct {
+	Path  string `json:"path"`
+	IsDir bool   `json:"isDir"`
+	Size  int64  `json:"size"`
+}
+
+type findResult struct {
+	Results   []findHit `json:"results"`
+	Truncated bool      `json:"truncated"` // more results past the limit, or timeout reached
+}
+
+var errFindDone = errors.New("find done")
+
+// findMatcher matches names case-insensitively, as a glob if the query has
+// wildcards, else as a substring
+func findMatcher(query string) (func(name string) bool, error) {
+	query = strings.ToLower(query)
+	if !strings.ContainsAny(query, "*?[") {
+		return func(name string) bool { return strings.Contains(strings.ToLower(name), query) }, nil
+	}
+	if _, err := path.Match(query, ""); err != nil {
+		return nil, badCall("invalid pattern %s", query)
+	}
+	return func(name string) bool {
+		ok, _ := path.Match(query, strings.ToLower(name))
+		return ok
+	}, nil
+}
+
+// find walks fullPath for names matching query, up to limit results and -find-timeout,
+// or until ctx is done
+func find(ctx context.Context, fullPath string, query string, limit string) ([]byte, error) {
+	match, err := findMatcher(query)
+	if err != nil {
+		return nil, err
+	}
+	max := *findMaxResults
+	if n, err := strconv.Atoi(limit); err == nil && n > 0 && n < max {
+		max = n
+	}
+
+	res := findResult{Results: []findHit{}}
+	deadline := time.Now().Add(*findTimeout)
+	ig := ignores{}
+	err = walkDir(fullPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // unreadable folders are skipped
+		} else if ctx.Err() != nil {
+			return ctx.Err()
+		} else if time.Now().After(deadline) {
+			res.Truncated = true
+			return errFindDone
+		} else if p == fullPath {
+			return nil
+		}
+		if isHiddenPath(p) || isInternal(p) || ig.match(p, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if match(d.Name()) {
+			if len(res.Results) == max {
+				res.Truncated = true
+				return errFindDone
+			}
+			hit := findHit{Path: relPath(p), IsDir: d.IsDir()}
+			if info, err := d.Info(); err == nil && !d.IsDir() {
+				hit.Size = info.Size()
+			}
+			res.Results = append(res.Results, hit)
+		}
+		return nil
+	})
+	if err != nil && err != errFindDone {
+		return nil, err
+	}
+	return json.Marshal(res)
+}
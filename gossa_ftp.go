@@ -0,0 +1,513 @@
+package gossa
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ftpMaxLine bounds the commands of a control connection, not to buffer a client endlessly
+const ftpMaxLine = 4096
+
+// ftpSession is a control connection, with the state its commands build up
+type ftpSession struct {
+	conn     net.Conn
+	r        *bufio.Reader
+	w        *bufio.Writer
+	tls      *tls.Config
+	users    map[string]sftpUser
+	user     string
+	home     string // set once logged in
+	cwd      string
+	pasv     net.Listener
+	private  bool // PROT P, data connections over tls
+	rest     int64
+	renaming string
+}
+
+// ftpServe accepts ftp sessions on -ftp, for the users of -sftp-users,
+// offering explicit tls (AUTH TLS) when -ftp-tls-cert is set
+func ftpServe() (net.Listener, error) {
+	users, err := loadSftpUsers(*sftpUsers)
+	if err != nil {
+		return nil, err
+	}
+	var config *tls.Config
+	if *ftpTLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(*ftpTLSCert, *ftpTLSKey)
+		if err != nil {
+			return nil, err
+		}
+		config = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	listener, err := net.Listen("tcp", *ftpAddr)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("ftp on %s, tls %t\n", listener.Addr(), config != nil)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if errors.Is(err, net.ErrClosed) {
+				return
+			} else if err != nil {
+				log.Println("ftp accept", err)
+				continue
+			}
+			s := &ftpSession{conn: conn, r: bufio.NewReaderSize(conn, ftpMaxLine), w: bufio.NewWriter(conn), tls: config, users: users, cwd: "/"}
+			go s.serve()
+		}
+	}()
+	return listener, nil
+}
+
+func (s *ftpSession) reply(code int, msg string) {
+	fmt.Fprintf(s.w, "%d %s\r\n", code, msg)
+	s.w.Flush()
+}
+
+func (s *ftpSession) serve() {
+	defer s.conn.Close()
+	defer s.closePasv()
+	s.reply(220, "gossa ftp ready")
+	for {
+		s.conn.SetReadDeadline(time.Now().Add(5 * time.Minute))
+		line, err := s.r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			s.reply(500, "line too long")
+			return
+		} else if err != nil {
+			return
+		}
+		cmd, arg, _ := strings.Cut(strings.TrimRight(string(line), "\r\n"), " ")
+		cmd = strings.ToUpper(cmd)
+		if *verb && cmd != "PASS" {
+			log.Println("ftp", s.user, cmd, arg)
+		}
+		if cmd == "QUIT" {
+			s.reply(221, "bye")
+			return
+		}
+		s.command(cmd, arg)
+	}
+}
+
+// path resolves an argument against the working folder and the home of the user
+func (s *ftpSession) path(arg string) (string, error) {
+	if !strings.HasPrefix(arg, "/") {
+		arg = path.Join(s.cwd, arg)
+	}
+	return homePath(s.home, arg)
+}
+
+func (s *ftpSession) writable(arg string) (string, error) {
+	if *ro {
+		return "", fmt.Errorf("read only: %w", os.ErrPermission)
+	}
+	return s.path(arg)
+}
+
+func (s *ftpSession) fail(err error) {
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		s.reply(550, "no such file or folder")
+	case errors.Is(err, os.ErrPermission):
+		s.reply(550, "permission denied")
+	case errors.Is(err, os.ErrExist):
+		s.reply(553, "already exists")
+	default:
+		s.reply(451, strings.ReplaceAll(err.Error(), rootPath, ""))
+	}
+}
+
+func (s *ftpSession) command(cmd string, arg string) {
+	switch cmd {
+	case "USER", "PASS", "AUTH", "PBSZ", "PROT", "FEAT", "SYST", "NOOP", "OPTS":
+	default:
+		if s.home == "" {
+			s.reply(530, "please login with USER and PASS")
+			return
+		}
+	}
+
+	switch cmd {
+	case "USER":
+		s.user, s.home = arg, ""
+		s.reply(331, "password required")
+	case "PASS":
+		u, ok := s.users[s.user]
+		if !ok || bcrypt.CompareHashAndPassword(u.hash, []byte(arg)) != nil {
+			s.reply(530, "invalid credentials")
+			return
+		}
+		s.home, s.cwd = u.home, "/"
+		s.reply(230, "logged in")
+	case "AUTH":
+		if s.tls == nil || strings.ToUpper(arg) != "TLS" {
+			s.reply(504, "only AUTH TLS is supported, when enabled")
+			return
+		}
+		s.reply(234, "starting tls")
+		s.conn = tls.Server(s.conn, s.tls)
+		s.r, s.w = bufio.NewReaderSize(s.conn, ftpMaxLine), bufio.NewWriter(s.conn)
+	case "PBSZ":
+		s.reply(200, "PBSZ=0")
+	case "PROT":
+		s.private = strings.ToUpper(arg) == "P"
+		if s.private && s.tls == nil {
+			s.private = false
+			s.reply(536, "tls not enabled")
+			return
+		}
+		s.reply(200, "protection level set")
+	case "FEAT":
+		feats := "211-features:\r\n UTF8\r\n PASV\r\n EPSV\r\n SIZE\r\n MDTM\r\n REST STREAM\r\n"
+		if s.tls != nil {
+			feats += " AUTH TLS\r\n PBSZ\r\n PROT\r\n"
+		}
+		fmt.Fprint(s.w, feats)
+		s.reply(211, "end")
+	case "SYST":
+		s.reply(215, "UNIX Type: L8")
+	case "NOOP", "TYPE", "MODE", "STRU", "OPTS":
+		s.reply(200, "ok")
+	case "PWD", "XPWD":
+		s.reply(257, strconv.Quote(s.cwd))
+	case "CWD", "XCWD", "CDUP":
+		if cmd == "CDUP" {
+			arg = ".."
+		}
+		fullPath, err := s.path(arg)
+		if err == nil {
+			var stat os.FileInfo
+			if stat, err = store.Stat(fullPath); err == nil && !stat.IsDir() {
+				err = os.ErrNotExist
+			}
+		}
+		if err != nil {
+			s.fail(err)
+			return
+		}
+		rel, _ := filepath.Rel(s.home, fullPath)
+		s.cwd = path.Clean("/" + filepath.ToSlash(rel))
+		s.reply(250, "now in "+s.cwd)
+	case "PASV", "EPSV":
+		s.openPasv(cmd)
+	case "LIST", "NLST":
+		s.list(cmd, arg)
+	case "REST":
+		n, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || n < 0 {
+			s.reply(501, "invalid offset")
+			return
+		}
+		s.rest = n
+		s.reply(350, "restarting at "+arg)
+	case "RETR":
+		s.retrieve(arg)
+	case "STOR", "APPE":
+		s.store(cmd, arg)
+	case "SIZE", "MDTM":
+		fullPath, err := s.path(arg)
+		var stat os.FileInfo
+		if err == nil {
+			stat, err = store.Stat(fullPath)
+		}
+		if err != nil {
+			s.fail(err)
+		} else if cmd == "SIZE" {
+			s.reply(213, strconv.FormatInt(stat.Size(), 10))
+		} else {
+			s.reply(213, stat.ModTime().UTC().Format("20060102150405"))
+		}
+	case "MKD", "XMKD":
+		fullPath, err := s.writable(arg)
+		if err == nil {
+			err = store.Mkdir(fullPath, os.ModePerm)
+		}
+		if err != nil {
+			s.fail(err)
+			return
+		}
+		notify("mkdir", fullPath, "")
+		changed(fullPath)
+		s.reply(257, "created")
+	case "DELE", "RMD", "XRMD":
+		fullPath, err := s.writable(arg)
+		if err == nil && fullPath == s.home {
+			err = os.ErrPermission
+		}
+		if entries, errDir := store.ReadDir(fullPath); err == nil && errDir == nil && len(entries) > 0 {
+			err = errors.New("folder not empty")
+		}
+		if err == nil {
+			err = discard(fullPath)
+		}
+		if err != nil {
+			s.fail(err)
+			return
+		}
+		s.reply(250, "deleted")
+	case "RNFR":
+		fullPath, err := s.writable(arg)
+		if err == nil {
+			_, err = store.Lstat(fullPath)
+		}
+		if err != nil {
+			s.fail(err)
+			return
+		}
+		s.renaming = fullPath
+		s.reply(350, "ready for RNTO")
+	case "RNTO":
+		src := s.renaming
+		s.renaming = ""
+		dst, err := s.writable(arg)
+		if src == "" {
+			s.reply(503, "RNFR first")
+			return
+		} else if err == nil {
+			err = keepVersion(dst)
+		}
+		if err == nil {
+			err = moveAny(src, dst)
+		}
+		if err != nil {
+			s.fail(err)
+			return
+		}
+		notify("rename", src, dst)
+		changed(src)
+		changed(dst)
+		s.reply(250, "renamed")
+	default:
+		s.reply(502, "command not implemented")
+	}
+}
+
+func (s *ftpSession) closePasv() {
+	if s.pasv != nil {
+		s.pasv.Close()
+		s.pasv = nil
+	}
+}
+
+// pasvListen listens on a free port of -ftp-pasv-ports, or any port when unset
+func pasvListen(ip string) (net.Listener, error) {
+	from, to, ok := strings.Cut(*ftpPasvPorts, "-")
+	if !ok {
+		return net.Listen("tcp", net.JoinHostPort(ip, "0"))
+	}
+	low, err1 := strconv.Atoi(from)
+	high, err2 := strconv.Atoi(to)
+	if err1 != nil || err2 != nil || low > high {
+		return nil, errors.New("invalid -ftp-pasv-ports range")
+	}
+	for port := low; port <= high; port++ {
+		if l, err := net.Listen("tcp", net.JoinHostPort(ip, strconv.Itoa(port))); err == nil {
+			return l, nil
+		}
+	}
+	return nil, errors.New("no passive port available")
+}
+
+func (s *ftpSession) openPasv(cmd string) {
+	s.closePasv()
+	ip := s.conn.LocalAddr().(*net.TCPAddr).IP
+	l, err := pasvListen(ip.String())
+	if err != nil {
+		s.fail(err)
+		return
+	}
+	s.pasv = l
+	port := l.Addr().(*net.TCPAddr).Port
+	if cmd == "EPSV" {
+		s.reply(229, fmt.Sprintf("entering extended passive mode (|||%d|)", port))
+		return
+	}
+	ip4 := ip.To4()
+	if ip4 == nil {
+		s.closePasv()
+		s.reply(522, "use EPSV over ipv6")
+		return
+	}
+	s.reply(227, fmt.Sprintf("entering passive mode (%d,%d,%d,%d,%d,%d)", ip4[0], ip4[1], ip4[2], ip4[3], port>>8, port&0xff))
+}
+
+// data accepts the data connection of a transfer, after its 150 reply. Only
+// the host of the control connection may connect, not to hand transfers to
+// whoever reached the passive port first
+func (s *ftpSession) data() (net.Conn, error) {
+	if s.pasv == nil {
+		return nil, errors.New("use PASV or EPSV first")
+	}
+	defer s.closePasv()
+	s.reply(150, "opening data connection")
+	s.pasv.(*net.TCPListener).SetDeadline(time.Now().Add(30 * time.Second))
+	peer := s.conn.RemoteAddr().(*net.TCPAddr).IP
+	var conn net.Conn
+	for {
+		var err error
+		if conn, err = s.pasv.Accept(); err != nil {
+			return nil, err
+		} else if conn.RemoteAddr().(*net.TCPAddr).IP.Equal(peer) {
+			break
+		}
+		log.Println("ftp data connection refused from", conn.RemoteAddr())
+		conn.Close()
+	}
+	if s.private {
+		conn = tls.Server(conn, s.tls)
+	}
+	return conn, nil
+}
+
+func (s *ftpSession) list(cmd string, arg string) {
+	if strings.HasPrefix(arg, "-") { // ls flags some clients send, e.g. -la
+		arg = ""
+	}
+	fullPath, err := s.path(arg)
+	var entries []os.DirEntry
+	if err == nil {
+		entries, err = store.ReadDir(fullPath)
+	}
+	if err != nil {
+		s.fail(err)
+		return
+	}
+	conn, err := s.data()
+	if err != nil {
+		s.fail(err)
+		return
+	}
+	w := bufio.NewWriter(conn)
+	for _, el := range entries {
+		if p := filepath.Join(fullPath, el.Name()); isHiddenPath(p) || isInternal(p) {
+			continue
+		}
+		if !*symlinks && el.Type()&os.ModeSymlink != 0 {
+			continue
+		}
+		info, err := entryInfo(fullPath, el)
+		if err != nil {
+			continue
+		}
+		if cmd == "NLST" {
+			fmt.Fprintf(w, "%s\r\n", el.Name())
+			continue
+		}
+		date := info.ModTime().Format("Jan _2 15:04")
+		if time.Since(info.ModTime()) > 180*24*time.Hour {
+			date = info.ModTime().Format("Jan _2  2006")
+		}
+		fmt.Fprintf(w, "%s 1 gossa gossa %12d %s %s\r\n", info.Mode().String(), info.Size(), date, el.Name())
+	}
+	err = w.Flush()
+	conn.Close()
+	if err != nil {
+		s.reply(426, "transfer aborted")
+		return
+	}
+	s.reply(226, "done")
+}
+
+func (s *ftpSession) retrieve(arg string) {
+	offset := s.rest
+	s.rest = 0
+	fullPath, err := s.path(arg)
+	var f File
+	if err == nil {
+		f, err = store.Open(fullPath)
+	}
+	if err == nil {
+		defer f.Close()
+		_, err = f.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		s.fail(err)
+		return
+	}
+	conn, err := s.data()
+	if err != nil {
+		s.fail(err)
+		return
+	}
+	_, err = copyBuffer(conn, f)
+	conn.Close()
+	if err != nil {
+		s.reply(426, "transfer aborted")
+		return
+	}
+	s.reply(226, "done")
+}
+
+func (s *ftpSession) store(cmd string, arg string) {
+	offset := s.rest
+	s.rest = 0
+	fullPath, err := s.writable(arg)
+	if err != nil {
+		s.fail(err)
+		return
+	}
+	_, err = store.Lstat(fullPath)
+	isNew := errors.Is(err, os.ErrNotExist)
+	conn, err := s.data() // before opening, not to truncate the file if no data comes
+	if err != nil {
+		s.fail(err)
+		return
+	}
+	defer conn.Close()
+	flags := os.O_WRONLY | os.O_CREATE
+	if cmd == "APPE" {
+		flags |= os.O_APPEND
+	} else if offset == 0 {
+		flags |= os.O_TRUNC
+		if !isNew {
+			if err := keepVersion(fullPath); err != nil {
+				s.fail(err)
+				return
+			}
+		}
+	}
+	f, err := store.OpenFile(fullPath, flags, 0666)
+	if err == nil && offset > 0 {
+		if _, err = f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+		}
+	}
+	if err != nil {
+		s.fail(err)
+		return
+	}
+	_, err = copyBuffer(f, conn)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		if isNew {
+			store.Remove(fullPath) // rather than a truncated file
+		} else {
+			changed(fullPath)
+		}
+		s.reply(426, "transfer aborted")
+		return
+	}
+	if isNew {
+		journalPush(journalEntry{op: "upload", dst: fullPath})
+	}
+	notify("upload", fullPath, "")
+	changed(fullPath)
+	s.reply(226, "done")
+}
@@ -0,0 +1,126 @@
+package gossa
+
+import (
+	"encoding/json"
+	"image"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var imageExts = map[string]bool{"jpg": true, "jpeg": true, "png": true, "gif": true, "webp": true, "bmp": true, "tif": true, "tiff": true}
+
+type galleryEntry struct {
+	Name   string    `json:"name"`
+	Href   string    `json:"href"`
+	Thumb  string    `json:"thumb"`
+	Type   string    `json:"type"` // image or video
+	Width  int       `json:"width,omitempty"`
+	Height int       `json:"height,omitempty"`
+	Taken  string    `json:"taken,omitempty"`
+	Mtime  time.Time `json:"mtime"`
+}
+
+type gallery struct {
+	Path    string         `json:"path"`
+	Total   int            `json:"total"` // media of the folder, all pages included
+	Offset  int            `json:"offset"`
+	Entries []galleryEntry `json:"entries"`
+}
+
+// media probed, by path and mtime, as reading headers of every photo of a
+// large folder on each visit adds up
+var galleryCache = struct {
+	sync.Mutex
+	m map[string]galleryEntry
+}{m: map[string]galleryEntry{}}
+
+const galleryCacheMax = 100_000
+
+func mediaType(name string) string {
+	if imageExts[strings.ToLower(strings.TrimPrefix(filepath.Ext(name), "."))] {
+		return "image"
+	} else if isVideo(name) {
+		return "video"
+	}
+	return ""
+}
+
+// probeMedia reads the dimensions, as shown, and capture date of a photo
+func probeMedia(fullPath string, stat os.FileInfo, e *galleryEntry) {
+	key := fullPath + "\x00" + stat.ModTime().String()
+	galleryCache.Lock()
+	cached, ok := galleryCache.m[key]
+	galleryCache.Unlock()
+	if ok {
+		e.Width, e.Height, e.Taken = cached.Width, cached.Height, cached.Taken
+		return
+	}
+
+	if e.Type == "image" {
+		if f, err := store.Open(fullPath); err == nil {
+			if config, _, err := image.DecodeConfig(f); err == nil {
+				e.Width, e.Height = config.Width, config.Height
+			}
+			f.Close()
+		}
+		if info, err := readExif(fullPath); err == nil {
+			e.Taken = info.Taken
+			if info.Orientation >= 5 {
+				e.Width, e.Height = e.Height, e.Width
+			}
+		}
+	}
+
+	galleryCache.Lock()
+	if len(galleryCache.m) >= galleryCacheMax {
+		galleryCache.m = map[string]galleryEntry{}
+	}
+	galleryCache.m[key] = *e
+	galleryCache.Unlock()
+}
+
+// galleryAPI serves api/gallery?path=, the photos and videos of a folder
+// with what a lightbox needs, paged with offset & limit over the media only
+func galleryAPI(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	defer exitPath(w, "gallery", q.Get("path"))
+	fullPath := enforcePath(q.Get("path"))
+	stat, err := store.Stat(fullPath)
+	check(err)
+	if !stat.IsDir() {
+		check(badCall("not a folder"))
+	}
+
+	all := url.Values{"sort": {q.Get("sort")}, "order": {q.Get("order")}, "filter": {q.Get("filter")}, "limit": {"0"}}
+	stats, _, err := listDir(fullPath, all)
+	check(err)
+	var media []os.FileInfo
+	for _, el := range stats {
+		if !el.IsDir() && mediaType(el.Name()) != "" {
+			media = append(media, el)
+		}
+	}
+	offset, limit := pageBounds(q)
+	res := gallery{Path: relPath(fullPath), Total: len(media), Offset: offset, Entries: []galleryEntry{}}
+	if !strings.HasSuffix(res.Path, "/") {
+		res.Path += "/"
+	}
+
+	for _, el := range page(media, offset, limit) {
+		rel := res.Path + el.Name()
+		href := &url.URL{Path: *extraPath + strings.TrimPrefix(rel, "/")}
+		e := galleryEntry{Name: el.Name(), Href: href.EscapedPath(), Type: mediaType(el.Name()), Mtime: el.ModTime()}
+		e.Thumb = *extraPath + "thumb?" + url.Values{"path": {rel}}.Encode()
+		probeMedia(filepath.Join(fullPath, el.Name()), el, &e)
+		res.Entries = append(res.Entries, e)
+	}
+	b, err := json.Marshal(res)
+	check(err)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
@@ -0,0 +1,234 @@
+package gossa
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hlsProfile is a rendition videos are transcoded to, e.g. 720p=1280x720@3000k
+type hlsProfile struct {
+	name    string
+	w, h    int
+	bitrate int // kbit/s
+}
+
+// hlsSession is a video being watched, its segments transcoded on demand in its own temp dir
+type hlsSession struct {
+	sync.Mutex // held while transcoding
+	fullPath   string
+	duration   float64
+	dir        string
+	lastUsed   time.Time
+}
+
+var hlsProfiles []hlsProfile
+
+var hlsSessions = struct {
+	sync.Mutex
+	m map[string]*hlsSession
+}{m: map[string]*hlsSession{}}
+
+// loadHlsProfiles parses -hls-profiles, e.g. 480p=854x480@1200k,720p=1280x720@3000k
+func loadHlsProfiles(s string) error {
+	hlsProfiles = nil
+	for _, el := range splitList(s) {
+		var p hlsProfile
+		name, spec, _ := strings.Cut(el, "=")
+		if _, err := fmt.Sscanf(spec, "%dx%d@%dk", &p.w, &p.h, &p.bitrate); err != nil || name == "" || p.w < 16 || p.h < 16 || p.bitrate < 1 {
+			return fmt.Errorf("invalid hls profile %q, expected e.g. 720p=1280x720@3000k", el)
+		}
+		p.name = name
+		hlsProfiles = append(hlsProfiles, p)
+	}
+	if len(hlsProfiles) == 0 {
+		return errors.New("no hls profile")
+	}
+	return nil
+}
+
+// hlsJanitor removes the sessions not watched for -hls-idle, and their segments
+func hlsJanitor() {
+	for range time.Tick(time.Minute) {
+		hlsSessions.Lock()
+		for id, s := range hlsSessions.m {
+			if time.Since(s.lastUsed) > *hlsIdle {
+				delete(hlsSessions.m, id)
+				os.RemoveAll(s.dir)
+			}
+		}
+		hlsSessions.Unlock()
+	}
+}
+
+// probeDuration reads the duration of a video, as printed by ffmpeg
+func probeDuration(fullPath string) (float64, error) {
+	info := parseProbe(ffmpegProbe(fullPath))
+	if info.Duration == 0 {
+		return 0, fmt.Errorf("%w: no duration found, not a video", errNotImage)
+	}
+	return info.Duration, nil
+}
+
+func newHlsSession(fullPath string) (string, *hlsSession, error) {
+	duration, err := probeDuration(fullPath)
+	if err != nil {
+		return "", nil, err
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	id := hex.EncodeToString(b)
+	dir, err := os.MkdirTemp("", "gossa-hls-")
+	if err != nil {
+		return "", nil, err
+	}
+	s := &hlsSession{fullPath: fullPath, duration: duration, dir: dir, lastUsed: time.Now()}
+	hlsSessions.Lock()
+	hlsSessions.m[id] = s
+	hlsSessions.Unlock()
+	return id, s, nil
+}
+
+func hlsSessionOf(id string) (*hlsSession, error) {
+	hlsSessions.Lock()
+	defer hlsSessions.Unlock()
+	s := hlsSessions.m[id]
+	if s == nil {
+		return nil, fmt.Errorf("hls session expired: %w", os.ErrNotExist)
+	}
+	s.lastUsed = time.Now()
+	return s, nil
+}
+
+func hlsProfileOf(name string) (hlsProfile, error) {
+	for _, el := range hlsProfiles {
+		if el.name == name {
+			return el, nil
+		}
+	}
+	return hlsProfile{}, badCall("unknown hls profile %s", name)
+}
+
+func (s *hlsSession) segments() int {
+	return max(1, int(math.Ceil(s.duration/hlsSegment.Seconds())))
+}
+
+// segment transcodes a segment, unless done already, and returns its file
+func (s *hlsSession) segment(p hlsProfile, n int) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+	out := filepath.Join(s.dir, fmt.Sprintf("%s-%d.ts", p.name, n))
+	if _, err := os.Stat(out); err == nil {
+		return out, nil
+	}
+
+	ffmpegSlots <- struct{}{}
+	defer func() { <-ffmpegSlots }()
+	ctx, cancel := context.WithTimeout(context.Background(), *ffmpegTimeout)
+	defer cancel()
+	start := strconv.FormatFloat(float64(n)*hlsSegment.Seconds(), 'f', 3, 64)
+	scale := fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease,scale=trunc(iw/2)*2:trunc(ih/2)*2", p.w, p.h)
+	rate := strconv.Itoa(p.bitrate) + "k"
+	input, stdin, closeInput, err := ffmpegInput(s.fullPath)
+	if err != nil {
+		return "", err
+	}
+	defer closeInput()
+	tmp, err := os.CreateTemp(s.dir, "tmp-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, ffmpegBin, "-nostdin", "-loglevel", "error", "-ss", start, "-t", strconv.FormatFloat(hlsSegment.Seconds(), 'f', 3, 64), "-i", input,
+		"-map", "0:v:0", "-map", "0:a:0?", "-vf", scale, "-c:v", "libx264", "-preset", "veryfast", "-b:v", rate, "-maxrate", rate, "-bufsize", strconv.Itoa(2*p.bitrate)+"k",
+		"-c:a", "aac", "-b:a", "128k", "-ac", "2", "-output_ts_offset", start, "-f", "mpegts", "-")
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdin, tmp, &stderr
+	err = cmd.Run()
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", fmt.Errorf("ffmpeg segment %d: %v %s", n, err, strings.TrimSpace(stderr.String()))
+	}
+	return out, os.Rename(tmp.Name(), out)
+}
+
+// hlsHandler serves videos as hls, hls?path= replying the master playlist of
+// a new session, then hls?session=&profile= the playlist of a profile and
+// hls?session=&profile=&seg= its segments
+func hlsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	defer exitPath(w, "hls", q.Get("path"), q.Get("session"))
+	if ffmpegBin == "" {
+		check(fmt.Errorf("%w: no ffmpeg for hls", errNotImage))
+	}
+	var out strings.Builder
+	out.WriteString("#EXTM3U\n")
+	w.Header().Set("Cache-Control", "no-store")
+
+	if q.Has("path") {
+		fullPath := enforcePath(q.Get("path"))
+		stat, err := store.Stat(fullPath)
+		check(err)
+		if !stat.Mode().IsRegular() || !isVideo(fullPath) {
+			check(badCall("not a video"))
+		}
+		id, _, err := newHlsSession(fullPath)
+		check(err)
+		for _, p := range hlsProfiles {
+			fmt.Fprintf(&out, "#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d,NAME=%q\n", (p.bitrate+128)*1000, p.w, p.h, p.name)
+			fmt.Fprintf(&out, "hls?%s\n", url.Values{"session": {id}, "profile": {p.name}}.Encode())
+		}
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		w.Write([]byte(out.String()))
+		return
+	}
+
+	s, err := hlsSessionOf(q.Get("session"))
+	check(err)
+	p, err := hlsProfileOf(q.Get("profile"))
+	check(err)
+	if q.Has("seg") {
+		n, err := strconv.Atoi(q.Get("seg"))
+		if err != nil || n < 0 || n >= s.segments() {
+			check(badCall("invalid segment %s", q.Get("seg")))
+		}
+		seg, err := s.segment(p, n)
+		check(err)
+		w.Header().Set("Content-Type", "video/mp2t")
+		http.ServeFile(w, r, seg)
+		return
+	}
+
+	segDuration := hlsSegment.Seconds()
+	fmt.Fprintf(&out, "#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n", int(math.Ceil(segDuration)))
+	for n := 0; n < s.segments(); n++ {
+		d := math.Min(segDuration, s.duration-float64(n)*segDuration)
+		fmt.Fprintf(&out, "#EXTINF:%.3f,\nhls?%s\n", d, url.Values{"session": {q.Get("session")}, "profile": {p.name}, "seg": {strconv.Itoa(n)}}.Encode())
+	}
+	out.WriteString("#EXT-X-ENDLIST\n")
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Write([]byte(out.String()))
+}
+
+func hlsSetup() {
+	check(loadHlsProfiles(*hlsProfilesFlag))
+	if ffmpegBin == "" {
+		log.Println("-hls needs ffmpeg, hls requests will fail")
+	}
+}
@@ -0,0 +1,66 @@
+package gossa
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"maps"
+	"net/http"
+	"path"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+//go:embed ui/i18n/*.json
+var i18nFiles embed.FS
+
+// the strings of the page, by language. Those missing from a catalog are in english
+var catalogs = map[string]map[string]string{}
+
+// i18nTags are the languages of the catalogs, english first as the fallback
+var i18nTags = []language.Tag{language.English}
+var i18nMatcher language.Matcher
+
+func init() {
+	catalogs["en"] = readCatalog("en", nil)
+	files, err := fs.Glob(i18nFiles, "ui/i18n/*.json")
+	check(err)
+	for _, el := range files {
+		if lang := strings.TrimSuffix(path.Base(el), ".json"); lang != "en" {
+			catalogs[lang] = readCatalog(lang, catalogs["en"])
+			i18nTags = append(i18nTags, language.MustParse(lang))
+		}
+	}
+	i18nMatcher = language.NewMatcher(i18nTags)
+}
+
+// readCatalog reads the strings of a language, over the fallback ones
+func readCatalog(lang string, fallback map[string]string) map[string]string {
+	texts := maps.Clone(fallback)
+	if texts == nil {
+		texts = map[string]string{}
+	}
+	b, err := i18nFiles.ReadFile("ui/i18n/" + lang + ".json")
+	check(err)
+	check(json.Unmarshal(b, &texts))
+	return texts
+}
+
+// pageLang picks the language of the page from -lang, or else from the Accept-Language of the request
+func pageLang(r *http.Request) (string, map[string]string) {
+	if *lang != "" {
+		return *lang, catalogs[*lang]
+	}
+	_, i := language.MatchStrings(i18nMatcher, r.Header.Get("Accept-Language"))
+	return i18nTags[i].String(), catalogs[i18nTags[i].String()]
+}
+
+// checkLang validates -lang
+func checkLang() error {
+	if *lang != "" && catalogs[*lang] == nil {
+		return fmt.Errorf("no translation for -lang %s", *lang)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package gossa
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// iconNames are the icons of the ui an extension can be mapped to, named after one of their extensions
+var iconNames = regexp.MustCompile(`^[a-z0-9]+$`)
+
+// iconData are the images given inline, without what would end the css url they're put in
+var iconData = regexp.MustCompile(`^data:image/[a-z0-9.+-]+(;base64)?,[A-Za-z0-9+/=%._-]+$`)
+
+// with -icons, the icon class shown for an extension, and the css of the icons given as data uris
+var iconClasses = map[string]string{}
+var iconCSS template.CSS
+
+// loadIcons reads a json file mapping extensions to an icon of the ui, e.g.
+// {"dng": "jpg", "parquet": "csv"}, or to an image as a data uri
+func loadIcons(file string) error {
+	if file == "" {
+		return nil
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	icons := map[string]string{}
+	if err := json.Unmarshal(b, &icons); err != nil {
+		return fmt.Errorf("icons %s: %w", file, err)
+	}
+	exts := make([]string, 0, len(icons))
+	for ext := range icons {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+
+	var css strings.Builder
+	for _, ext := range exts {
+		icon, key := icons[ext], strings.ToLower(strings.TrimPrefix(ext, "."))
+		switch {
+		case !iconNames.MatchString(key):
+			return fmt.Errorf("icons %s: invalid extension %q", file, ext)
+		case iconNames.MatchString(icon):
+			iconClasses[key] = icon
+		case iconData.MatchString(icon):
+			iconClasses[key] = "custom-" + key
+			fmt.Fprintf(&css, ".icon-custom-%s { background-image: url(\"%s\"); }\n", key, icon)
+		default:
+			return fmt.Errorf("icons %s: %s is neither an icon name nor an image data uri", file, ext)
+		}
+	}
+	iconCSS = template.CSS(css.String())
+	return nil
+}
+
+// iconOf is the icon class of an extension, the extension itself unless mapped with -icons
+func iconOf(ext string) string {
+	if icon, ok := iconClasses[ext]; ok {
+		return icon
+	}
+	return ext
+}
@@ -0,0 +1,103 @@
+package gossa
+
+import (
+	"bufio"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreFile lists gitignore style patterns of entries hidden from listings,
+// downloads and searches, in the folder it's in and below
+const ignoreFile = ".gossaignore"
+
+type ignoreRule struct {
+	pattern  string
+	negate   bool // !pattern, showing again what an earlier rule hid
+	dirOnly  bool // pattern/
+	anchored bool // matched against the path from the folder of the file, rather than any name
+}
+
+// parseIgnore reads the rules of an ignore file, skipping comments and invalid patterns
+func parseIgnore(r io.Reader) []ignoreRule {
+	var rules []ignoreRule
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var rule ignoreRule
+		if rule.negate = strings.HasPrefix(line, "!"); rule.negate {
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\#`) || strings.HasPrefix(line, `\!`) {
+			line = line[1:]
+		}
+		if rule.dirOnly = strings.HasSuffix(line, "/"); rule.dirOnly {
+			line = strings.TrimRight(line, "/")
+		}
+		rule.anchored = strings.Contains(line, "/")
+		rule.pattern = strings.TrimPrefix(line, "/")
+		if _, err := path.Match(strings.ReplaceAll(rule.pattern, "/", ""), ""); err != nil || rule.pattern == "" {
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+func (r ignoreRule) match(segs []string) bool {
+	if r.anchored {
+		return globMatchSegs(strings.Split(r.pattern, "/"), segs)
+	}
+	ok, _ := path.Match(r.pattern, segs[len(segs)-1])
+	return ok
+}
+
+// ignores holds the rules of the ignore files met along a request, each read once
+type ignores map[string][]ignoreRule
+
+func (ig ignores) rules(dir string) []ignoreRule {
+	rules, ok := ig[dir]
+	if !ok {
+		if f, err := store.Open(filepath.Join(dir, ignoreFile)); err == nil {
+			rules = parseIgnore(io.LimitReader(f, 64<<10))
+			f.Close()
+		}
+		ig[dir] = rules
+	}
+	return rules
+}
+
+// match tells if an entry is hidden by the ignore files of the folders above
+// it. Deeper files and later lines win, as with git. Callers walking a tree
+// skip ignored folders themselves, see hidden otherwise
+func (ig ignores) match(fullPath string, isDir bool) bool {
+	rel, err := filepath.Rel(rootPath, fullPath)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+	segs := strings.Split(filepath.ToSlash(rel), "/")
+	ignored := false
+	dir := rootPath
+	for i := range segs {
+		for _, r := range ig.rules(dir) {
+			if (isDir || !r.dirOnly) && r.negate == ignored && r.match(segs[i:]) {
+				ignored = !r.negate
+			}
+		}
+		dir = filepath.Join(dir, segs[i])
+	}
+	return ignored
+}
+
+// hidden tells if an entry, or any folder it's in, is ignored
+func (ig ignores) hidden(fullPath string, isDir bool) bool {
+	for p := fullPath; p != rootPath && len(p) > len(rootPath); p = filepath.Dir(p) {
+		if ig.match(p, isDir || p != fullPath) {
+			return true
+		}
+	}
+	return false
+}
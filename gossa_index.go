@@ -0,0 +1,350 @@
+package gossa
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+type indexDoc struct {
+	Size    int64
+	ModTime time.Time
+	Length  int            // number of terms
+	Terms   map[string]int // term frequencies
+}
+
+// textIndex is an inverted index of the text files under the root, keyed by path relative to the root
+type textIndex struct {
+	sync.RWMutex
+	docs     map[string]*indexDoc
+	postings map[string]map[string]int // term -> path -> frequency
+	ready    bool                      // initial walk done
+	dirty    bool
+}
+
+type searchHit struct {
+	Path  string  `json:"path"`
+	Score float64 `json:"score"`
+}
+
+type searchResult struct {
+	Results []searchHit `json:"results"`
+	Indexed int         `json:"indexed"`
+	Ready   bool        `json:"ready"`
+}
+
+var index = &textIndex{docs: map[string]*indexDoc{}, postings: map[string]map[string]int{}}
+var indexQueue = make(chan string, 1024)
+
+func indexFile() string {
+	if *indexPath != "" {
+		return *indexPath
+	}
+	return filepath.Join(rootPath, ".gossa-index")
+}
+
+// indexStore is where the index is kept, the storage served unless -index-path
+// puts it elsewhere on the host
+func indexStore() Storage {
+	if *indexPath != "" {
+		return osStorage{}
+	}
+	return store
+}
+
+// tokenize splits a text in lowercased words
+func tokenize(s string) []string {
+	var out []string
+	for _, el := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+		if l := utf8.RuneCountInString(el); l > 1 && l <= 64 {
+			out = append(out, el)
+		}
+	}
+	return out
+}
+
+// indexable tells if a file goes in the index, from its name and size
+func indexable(fullPath string, info fs.FileInfo) bool {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(fullPath), "."))
+	if !info.Mode().IsRegular() || info.Size() > *indexMaxSize || isInternal(fullPath) {
+		return false
+	} else if isHiddenPath(fullPath) {
+		return false
+	}
+	for _, el := range splitList(*indexExts) {
+		if el == ext {
+			return true
+		}
+	}
+	return false
+}
+
+func (ix *textIndex) put(rel string, doc *indexDoc) {
+	ix.drop(rel)
+	ix.docs[rel] = doc
+	for term, n := range doc.Terms {
+		if ix.postings[term] == nil {
+			ix.postings[term] = map[string]int{}
+		}
+		ix.postings[term][rel] = n
+	}
+	ix.dirty = true
+}
+
+func (ix *textIndex) drop(rel string) {
+	doc := ix.docs[rel]
+	if doc == nil {
+		return
+	}
+	for term := range doc.Terms {
+		delete(ix.postings[term], rel)
+		if len(ix.postings[term]) == 0 {
+			delete(ix.postings, term)
+		}
+	}
+	delete(ix.docs, rel)
+	ix.dirty = true
+}
+
+// indexOne updates the index for a single file, reading it only if it changed
+func indexOne(fullPath string, info fs.FileInfo) {
+	rel := relPath(fullPath)
+	if !indexable(fullPath, info) {
+		index.Lock()
+		index.drop(rel)
+		index.Unlock()
+		return
+	}
+
+	index.RLock()
+	doc := index.docs[rel]
+	index.RUnlock()
+	if doc != nil && doc.Size == info.Size() && doc.ModTime.Equal(info.ModTime()) {
+		return
+	}
+
+	b, err := readFile(fullPath)
+	if err != nil || !utf8.Valid(b) {
+		return
+	}
+	doc = &indexDoc{Size: info.Size(), ModTime: info.ModTime(), Terms: map[string]int{}}
+	for _, term := range tokenize(string(b)) {
+		doc.Terms[term]++
+		doc.Length++
+	}
+	index.Lock()
+	index.put(rel, doc)
+	index.Unlock()
+}
+
+// indexTree refreshes everything under a path, dropping what went away
+func indexTree(fullPath string) {
+	rel := relPath(fullPath)
+	seen := map[string]bool{}
+	walk(fullPath, func(p string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		} else if info.IsDir() && p != fullPath && (isInternal(p) || isHiddenPath(p)) {
+			return filepath.SkipDir
+		}
+		if !info.IsDir() {
+			seen[relPath(p)] = true
+			indexOne(p, info)
+		}
+		return nil
+	})
+
+	index.Lock()
+	defer index.Unlock()
+	for el := range index.docs {
+		if !seen[el] && (el == rel || rel == "/" || strings.HasPrefix(el, rel+"/")) {
+			index.drop(el)
+		}
+	}
+}
+
+// reindex queues a changed path for the indexer, without blocking
+func reindex(fullPath string) {
+	if !*indexOn {
+		return
+	}
+	select {
+	case indexQueue <- fullPath:
+	default:
+		log.Println("error - index queue full, skipping", fullPath)
+	}
+}
+
+func loadIndex() {
+	f, err := indexStore().Open(indexFile())
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	check(err)
+	defer f.Close()
+	docs := map[string]*indexDoc{}
+	if err = gob.NewDecoder(f).Decode(&docs); err != nil {
+		log.Println("error - cant read index, rebuilding", err)
+		return
+	}
+	for rel, doc := range docs {
+		index.put(rel, doc)
+	}
+	index.dirty = false
+}
+
+func saveIndex() error {
+	index.Lock()
+	defer index.Unlock()
+	if !index.dirty {
+		return nil
+	}
+	tmp := indexFile() + ".tmp"
+	f, err := indexStore().Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = gob.NewEncoder(f).Encode(index.docs)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		indexStore().Remove(tmp)
+		return err
+	}
+	index.dirty = false
+	return indexStore().Rename(tmp, indexFile())
+}
+
+// indexer builds the index, then keeps it up to date from the queue, saving it when idle
+func indexer() {
+	loadIndex()
+	indexTree(rootPath)
+	index.Lock()
+	index.ready = true
+	index.Unlock()
+	if *indexWatch {
+		go indexWatcher()
+	}
+
+	for {
+		select {
+		case p := <-indexQueue:
+			indexTree(p)
+		case <-time.After(2 * time.Second):
+			if err := saveIndex(); err != nil {
+				log.Println("error - cant save index", err)
+			}
+		}
+	}
+}
+
+// indexWatcher follows changes made outside of gossa
+func indexWatcher() {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Println("error - cant watch for changes", err)
+		return
+	}
+	watch := func(root string) {
+		walkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || !d.IsDir() {
+				return nil
+			} else if p != rootPath && (isInternal(p) || isHiddenPath(p)) {
+				return filepath.SkipDir
+			}
+			watcher.Add(p)
+			return nil
+		})
+	}
+	watch(rootPath)
+
+	for {
+		select {
+		case e := <-watcher.Events:
+			if isInternal(e.Name) {
+				continue
+			}
+			if e.Has(fsnotify.Create) {
+				if info, err := store.Stat(e.Name); err == nil && info.IsDir() {
+					watch(e.Name)
+				}
+			}
+			reindex(e.Name)
+		case err := <-watcher.Errors:
+			log.Println("error - watcher", err)
+		}
+	}
+}
+
+// search ranks the files containing all words of a query, with tf-idf
+func search(query string, under string, limit int) searchResult {
+	index.RLock()
+	defer index.RUnlock()
+	res := searchResult{Results: []searchHit{}, Indexed: len(index.docs), Ready: index.ready}
+	terms := tokenize(query)
+	if len(terms) == 0 {
+		return res
+	}
+
+	scores := map[string]float64{}
+	for i, term := range terms {
+		postings := index.postings[term]
+		idf := math.Log(1 + float64(len(index.docs))/float64(1+len(postings)))
+		next := map[string]float64{}
+		for rel, n := range postings {
+			if _, ok := scores[rel]; i > 0 && !ok {
+				continue // all terms must match
+			}
+			next[rel] = scores[rel] + float64(n)*idf/math.Sqrt(float64(index.docs[rel].Length))
+		}
+		scores = next
+	}
+
+	ig := ignores{}
+	for rel, score := range scores {
+		if (under == "/" || strings.HasPrefix(rel, strings.TrimSuffix(under, "/")+"/")) && !ig.hidden(filepath.Join(rootPath, rel), false) {
+			res.Results = append(res.Results, searchHit{rel, score})
+		}
+	}
+	sort.Slice(res.Results, func(i, j int) bool {
+		if res.Results[i].Score != res.Results[j].Score {
+			return res.Results[i].Score > res.Results[j].Score
+		}
+		return res.Results[i].Path < res.Results[j].Path
+	})
+	if len(res.Results) > limit {
+		res.Results = res.Results[:limit]
+	}
+	return res
+}
+
+// searchHandler answers search?q=words&path=/sub/folder&limit=50
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	defer exitPath(w, "search", q.Get("q"))
+	under := relPath(enforcePath(q.Get("path")))
+	limit, err := strconv.Atoi(q.Get("limit"))
+	if err != nil || limit <= 0 || limit > 500 {
+		limit = 50
+	}
+	b, err := json.Marshal(search(q.Get("q"), under, limit))
+	check(err)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
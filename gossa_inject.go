@@ -0,0 +1,108 @@
+package gossa
+
+import (
+	"bytes"
+	"html/template"
+	"net/url"
+	"os"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// shown above and below listings, read from -header-file and -footer-file
+var headerHTML, footerHTML template.HTML
+
+// injectedTags are the elements kept in headers and footers, the others being
+// dropped along with what they hold. Scripts are kept only when loaded from a
+// http(s) url, e.g. for analytics, never inline
+var injectedTags = map[string]bool{
+	"a": true, "abbr": true, "b": true, "blockquote": true, "br": true, "code": true, "div": true, "em": true, "footer": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "header": true, "hr": true, "i": true, "img": true,
+	"li": true, "nav": true, "ol": true, "p": true, "pre": true, "script": true, "section": true, "small": true, "span": true,
+	"strong": true, "table": true, "tbody": true, "td": true, "th": true, "thead": true, "tr": true, "u": true, "ul": true,
+}
+
+// injectedAttrs are the attributes kept in headers and footers, along with data-*
+var injectedAttrs = map[string]bool{
+	"alt": true, "async": true, "class": true, "defer": true, "height": true, "href": true, "id": true,
+	"lang": true, "rel": true, "src": true, "target": true, "title": true, "width": true,
+}
+
+var injectedSchemes = map[string]bool{"": true, "http": true, "https": true, "mailto": true}
+
+func safeURL(s string) bool {
+	u, err := url.Parse(strings.TrimSpace(s))
+	return err == nil && injectedSchemes[strings.ToLower(u.Scheme)]
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// sanitizeNode drops the comments, elements and attributes of n not allowed in headers and footers
+func sanitizeNode(n *html.Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		switch {
+		case c.Type == html.CommentNode:
+			n.RemoveChild(c)
+		case c.Type != html.ElementNode:
+		case !injectedTags[c.Data] || c.Data == "script" && (!safeURL(attr(c, "src")) || attr(c, "src") == ""):
+			n.RemoveChild(c)
+		default:
+			kept := c.Attr[:0]
+			for _, a := range c.Attr {
+				if a.Namespace == "" && (injectedAttrs[a.Key] || strings.HasPrefix(a.Key, "data-")) && (a.Key != "href" && a.Key != "src" || safeURL(a.Val)) {
+					kept = append(kept, a)
+				}
+			}
+			c.Attr = kept
+			if c.Data == "script" {
+				for c.FirstChild != nil {
+					c.RemoveChild(c.FirstChild) // no inline code
+				}
+			}
+			sanitizeNode(c)
+		}
+		c = next
+	}
+}
+
+// sanitizeHTML keeps the markup of a header or footer safe to show on every page
+func sanitizeHTML(src []byte) (template.HTML, error) {
+	body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(bytes.NewReader(src), body)
+	if err != nil {
+		return "", err
+	}
+	for _, n := range nodes {
+		body.AppendChild(n)
+	}
+	sanitizeNode(body)
+	var out bytes.Buffer
+	for c := body.FirstChild; c != nil; c = c.NextSibling {
+		if err = html.Render(&out, c); err != nil {
+			return "", err
+		}
+	}
+	return template.HTML(out.String()), nil
+}
+
+// injectFile reads and sanitizes a header or footer, empty when not set
+func injectFile(p string) (template.HTML, error) {
+	if p == "" {
+		return "", nil
+	}
+	src, err := os.ReadFile(p)
+	if err != nil {
+		return "", err
+	}
+	return sanitizeHTML(src)
+}
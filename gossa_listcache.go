@@ -0,0 +1,129 @@
+package gossa
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// listCacheMax is how many folders are cached at most, each taking an inotify watch
+const listCacheMax = 1024
+
+// cachedEntry is a stat'ed entry of a cached folder, along with its listing row
+type cachedEntry struct {
+	os.FileInfo
+	path string // url path of the folder the row was made for
+	row  listEntry
+}
+
+type cachedDir struct {
+	stats []os.FileInfo // visible entries, in the folder order
+	made  time.Time
+}
+
+// folders are cached with -list-cache, until written to by gossa, changed on
+// disk as told by inotify, or once expired
+var listCache = map[string]*cachedDir{}
+var listCacheGen uint64 // bumped by invalidations, so listings read meanwhile aren't kept
+var listCacheLock sync.Mutex
+var listWatcher *fsnotify.Watcher
+
+// listingPath is the url path a folder is listed at
+func listingPath(fullPath string) string {
+	return strings.TrimSuffix(*extraPath+strings.TrimPrefix(relPath(fullPath), "/"), "/") + "/"
+}
+
+// cachedStats returns the visible entries of a folder, stat'ed, from the cache if still fresh
+func cachedStats(fullPath string, list func() ([]os.FileInfo, error)) ([]os.FileInfo, error) {
+	listCacheLock.Lock()
+	c := listCache[fullPath]
+	gen := listCacheGen
+	listCacheLock.Unlock()
+	if c != nil && time.Since(c.made) < *listCacheTTL {
+		return c.stats, nil
+	}
+
+	infos, err := list()
+	if err != nil {
+		return nil, err
+	}
+	path := listingPath(fullPath)
+	stats := make([]os.FileInfo, 0, len(infos))
+	for _, el := range infos {
+		stat, err := entryInfo(fullPath, el.(dirEntryInfo))
+		if err != nil {
+			log.Println("error - cant stat a file", err)
+			continue
+		}
+		stats = append(stats, cachedEntry{FileInfo: stat, path: path, row: newListEntry(path, stat)})
+	}
+
+	listCacheLock.Lock()
+	defer listCacheLock.Unlock()
+	if gen != listCacheGen || listWatcher == nil || listWatcher.Add(fullPath) != nil {
+		return stats, nil // changed meanwhile, or changes couldn't be followed
+	}
+	if len(listCache) >= listCacheMax {
+		evictListing()
+	}
+	listCache[fullPath] = &cachedDir{stats: stats, made: time.Now()}
+	return stats, nil
+}
+
+// evictListing drops the oldest cached folder
+func evictListing() {
+	oldest := ""
+	for key, c := range listCache {
+		if oldest == "" || c.made.Before(listCache[oldest].made) {
+			oldest = key
+		}
+	}
+	dropListing(oldest)
+}
+
+func dropListing(key string) {
+	delete(listCache, key)
+	listWatcher.Remove(key)
+}
+
+// invalidateListing drops the cached listings of a path, of its folder and of
+// what's below it. A changed .gossaignore drops everything below its folder
+func invalidateListing(fullPath string) {
+	listCacheLock.Lock()
+	defer listCacheLock.Unlock()
+	listCacheGen++
+	parent := filepath.Dir(fullPath)
+	if filepath.Base(fullPath) == ignoreFile {
+		fullPath = parent
+	}
+	sep := string(filepath.Separator)
+	for key := range listCache {
+		if key == fullPath || key == parent || strings.HasPrefix(key, fullPath+sep) {
+			dropListing(key)
+		}
+	}
+}
+
+// listCacheWatcher drops the listings of the folders changed outside of gossa
+func listCacheWatcher() {
+	for {
+		select {
+		case e := <-listWatcher.Events:
+			invalidateListing(e.Name)
+		case err := <-listWatcher.Errors:
+			log.Println("error - listing cache watcher", err)
+		}
+	}
+}
+
+func listCacheSetup() {
+	var err error
+	if listWatcher, err = fsnotify.NewWatcher(); err != nil {
+		log.Println("error - cant watch for changes, listings not cached", err)
+	}
+}
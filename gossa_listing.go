@@ -0,0 +1,358 @@
+package gossa
+
+import (
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+type listEntry struct {
+	Name  string    `json:"name"`
+	Href  string    `json:"href"`
+	Size  int64     `json:"size"`
+	Mtime time.Time `json:"mtime"`
+	Type  string    `json:"type"` // file or dir
+
+	Modified string `json:"modified"`        // mtime formatted with -date-format
+	Human    string `json:"human,omitempty"` // size formatted with -size-units, of files only
+	Mode     string `json:"mode,omitempty"`
+	Owner    string `json:"owner,omitempty"`
+
+	Media *mediaInfo `json:"media,omitempty"` // of audio and video files, with ?media=1
+}
+
+type listing struct {
+	Path    string      `json:"path"`
+	Total   int         `json:"total"` // visible entries of the folder, all pages included
+	Offset  int         `json:"offset"`
+	Entries []listEntry `json:"entries"`
+}
+
+// listingFormat tells if a listing is wanted as json, from ?format=json or the Accept header
+func listingFormat(r *http.Request) string {
+	if f := r.URL.Query().Get("format"); f != "" {
+		return f
+	}
+	for _, el := range splitList(r.Header.Get("Accept")) {
+		if t, _, err := mime.ParseMediaType(el); err == nil && t == "application/json" {
+			return "json"
+		}
+	}
+	return "html"
+}
+
+func newListEntry(path string, el os.FileInfo) listEntry {
+	if c, ok := el.(cachedEntry); ok && c.path == path {
+		return c.row
+	}
+	href := &url.URL{Path: path + el.Name()}
+	e := listEntry{Name: el.Name(), Href: href.EscapedPath(), Size: entrySize(el), Mtime: el.ModTime(), Type: "file"}
+	e.Modified = el.ModTime().Format(*dateFormat)
+	if *showPerms {
+		e.Mode = el.Mode().Perm().String()
+		owner, group := fileOwner(el)
+		e.Owner = owner + ":" + group
+	}
+	if el.IsDir() {
+		e.Href += "/"
+		e.Type = "dir"
+	} else {
+		e.Human = humanize(e.Size)
+	}
+	return e
+}
+
+// naturalLess compares names case insensitively, numbers by value, e.g. file2 < file10
+func naturalLess(a string, b string) bool {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	for a != "" && b != "" {
+		da, db := digitsPrefix(a), digitsPrefix(b)
+		if da == "" || db == "" {
+			ra, sa := utf8.DecodeRuneInString(a)
+			rb, sb := utf8.DecodeRuneInString(b)
+			if ra != rb {
+				return ra < rb
+			}
+			a, b = a[sa:], b[sb:]
+			continue
+		}
+		// compare numbers by value, shorter once leading zeros are stripped means smaller
+		na, nb := strings.TrimLeft(da, "0"), strings.TrimLeft(db, "0")
+		if len(na) != len(nb) {
+			return len(na) < len(nb)
+		} else if na != nb {
+			return na < nb
+		} else if len(da) != len(db) {
+			return len(da) < len(db) // 01 before 001
+		}
+		a, b = a[len(da):], b[len(db):]
+	}
+	return len(a) < len(b)
+}
+
+func digitsPrefix(s string) string {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return s[:i]
+}
+
+// sortCollation is the language of -sort-locale, und for the unicode root collation
+var sortCollation = language.Und
+
+func checkSortLocale() error {
+	if *sortLocale == "" {
+		return nil
+	}
+	tag, err := language.Parse(*sortLocale)
+	if err != nil {
+		return fmt.Errorf("invalid -sort-locale %s: %w", *sortLocale, err)
+	}
+	sortCollation = tag
+	return nil
+}
+
+// sortListing orders entries by name (case insensitive), natural name, name
+// in the alphabet of -sort-locale, size or mtime
+func sortListing(stats []os.FileInfo, by string, order string) error {
+	if by == "" {
+		by = *sortDefault
+	}
+	name := func(i, j int) bool { return strings.ToLower(stats[i].Name()) < strings.ToLower(stats[j].Name()) }
+	var less func(i, j int) bool
+	switch by {
+	case "name":
+		less = name
+	case "natural":
+		less = func(i, j int) bool { return naturalLess(stats[i].Name(), stats[j].Name()) }
+	case "collate":
+		c := collate.New(sortCollation, collate.IgnoreCase) // not safe for concurrent use, one per sort
+		less = func(i, j int) bool { return c.CompareString(stats[i].Name(), stats[j].Name()) < 0 }
+	case "size":
+		less = func(i, j int) bool {
+			if a, b := entrySize(stats[i]), entrySize(stats[j]); a != b {
+				return a < b
+			}
+			return name(i, j)
+		}
+	case "mtime":
+		less = func(i, j int) bool {
+			if a, b := stats[i].ModTime(), stats[j].ModTime(); !a.Equal(b) {
+				return a.Before(b)
+			}
+			return name(i, j)
+		}
+	default:
+		return badCall("invalid sort %s", by)
+	}
+
+	switch order {
+	case "", "asc":
+		sort.SliceStable(stats, less)
+	case "desc":
+		sort.SliceStable(stats, func(i, j int) bool { return less(j, i) })
+	default:
+		return badCall("invalid order %s", order)
+	}
+	return nil
+}
+
+// entrySize is the size shown in listings, folders counting as empty
+func entrySize(el os.FileInfo) int64 {
+	if el.IsDir() {
+		return 0
+	}
+	return el.Size()
+}
+
+// dirEntryInfo lets entries not stat'ed yet be sorted by name
+type dirEntryInfo struct{ fs.DirEntry }
+
+func (d dirEntryInfo) Size() int64        { return 0 }
+func (d dirEntryInfo) Mode() fs.FileMode  { return d.Type() }
+func (d dirEntryInfo) ModTime() time.Time { return time.Time{} }
+func (d dirEntryInfo) Sys() any           { return nil }
+
+// pageBounds reads ?offset= and ?limit=, a zero limit meaning everything
+func pageBounds(q url.Values) (int, int) {
+	offset, _ := strconv.Atoi(q.Get("offset"))
+	limit, err := strconv.Atoi(q.Get("limit"))
+	if err != nil {
+		limit = *pageSize
+	}
+	return max(offset, 0), max(limit, 0)
+}
+
+func page[T any](stats []T, offset int, limit int) []T {
+	stats = stats[min(offset, len(stats)):]
+	if limit > 0 && limit < len(stats) {
+		stats = stats[:limit]
+	}
+	return stats
+}
+
+// pageLinks returns the query strings of the previous and next pages, if any
+func pageLinks(q url.Values, offset int, limit int, total int) (template.URL, template.URL) {
+	if limit == 0 {
+		return "", ""
+	}
+	link := func(off int) template.URL {
+		q2 := url.Values{}
+		for k, v := range q {
+			q2[k] = v
+		}
+		q2.Set("offset", strconv.Itoa(off))
+		q2.Set("limit", strconv.Itoa(limit))
+		return template.URL("?" + q2.Encode())
+	}
+	var prev, next template.URL
+	if offset > 0 {
+		prev = link(max(offset-limit, 0))
+	}
+	if offset+limit < total {
+		next = link(offset + limit)
+	}
+	return prev, next
+}
+
+type crumb struct {
+	Name string
+	URL  template.URL
+}
+
+// breadcrumbs links the folders a page is in, from the root down, e.g. ./ a/ b/ for /a/b/,
+// ending with the file itself when the title isn't a folder, e.g. ./ a/ b.md for /a/b.md
+func breadcrumbs(title string) []crumb {
+	link := (&url.URL{Path: *extraPath}).EscapedPath()
+	res := []crumb{{Name: "./", URL: template.URL(link)}}
+	names := strings.Split(strings.Trim(title, "/"), "/")
+	for i, name := range names {
+		if name == "" {
+			continue
+		}
+		link += url.PathEscape(name)
+		if i < len(names)-1 || strings.HasSuffix(title, "/") {
+			name, link = name+"/", link+"/"
+		}
+		res = append(res, crumb{Name: name, URL: template.URL(link)})
+	}
+	return res
+}
+
+// listDir returns the visible entries of a folder matching ?filter=, sorted,
+// for the requested page, along with the count of all such entries. Unless
+// sorting by size or mtime, only the entries of the page are stat'ed.
+func listDir(fullPath string, q url.Values) ([]os.FileInfo, int, error) {
+	if *listCacheTTL > 0 {
+		stats, err := cachedStats(fullPath, func() ([]os.FileInfo, error) { return visibleEntries(fullPath) })
+		if err != nil {
+			return nil, 0, err
+		}
+		infos := append([]os.FileInfo(nil), stats...) // sorted and filtered in place
+		return listPage(infos, q, func(el os.FileInfo) (os.FileInfo, error) { return el, nil })
+	}
+	infos, err := visibleEntries(fullPath)
+	if err != nil {
+		return nil, 0, err
+	}
+	return listPage(infos, q, func(el os.FileInfo) (os.FileInfo, error) {
+		return entryInfo(fullPath, el.(dirEntryInfo))
+	})
+}
+
+// entryInfo stats an entry of a folder. ReadDir knows all about the entries
+// but symlinks, which are followed: only these cost a stat, or a request to
+// the remote backends
+func entryInfo(fullPath string, el os.DirEntry) (os.FileInfo, error) {
+	if el.Type()&os.ModeSymlink == 0 {
+		return el.Info()
+	}
+	return store.Stat(filepath.Join(fullPath, el.Name()))
+}
+
+// visibleEntries returns the entries of a folder that may be listed, not stat'ed yet
+func visibleEntries(fullPath string) ([]os.FileInfo, error) {
+	files, err := store.ReadDir(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	var infos []os.FileInfo
+	ig := ignores{}
+	for _, el := range files {
+		p := filepath.Join(fullPath, el.Name())
+		if isHiddenPath(p) || isInternal(p) || ig.match(p, el.IsDir()) {
+			continue // dont print hidden files if we're not allowed
+		}
+		if el.Type()&os.ModeSymlink != 0 && !*symlinks && !linkListed(resolveLink(p)) {
+			continue // dont follow symlinks if we're not allowed
+		}
+		infos = append(infos, dirEntryInfo{el})
+	}
+	return infos, nil
+}
+
+// listPage filters entries with ?filter=, sorts them and returns the page
+// requested, stat'ed, along with the count of the entries matching
+func listPage(infos []os.FileInfo, q url.Values, restat func(os.FileInfo) (os.FileInfo, error)) ([]os.FileInfo, int, error) {
+	filter := strings.ToLower(q.Get("filter"))
+	if _, err := path.Match(filter, ""); err != nil {
+		return nil, 0, badCall("invalid filter %s", filter)
+	}
+	if filter != "" {
+		matching := infos[:0]
+		for _, el := range infos {
+			if ok, _ := path.Match(filter, strings.ToLower(el.Name())); ok {
+				matching = append(matching, el)
+			}
+		}
+		infos = matching
+	}
+	total := len(infos)
+	var err error
+
+	by, order := q.Get("sort"), q.Get("order")
+	if by == "" {
+		by = *sortDefault
+	}
+	offset, limit := pageBounds(q)
+	byName := by != "size" && by != "mtime"
+	if byName {
+		if err = sortListing(infos, by, order); err != nil {
+			return nil, 0, err
+		}
+		infos = page(infos, offset, limit)
+	}
+
+	var stats []os.FileInfo
+	for _, el := range infos {
+		stat, err := restat(el)
+		if err != nil {
+			log.Println("error - cant stat a file", err)
+			continue
+		}
+		stats = append(stats, stat)
+	}
+	if !byName {
+		if err = sortListing(stats, by, order); err != nil {
+			return nil, 0, err
+		}
+		stats = page(stats, offset, limit)
+	}
+	return stats, total, nil
+}
@@ -0,0 +1,147 @@
+package gossa
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+type liveEvent struct {
+	Type string `json:"type"` // created, modified or deleted
+	Path string `json:"path"`
+}
+
+// liveKeepalive is how often idle event streams are written to, so proxies keep them open
+const liveKeepalive = 30 * time.Second
+
+// with -live, the folders viewed are watched as long as someone views them
+var live = struct {
+	sync.Mutex
+	watcher *fsnotify.Watcher
+	subs    map[string]map[chan liveEvent]bool // by folder
+}{subs: map[string]map[chan liveEvent]bool{}}
+
+func liveSubscribe(fullPath string) (chan liveEvent, error) {
+	live.Lock()
+	defer live.Unlock()
+	if len(live.subs[fullPath]) == 0 {
+		if err := live.watcher.Add(fullPath); err != nil {
+			return nil, err
+		}
+		live.subs[fullPath] = map[chan liveEvent]bool{}
+	}
+	c := make(chan liveEvent, 64)
+	live.subs[fullPath][c] = true
+	return c, nil
+}
+
+func liveUnsubscribe(fullPath string, c chan liveEvent) {
+	live.Lock()
+	defer live.Unlock()
+	delete(live.subs[fullPath], c)
+	if len(live.subs[fullPath]) == 0 {
+		delete(live.subs, fullPath)
+		live.watcher.Remove(fullPath)
+	}
+}
+
+// liveType names a change as reported to the ui, empty for the ones not shown
+func liveType(op fsnotify.Op) string {
+	switch {
+	case op.Has(fsnotify.Create):
+		return "created"
+	case op.Has(fsnotify.Remove), op.Has(fsnotify.Rename):
+		return "deleted" // the new name of a rename comes as created
+	case op.Has(fsnotify.Write), op.Has(fsnotify.Chmod):
+		return "modified"
+	}
+	return ""
+}
+
+// liveWatcher hands the changes of the folders viewed to their viewers
+func liveWatcher() {
+	ig := ignores{}
+	for {
+		select {
+		case e := <-live.watcher.Events:
+			name := filepath.Base(e.Name)
+			if name == ignoreFile {
+				ig = ignores{} // read again what's hidden
+			}
+			typ := liveType(e.Op)
+			if typ == "" || isHiddenPath(e.Name) || isInternal(e.Name) || ig.match(e.Name, false) {
+				continue
+			}
+			ev := liveEvent{Type: typ, Path: relPath(e.Name)}
+			live.Lock()
+			for c := range live.subs[filepath.Dir(e.Name)] {
+				select {
+				case c <- ev:
+				default: // a viewer too slow misses events, the next refreshes its listing anyway
+				}
+			}
+			live.Unlock()
+		case err := <-live.watcher.Errors:
+			log.Println("error - live watcher", err)
+		}
+	}
+}
+
+// liveSetup makes the watcher of -live, liveWatcher being started once gossa is set up
+func liveSetup() {
+	var err error
+	live.watcher, err = fsnotify.NewWatcher()
+	check(err)
+}
+
+// liveHandler streams the changes of a folder as server-sent events, until the client leaves
+func liveHandler(w http.ResponseWriter, r *http.Request) {
+	p := r.URL.Query().Get("path")
+	defer exitPath(w, "live", p)
+	fullPath := enforcePath(p)
+	stat, err := store.Stat(fullPath)
+	check(err)
+	if !stat.IsDir() {
+		check(badCall("not a folder"))
+	}
+	events, err := liveSubscribe(fullPath)
+	check(err)
+	defer liveUnsubscribe(fullPath, events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher, _ := w.(http.Flusher)
+	flush := func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	fmt.Fprint(w, ": watching\n\n")
+	flush()
+
+	keepalive := time.NewTicker(liveKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case ev := <-events:
+			b, _ := json.Marshal(ev)
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, b); err != nil {
+				return
+			}
+			flush()
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
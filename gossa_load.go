@@ -0,0 +1,83 @@
+package gossa
+
+import (
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// caps how many requests are served at once, sized by -max-requests, nil when unlimited
+var requestSlots chan struct{}
+
+// loadShed replies 503 right away to the requests past -max-requests, rather
+// than letting them all compete for the disk and cpu. Event streams, which
+// stay open as long as a folder is viewed, don't count
+func loadShed(h http.Handler) http.Handler {
+	if *maxRequests <= 0 {
+		return h
+	}
+	requestSlots = make(chan struct{}, *maxRequests)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == *extraPath+"events" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		select {
+		case requestSlots <- struct{}{}:
+			defer func() { <-requestSlots }()
+			h.ServeHTTP(w, r)
+		default:
+			w.Header().Set("Retry-After", "1")
+			replyError(w, errOverloaded)
+		}
+	})
+}
+
+// overloaded is written as is to the connections past -max-conns
+const overloaded = "HTTP/1.1 503 Service Unavailable\r\nRetry-After: 1\r\nConnection: close\r\nContent-Length: 0\r\n\r\n"
+
+// connLimiter turns away the connections past its limit with a 503
+type connLimiter struct {
+	net.Listener
+	open  atomic.Int64
+	limit int64
+}
+
+func (l *connLimiter) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if l.open.Add(1) <= l.limit {
+			return &limitedConn{Conn: c, l: l}, nil
+		}
+		l.open.Add(-1)
+		c.SetWriteDeadline(time.Now().Add(time.Second))
+		c.Write([]byte(overloaded))
+		c.Close()
+	}
+}
+
+type limitedConn struct {
+	net.Conn
+	l      *connLimiter
+	closed atomic.Bool
+}
+
+func (c *limitedConn) Close() error {
+	if c.closed.CompareAndSwap(false, true) {
+		c.l.open.Add(-1)
+	}
+	return c.Conn.Close()
+}
+
+// listen opens the listener of Run, capped to -max-conns
+func listen(addr string) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil || *maxConns <= 0 {
+		return ln, err
+	}
+	return &connLimiter{Listener: ln, limit: int64(*maxConns)}, nil
+}
@@ -0,0 +1,228 @@
+package gossa
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf16"
+)
+
+var audioExts = map[string]bool{"mp3": true, "flac": true, "ogg": true, "oga": true, "opus": true, "m4a": true, "aac": true, "wav": true, "wma": true, "aiff": true}
+
+// mediaInfo is what listings show of audio and video files, with ?media=1
+type mediaInfo struct {
+	Duration   float64 `json:"duration,omitempty"` // seconds
+	VideoCodec string  `json:"videoCodec,omitempty"`
+	AudioCodec string  `json:"audioCodec,omitempty"`
+	Title      string  `json:"title,omitempty"`
+	Artist     string  `json:"artist,omitempty"`
+	Album      string  `json:"album,omitempty"`
+}
+
+// media probed, by path and mtime, as probing runs ffmpeg once per file
+var mediaCache = struct {
+	sync.Mutex
+	m map[string]*mediaInfo
+}{m: map[string]*mediaInfo{}}
+
+const mediaCacheMax = 100_000
+
+var (
+	durationRe = regexp.MustCompile(`Duration: (\d+):(\d\d):(\d\d(?:\.\d+)?)`)
+	codecRe    = regexp.MustCompile(`Stream #\d+:\d+.*?: (Video|Audio): (\w+)`)
+	tagRe      = regexp.MustCompile(`(?m)^\s+(title|artist|album)\s*: (.+)$`)
+)
+
+func isAudio(fullPath string) bool {
+	return audioExts[strings.ToLower(strings.TrimPrefix(filepath.Ext(fullPath), "."))]
+}
+
+// ffmpegProbe returns what ffmpeg prints of a file when given no output
+func ffmpegProbe(fullPath string) string {
+	input, stdin, closeInput, err := ffmpegInput(fullPath)
+	if err != nil {
+		return ""
+	}
+	defer closeInput()
+	ffmpegSlots <- struct{}{}
+	defer func() { <-ffmpegSlots }()
+	ctx, cancel := context.WithTimeout(context.Background(), *ffmpegTimeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, ffmpegBin, "-hide_banner", "-nostdin", "-i", input)
+	cmd.Stdin, cmd.Stderr = stdin, &stderr
+	cmd.Run() // errors as no output is given, the file is described nonetheless
+	return stderr.String()
+}
+
+// parseProbe reads the duration, codecs and tags of a file out of ffmpeg's description
+func parseProbe(probe string) *mediaInfo {
+	info := &mediaInfo{}
+	head, _, _ := strings.Cut(probe, "Duration:") // the tags of the file, not of its streams
+	for _, m := range tagRe.FindAllStringSubmatch(head, -1) {
+		switch v := strings.TrimSpace(m[2]); m[1] {
+		case "title":
+			info.Title = v
+		case "artist":
+			info.Artist = v
+		case "album":
+			info.Album = v
+		}
+	}
+	if m := durationRe.FindStringSubmatch(probe); m != nil {
+		h, _ := strconv.Atoi(m[1])
+		min, _ := strconv.Atoi(m[2])
+		sec, _ := strconv.ParseFloat(m[3], 64)
+		info.Duration = float64(h*3600+min*60) + sec
+	}
+	for _, m := range codecRe.FindAllStringSubmatch(probe, -1) {
+		if m[1] == "Video" && info.VideoCodec == "" {
+			info.VideoCodec = m[2]
+		} else if m[1] == "Audio" && info.AudioCodec == "" {
+			info.AudioCodec = m[2]
+		}
+	}
+	return info
+}
+
+// id3Text decodes an id3v2 text frame, its first byte telling the encoding
+func id3Text(b []byte) string {
+	if len(b) < 1 {
+		return ""
+	}
+	enc, b := b[0], b[1:]
+	var s string
+	switch enc {
+	case 1, 2: // utf-16, with a bom or big endian
+		var order binary.ByteOrder = binary.BigEndian
+		if len(b) >= 2 && b[0] == 0xff && b[1] == 0xfe {
+			order, b = binary.LittleEndian, b[2:]
+		} else if len(b) >= 2 && b[0] == 0xfe && b[1] == 0xff {
+			b = b[2:]
+		}
+		u := make([]uint16, len(b)/2)
+		for i := range u {
+			u[i] = order.Uint16(b[2*i:])
+		}
+		s = string(utf16.Decode(u))
+	case 3:
+		s = string(b)
+	default:
+		s = latin1(b)
+	}
+	s, _, _ = strings.Cut(s, "\x00")
+	return strings.TrimSpace(s)
+}
+
+func latin1(b []byte) string {
+	r := make([]rune, len(b))
+	for i, c := range b {
+		r[i] = rune(c)
+	}
+	return string(r)
+}
+
+func syncsafe(b []byte) int {
+	return int(b[0])<<21 | int(b[1])<<14 | int(b[2])<<7 | int(b[3])
+}
+
+// readID3 reads the title, artist and album of an mp3 from its id3v2 tag,
+// else its id3v1 one
+func readID3(fullPath string) *mediaInfo {
+	info := &mediaInfo{}
+	f, err := store.Open(fullPath)
+	if err != nil {
+		return info
+	}
+	defer f.Close()
+
+	head := make([]byte, 10)
+	if _, err := io.ReadFull(f, head); err == nil && string(head[:3]) == "ID3" && head[3] >= 2 && head[3] <= 4 {
+		tag := make([]byte, min(syncsafe(head[6:]), 1<<20))
+		n, _ := io.ReadFull(f, tag)
+		tag = tag[:n]
+		version, idLen, headLen := head[3], 4, 10
+		if version == 2 {
+			idLen, headLen = 3, 6
+		}
+		if head[5]&0x40 != 0 && version > 2 && len(tag) >= 4 { // extended header
+			skip := int(binary.BigEndian.Uint32(tag)) + 4
+			if version == 4 {
+				skip = syncsafe(tag)
+			}
+			tag = tag[min(skip, len(tag)):]
+		}
+		frames := map[string]*string{"TIT2": &info.Title, "TPE1": &info.Artist, "TALB": &info.Album, "TT2": &info.Title, "TP1": &info.Artist, "TAL": &info.Album}
+		for len(tag) >= headLen && tag[0] != 0 {
+			id := string(tag[:idLen])
+			var size int
+			switch version {
+			case 2:
+				size = int(tag[3])<<16 | int(tag[4])<<8 | int(tag[5])
+			case 3:
+				size = int(binary.BigEndian.Uint32(tag[4:]))
+			default:
+				size = syncsafe(tag[4:])
+			}
+			if size < 0 || headLen+size > len(tag) {
+				break
+			}
+			if dst := frames[id]; dst != nil && *dst == "" {
+				*dst = id3Text(tag[headLen : headLen+size])
+			}
+			tag = tag[headLen+size:]
+		}
+		if info.Title != "" || info.Artist != "" {
+			return info
+		}
+	}
+
+	v1 := make([]byte, 128)
+	if stat, err := f.Stat(); err == nil && stat.Size() >= 128 {
+		if _, err := f.ReadAt(v1, stat.Size()-128); err == nil && string(v1[:3]) == "TAG" {
+			field := func(b []byte) string {
+				s, _, _ := strings.Cut(latin1(b), "\x00")
+				return strings.TrimSpace(s)
+			}
+			info.Title, info.Artist, info.Album = field(v1[3:33]), field(v1[33:63]), field(v1[63:93])
+		}
+	}
+	return info
+}
+
+// mediaInfoOf describes an audio or video file, probed by ffmpeg when
+// available, else only the tags of mp3s are read
+func mediaInfoOf(fullPath string, stat os.FileInfo) *mediaInfo {
+	key := fullPath + "\x00" + stat.ModTime().String()
+	mediaCache.Lock()
+	info, ok := mediaCache.m[key]
+	mediaCache.Unlock()
+	if ok {
+		return info
+	}
+
+	info = &mediaInfo{}
+	if ffmpegBin != "" {
+		info = parseProbe(ffmpegProbe(fullPath))
+	}
+	if strings.EqualFold(filepath.Ext(fullPath), ".mp3") && info.Title == "" && info.Artist == "" {
+		tags := readID3(fullPath)
+		info.Title, info.Artist, info.Album = tags.Title, tags.Artist, tags.Album
+	}
+
+	mediaCache.Lock()
+	if len(mediaCache.m) >= mediaCacheMax {
+		mediaCache.m = map[string]*mediaInfo{}
+	}
+	mediaCache.m[key] = info
+	mediaCache.Unlock()
+	return info
+}
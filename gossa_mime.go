@@ -0,0 +1,76 @@
+package gossa
+
+import (
+	"bufio"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+type mimePolicy struct {
+	contentType string
+	disposition string
+}
+
+// per extension overrides, extensions are lowercased and without dot
+var mimePolicies = map[string]*mimePolicy{}
+
+func getMimePolicy(ext string) *mimePolicy {
+	if mimePolicies[ext] == nil {
+		mimePolicies[ext] = &mimePolicy{}
+	}
+	return mimePolicies[ext]
+}
+
+// loadMimeTypes reads an Apache style .types file, e.g. "video/x-matroska mkv mk3d"
+func loadMimeTypes(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		for _, ext := range fields[1:] {
+			getMimePolicy(strings.ToLower(strings.TrimPrefix(ext, "."))).contentType = fields[0]
+		}
+	}
+	return scanner.Err()
+}
+
+// loadDispositions parses a list such as "html=attachment,mkv=inline"
+func loadDispositions(list string) {
+	for _, el := range splitList(list) {
+		ext, disp, _ := strings.Cut(el, "=")
+		getMimePolicy(strings.ToLower(strings.TrimPrefix(ext, "."))).disposition = disp
+	}
+}
+
+// applyMimePolicy sets the configured content-type and disposition for a file name
+func applyMimePolicy(w http.ResponseWriter, name string) {
+	policy := mimePolicies[strings.ToLower(strings.TrimPrefix(path.Ext(name), "."))]
+	if policy == nil {
+		return
+	}
+	if policy.contentType != "" {
+		w.Header().Set("Content-Type", policy.contentType)
+	}
+	if policy.disposition != "" && w.Header().Get("Content-Disposition") == "" {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType(policy.disposition, map[string]string{"filename": name}))
+	}
+}
+
+// mimeHandler applies the mime policies before handing over to the file server
+func mimeHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		applyMimePolicy(w, path.Base(r.URL.Path))
+		h.ServeHTTP(w, r)
+	})
+}
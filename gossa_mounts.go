@@ -0,0 +1,306 @@
+package gossa
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// mount is a folder shown as a top level folder of the root
+type mount struct {
+	name     string
+	dir      string
+	resolved string // dir, its symlinks evaluated
+}
+
+// mountStorage lays folders over the top level of the root, e.g. /media
+// being /mnt/media. Without a folder to share, the root is synthesized: only
+// the mounts are listed and written to, gossa keeping its own state (trash,
+// versions, caches) in a private folder
+type mountStorage struct {
+	root        string
+	mounts      []mount
+	synthesized bool
+}
+
+// newMountStorage parses mounts as name=/dir,name2=/dir2
+func newMountStorage(root string, spec string, synthesized bool) (*mountStorage, error) {
+	s := &mountStorage{root: root, synthesized: synthesized}
+	for _, el := range strings.Split(spec, ",") {
+		name, dir, ok := strings.Cut(strings.TrimSpace(el), "=")
+		name = strings.Trim(name, "/")
+		if !ok || name == "" || strings.Contains(name, "/") || name == "." || name == ".." || dir == "" {
+			return nil, fmt.Errorf("invalid mount %q, expected e.g. media=/mnt/media", el)
+		}
+		for _, m := range s.mounts {
+			if m.name == name {
+				return nil, fmt.Errorf("mount %s given twice", name)
+			}
+		}
+		dir, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("mount %s: %s isn't a folder", name, dir)
+		}
+		resolved, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			return nil, err
+		}
+		s.mounts = append(s.mounts, mount{name, dir, resolved})
+	}
+	return s, nil
+}
+
+// real is the path a name is at, along with the mount it's in, if any
+func (s *mountStorage) real(op string, name string) (string, *mount, error) {
+	rel, err := filepath.Rel(s.root, name)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return name, nil, nil // the root, or temp files already real
+	}
+	top, rest, _ := strings.Cut(rel, string(filepath.Separator))
+	for i, el := range s.mounts {
+		if el.name == top {
+			return filepath.Join(el.dir, rest), &s.mounts[i], nil
+		}
+	}
+	if s.synthesized && !isInternal(name) {
+		return "", nil, &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+	return name, nil, nil
+}
+
+// point errors on writes to a mount point itself, which would hit the folder it mounts
+func (s *mountStorage) point(op string, name string) error {
+	if real, m, err := s.real(op, name); err != nil {
+		return err
+	} else if m != nil && real == m.dir {
+		return &os.PathError{Op: op, Path: name, Err: os.ErrPermission}
+	} else if m == nil && s.synthesized && filepath.Dir(name) == s.root && !isInternal(name) {
+		return &os.PathError{Op: op, Path: name, Err: os.ErrPermission}
+	}
+	return nil
+}
+
+// mountInfo renames the folder of a mount point
+type mountInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (i mountInfo) Name() string { return i.name }
+
+// mountFile keeps the name a file was opened with, so it can be renamed
+// to its final name once written
+type mountFile struct {
+	File
+	name   string
+	s      *mountStorage
+	listed bool
+}
+
+func (f *mountFile) OSFile() *os.File {
+	raw, _ := f.File.(*os.File)
+	return raw
+}
+
+func (f *mountFile) Name() string { return f.name }
+
+func (f *mountFile) Stat() (os.FileInfo, error) {
+	info, err := f.File.Stat()
+	if err == nil && filepath.Dir(f.name) == f.s.root {
+		if _, m, _ := f.s.real("stat", f.name); m != nil {
+			info = mountInfo{info, m.name}
+		}
+	}
+	return info, err
+}
+
+func (f *mountFile) Readdir(count int) ([]os.FileInfo, error) {
+	if f.name != f.s.root {
+		return f.File.Readdir(count)
+	} else if f.listed && count > 0 {
+		return nil, io.EOF
+	}
+	f.listed = true // at once, whatever the count
+	entries, err := f.s.ReadDir(f.name)
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, el := range entries {
+		if info, err := el.Info(); err == nil {
+			infos = append(infos, info)
+		}
+	}
+	return infos, err
+}
+
+func (s *mountStorage) stat(name string, fn func(string) (os.FileInfo, error)) (os.FileInfo, error) {
+	real, m, err := s.real("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	if m != nil && real == m.dir {
+		info, err := os.Stat(real) // mount points being symlinks or not
+		if err != nil {
+			return nil, err
+		}
+		return mountInfo{info, m.name}, nil
+	}
+	return fn(real)
+}
+
+func (s *mountStorage) Stat(name string) (os.FileInfo, error)  { return s.stat(name, os.Stat) }
+func (s *mountStorage) Lstat(name string) (os.FileInfo, error) { return s.stat(name, os.Lstat) }
+
+func (s *mountStorage) ReadDir(name string) ([]os.DirEntry, error) {
+	real, _, err := s.real("readdir", name)
+	if err != nil {
+		return nil, err
+	} else if name != s.root {
+		return os.ReadDir(real)
+	}
+	var entries []os.DirEntry
+	if !s.synthesized {
+		if entries, err = os.ReadDir(real); err != nil {
+			return nil, err
+		}
+	}
+	out := entries[:0]
+	for _, el := range entries {
+		if _, m, _ := s.real("readdir", filepath.Join(name, el.Name())); m == nil {
+			out = append(out, el) // not shadowed by a mount
+		}
+	}
+	for _, el := range s.mounts {
+		info, err := os.Stat(el.dir)
+		if err != nil {
+			continue // gone since, e.g. an unplugged drive
+		}
+		out = append(out, fs.FileInfoToDirEntry(mountInfo{info, el.name}))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name() < out[j].Name() })
+	return out, nil
+}
+
+func (s *mountStorage) Open(name string) (File, error) {
+	return s.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (s *mountStorage) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	real, _, err := s.real("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE) != 0 {
+		if err = s.point("open", name); err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.OpenFile(real, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &mountFile{File: f, name: name, s: s}, nil
+}
+
+func (s *mountStorage) Create(name string) (File, error) {
+	return s.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+// write runs an operation changing a path, but the mount points
+func (s *mountStorage) write(op string, name string, fn func(real string) error) error {
+	if err := s.point(op, name); err != nil {
+		return err
+	}
+	real, _, err := s.real(op, name)
+	if err != nil {
+		return err
+	}
+	return fn(real)
+}
+
+func (s *mountStorage) Mkdir(name string, perm os.FileMode) error {
+	return s.write("mkdir", name, func(real string) error { return os.Mkdir(real, perm) })
+}
+
+func (s *mountStorage) MkdirAll(name string, perm os.FileMode) error {
+	if info, err := s.Stat(name); err == nil && info.IsDir() {
+		return nil // the mount points included
+	}
+	return s.write("mkdir", name, func(real string) error { return os.MkdirAll(real, perm) })
+}
+
+func (s *mountStorage) Rename(oldname string, newname string) error {
+	if err := s.point("rename", newname); err != nil {
+		return err
+	}
+	newReal, _, err := s.real("rename", newname)
+	if err != nil {
+		return err
+	}
+	return s.write("rename", oldname, func(real string) error { return os.Rename(real, newReal) })
+}
+
+func (s *mountStorage) Remove(name string) error {
+	return s.write("remove", name, os.Remove)
+}
+
+func (s *mountStorage) RemoveAll(name string) error {
+	return s.write("remove", name, os.RemoveAll)
+}
+
+func (s *mountStorage) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return s.write("chtimes", name, func(real string) error { return os.Chtimes(real, atime, mtime) })
+}
+
+func (s *mountStorage) Chmod(name string, mode os.FileMode) error {
+	return s.write("chmod", name, func(real string) error { return os.Chmod(real, mode) })
+}
+
+func (s *mountStorage) Symlink(oldname string, newname string) error {
+	return s.write("symlink", newname, func(real string) error { return os.Symlink(oldname, real) })
+}
+
+func (s *mountStorage) Readlink(name string) (string, error) {
+	real, _, err := s.real("readlink", name)
+	if err != nil {
+		return "", err
+	}
+	return os.Readlink(real)
+}
+
+// EvalSymlinks resolves a path, mapping it back below the root when it
+// lands in a mount, so links within mounts stay in bounds
+func (s *mountStorage) EvalSymlinks(name string) (string, error) {
+	real, _, err := s.real("readlink", name)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(real)
+	if err != nil {
+		return "", err
+	}
+	for _, el := range s.mounts {
+		if rel, err := filepath.Rel(el.resolved, resolved); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return filepath.Join(s.root, el.name, rel), nil
+		}
+	}
+	return resolved, nil
+}
+
+func (s *mountStorage) Lchown(name string, uid int, gid int) error {
+	return s.write("chown", name, func(real string) error { return os.Lchown(real, uid, gid) })
+}
+
+func (s *mountStorage) DiskSpace(name string) (uint64, uint64, error) {
+	real, _, err := s.real("statfs", name)
+	if err != nil {
+		return 0, 0, err
+	}
+	return diskSpace(real)
+}
@@ -0,0 +1,181 @@
+package gossa
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// copyFile copies a regular file, keeping its mode and mtime
+func copyFile(ctx context.Context, src string, dst string, stat os.FileInfo) error {
+	in, err := store.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := store.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, stat.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = copyBuffer(out, ctxReader{ctx, in})
+	if errClose := out.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		return err
+	}
+	return store.Chtimes(dst, stat.ModTime(), stat.ModTime())
+}
+
+// copyPath copies a file or a folder recursively. The destination must not exist.
+// What's copied once ctx is done is left in place
+func copyPath(ctx context.Context, src string, dst string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if dst == src || strings.HasPrefix(dst, src+string(filepath.Separator)) {
+		return badCall("cant copy a folder into itself")
+	}
+	stat, err := store.Lstat(src)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case stat.Mode()&os.ModeSymlink != 0:
+		if !*symlinks {
+			return nil // dont follow symlinks if we're not allowed
+		}
+		ls, err := linkStore()
+		if err != nil {
+			return err
+		}
+		target, err := ls.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return ls.Symlink(target, dst)
+
+	case stat.IsDir():
+		err = store.Mkdir(dst, stat.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		entries, err := store.ReadDir(src)
+		if err != nil {
+			return err
+		}
+		for _, el := range entries {
+			err = copyPath(ctx, filepath.Join(src, el.Name()), filepath.Join(dst, el.Name()))
+			if err != nil {
+				return err
+			}
+		}
+		return store.Chtimes(dst, stat.ModTime(), stat.ModTime())
+
+	case stat.Mode().IsRegular():
+		return copyFile(ctx, src, dst, stat)
+	}
+	return nil // devices, sockets, pipes...
+}
+
+// touchPath creates an empty file, or bumps the mtime of an existing file or folder
+func touchPath(p string) error {
+	if _, err := store.Stat(p); errors.Is(err, os.ErrNotExist) {
+		f, err := store.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+		if err != nil {
+			return err
+		}
+		return f.Close()
+	}
+	now := time.Now()
+	return store.Chtimes(p, now, now)
+}
+
+// chmodPath sets the permission bits of a path, from an octal string such as "755".
+// setuid, setgid and sticky bits are refused.
+func chmodPath(p string, mode string) error {
+	m, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || m > 0777 {
+		return badCall("invalid mode %s", mode)
+	}
+	return store.Chmod(p, os.FileMode(m))
+}
+
+// lookupID resolves a user or group name, or a numeric id
+func lookupID(name string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	id, err := lookup(name)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(id)
+}
+
+// chownPath changes the owner of a path from "user", "user:group" or ":group". Only available to root.
+func chownPath(p string, owner string) error {
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("chown is only available when running as root: %w", os.ErrPermission)
+	}
+	uid, gid := -1, -1
+	userName, groupName, _ := strings.Cut(owner, ":")
+	var err error
+	if userName != "" {
+		uid, err = lookupID(userName, func(n string) (string, error) {
+			u, err := user.Lookup(n)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if groupName != "" {
+		gid, err = lookupID(groupName, func(n string) (string, error) {
+			g, err := user.LookupGroup(n)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	ls, err := linkStore()
+	if err != nil {
+		return err
+	}
+	return ls.Lchown(p, uid, gid)
+}
+
+// linkPath creates a relative symlink at link pointing to target. The target
+// must exist and resolve inside the root, whatever -symlinks says.
+func linkPath(target string, link string) error {
+	ls, err := linkStore()
+	if err != nil {
+		return err
+	}
+	resolved, err := ls.EvalSymlinks(target)
+	if err != nil {
+		return err
+	}
+	if resolved != rootPath && !strings.HasPrefix(resolved, rootPath+string(filepath.Separator)) || isInternal(resolved) {
+		return errInvalidPath
+	}
+	rel, err := filepath.Rel(filepath.Dir(link), target)
+	if err != nil {
+		return err
+	}
+	return ls.Symlink(rel, link)
+}
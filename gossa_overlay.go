@@ -0,0 +1,489 @@
+package gossa
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// whiteouts mark what was deleted from the lower folder, as with aufs: .wh.a
+// in the upper folder hides a, and .wh..wh..opq everything of the lower folder
+// below the folder it's in
+const (
+	whiteoutPrefix = ".wh."
+	opaqueMarker   = ".wh..wh..opq"
+)
+
+// overlayStorage lays a writable folder over the one shared, which is never
+// written to: files are copied up to the upper folder before being changed,
+// and deletions recorded there as whiteouts
+type overlayStorage struct {
+	root          string // the lower folder, as named below rootPath
+	upper         string
+	lowerResolved string // symlinks evaluated
+	upperResolved string
+}
+
+func newOverlayStorage(lower string, upper string) (*overlayStorage, error) {
+	upper, err := filepath.Abs(upper)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(upper, 0755); err != nil {
+		return nil, err
+	}
+	s := &overlayStorage{root: lower, upper: upper}
+	if s.lowerResolved, err = filepath.EvalSymlinks(lower); err != nil {
+		return nil, err
+	} else if s.upperResolved, err = filepath.EvalSymlinks(upper); err != nil {
+		return nil, err
+	} else if inside(s.lowerResolved, s.upperResolved) || inside(s.upperResolved, s.lowerResolved) {
+		return nil, fmt.Errorf("overlay %s and the folder shared can't be in one another", upper)
+	}
+	return s, nil
+}
+
+func exists(p string) bool {
+	_, err := os.Lstat(p)
+	return err == nil
+}
+
+func (s *overlayStorage) rel(op string, name string) (string, error) {
+	if !inside(s.root, name) {
+		return "", &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+	rel, _ := filepath.Rel(s.root, name)
+	return rel, nil
+}
+
+func (s *overlayStorage) whiteout(rel string) string {
+	return filepath.Join(s.upper, filepath.Dir(rel), whiteoutPrefix+filepath.Base(rel))
+}
+
+// lowerVisible tells if the lower folder still shows at rel, not hidden by
+// a whiteout, an opaque folder, or a file replacing one of its folders
+func (s *overlayStorage) lowerVisible(rel string) bool {
+	if rel == "." {
+		return true
+	}
+	parts := strings.Split(rel, string(filepath.Separator))
+	dir := s.upper
+	for _, el := range parts {
+		info, err := os.Lstat(dir)
+		if err != nil {
+			return true // nothing deeper in the upper folder
+		} else if !info.IsDir() || exists(filepath.Join(dir, opaqueMarker)) || exists(filepath.Join(dir, whiteoutPrefix+el)) {
+			return false
+		}
+		dir = filepath.Join(dir, el)
+	}
+	return true
+}
+
+// locate is where a name is read from, the upper folder first
+func (s *overlayStorage) locate(op string, name string) (string, string, error) {
+	rel, err := s.rel(op, name)
+	if err != nil {
+		return "", "", err
+	} else if up := filepath.Join(s.upper, rel); exists(up) {
+		return up, rel, nil
+	} else if low := filepath.Join(s.root, rel); rel == "." || s.lowerVisible(rel) && exists(low) {
+		return low, rel, nil
+	}
+	return "", "", &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+}
+
+// inLower tells if the lower folder shows something at rel
+func (s *overlayStorage) inLower(rel string) bool {
+	return rel != "." && s.lowerVisible(rel) && exists(filepath.Join(s.root, rel))
+}
+
+// copyUp makes rel exist in the upper folder, with its parents: files with
+// their content, folders empty, their children still showing from below
+func (s *overlayStorage) copyUp(rel string) error {
+	up := filepath.Join(s.upper, rel)
+	if rel == "." || exists(up) {
+		return nil
+	} else if err := s.copyUp(filepath.Dir(rel)); err != nil {
+		return err
+	}
+	low := filepath.Join(s.root, rel)
+	if !s.lowerVisible(rel) {
+		return &os.PathError{Op: "copyup", Path: low, Err: os.ErrNotExist}
+	}
+	info, err := os.Lstat(low)
+	if err != nil {
+		return err
+	}
+	switch {
+	case info.IsDir():
+		err = os.Mkdir(up, info.Mode().Perm())
+	case info.Mode()&os.ModeSymlink != 0:
+		var target string
+		if target, err = os.Readlink(low); err == nil {
+			return os.Symlink(target, up)
+		}
+	default:
+		err = copyUpFile(low, up, info.Mode().Perm())
+	}
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(up, info.ModTime(), info.ModTime())
+}
+
+func copyUpFile(src string, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	_, err = copyBuffer(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
+
+// copyUpTree copies a folder up with all it shows, before it's moved
+func (s *overlayStorage) copyUpTree(rel string) error {
+	if err := s.copyUp(rel); err != nil {
+		return err
+	}
+	entries, err := s.readDir(rel)
+	if err != nil {
+		return nil // not a folder
+	}
+	for _, el := range entries {
+		if err = s.copyUpTree(filepath.Join(rel, el.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clearWhiteout removes the whiteout of rel, telling if there was one
+func (s *overlayStorage) clearWhiteout(rel string) (bool, error) {
+	err := os.Remove(s.whiteout(rel))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// hide puts a whiteout over rel, when the lower folder has it
+func (s *overlayStorage) hide(rel string, inLower bool) error {
+	if !inLower {
+		return nil
+	} else if err := s.copyUp(filepath.Dir(rel)); err != nil {
+		return err
+	}
+	return os.WriteFile(s.whiteout(rel), nil, 0600)
+}
+
+// opaque hides what the lower folder has below rel, when it has it
+func (s *overlayStorage) opaque(rel string, inLower bool) error {
+	if !inLower {
+		return nil
+	}
+	return os.WriteFile(filepath.Join(s.upper, rel, opaqueMarker), nil, 0600)
+}
+
+func (s *overlayStorage) stat(op string, name string, fn func(string) (os.FileInfo, error)) (os.FileInfo, error) {
+	real, _, err := s.locate(op, name)
+	if err != nil {
+		return nil, err
+	}
+	return fn(real)
+}
+
+func (s *overlayStorage) Stat(name string) (os.FileInfo, error) { return s.stat("stat", name, os.Stat) }
+func (s *overlayStorage) Lstat(name string) (os.FileInfo, error) {
+	return s.stat("lstat", name, os.Lstat)
+}
+
+// readDir merges the upper and lower folders at rel
+func (s *overlayStorage) readDir(rel string) ([]os.DirEntry, error) {
+	up := filepath.Join(s.upper, rel)
+	upper, uerr := os.ReadDir(up)
+	var lower []os.DirEntry
+	lerr := os.ErrNotExist
+	if !exists(filepath.Join(up, opaqueMarker)) && (rel == "." || s.lowerVisible(rel)) {
+		lower, lerr = os.ReadDir(filepath.Join(s.root, rel))
+	}
+	if uerr != nil && lerr != nil {
+		return nil, lerr
+	}
+	seen := map[string]bool{}
+	var out []os.DirEntry
+	for _, el := range upper {
+		if !strings.HasPrefix(el.Name(), whiteoutPrefix) {
+			seen[el.Name()] = true
+			out = append(out, el)
+		}
+	}
+	for _, el := range lower {
+		if !seen[el.Name()] && !exists(filepath.Join(up, whiteoutPrefix+el.Name())) {
+			out = append(out, el)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name() < out[j].Name() })
+	return out, nil
+}
+
+func (s *overlayStorage) ReadDir(name string) ([]os.DirEntry, error) {
+	info, err := s.Stat(name)
+	if err != nil {
+		return nil, err
+	} else if !info.IsDir() {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: syscall.ENOTDIR}
+	}
+	rel, _ := s.rel("readdir", name)
+	return s.readDir(rel)
+}
+
+// overlayFile keeps the name a file was opened with, listing folders merged
+type overlayFile struct {
+	File
+	name string
+	s    *overlayStorage
+	dirLister
+}
+
+func (f *overlayFile) OSFile() *os.File {
+	raw, _ := f.File.(*os.File)
+	return raw
+}
+
+func (f *overlayFile) Name() string { return f.name }
+
+func (f *overlayFile) Readdir(count int) ([]os.FileInfo, error) {
+	return f.readdir(func() ([]os.DirEntry, error) { return f.s.ReadDir(f.name) }, count)
+}
+
+func (s *overlayStorage) Open(name string) (File, error) {
+	return s.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (s *overlayStorage) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	real, rel, err := s.locate("open", name)
+	writes := flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0
+	switch {
+	case err != nil && (!writes || flag&os.O_CREATE == 0):
+		return nil, err
+	case err != nil: // a new file
+		if rel, err = s.rel("open", name); err != nil {
+			return nil, err
+		} else if err = s.copyUp(filepath.Dir(rel)); err != nil {
+			return nil, err
+		} else if _, err = s.clearWhiteout(rel); err != nil {
+			return nil, err
+		}
+		real = filepath.Join(s.upper, rel)
+	case writes && flag&os.O_EXCL != 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	case writes && flag&os.O_TRUNC != 0 && !exists(filepath.Join(s.upper, rel)):
+		if err = s.copyUp(filepath.Dir(rel)); err != nil { // no need for the content
+			return nil, err
+		}
+		real, flag = filepath.Join(s.upper, rel), flag|os.O_CREATE
+	case writes:
+		if err = s.copyUp(rel); err != nil {
+			return nil, err
+		}
+		real = filepath.Join(s.upper, rel)
+	}
+	f, err := os.OpenFile(real, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &overlayFile{File: f, name: name, s: s}, nil
+}
+
+func (s *overlayStorage) Create(name string) (File, error) {
+	return s.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (s *overlayStorage) Mkdir(name string, perm os.FileMode) error {
+	rel, err := s.rel("mkdir", name)
+	if err != nil {
+		return err
+	} else if _, err = s.Lstat(name); err == nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	} else if err = s.copyUp(filepath.Dir(rel)); err != nil {
+		return err
+	}
+	hidden, err := s.clearWhiteout(rel)
+	if err != nil {
+		return err
+	} else if err = os.Mkdir(filepath.Join(s.upper, rel), perm); err != nil {
+		return err
+	}
+	return s.opaque(rel, hidden) // what was deleted stays so
+}
+
+func (s *overlayStorage) MkdirAll(name string, perm os.FileMode) error {
+	if info, err := s.Stat(name); err == nil && info.IsDir() {
+		return nil
+	} else if err == nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: syscall.ENOTDIR}
+	} else if parent := filepath.Dir(name); parent != name && inside(s.root, parent) {
+		if err = s.MkdirAll(parent, perm); err != nil {
+			return err
+		}
+	}
+	return s.Mkdir(name, perm)
+}
+
+func (s *overlayStorage) remove(name string, all bool) error {
+	info, err := s.Lstat(name)
+	if err != nil {
+		return err
+	}
+	rel, _ := s.rel("remove", name)
+	if rel == "." {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrPermission}
+	} else if info.IsDir() && !all {
+		if entries, err := s.readDir(rel); err != nil {
+			return err
+		} else if len(entries) > 0 {
+			return &os.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+		}
+	}
+	inLower := s.inLower(rel)
+	if err = os.RemoveAll(filepath.Join(s.upper, rel)); err != nil {
+		return err
+	}
+	return s.hide(rel, inLower)
+}
+
+func (s *overlayStorage) Remove(name string) error {
+	return s.remove(name, false)
+}
+
+func (s *overlayStorage) RemoveAll(name string) error {
+	if err := s.remove(name, true); !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Rename copies up what's moved, folders whole, then moves it in the upper folder
+func (s *overlayStorage) Rename(oldname string, newname string) error {
+	info, err := s.Lstat(oldname)
+	if err != nil {
+		return err
+	}
+	oldRel, _ := s.rel("rename", oldname)
+	newRel, err := s.rel("rename", newname)
+	if err != nil {
+		return err
+	} else if oldRel == "." || newRel == "." {
+		return &os.PathError{Op: "rename", Path: oldname, Err: os.ErrPermission}
+	}
+	if dst, err := s.Lstat(newname); err == nil && dst.IsDir() {
+		if entries, err := s.readDir(newRel); err != nil || len(entries) > 0 || !info.IsDir() {
+			return &os.PathError{Op: "rename", Path: newname, Err: syscall.EEXIST}
+		}
+	} else if err == nil && info.IsDir() {
+		return &os.PathError{Op: "rename", Path: newname, Err: syscall.ENOTDIR}
+	}
+	oldLower, newLower := s.inLower(oldRel), s.inLower(newRel)
+	if err = s.copyUpTree(oldRel); err != nil {
+		return err
+	} else if err = s.copyUp(filepath.Dir(newRel)); err != nil {
+		return err
+	} else if err = os.RemoveAll(filepath.Join(s.upper, newRel)); err != nil { // an empty folder, its whiteouts and all
+		return err
+	} else if err = os.Rename(filepath.Join(s.upper, oldRel), filepath.Join(s.upper, newRel)); err != nil {
+		return err
+	} else if _, err = s.clearWhiteout(newRel); err != nil {
+		return err
+	} else if info.IsDir() {
+		if err = s.opaque(newRel, newLower); err != nil {
+			return err
+		}
+	}
+	return s.hide(oldRel, oldLower)
+}
+
+// change copies a path up, to change it there
+func (s *overlayStorage) change(op string, name string, fn func(up string) error) error {
+	if _, err := s.Lstat(name); err != nil {
+		return err
+	}
+	rel, _ := s.rel(op, name)
+	if err := s.copyUp(rel); err != nil {
+		return err
+	}
+	return fn(filepath.Join(s.upper, rel))
+}
+
+func (s *overlayStorage) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return s.change("chtimes", name, func(up string) error { return os.Chtimes(up, atime, mtime) })
+}
+
+func (s *overlayStorage) Chmod(name string, mode os.FileMode) error {
+	return s.change("chmod", name, func(up string) error { return os.Chmod(up, mode) })
+}
+
+func (s *overlayStorage) Lchown(name string, uid int, gid int) error {
+	return s.change("chown", name, func(up string) error { return os.Lchown(up, uid, gid) })
+}
+
+func (s *overlayStorage) Symlink(oldname string, newname string) error {
+	rel, err := s.rel("symlink", newname)
+	if err != nil {
+		return err
+	} else if _, err = s.Lstat(newname); err == nil {
+		return &os.PathError{Op: "symlink", Path: newname, Err: os.ErrExist}
+	} else if err = s.copyUp(filepath.Dir(rel)); err != nil {
+		return err
+	} else if _, err = s.clearWhiteout(rel); err != nil {
+		return err
+	}
+	return os.Symlink(oldname, filepath.Join(s.upper, rel))
+}
+
+func (s *overlayStorage) Readlink(name string) (string, error) {
+	real, _, err := s.locate("readlink", name)
+	if err != nil {
+		return "", err
+	}
+	return os.Readlink(real)
+}
+
+// EvalSymlinks resolves a path, mapping it back below the root when it lands
+// in either folder, so links staying in the overlay stay in bounds
+func (s *overlayStorage) EvalSymlinks(name string) (string, error) {
+	real, _, err := s.locate("readlink", name)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(real)
+	if err != nil {
+		return "", err
+	}
+	for _, el := range []string{s.upperResolved, s.lowerResolved} {
+		if inside(el, resolved) {
+			rel, _ := filepath.Rel(el, resolved)
+			return filepath.Join(s.root, rel), nil
+		}
+	}
+	return resolved, nil
+}
+
+func (s *overlayStorage) DiskSpace(name string) (uint64, uint64, error) {
+	return diskSpace(s.upper) // where writes land
+}
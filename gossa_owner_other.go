@@ -0,0 +1,10 @@
+//go:build !unix
+
+package gossa
+
+import "os"
+
+// fileOwner isnt available on this platform
+func fileOwner(stat os.FileInfo) (string, string) {
+	return "", ""
+}
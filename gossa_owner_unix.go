@@ -0,0 +1,27 @@
+//go:build unix
+
+package gossa
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// fileOwner returns the user and group owning a file, by name when resolvable
+func fileOwner(stat os.FileInfo) (string, string) {
+	sys, ok := stat.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", ""
+	}
+	uid := strconv.FormatUint(uint64(sys.Uid), 10)
+	gid := strconv.FormatUint(uint64(sys.Gid), 10)
+	if u, err := user.LookupId(uid); err == nil {
+		uid = u.Username
+	}
+	if g, err := user.LookupGroupId(gid); err == nil {
+		gid = g.Name
+	}
+	return uid, gid
+}
@@ -0,0 +1,79 @@
+package gossa
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+var precompressedEncodings = []struct {
+	encoding string
+	ext      string
+	magic    []byte
+}{
+	{"br", ".br", nil},
+	{"gzip", ".gz", []byte{0x1f, 0x8b}},
+}
+
+// servePrecompressed serves a compressed sibling of fullPath (e.g. foo.js.gz for foo.js)
+// when the client accepts its encoding. Returns false if no suitable sibling exists.
+func servePrecompressed(w http.ResponseWriter, r *http.Request, fullPath string) bool {
+	accept := r.Header.Get("Accept-Encoding")
+	sibling := false
+	for _, enc := range precompressedEncodings {
+		if !acceptsEncoding(accept, enc.encoding) {
+			if stat, err := store.Stat(fullPath + enc.ext); err == nil && stat.Mode().IsRegular() {
+				sibling = true
+			}
+			continue
+		}
+
+		file, stat, ok := openSibling(fullPath+enc.ext, enc.magic)
+		if !ok {
+			continue
+		}
+		defer file.Close()
+
+		name := filepath.Base(fullPath)
+		w.Header().Set("Content-Encoding", enc.encoding)
+		w.Header().Add("Vary", "Accept-Encoding")
+		w.Header().Set("ETag", fmt.Sprintf(`"%x-%x-%s"`, stat.ModTime().UnixNano(), stat.Size(), enc.encoding))
+		applyMimePolicy(w, name)
+		rel, err := filepath.Rel(rootPath, fullPath)
+		check(err)
+		applyCacheControl(w, filepath.ToSlash(rel))
+		http.ServeContent(rateLimit(w), r, name, stat.ModTime(), sendable(file))
+		return true
+	}
+	if sibling { // another client would have had it compressed
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+	return false
+}
+
+// openSibling opens a compressed sibling, rewound after the check of its magic bytes.
+// Siblings that aren't what they claim to be are closed and skipped
+func openSibling(name string, magic []byte) (_ File, stat os.FileInfo, ok bool) {
+	file, err := store.Open(name)
+	if err != nil {
+		return nil, nil, false
+	}
+	defer func() {
+		if !ok {
+			file.Close()
+		}
+	}()
+	stat, err = file.Stat()
+	if err != nil || !stat.Mode().IsRegular() {
+		return nil, nil, false
+	}
+	head := make([]byte, len(magic))
+	if _, err := io.ReadFull(file, head); err != nil || !bytes.Equal(head, magic) {
+		return nil, nil, false
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	return file, stat, err == nil
+}
@@ -0,0 +1,92 @@
+package gossa
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"path/filepath"
+	"unicode/utf8"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+)
+
+// previewLexer picks the lexer of a file from its name, else its content
+func previewLexer(name string, src []byte) chroma.Lexer {
+	lexer := lexers.Match(name)
+	if lexer == nil {
+		lexer = lexers.Analyse(string(src))
+	}
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	return chroma.Coalesce(lexer)
+}
+
+// readPreview reads the start of a text file in utf-8, cut on a line when
+// it's over -preview-max-size, transcoded from the charset given, else the
+// one detected. Returns the charset, and whether the file was cut
+func readPreview(fullPath string, charset string) ([]byte, string, bool, error) {
+	f, err := store.Open(fullPath)
+	if err != nil {
+		return nil, "", false, err
+	}
+	defer f.Close()
+	b, err := io.ReadAll(io.LimitReader(f, *previewMaxSize+1))
+	if err != nil {
+		return nil, "", false, err
+	}
+	cut := int64(len(b)) > *previewMaxSize
+	if cut {
+		b = b[:*previewMaxSize]
+		if i := bytes.LastIndexByte(b, '\n'); i >= 0 {
+			b = b[:i+1]
+		}
+		for n := 1; n < utf8.UTFMax && n < len(b) && !utf8.Valid(b); n++ { // a utf-8 rune split at the end, on a single line file
+			if utf8.Valid(b[:len(b)-n]) {
+				b = b[:len(b)-n]
+			}
+		}
+	}
+	text, charset, err := decodeText(b, charset)
+	return text, charset, cut, err
+}
+
+// previewHandler serves preview?path=, a text file syntax highlighted, its
+// lines linkable as #L12
+func previewHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	defer exitPath(w, "preview", q.Get("path"))
+	fullPath := enforcePath(q.Get("path"))
+	stat, err := store.Stat(fullPath)
+	check(err)
+	if !stat.Mode().IsRegular() {
+		check(badCall("not a file"))
+	}
+	src, _, cut, err := readPreview(fullPath, q.Get("charset"))
+	check(err)
+
+	name := filepath.Base(fullPath)
+	it, err := previewLexer(name, src).Tokenise(nil, string(src))
+	check(err)
+	formatter := chromahtml.New(chromahtml.WithLineNumbers(true), chromahtml.WithLinkableLineNumbers(true, "L"), chromahtml.WithClasses(true))
+	style := styles.Get(*previewStyle)
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "<!doctype html>\n<html><head><meta charset=\"utf-8\"><meta name=\"viewport\" content=\"width=device-width, initial-scale=1\"><title>%s</title><style>", html.EscapeString(name))
+	check(formatter.WriteCSS(&out, style))
+	out.WriteString("</style></head><body>")
+	if cut {
+		fmt.Fprintf(&out, "<p><code>only the start of the %s file is shown</code></p>", humanize(stat.Size()))
+	}
+	check(formatter.Format(&out, style, it))
+	out.WriteString("</body></html>\n")
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("ETag", fileETag(stat))
+	http.ServeContent(w, r, "", stat.ModTime(), bytes.NewReader(out.Bytes()))
+}
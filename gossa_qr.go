@@ -0,0 +1,483 @@
+package gossa
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// qr codes are made in byte mode, with the error correction level M (15% of
+// the code may be lost), the version being the smallest the data fits in
+
+// error correction codewords per block, and number of blocks, of each version at level M
+var qrEccPerBlock = [41]int{-1, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28}
+var qrEccBlocks = [41]int{-1, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49}
+
+// qrBorder is the quiet zone around codes, in modules
+const qrBorder = 4
+
+type qrCode struct {
+	version  int
+	size     int
+	dark     [][]bool
+	function [][]bool // finders, timings, alignments, format and version
+}
+
+// qrRawModules is how many modules of a version hold data and error correction
+func qrRawModules(ver int) int {
+	n := (16*ver+128)*ver + 64
+	if ver >= 2 {
+		align := ver/7 + 2
+		n -= (25*align-10)*align - 55
+		if ver >= 7 {
+			n -= 36
+		}
+	}
+	return n
+}
+
+func qrDataCodewords(ver int) int {
+	return qrRawModules(ver)/8 - qrEccPerBlock[ver]*qrEccBlocks[ver]
+}
+
+// qrMul multiplies in GF(2^8), modulo x^8 + x^4 + x^3 + x^2 + 1
+func qrMul(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11d)
+		z ^= int((y>>i)&1) * int(x)
+	}
+	return byte(z)
+}
+
+// qrDivisor is the reed-solomon generator polynomial of a degree, highest term omitted
+func qrDivisor(degree int) []byte {
+	res := make([]byte, degree)
+	res[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range res {
+			res[j] = qrMul(res[j], root)
+			if j+1 < len(res) {
+				res[j] ^= res[j+1]
+			}
+		}
+		root = qrMul(root, 2)
+	}
+	return res
+}
+
+// qrRemainder is the error correction of data
+func qrRemainder(data []byte, divisor []byte) []byte {
+	res := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ res[0]
+		copy(res, res[1:])
+		res[len(res)-1] = 0
+		for i := range res {
+			res[i] ^= qrMul(divisor[i], factor)
+		}
+	}
+	return res
+}
+
+// qrCodewords encodes text in byte mode, padded to the capacity of the smallest version fitting it
+func qrCodewords(text string) (int, []byte, error) {
+	ver := 1
+	for ; ver <= 40; ver++ {
+		countBits := 8
+		if ver >= 10 {
+			countBits = 16
+		}
+		if 4+countBits+8*len(text) <= qrDataCodewords(ver)*8 && len(text) < 1<<countBits {
+			break
+		}
+	}
+	if ver > 40 {
+		return 0, nil, fmt.Errorf("%d bytes qr code: %w", len(text), errTooLarge)
+	}
+
+	var bits []bool
+	put := func(val int, n int) {
+		for i := n - 1; i >= 0; i-- {
+			bits = append(bits, (val>>i)&1 == 1)
+		}
+	}
+	put(4, 4) // byte mode
+	if ver < 10 {
+		put(len(text), 8)
+	} else {
+		put(len(text), 16)
+	}
+	for i := 0; i < len(text); i++ {
+		put(int(text[i]), 8)
+	}
+	capacity := qrDataCodewords(ver) * 8
+	put(0, min(4, capacity-len(bits))) // terminator
+	put(0, (8-len(bits)%8)%8)
+	for pad := 0xec; len(bits) < capacity; pad ^= 0xec ^ 0x11 {
+		put(pad, 8)
+	}
+
+	data := make([]byte, len(bits)/8)
+	for i, b := range bits {
+		if b {
+			data[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+	return ver, data, nil
+}
+
+// qrInterleave splits data in blocks, adds their error correction, and interleaves them
+func qrInterleave(ver int, data []byte) []byte {
+	blocks, eccLen := qrEccBlocks[ver], qrEccPerBlock[ver]
+	raw := qrRawModules(ver) / 8
+	short := blocks - raw%blocks
+	shortLen := raw / blocks
+	divisor := qrDivisor(eccLen)
+
+	var all [][]byte
+	for i, k := 0, 0; i < blocks; i++ {
+		n := shortLen - eccLen
+		if i >= short {
+			n++
+		}
+		block := append([]byte{}, data[k:k+n]...)
+		k += n
+		ecc := qrRemainder(block, divisor)
+		if i < short {
+			block = append(block, 0) // skipped below, so all blocks have the same length
+		}
+		all = append(all, append(block, ecc...))
+	}
+
+	var res []byte
+	for i := range all[0] {
+		for j, block := range all {
+			if i != shortLen-eccLen || j >= short {
+				res = append(res, block[i])
+			}
+		}
+	}
+	return res
+}
+
+func (q *qrCode) set(x, y int, dark bool) {
+	q.dark[y][x] = dark
+	q.function[y][x] = true
+}
+
+func (q *qrCode) finder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			d := max(dx, -dx, dy, -dy)
+			if xx, yy := x+dx, y+dy; xx >= 0 && xx < q.size && yy >= 0 && yy < q.size {
+				q.set(xx, yy, d != 2 && d != 4)
+			}
+		}
+	}
+}
+
+func (q *qrCode) alignment(x, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			q.set(x+dx, y+dy, max(dx, -dx, dy, -dy) != 1)
+		}
+	}
+}
+
+// alignments are the centers of the alignment patterns, on both axes
+func (q *qrCode) alignments() []int {
+	if q.version == 1 {
+		return nil
+	}
+	n := q.version/7 + 2
+	step := (q.version*8 + n*3 + 5) / (n*4 - 4) * 2
+	res := make([]int, n)
+	res[0] = 6
+	for i, pos := n-1, q.size-7; i >= 1; i, pos = i-1, pos-step {
+		res[i] = pos
+	}
+	return res
+}
+
+// qrFormatBits are the 15 bits telling the error correction level, M, and the mask
+func qrFormatBits(mask int) int {
+	data := 0<<3 | mask // level M is 0
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+// qrVersionBits are the 18 bits telling the version, from version 7
+func qrVersionBits(ver int) int {
+	rem := ver
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1f25)
+	}
+	return ver<<12 | rem
+}
+
+func (q *qrCode) format(mask int) {
+	bits := qrFormatBits(mask)
+	bit := func(i int) bool { return (bits>>i)&1 == 1 }
+	for i := 0; i <= 5; i++ {
+		q.set(8, i, bit(i))
+	}
+	q.set(8, 7, bit(6))
+	q.set(8, 8, bit(7))
+	q.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		q.set(14-i, 8, bit(i))
+	}
+	for i := 0; i < 8; i++ {
+		q.set(q.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.set(8, q.size-15+i, bit(i))
+	}
+	q.set(8, q.size-8, true) // always dark
+}
+
+func (q *qrCode) functionPatterns() {
+	for i := 0; i < q.size; i++ {
+		q.set(6, i, i%2 == 0)
+		q.set(i, 6, i%2 == 0)
+	}
+	q.finder(3, 3)
+	q.finder(q.size-4, 3)
+	q.finder(3, q.size-4)
+	pos := q.alignments()
+	for i := range pos {
+		for j := range pos {
+			if !(i == 0 && j == 0 || i == 0 && j == len(pos)-1 || i == len(pos)-1 && j == 0) {
+				q.alignment(pos[i], pos[j])
+			}
+		}
+	}
+	q.format(0) // reserves the area, drawn again once the mask is picked
+	if q.version >= 7 {
+		bits := qrVersionBits(q.version)
+		for i := 0; i < 18; i++ {
+			dark := (bits>>i)&1 == 1
+			a, b := q.size-11+i%3, i/3
+			q.set(a, b, dark)
+			q.set(b, a, dark)
+		}
+	}
+}
+
+// codewords lays data out in the zigzag of two columns wide strips, right to left
+func (q *qrCode) codewords(data []byte) {
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5 // skips the vertical timing
+		}
+		for vert := 0; vert < q.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = q.size - 1 - vert // upward
+				}
+				if !q.function[y][x] && i < len(data)*8 {
+					q.dark[y][x] = (data[i/8]>>(7-i%8))&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+// mask flips the data modules of a mask pattern, flipping them back when called twice
+func (q *qrCode) mask(mask int) {
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			var flip bool
+			switch mask {
+			case 0:
+				flip = (x+y)%2 == 0
+			case 1:
+				flip = y%2 == 0
+			case 2:
+				flip = x%3 == 0
+			case 3:
+				flip = (x+y)%3 == 0
+			case 4:
+				flip = (x/3+y/2)%2 == 0
+			case 5:
+				flip = x*y%2+x*y%3 == 0
+			case 6:
+				flip = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				flip = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if flip && !q.function[y][x] {
+				q.dark[y][x] = !q.dark[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores how hard a code is to read, to pick the mask scoring the least
+func (q *qrCode) penalty() int {
+	score := 0
+	at := func(x, y int, transpose bool) bool {
+		if transpose {
+			return q.dark[x][y]
+		}
+		return q.dark[y][x]
+	}
+	finderLike := [][]bool{
+		{true, false, true, true, true, false, true, false, false, false, false},
+		{false, false, false, false, true, false, true, true, true, false, true},
+	}
+	for _, transpose := range []bool{false, true} {
+		for y := 0; y < q.size; y++ {
+			run := 1
+			for x := 1; x <= q.size; x++ {
+				if x < q.size && at(x, y, transpose) == at(x-1, y, transpose) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					score += run - 2 // runs of 5 or more score 3, plus 1 past 5
+				}
+				run = 1
+			}
+			for x := 0; x+11 <= q.size; x++ {
+				for _, pattern := range finderLike {
+					match := true
+					for k, dark := range pattern {
+						if at(x+k, y, transpose) != dark {
+							match = false
+							break
+						}
+					}
+					if match {
+						score += 40
+					}
+				}
+			}
+		}
+	}
+
+	dark := 0
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if q.dark[y][x] {
+				dark++
+			}
+			if x+1 < q.size && y+1 < q.size {
+				c := q.dark[y][x]
+				if c == q.dark[y][x+1] && c == q.dark[y+1][x] && c == q.dark[y+1][x+1] {
+					score += 3
+				}
+			}
+		}
+	}
+	total := q.size * q.size
+	score += ((max(dark*20-total*10, total*10-dark*20)+total-1)/total - 1) * 10 // 10 per 5% away from half dark
+	return score
+}
+
+// newQR encodes text as a qr code
+func newQR(text string) (*qrCode, error) {
+	ver, data, err := qrCodewords(text)
+	if err != nil {
+		return nil, err
+	}
+	q := &qrCode{version: ver, size: ver*4 + 17}
+	q.dark, q.function = make([][]bool, q.size), make([][]bool, q.size)
+	for i := range q.dark {
+		q.dark[i], q.function[i] = make([]bool, q.size), make([]bool, q.size)
+	}
+	q.functionPatterns()
+	q.codewords(qrInterleave(ver, data))
+
+	best, lowest := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		q.mask(mask)
+		q.format(mask)
+		if score := q.penalty(); lowest < 0 || score < lowest {
+			best, lowest = mask, score
+		}
+		q.mask(mask)
+	}
+	q.mask(best)
+	q.format(best)
+	return q, nil
+}
+
+// svg draws a code as a path of its dark modules
+func (q *qrCode) svg() []byte {
+	var b bytes.Buffer
+	n := q.size + 2*qrBorder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges"><rect width="100%%" height="100%%" fill="#fff"/><path fill="#000" d="`, n, n)
+	for y := range q.dark {
+		for x, dark := range q.dark[y] {
+			if dark {
+				fmt.Fprintf(&b, "M%d,%dh1v1h-1z", x+qrBorder, y+qrBorder)
+			}
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	return b.Bytes()
+}
+
+// png draws a code with modules of scale pixels
+func (q *qrCode) png(scale int) ([]byte, error) {
+	n := (q.size + 2*qrBorder) * scale
+	img := image.NewPaletted(image.Rect(0, 0, n, n), color.Palette{color.White, color.Black})
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			mx, my := x/scale-qrBorder, y/scale-qrBorder
+			if mx >= 0 && my >= 0 && mx < q.size && my < q.size && q.dark[my][mx] {
+				img.SetColorIndex(x, y, 1)
+			}
+		}
+	}
+	var b bytes.Buffer
+	err := png.Encode(&b, img)
+	return b.Bytes(), err
+}
+
+// qrHandler serves qr?path=&format=svg|png&scale=, a qr code of the url of a
+// file or folder, e.g. to open it on a phone
+func qrHandler(w http.ResponseWriter, r *http.Request) {
+	p := r.URL.Query().Get("path")
+	defer exitPath(w, "qr", p)
+	fullPath := enforcePath(p)
+	stat, err := store.Stat(fullPath)
+	check(err)
+	target := *extraPath + strings.TrimPrefix(relPath(fullPath), "/")
+	if stat.IsDir() {
+		target = listingPath(fullPath)
+	}
+	q, err := newQR(publicURL(r, target).String())
+	check(err)
+
+	switch format := r.URL.Query().Get("format"); format {
+	case "svg":
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Write(q.svg())
+	case "", "png":
+		scale, err := strconv.Atoi(r.URL.Query().Get("scale"))
+		if err != nil || scale <= 0 {
+			scale = 8
+		}
+		b, err := q.png(min(scale, 32))
+		check(err)
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(b)
+	default:
+		check(badCall("invalid format %s, expected png or svg", format))
+	}
+}
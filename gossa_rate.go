@@ -0,0 +1,42 @@
+package gossa
+
+import (
+	"net/http"
+	"time"
+)
+
+// rateWriter caps the throughput of a single response to rate bytes per second
+type rateWriter struct {
+	http.ResponseWriter
+	rate  int64
+	start time.Time
+	sent  int64
+}
+
+func (rw *rateWriter) Write(b []byte) (int, error) {
+	written := 0
+	chunk := int(max(rw.rate/10, 1)) // small slices keep the flow smooth
+	for len(b) > 0 {
+		n, err := rw.ResponseWriter.Write(b[:min(chunk, len(b))])
+		written += n
+		rw.sent += int64(n)
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+
+		expected := time.Duration(rw.sent * int64(time.Second) / rw.rate)
+		if elapsed := time.Since(rw.start); expected > elapsed {
+			time.Sleep(expected - elapsed)
+		}
+	}
+	return written, nil
+}
+
+// rateLimit wraps w when a per-download limit is set
+func rateLimit(w http.ResponseWriter) http.ResponseWriter {
+	if *dlRate <= 0 {
+		return w
+	}
+	return &rateWriter{ResponseWriter: w, rate: *dlRate, start: time.Now()}
+}
@@ -0,0 +1,96 @@
+package gossa
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// markdown is rendered without raw html, and goldmark drops dangerous links
+var markdown = goldmark.New(goldmark.WithExtensions(extension.GFM), goldmark.WithParserOptions(parser.WithASTTransformers(util.Prioritized(renderLinks{}, 100))))
+
+var markdownExts = map[string]bool{".md": true, ".markdown": true}
+
+// renderLinks has relative links to other markdown files open them rendered,
+// so documentation trees can be browsed
+type renderLinks struct{}
+
+func (renderLinks) Transform(doc *ast.Document, _ text.Reader, _ parser.Context) {
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		link, ok := n.(*ast.Link)
+		if !entering || !ok {
+			return ast.WalkContinue, nil
+		}
+		u, err := url.Parse(string(link.Destination))
+		if err == nil && u.Scheme == "" && u.Host == "" && u.RawQuery == "" && markdownExts[strings.ToLower(filepath.Ext(u.Path))] {
+			u.RawQuery = "render=1"
+			link.Destination = []byte(u.String())
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+const readmeMaxSize = 1 << 20
+
+// readmeStat finds the readme of a folder, if any
+func readmeStat(fullPath string) (string, os.FileInfo) {
+	if *readme == "" {
+		return "", nil
+	}
+	p := filepath.Join(fullPath, filepath.Base(*readme))
+	stat, err := store.Stat(p)
+	if err != nil || !stat.Mode().IsRegular() || stat.Size() > readmeMaxSize || isInternal(p) {
+		return "", nil
+	}
+	return p, stat
+}
+
+// renderReadme renders the markdown of a readme, shown above listings
+func renderReadme(p string) template.HTML {
+	src, err := readFile(p)
+	if err != nil {
+		return ""
+	}
+	var out bytes.Buffer
+	if markdown.Convert(src, &out) != nil {
+		return ""
+	}
+	return template.HTML(out.String())
+}
+
+func isMarkdown(p string) bool {
+	return markdownExts[strings.ToLower(filepath.Ext(p))]
+}
+
+// replyMarkdown serves a markdown file rendered within the gossa page, for ?render=1
+func replyMarkdown(w http.ResponseWriter, r *http.Request, fullPath string, path string, stat os.FileInfo) {
+	if stat.Size() > readmeMaxSize {
+		check(fmt.Errorf("markdown of %d bytes: %w", stat.Size(), errTooLarge))
+	}
+	p := pageTemplate{Title: template.HTML(html.EscapeString(path)), ExtraPath: template.HTML(html.EscapeString(*extraPath)), Ro: *ro}
+	p.Crumbs = breadcrumbs("/" + strings.TrimPrefix(path, *extraPath))
+	p.Readme = renderReadme(fullPath)
+	p.Header, p.Footer = headerHTML, footerHTML
+	p.Lang, p.T = pageLang(r)
+	p.TitlePrefix, p.Logo = *titlePrefix, logoURI
+	var out bytes.Buffer
+	tmpl := ui.Load().tmpl
+	tmpl.Execute(&out, p)
+	tmpl.ExecuteTemplate(&out, "row", rowTemplate{Name: "../", Href: "./", Ext: "folder"})
+	tmpl.ExecuteTemplate(&out, "foot", p)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	http.ServeContent(w, r, "", stat.ModTime(), bytes.NewReader(out.Bytes()))
+}
@@ -0,0 +1,688 @@
+package gossa
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const s3UploadsDirName = ".gossa-s3"
+const s3Namespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+var errS3Signature = errors.New("signature does not match")
+var errS3Skewed = errors.New("request time too skewed")
+var errS3Payload = errors.New("body does not match its signed sha256")
+var s3UploadID = regexp.MustCompile(`^[0-9a-f]{32}$`)
+var s3PayloadSum = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// signed requests are only valid this long around their X-Amz-Date, not to be replayed later
+const s3MaxSkew = 15 * time.Minute
+
+// the chunks of streaming signed uploads are checked whole, so held in memory
+const s3MaxChunk = 16 << 20
+
+type s3Object struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type s3BucketInfo struct {
+	Name         string `xml:"Name"`
+	CreationDate string `xml:"CreationDate"`
+}
+
+type s3Prefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type s3ListResult struct {
+	XMLName               xml.Name   `xml:"ListBucketResult"`
+	Xmlns                 string     `xml:"xmlns,attr"`
+	Name                  string     `xml:"Name"`
+	Prefix                string     `xml:"Prefix"`
+	Delimiter             string     `xml:"Delimiter,omitempty"`
+	MaxKeys               int        `xml:"MaxKeys"`
+	IsTruncated           bool       `xml:"IsTruncated"`
+	Marker                *string    `xml:"Marker"` // v1
+	NextMarker            string     `xml:"NextMarker,omitempty"`
+	KeyCount              *int       `xml:"KeyCount"` // v2
+	ContinuationToken     string     `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string     `xml:"NextContinuationToken,omitempty"`
+	StartAfter            string     `xml:"StartAfter,omitempty"`
+	Contents              []s3Object `xml:"Contents"`
+	CommonPrefixes        []s3Prefix `xml:"CommonPrefixes"`
+}
+
+type s3Part struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// s3Chunked decodes aws-chunked bodies, as sent by streaming signed uploads.
+// With sign, each chunk is checked against its signature before being read,
+// trailers being skipped
+type s3Chunked struct {
+	r     *bufio.Reader
+	left  int64
+	done  bool
+	sign  *s3ChunkSign
+	chunk []byte // checked, yet to be read
+	body  io.Closer
+}
+
+// s3ChunkSign chains the signatures of the chunks of a streaming upload,
+// seeded with the signature of the request
+type s3ChunkSign struct {
+	key   []byte
+	date  string
+	scope string
+	prev  string
+}
+
+func (s *s3ChunkSign) check(signature string, chunk []byte) bool {
+	empty, sum := sha256.Sum256(nil), sha256.Sum256(chunk)
+	toSign := "AWS4-HMAC-SHA256-PAYLOAD\n" + s.date + "\n" + s.scope + "\n" + s.prev + "\n" + hex.EncodeToString(empty[:]) + "\n" + hex.EncodeToString(sum[:])
+	s.prev = hex.EncodeToString(hmacSHA256(s.key, toSign))
+	return hmac.Equal([]byte(signature), []byte(s.prev))
+}
+
+func (c *s3Chunked) Close() error {
+	if c.body == nil {
+		return nil
+	}
+	return c.body.Close()
+}
+
+func (c *s3Chunked) Read(p []byte) (int, error) {
+	for c.left == 0 && len(c.chunk) == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return 0, io.ErrUnexpectedEOF
+		}
+		if line = strings.TrimSpace(line); line == "" {
+			continue // end of the previous chunk
+		}
+		size, params, _ := strings.Cut(line, ";")
+		if c.left, err = strconv.ParseInt(size, 16, 64); err != nil || c.left < 0 {
+			return 0, badCall("invalid aws-chunked body")
+		}
+		c.done = c.left == 0
+		if c.sign == nil {
+			continue
+		} else if c.left > s3MaxChunk {
+			return 0, badCall("aws-chunked chunk over %d bytes", s3MaxChunk)
+		}
+		c.chunk = make([]byte, c.left)
+		if _, err = io.ReadFull(c.r, c.chunk); err != nil {
+			return 0, io.ErrUnexpectedEOF
+		}
+		c.left = 0
+		if !c.sign.check(strings.TrimPrefix(params, "chunk-signature="), c.chunk) {
+			return 0, errS3Payload
+		}
+	}
+	if len(c.chunk) > 0 {
+		n := copy(p, c.chunk)
+		c.chunk = c.chunk[n:]
+		return n, nil
+	}
+	if int64(len(p)) > c.left {
+		p = p[:c.left]
+	}
+	n, err := c.r.Read(p)
+	c.left -= int64(n)
+	if err == io.EOF && c.left > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// s3Hashed fails at the end of a body not matching the sha256 it was signed with
+type s3Hashed struct {
+	io.ReadCloser
+	sum  hash.Hash
+	want string
+}
+
+func (b *s3Hashed) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.sum.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(b.sum.Sum(nil)) != b.want {
+		err = errS3Payload
+	}
+	return n, err
+}
+
+func s3Body(r *http.Request) io.Reader {
+	if chunked, ok := r.Body.(*s3Chunked); ok { // decoded and checked by s3Authorized
+		return chunked
+	} else if strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") || strings.Contains(r.Header.Get("Content-Encoding"), "aws-chunked") {
+		return &s3Chunked{r: bufio.NewReader(r.Body)}
+	}
+	return r.Body
+}
+
+// s3Escape encodes as sigv4 expects, everything but unreserved characters
+func s3Escape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' || keepSlash && c == '/' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Signature computes the aws sigv4 signature of a request, for the headers
+// it says are signed. scope is date/region/service/aws4_request
+func s3Signature(r *http.Request, signedHeaders []string, scope string, secret string) string {
+	query := []string{}
+	for k, vs := range r.URL.Query() {
+		for _, v := range vs {
+			query = append(query, s3Escape(k, false)+"="+s3Escape(v, false))
+		}
+	}
+	sort.Strings(query)
+	headers := ""
+	for _, h := range signedHeaders {
+		v := r.Header.Get(h)
+		if h == "host" {
+			v = r.Host
+		}
+		headers += h + ":" + strings.Join(strings.Fields(v), " ") + "\n"
+	}
+	canonical := strings.Join([]string{r.Method, s3Escape(r.URL.Path, true), strings.Join(query, "&"), headers, strings.Join(signedHeaders, ";"), r.Header.Get("X-Amz-Content-Sha256")}, "\n")
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + r.Header.Get("X-Amz-Date") + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+	return hex.EncodeToString(hmacSHA256(s3SigningKey(scope, secret), toSign))
+}
+
+func s3SigningKey(scope string, secret string) []byte {
+	key := []byte("AWS4" + secret)
+	for _, el := range strings.Split(scope, "/") {
+		key = hmacSHA256(key, el)
+	}
+	return key
+}
+
+// s3Authorized checks the sigv4 authorization header against -s3-access-key,
+// and that the request is recent. The body is then checked as it's read, against
+// its signed sha256 or chunk signatures, unless sent as UNSIGNED-PAYLOAD
+func s3Authorized(r *http.Request) error {
+	if *s3AccessKey == "" {
+		return nil
+	}
+	auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ")
+	if !ok {
+		return errS3Signature
+	}
+	fields := map[string]string{}
+	for _, el := range strings.Split(auth, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(el), "=")
+		fields[k] = v
+	}
+	access, scope, _ := strings.Cut(fields["Credential"], "/")
+	signed := strings.Split(fields["SignedHeaders"], ";")
+	if access != *s3AccessKey || !hmac.Equal([]byte(fields["Signature"]), []byte(s3Signature(r, signed, scope, *s3SecretKey))) {
+		return errS3Signature
+	}
+	date, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return errS3Signature
+	} else if skew := time.Since(date); skew > s3MaxSkew || skew < -s3MaxSkew {
+		return errS3Skewed
+	}
+
+	switch payload := r.Header.Get("X-Amz-Content-Sha256"); {
+	case payload == "UNSIGNED-PAYLOAD" || payload == "STREAMING-UNSIGNED-PAYLOAD-TRAILER":
+	case strings.HasPrefix(payload, "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"):
+		sign := &s3ChunkSign{s3SigningKey(scope, *s3SecretKey), r.Header.Get("X-Amz-Date"), scope, fields["Signature"]}
+		r.Body = &s3Chunked{r: bufio.NewReader(r.Body), sign: sign, body: r.Body}
+	case s3PayloadSum.MatchString(payload):
+		r.Body = &s3Hashed{r.Body, sha256.New(), payload}
+	default:
+		return badCall("unsupported X-Amz-Content-Sha256 %q", payload)
+	}
+	return nil
+}
+
+func s3Error(w http.ResponseWriter, err error) {
+	status, _ := errorStatus(err)
+	code := map[int]string{400: "InvalidArgument", 403: "AccessDenied", 404: "NoSuchKey", 409: "OperationAborted", 412: "PreconditionFailed", 413: "EntityTooLarge"}[status]
+	if errors.Is(err, errS3Signature) {
+		status, code = http.StatusForbidden, "SignatureDoesNotMatch"
+	} else if errors.Is(err, errS3Skewed) {
+		status, code = http.StatusForbidden, "RequestTimeTooSkewed"
+	} else if errors.Is(err, errS3Payload) {
+		status, code = http.StatusBadRequest, "XAmzContentSHA256Mismatch"
+	} else if code == "" {
+		code = "InternalError"
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "%s<Error><Code>%s</Code><Message>%s</Message></Error>", xml.Header, code, xmlEscape(errorMessage(err)))
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func s3Reply(w http.ResponseWriter, v any) {
+	b, err := xml.Marshal(v)
+	check(err)
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write([]byte(xml.Header))
+	w.Write(b)
+}
+
+func s3Time(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+func s3UploadsDir() string {
+	return filepath.Join(rootPath, s3UploadsDirName)
+}
+
+// s3Serve serves the folder as a single bucket on -s3, path style
+func s3Serve() (net.Listener, error) {
+	listener, err := net.Listen("tcp", *s3Addr)
+	if err != nil {
+		return nil, err
+	}
+	server := &http.Server{Handler: http.HandlerFunc(s3Handler)}
+	fmt.Printf("s3 bucket %s on %s\n", *s3Bucket, listener.Addr())
+	go func() {
+		if err := server.Serve(listener); !errors.Is(err, net.ErrClosed) {
+			log.Println("s3", err)
+		}
+	}()
+	return listener, nil
+}
+
+func s3Handler(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if e := recover(); e != nil {
+			log.Println("error s3", r.Method, r.URL.Path, e)
+			s3Error(w, asError(e))
+		} else if *verb {
+			log.Println("s3", r.Method, r.URL)
+		}
+	}()
+	check(s3Authorized(r))
+	writes := r.Method != http.MethodGet && r.Method != http.MethodHead
+	if writes && *ro {
+		check(fmt.Errorf("read only: %w", os.ErrPermission))
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	q := r.URL.Query()
+	switch {
+	case bucket == "":
+		s3Reply(w, struct {
+			XMLName xml.Name       `xml:"ListAllMyBucketsResult"`
+			Xmlns   string         `xml:"xmlns,attr"`
+			Owner   string         `xml:"Owner>ID"`
+			Buckets []s3BucketInfo `xml:"Buckets>Bucket"`
+		}{Xmlns: s3Namespace, Owner: "gossa", Buckets: []s3BucketInfo{{*s3Bucket, s3Time(time.Unix(0, 0))}}})
+	case bucket != *s3Bucket:
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "%s<Error><Code>NoSuchBucket</Code><Message>no such bucket</Message></Error>", xml.Header)
+	case key == "" && r.Method == http.MethodPost && q.Has("delete"):
+		s3DeleteObjects(w, r)
+	case key == "" && q.Has("location"):
+		s3Reply(w, struct {
+			XMLName xml.Name `xml:"LocationConstraint"`
+			Xmlns   string   `xml:"xmlns,attr"`
+		}{Xmlns: s3Namespace})
+	case key == "" && r.Method == http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+	case key == "" && r.Method == http.MethodGet:
+		s3List(w, q)
+	case key == "":
+		check(badCall("unsupported bucket operation"))
+	case q.Has("uploads") || q.Has("uploadId"):
+		s3Multipart(w, r, key)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		s3Get(w, r, key)
+	case r.Method == http.MethodPut:
+		s3Put(w, r, key)
+	case r.Method == http.MethodDelete:
+		check(s3Delete(key))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		check(badCall("unsupported object operation"))
+	}
+}
+
+// s3Keys lists the objects below a prefix, and with the "/" delimiter the
+// folders as common prefixes, sorted by key
+func s3Keys(prefix string, delimiter string) ([]s3Object, []string) {
+	dir := prefix[:strings.LastIndex(prefix, "/")+1]
+	dirPath, err := safePath(dir)
+	if err != nil {
+		return nil, nil
+	}
+	var objects []s3Object
+	var prefixes []string
+	visit := func(fullPath string, d fs.DirEntry) bool {
+		if isHiddenPath(fullPath) || isInternal(fullPath) || !*symlinks && d.Type()&os.ModeSymlink != 0 {
+			return false
+		}
+		rel, _ := filepath.Rel(rootPath, fullPath)
+		key := filepath.ToSlash(rel)
+		if d.IsDir() {
+			if delimiter == "/" && strings.HasPrefix(key+"/", prefix) {
+				prefixes = append(prefixes, key+"/")
+			}
+			return strings.HasPrefix(key+"/", prefix) || strings.HasPrefix(prefix, key+"/")
+		}
+		if info, err := d.Info(); err == nil && strings.HasPrefix(key, prefix) {
+			objects = append(objects, s3Object{key, s3Time(info.ModTime()), fileETag(info), info.Size(), "STANDARD"})
+		}
+		return true
+	}
+
+	if delimiter == "/" {
+		entries, _ := store.ReadDir(dirPath)
+		for _, el := range entries {
+			visit(filepath.Join(dirPath, el.Name()), el)
+		}
+	} else {
+		walkDir(dirPath, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || p == dirPath {
+				return nil
+			}
+			if !visit(p, d) && d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		})
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	sort.Strings(prefixes) // a/ sorts after a-b/, unlike the folders a and a-b
+	return objects, prefixes
+}
+
+// s3List answers ListObjects, v2 when list-type=2 and v1 otherwise
+func s3List(w http.ResponseWriter, q url.Values) {
+	delimiter := q.Get("delimiter")
+	if delimiter != "" && delimiter != "/" {
+		check(badCall("only the / delimiter is supported"))
+	}
+	res := s3ListResult{Xmlns: s3Namespace, Name: *s3Bucket, Prefix: q.Get("prefix"), Delimiter: delimiter, MaxKeys: 1000}
+	if n, err := strconv.Atoi(q.Get("max-keys")); err == nil && n >= 0 && n < res.MaxKeys {
+		res.MaxKeys = n
+	}
+	v2 := q.Get("list-type") == "2"
+	after := q.Get("marker")
+	if v2 {
+		res.ContinuationToken, res.StartAfter = q.Get("continuation-token"), q.Get("start-after")
+		after = max(res.StartAfter, res.ContinuationToken)
+	} else {
+		res.Marker = &after
+	}
+
+	objects, prefixes := s3Keys(res.Prefix, delimiter)
+	oi, pi, count, last := 0, 0, 0, ""
+	for oi < len(objects) || pi < len(prefixes) {
+		isPrefix := oi == len(objects) || pi < len(prefixes) && prefixes[pi] < objects[oi].Key
+		key := ""
+		if isPrefix {
+			key = prefixes[pi]
+		} else {
+			key = objects[oi].Key
+		}
+		if key <= after {
+			if isPrefix {
+				pi++
+			} else {
+				oi++
+			}
+			continue
+		}
+		if count == res.MaxKeys {
+			res.IsTruncated = true
+			break
+		}
+		if isPrefix {
+			res.CommonPrefixes = append(res.CommonPrefixes, s3Prefix{key})
+			pi++
+		} else {
+			res.Contents = append(res.Contents, objects[oi])
+			oi++
+		}
+		count, last = count+1, key
+	}
+	if res.IsTruncated && v2 {
+		res.NextContinuationToken = last
+	} else if res.IsTruncated && delimiter != "" {
+		res.NextMarker = last
+	}
+	if v2 {
+		res.KeyCount = &count
+	}
+	s3Reply(w, res)
+}
+
+func s3Get(w http.ResponseWriter, r *http.Request, key string) {
+	fullPath, err := safePath(key)
+	check(err)
+	f, err := store.Open(fullPath)
+	check(err)
+	defer f.Close()
+	stat, err := f.Stat()
+	check(err)
+	if stat.IsDir() {
+		check(fmt.Errorf("%s: %w", key, os.ErrNotExist))
+	}
+	w.Header().Set("ETag", fileETag(stat))
+	w.Header().Set("Content-Type", guessMime(fullPath))
+	http.ServeContent(w, r, stat.Name(), stat.ModTime(), f)
+}
+
+func s3Put(w http.ResponseWriter, r *http.Request, key string) {
+	fullPath, err := safePath(key)
+	check(err)
+	if strings.HasSuffix(key, "/") { // folder marker
+		check(store.MkdirAll(fullPath, os.ModePerm))
+		changed(fullPath)
+		return
+	}
+	check(store.MkdirAll(filepath.Dir(fullPath), os.ModePerm)) // folders are implicit in s3
+
+	if source := r.Header.Get("X-Amz-Copy-Source"); source != "" {
+		source, err = url.PathUnescape(source)
+		check(err)
+		bucket, srcKey, _ := strings.Cut(strings.TrimPrefix(source, "/"), "/")
+		if bucket != *s3Bucket {
+			check(badCall("copies across buckets are unsupported"))
+		}
+		src, err := safePath(srcKey)
+		check(err)
+		check(keepVersion(fullPath))
+		check(copyPath(r.Context(), src, fullPath))
+		changed(fullPath)
+		stat, err := store.Stat(fullPath)
+		check(err)
+		s3Reply(w, struct {
+			XMLName      xml.Name `xml:"CopyObjectResult"`
+			LastModified string   `xml:"LastModified"`
+			ETag         string   `xml:"ETag"`
+		}{LastModified: s3Time(stat.ModTime()), ETag: fileETag(stat)})
+		return
+	}
+
+	check(saveFile(r.Context(), fullPath, s3Body(r)))
+	stat, err := store.Stat(fullPath)
+	check(err)
+	w.Header().Set("ETag", fileETag(stat))
+}
+
+// s3Delete removes an object. As in s3, deleting what doesnt exist is fine,
+// and folders only go once empty, through their "key/" marker
+func s3Delete(key string) error {
+	fullPath, err := safePath(key)
+	if err != nil {
+		return err
+	}
+	stat, err := store.Stat(fullPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if stat.IsDir() {
+		if strings.HasSuffix(key, "/") && fullPath != rootPath && store.Remove(fullPath) == nil {
+			changed(fullPath)
+		}
+		return nil
+	}
+	return discard(fullPath)
+}
+
+func s3DeleteObjects(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Quiet   bool `xml:"Quiet"`
+		Objects []struct {
+			Key string `xml:"Key"`
+		} `xml:"Object"`
+	}
+	check(xml.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req))
+	type s3Deleted struct {
+		Key     string `xml:"Key"`
+		Code    string `xml:"Code,omitempty"`
+		Message string `xml:"Message,omitempty"`
+	}
+	res := struct {
+		XMLName xml.Name    `xml:"DeleteResult"`
+		Xmlns   string      `xml:"xmlns,attr"`
+		Deleted []s3Deleted `xml:"Deleted"`
+		Errors  []s3Deleted `xml:"Error"`
+	}{Xmlns: s3Namespace}
+	for _, el := range req.Objects {
+		if err := s3Delete(el.Key); err != nil {
+			res.Errors = append(res.Errors, s3Deleted{el.Key, "AccessDenied", errorMessage(err)})
+		} else if !req.Quiet {
+			res.Deleted = append(res.Deleted, s3Deleted{Key: el.Key})
+		}
+	}
+	s3Reply(w, res)
+}
+
+// s3Multipart handles multipart uploads, parts being staged in .gossa-s3/<upload id>/
+func s3Multipart(w http.ResponseWriter, r *http.Request, key string) {
+	fullPath, err := safePath(key)
+	check(err)
+	q := r.URL.Query()
+	if q.Has("uploads") && r.Method == http.MethodPost {
+		id := make([]byte, 16)
+		rand.Read(id)
+		upload := hex.EncodeToString(id)
+		check(store.MkdirAll(filepath.Join(s3UploadsDir(), upload), 0700))
+		s3Reply(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Xmlns    string   `xml:"xmlns,attr"`
+			Bucket   string   `xml:"Bucket"`
+			Key      string   `xml:"Key"`
+			UploadID string   `xml:"UploadId"`
+		}{Xmlns: s3Namespace, Bucket: *s3Bucket, Key: key, UploadID: upload})
+		return
+	}
+
+	upload := q.Get("uploadId")
+	if !s3UploadID.MatchString(upload) {
+		check(fmt.Errorf("upload %s: %w", upload, os.ErrNotExist))
+	}
+	dir := filepath.Join(s3UploadsDir(), upload)
+	_, err = store.Stat(dir)
+	check(err)
+
+	switch r.Method {
+	case http.MethodPut:
+		n, err := strconv.Atoi(q.Get("partNumber"))
+		if err != nil || n < 1 || n > 10000 {
+			check(badCall("invalid part number"))
+		}
+		f, err := store.Create(filepath.Join(dir, fmt.Sprintf("%05d", n)))
+		check(err)
+		defer f.Close()
+		sum := md5.New()
+		_, err = copyBuffer(io.MultiWriter(f, sum), s3Body(r))
+		check(err)
+		check(f.Close())
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sum.Sum(nil)))
+
+	case http.MethodPost:
+		var req struct {
+			Parts []s3Part `xml:"Part"`
+		}
+		check(xml.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req))
+		if len(req.Parts) == 0 {
+			check(badCall("no parts to complete the upload with"))
+		}
+		var readers []io.Reader
+		sums := md5.New()
+		for _, el := range req.Parts {
+			f, err := store.Open(filepath.Join(dir, fmt.Sprintf("%05d", el.PartNumber)))
+			check(err)
+			defer f.Close()
+			readers = append(readers, f)
+			b, _ := hex.DecodeString(strings.Trim(el.ETag, `"`))
+			sums.Write(b)
+		}
+		check(store.MkdirAll(filepath.Dir(fullPath), os.ModePerm))
+		check(saveFile(r.Context(), fullPath, io.MultiReader(readers...)))
+		store.RemoveAll(dir)
+		s3Reply(w, struct {
+			XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+			Xmlns   string   `xml:"xmlns,attr"`
+			Bucket  string   `xml:"Bucket"`
+			Key     string   `xml:"Key"`
+			ETag    string   `xml:"ETag"`
+		}{Xmlns: s3Namespace, Bucket: *s3Bucket, Key: key, ETag: fmt.Sprintf(`"%x-%d"`, sums.Sum(nil), len(req.Parts))})
+
+	case http.MethodDelete:
+		check(store.RemoveAll(dir))
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		check(badCall("unsupported multipart operation"))
+	}
+}
@@ -0,0 +1,529 @@
+package gossa
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// parts of multipart uploads, s3 wanting at least 5MiB but the last
+const s3PartSize = 8 << 20
+
+// s3Storage serves a bucket of a s3 compatible server (aws, minio, gossa -s3...),
+// path style, from below an optional key prefix. Folders are the common
+// prefixes of keys, plus the empty "folder/" markers made by Mkdir
+type s3Storage struct {
+	endpoint *url.URL // scheme and host
+	bucket   string
+	prefix   string // of the keys, empty or ending with a slash
+	access   string
+	secret   string
+	region   string
+	client   *http.Client
+}
+
+// newS3Storage parses a backend url as http(s)://host:port/bucket[/prefix]
+func newS3Storage(raw string, access string, secret string, region string) (*s3Storage, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid s3 backend %s, expected e.g. https://host:9000/bucket", raw)
+	}
+	bucket, prefix, _ := strings.Cut(strings.Trim(u.Path, "/"), "/")
+	if bucket == "" {
+		return nil, fmt.Errorf("no bucket in s3 backend %s", raw)
+	}
+	if prefix != "" {
+		prefix += "/"
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = 32 // listings and zips fire many requests at the same host
+	return &s3Storage{&url.URL{Scheme: u.Scheme, Host: u.Host}, bucket, prefix, access, secret, region, &http.Client{Transport: transport}}, nil
+}
+
+// key is the key of a path, empty for the root
+func (s *s3Storage) key(name string) string {
+	rel, err := filepath.Rel(rootPath, name)
+	if err != nil || rel == "." {
+		return strings.TrimSuffix(s.prefix, "/")
+	}
+	return s.prefix + filepath.ToSlash(rel)
+}
+
+// do sends a sigv4 signed request, turning error replies into errors
+func (s *s3Storage) do(method string, key string, query url.Values, body io.Reader, headers ...string) (*http.Response, error) {
+	p := "/" + s.bucket + "/" + key
+	var q []string
+	for k, vs := range query {
+		for _, v := range vs {
+			q = append(q, s3Escape(k, false)+"="+s3Escape(v, false))
+		}
+	}
+	sort.Strings(q)
+	u := *s.endpoint
+	u.Path, u.RawPath, u.RawQuery = p, s3Escape(p, true), strings.Join(q, "&")
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	now := time.Now().UTC()
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	if s.access != "" {
+		scope := now.Format("20060102") + "/" + s.region + "/s3/aws4_request"
+		signed := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+		req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.access+"/"+scope+", SignedHeaders="+strings.Join(signed, ";")+", Signature="+s3Signature(req, signed, scope, s.secret))
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	} else if res.StatusCode < 300 {
+		return res, nil
+	}
+	defer res.Body.Close()
+	var reply struct{ Code, Message string }
+	b, _ := io.ReadAll(io.LimitReader(res.Body, 1<<16))
+	xml.Unmarshal(b, &reply)
+	name := "/" + strings.TrimPrefix(key, s.prefix)
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return nil, &os.PathError{Op: strings.ToLower(method), Path: name, Err: os.ErrNotExist}
+	case res.StatusCode == http.StatusForbidden:
+		return nil, &os.PathError{Op: strings.ToLower(method), Path: name, Err: os.ErrPermission}
+	}
+	return nil, fmt.Errorf("s3 %s %s: %s %s (%s)", method, name, reply.Code, reply.Message, res.Status)
+}
+
+// list lists the keys below a prefix, only the ones right below it with the
+// "/" delimiter, along with the common prefixes then
+func (s *s3Storage) list(prefix string, delimiter string, max int) ([]s3Object, []string, error) {
+	var objects []s3Object
+	var prefixes []string
+	q := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	if delimiter != "" {
+		q.Set("delimiter", delimiter)
+	}
+	if max > 0 {
+		q.Set("max-keys", strconv.Itoa(max))
+	}
+	for {
+		res, err := s.do(http.MethodGet, "", q, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		var page s3ListResult
+		err = xml.NewDecoder(res.Body).Decode(&page)
+		res.Body.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		objects = append(objects, page.Contents...)
+		for _, el := range page.CommonPrefixes {
+			prefixes = append(prefixes, el.Prefix)
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" || max > 0 {
+			return objects, prefixes, nil
+		}
+		q.Set("continuation-token", page.NextContinuationToken)
+	}
+}
+
+// remoteInfo describes a file or folder of a remote backend
+type remoteInfo struct {
+	name  string
+	size  int64
+	mtime time.Time
+	dir   bool
+}
+
+func (i remoteInfo) Name() string       { return i.name }
+func (i remoteInfo) Size() int64        { return i.size }
+func (i remoteInfo) ModTime() time.Time { return i.mtime }
+func (i remoteInfo) IsDir() bool        { return i.dir }
+func (i remoteInfo) Sys() any           { return nil }
+func (i remoteInfo) Mode() os.FileMode {
+	if i.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+func (s *s3Storage) Stat(name string) (os.FileInfo, error) {
+	key := s.key(name)
+	if key+"/" == s.prefix || key == "" {
+		return remoteInfo{name: filepath.Base(name), dir: true}, nil
+	}
+	res, err := s.do(http.MethodHead, key, nil, nil)
+	if err == nil {
+		res.Body.Close()
+		mtime, _ := http.ParseTime(res.Header.Get("Last-Modified"))
+		return remoteInfo{path.Base(key), res.ContentLength, mtime, false}, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	objects, prefixes, err := s.list(key+"/", "/", 1)
+	if err != nil {
+		return nil, err
+	} else if len(objects) == 0 && len(prefixes) == 0 {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	return remoteInfo{name: path.Base(key), dir: true}, nil
+}
+
+func (s *s3Storage) Lstat(name string) (os.FileInfo, error) {
+	return s.Stat(name)
+}
+
+func (s *s3Storage) ReadDir(name string) ([]os.DirEntry, error) {
+	info, err := s.Stat(name)
+	if err != nil {
+		return nil, err
+	} else if !info.IsDir() {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: syscall.ENOTDIR}
+	}
+	prefix := s.key(name) + "/"
+	if prefix == "/" {
+		prefix = ""
+	}
+	objects, prefixes, err := s.list(prefix, "/", 0)
+	if err != nil {
+		return nil, err
+	}
+	var entries []os.DirEntry
+	for _, el := range prefixes {
+		entries = append(entries, fs.FileInfoToDirEntry(remoteInfo{name: strings.TrimSuffix(strings.TrimPrefix(el, prefix), "/"), dir: true}))
+	}
+	for _, el := range objects {
+		if el.Key == prefix {
+			continue // the marker of the folder itself
+		}
+		mtime, _ := time.Parse(time.RFC3339, el.LastModified)
+		entries = append(entries, fs.FileInfoToDirEntry(remoteInfo{strings.TrimPrefix(el.Key, prefix), el.Size, mtime, false}))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (s *s3Storage) Open(name string) (File, error) {
+	info, err := s.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	f := &s3File{s: s, name: name, key: s.key(name), info: info.(remoteInfo)}
+	f.rangedReader = rangedReader{get: f.get, size: f.info.size}
+	return f, nil
+}
+
+// OpenFile opens files for reading, or for writing them whole: objects
+// can't be appended to nor changed in place
+func (s *s3Storage) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return s.Open(name)
+	} else if flag&(os.O_RDWR|os.O_APPEND) != 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: errUnsupported}
+	}
+	info, err := s.Stat(name)
+	switch {
+	case err == nil && info.IsDir():
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	case err == nil && flag&os.O_EXCL != 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	case err == nil && flag&os.O_TRUNC == 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: errUnsupported}
+	case errors.Is(err, os.ErrNotExist) && flag&os.O_CREATE == 0:
+		return nil, err
+	case err != nil && !errors.Is(err, os.ErrNotExist):
+		return nil, err
+	}
+	return &s3File{s: s, name: name, key: s.key(name), info: remoteInfo{name: filepath.Base(name), mtime: time.Now()}, writing: true}, nil
+}
+
+func (s *s3Storage) Create(name string) (File, error) {
+	return s.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (s *s3Storage) Mkdir(name string, perm os.FileMode) error {
+	if _, err := s.Stat(name); err == nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	}
+	return s.MkdirAll(name, perm)
+}
+
+// MkdirAll puts a folder marker, its parents being implied by its key
+func (s *s3Storage) MkdirAll(name string, perm os.FileMode) error {
+	info, err := s.Stat(name)
+	if err == nil && info.IsDir() {
+		return nil
+	} else if err == nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: syscall.ENOTDIR}
+	}
+	res, err := s.do(http.MethodPut, s.key(name)+"/", nil, http.NoBody)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+func (s *s3Storage) delete(key string) error {
+	res, err := s.do(http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+func (s *s3Storage) Remove(name string) error {
+	info, err := s.Stat(name)
+	if err != nil {
+		return err
+	} else if !info.IsDir() {
+		return s.delete(s.key(name))
+	}
+	prefix := s.key(name) + "/"
+	objects, prefixes, err := s.list(prefix, "/", 2)
+	if err != nil {
+		return err
+	} else if len(prefixes) > 0 || len(objects) > 1 || len(objects) == 1 && objects[0].Key != prefix {
+		return &os.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+	}
+	return s.delete(prefix)
+}
+
+func (s *s3Storage) RemoveAll(name string) error {
+	key := s.key(name)
+	objects, _, err := s.list(key+"/", "", 0)
+	if err != nil {
+		return err
+	}
+	for _, el := range objects {
+		if err := s.delete(el.Key); err != nil {
+			return err
+		}
+	}
+	if err = s.delete(key); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *s3Storage) copy(src string, dst string) error {
+	res, err := s.do(http.MethodPut, dst, nil, http.NoBody, "X-Amz-Copy-Source", s3Escape("/"+s.bucket+"/"+src, true))
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+// Rename copies then deletes, folders key by key
+func (s *s3Storage) Rename(oldname string, newname string) error {
+	info, err := s.Stat(oldname)
+	if err != nil {
+		return err
+	}
+	src, dst := s.key(oldname), s.key(newname)
+	if !info.IsDir() {
+		if err = s.copy(src, dst); err != nil {
+			return err
+		}
+		return s.delete(src)
+	}
+	objects, _, err := s.list(src+"/", "", 0)
+	if err != nil {
+		return err
+	}
+	for _, el := range objects {
+		if err = s.copy(el.Key, dst+strings.TrimPrefix(el.Key, src)); err != nil {
+			return err
+		}
+	}
+	for _, el := range objects {
+		if err = s.delete(el.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Chtimes is a noop, objects being dated by their last write
+func (s *s3Storage) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return nil
+}
+
+// Chmod is a noop, objects having no permissions
+func (s *s3Storage) Chmod(name string, mode os.FileMode) error {
+	return nil
+}
+
+// s3File reads an object with ranged gets, or uploads one, as a single put
+// when small and as a multipart upload otherwise
+type s3File struct {
+	s    *s3Storage
+	name string
+	key  string
+	info remoteInfo
+	rangedReader
+
+	writing bool
+	buf     bytes.Buffer
+	written int64
+	upload  string
+	parts   []s3Part
+
+	dirLister
+}
+
+func (f *s3File) Name() string               { return f.name }
+func (f *s3File) Stat() (os.FileInfo, error) { return f.info, nil }
+
+func (f *s3File) get(off int64, end int64) (io.ReadCloser, error) {
+	rng := fmt.Sprintf("bytes=%d-", off)
+	if end >= 0 {
+		rng += strconv.FormatInt(end, 10)
+	}
+	res, err := f.s.do(http.MethodGet, f.key, nil, nil, "Range", rng)
+	if err != nil {
+		return nil, err
+	} else if off > 0 && res.StatusCode != http.StatusPartialContent {
+		res.Body.Close()
+		return nil, fmt.Errorf("s3 get %s: ranges unsupported by the server", f.name)
+	}
+	return res.Body, nil
+}
+
+func (f *s3File) Read(p []byte) (int, error) {
+	if f.writing || f.info.dir {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: errUnsupported}
+	}
+	return f.rangedReader.Read(p)
+}
+
+func (f *s3File) ReadAt(p []byte, off int64) (int, error) {
+	if f.writing || f.info.dir {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: errUnsupported}
+	}
+	return f.rangedReader.ReadAt(p, off)
+}
+
+func (f *s3File) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += f.info.size
+	}
+	if offset < 0 || f.writing && offset != f.written {
+		return f.off, &os.PathError{Op: "seek", Path: f.name, Err: errUnsupported}
+	}
+	f.off = offset
+	return offset, nil
+}
+
+func (f *s3File) Write(p []byte) (int, error) {
+	if !f.writing {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: os.ErrPermission}
+	}
+	f.buf.Write(p)
+	f.written += int64(len(p))
+	f.off = f.written
+	for f.buf.Len() >= s3PartSize {
+		if err := f.putPart(f.buf.Next(s3PartSize)); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// WriteAt only writes sequentially, as sftp clients do
+func (f *s3File) WriteAt(p []byte, off int64) (int, error) {
+	if off != f.written {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: errUnsupported}
+	}
+	return f.Write(p)
+}
+
+func (f *s3File) Truncate(size int64) error {
+	if !f.writing || size != f.written {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: errUnsupported}
+	}
+	return nil
+}
+
+func (f *s3File) putPart(b []byte) error {
+	if f.upload == "" {
+		res, err := f.s.do(http.MethodPost, f.key, url.Values{"uploads": {""}}, nil)
+		if err != nil {
+			return err
+		}
+		var init struct{ UploadId string }
+		err = xml.NewDecoder(res.Body).Decode(&init)
+		res.Body.Close()
+		if err != nil {
+			return err
+		}
+		f.upload = init.UploadId
+	}
+	n := len(f.parts) + 1
+	res, err := f.s.do(http.MethodPut, f.key, url.Values{"partNumber": {strconv.Itoa(n)}, "uploadId": {f.upload}}, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	f.parts = append(f.parts, s3Part{n, res.Header.Get("ETag")})
+	return nil
+}
+
+// Close uploads what's left, completing the multipart upload if one was started
+func (f *s3File) Close() error {
+	f.closeBody()
+	if !f.writing {
+		return nil
+	}
+	f.writing = false
+	if f.upload == "" {
+		res, err := f.s.do(http.MethodPut, f.key, nil, bytes.NewReader(f.buf.Bytes()))
+		if err != nil {
+			return err
+		}
+		return res.Body.Close()
+	}
+
+	err := f.putPart(f.buf.Bytes())
+	if err == nil {
+		var b []byte
+		b, err = xml.Marshal(struct {
+			XMLName xml.Name `xml:"CompleteMultipartUpload"`
+			Parts   []s3Part `xml:"Part"`
+		}{Parts: f.parts})
+		var res *http.Response
+		if res, err = f.s.do(http.MethodPost, f.key, url.Values{"uploadId": {f.upload}}, bytes.NewReader(b)); err == nil {
+			return res.Body.Close()
+		}
+	}
+	if res, aerr := f.s.do(http.MethodDelete, f.key, url.Values{"uploadId": {f.upload}}, nil); aerr == nil {
+		res.Body.Close() // aborted, so the parts don't linger
+	}
+	return err
+}
+
+func (f *s3File) Readdir(count int) ([]os.FileInfo, error) {
+	if !f.info.dir {
+		return nil, &os.PathError{Op: "readdir", Path: f.name, Err: syscall.ENOTDIR}
+	}
+	return f.readdir(func() ([]os.DirEntry, error) { return f.s.ReadDir(f.name) }, count)
+}
@@ -0,0 +1,337 @@
+package gossa
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
+)
+
+type sftpUser struct {
+	hash []byte
+	home string // full path the user is confined to
+}
+
+// sftpHandler maps sftp requests onto the served folder, below the home of
+// the user, with the checks and side effects of the rpc calls
+type sftpHandler struct {
+	home string
+}
+
+// sftpFile reports uploads once closed
+type sftpFile struct {
+	File
+	fullPath string
+	isNew    bool
+}
+
+type sftpLister []os.FileInfo
+
+// loadSftpUsers reads accounts from a htpasswd -B like file, one
+// user:bcrypt-hash[:home] per line
+func loadSftpUsers(file string) (map[string]sftpUser, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	users := map[string]sftpUser{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("invalid sftp user line %q", line)
+		}
+		home, err := safePath(append(fields, "")[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid home for sftp user %s: %w", fields[0], err)
+		}
+		users[fields[0]] = sftpUser{[]byte(fields[1]), home}
+	}
+	return users, scanner.Err()
+}
+
+func sftpHostSigner() (ssh.Signer, error) {
+	if *sftpHostKey != "" {
+		pem, err := os.ReadFile(*sftpHostKey)
+		if err != nil {
+			return nil, err
+		}
+		return ssh.ParsePrivateKey(pem)
+	}
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.NewSignerFromKey(key)
+}
+
+// sftpServe accepts sftp sessions on -sftp, for the users of -sftp-users
+func sftpServe() (net.Listener, error) {
+	users, err := loadSftpUsers(*sftpUsers)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := sftpHostSigner()
+	if err != nil {
+		return nil, err
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			u, ok := users[c.User()]
+			if !ok || bcrypt.CompareHashAndPassword(u.hash, pass) != nil {
+				return nil, errors.New("invalid credentials")
+			}
+			return &ssh.Permissions{Extensions: map[string]string{"home": u.home}}, nil
+		},
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", *sftpAddr)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("sftp on %s, host key %s\n", listener.Addr(), ssh.FingerprintSHA256(signer.PublicKey()))
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if errors.Is(err, net.ErrClosed) {
+				return
+			} else if err != nil {
+				log.Println("sftp accept", err)
+				continue
+			}
+			go sftpConn(conn, config)
+		}
+	}()
+	return listener, nil
+}
+
+func sftpConn(conn net.Conn, config *ssh.ServerConfig) {
+	defer conn.Close()
+	sc, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		if *verb {
+			log.Println("sftp handshake", err)
+		}
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	h := sftpHandler{sc.Permissions.Extensions["home"]}
+
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+		ch, chReqs, err := nc.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			for req := range chReqs {
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+			}
+		}()
+		go func() {
+			defer ch.Close()
+			server := sftp.NewRequestServer(ch, sftp.Handlers{FileGet: h, FilePut: h, FileCmd: h, FileList: h})
+			if err := server.Serve(); err != nil && err != io.EOF && *verb {
+				log.Println("sftp session", sc.User(), err)
+			}
+		}()
+	}
+}
+
+// homePath maps the path of a user onto their home, the paths out of reach looking absent
+func homePath(home string, p string) (string, error) {
+	rel, err := filepath.Rel(rootPath, filepath.Join(home, filepath.FromSlash(path.Clean("/"+p))))
+	if err == nil {
+		var fullPath string
+		if fullPath, err = safePath(rel); err == nil && (fullPath == home || strings.HasPrefix(fullPath, home+string(filepath.Separator))) {
+			return fullPath, nil
+		}
+	}
+	return "", &os.PathError{Op: "open", Path: p, Err: os.ErrNotExist}
+}
+
+func (h sftpHandler) resolve(p string) (string, error) {
+	return homePath(h.home, p)
+}
+
+func (h sftpHandler) writable(p string) (string, error) {
+	if *ro {
+		return "", sftp.ErrSSHFxPermissionDenied
+	}
+	return h.resolve(p)
+}
+
+func (h sftpHandler) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	fullPath, err := h.resolve(r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	return store.Open(fullPath)
+}
+
+func (h sftpHandler) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	fullPath, err := h.writable(r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	_, err = store.Lstat(fullPath)
+	isNew := errors.Is(err, os.ErrNotExist)
+	flags := os.O_WRONLY | os.O_CREATE
+	if pf := r.Pflags(); pf.Trunc {
+		flags |= os.O_TRUNC
+		if !isNew {
+			if err := keepVersion(fullPath); err != nil {
+				return nil, err
+			}
+		}
+	}
+	f, err := store.OpenFile(fullPath, flags, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return &sftpFile{f, fullPath, isNew}, nil
+}
+
+// truncateFile resizes a file through the storage, as os.Truncate does
+func truncateFile(fullPath string, size int64) error {
+	f, err := store.OpenFile(fullPath, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	err = f.Truncate(size)
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	return err
+}
+
+func (h sftpHandler) Filecmd(r *sftp.Request) error {
+	fullPath, err := h.writable(r.Filepath)
+	if err != nil {
+		return err
+	}
+	switch r.Method {
+	case "Setstat":
+		attrs, flags := r.Attributes(), r.AttrFlags()
+		if flags.Size {
+			err = truncateFile(fullPath, int64(attrs.Size))
+		}
+		if flags.Permissions && err == nil {
+			err = store.Chmod(fullPath, attrs.FileMode().Perm())
+		}
+		if flags.Acmodtime && err == nil {
+			err = store.Chtimes(fullPath, attrs.AccessTime(), attrs.ModTime())
+		}
+	case "Rename", "PosixRename":
+		var dst string
+		if dst, err = h.writable(r.Target); err != nil {
+			return err
+		}
+		if _, err := store.Lstat(dst); err == nil && r.Method == "Rename" {
+			return &os.PathError{Op: "rename", Path: r.Target, Err: os.ErrExist}
+		}
+		if err = keepVersion(dst); err != nil {
+			return err
+		}
+		if err = moveAny(fullPath, dst); err == nil {
+			notify("rename", fullPath, dst)
+			changed(dst)
+		}
+	case "Mkdir":
+		if err = store.Mkdir(fullPath, os.ModePerm); err == nil {
+			notify("mkdir", fullPath, "")
+		}
+	case "Rmdir", "Remove":
+		if fullPath == h.home {
+			return sftp.ErrSSHFxPermissionDenied
+		}
+		if entries, err := store.ReadDir(fullPath); err == nil && len(entries) > 0 {
+			return sftp.ErrSSHFxFailure // rmdir of a non empty folder
+		}
+		err = discard(fullPath)
+	default:
+		return sftp.ErrSSHFxOpUnsupported
+	}
+	changed(fullPath)
+	return err
+}
+
+func (h sftpHandler) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	fullPath, err := h.resolve(r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	switch r.Method {
+	case "List":
+		entries, err := store.ReadDir(fullPath)
+		if err != nil {
+			return nil, err
+		}
+		var infos sftpLister
+		for _, el := range entries {
+			if p := filepath.Join(fullPath, el.Name()); isHiddenPath(p) || isInternal(p) {
+				continue
+			}
+			if !*symlinks && el.Type()&os.ModeSymlink != 0 {
+				continue
+			}
+			if info, err := entryInfo(fullPath, el); err == nil {
+				infos = append(infos, info)
+			}
+		}
+		return infos, nil
+	case "Stat":
+		info, err := store.Stat(fullPath)
+		if err != nil {
+			return nil, err
+		}
+		return sftpLister{info}, nil
+	default:
+		return nil, sftp.ErrSSHFxOpUnsupported
+	}
+}
+
+func (f *sftpFile) Close() error {
+	err := f.File.Close()
+	if err == nil {
+		if f.isNew {
+			journalPush(journalEntry{op: "upload", dst: f.fullPath})
+		}
+		notify("upload", f.fullPath, "")
+		changed(f.fullPath)
+	}
+	return err
+}
+
+func (l sftpLister) ListAt(ls []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(ls, l[offset:])
+	if n < len(ls) {
+		return n, io.EOF
+	}
+	return n, nil
+}
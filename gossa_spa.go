@@ -0,0 +1,62 @@
+package gossa
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// spaRoot is the folder of -spa, relative to the root, e.g. /app/
+func spaRoot() string {
+	if *spa == "" {
+		return ""
+	}
+	return strings.TrimSuffix(path.Clean("/"+*spa), "/") + "/"
+}
+
+// inSpa tells if a path relative to the root is below the folder of -spa
+func inSpa(rel string) bool {
+	root := spaRoot()
+	return root != "" && strings.HasPrefix(path.Clean("/"+rel)+"/", root)
+}
+
+// acceptsHTML tells if a request comes from a page navigation rather than
+// a script or asset load, which would better get a 404 than a page
+func acceptsHTML(r *http.Request) bool {
+	for _, el := range splitList(r.Header.Get("Accept")) {
+		if t, _, err := mime.ParseMediaType(el); err == nil && t == "text/html" {
+			return true
+		}
+	}
+	return false
+}
+
+// spaFallback serves the index.html of -spa for the missing paths below it,
+// so single page apps can route client side
+func spaFallback(w http.ResponseWriter, r *http.Request, rel string) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead || !inSpa(rel) {
+		return false
+	}
+	if path.Ext(rel) != "" && !acceptsHTML(r) {
+		return false // missing assets
+	}
+	fullPath, err := safePath(spaRoot() + "index.html")
+	if err != nil {
+		return false
+	}
+	f, err := store.Open(fullPath)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil || !stat.Mode().IsRegular() {
+		return false
+	}
+	w.Header().Set("Cache-Control", "no-cache") // revalidated, so new deploys show up
+	w.Header().Set("ETag", fileETag(stat))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	http.ServeContent(w, r, "index.html", stat.ModTime(), f)
+	return true
+}
@@ -0,0 +1,76 @@
+package gossa
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type statResult struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	Mode    string    `json:"mode"`
+	Perm    string    `json:"perm"`
+	ModTime time.Time `json:"mtime"`
+	IsDir   bool      `json:"isDir"`
+	Owner   string    `json:"owner,omitempty"`
+	Group   string    `json:"group,omitempty"`
+	Symlink string    `json:"symlink,omitempty"`
+	Mime    string    `json:"mime,omitempty"`
+}
+
+// guessMime uses the extension first, and sniffs the content otherwise
+func guessMime(fullPath string) string {
+	ext := filepath.Ext(fullPath)
+	if policy := mimePolicies[strings.ToLower(strings.TrimPrefix(ext, "."))]; policy != nil && policy.contentType != "" {
+		return policy.contentType
+	}
+	if t := mime.TypeByExtension(ext); t != "" {
+		return t
+	}
+	file, err := store.Open(fullPath)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	buf := make([]byte, 512)
+	n, _ := io.ReadFull(file, buf)
+	return http.DetectContentType(buf[:n])
+}
+
+// statPath describes a path as json
+func statPath(fullPath string) ([]byte, error) {
+	lstat, err := store.Lstat(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	res := statResult{}
+	if lstat.Mode()&os.ModeSymlink != 0 && *symlinks {
+		res.Symlink, err = readlink(fullPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	stat, err := store.Stat(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	res.Name = stat.Name()
+	res.Size = stat.Size()
+	res.Mode = stat.Mode().String()
+	res.Perm = fmt.Sprintf("%04o", stat.Mode().Perm())
+	res.ModTime = stat.ModTime()
+	res.IsDir = stat.IsDir()
+	res.Owner, res.Group = fileOwner(stat)
+	if !stat.IsDir() {
+		res.Mime = guessMime(fullPath)
+	}
+	return json.Marshal(res)
+}
@@ -0,0 +1,415 @@
+package gossa
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"math/rand"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Storage is where the shared folder lives, the os unless Config.Storage
+// plugs another backend in. Names are the paths requests resolve to below
+// the root, e.g. /srv/share/a/b.txt for /a/b.txt of a gossa sharing /srv/share
+type Storage interface {
+	Open(name string) (File, error)
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+	Create(name string) (File, error)
+	Stat(name string) (os.FileInfo, error)
+	Lstat(name string) (os.FileInfo, error)
+	ReadDir(name string) ([]os.DirEntry, error) // sorted by name
+	Mkdir(name string, perm os.FileMode) error
+	MkdirAll(name string, perm os.FileMode) error
+	Rename(oldname string, newname string) error
+	Remove(name string) error
+	RemoveAll(name string) error
+	Chtimes(name string, atime time.Time, mtime time.Time) error
+	Chmod(name string, mode os.FileMode) error
+}
+
+// File is a file opened from a Storage, as *os.File does
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Writer
+	io.WriterAt
+	io.Seeker
+	io.Closer
+	Name() string
+	Stat() (os.FileInfo, error)
+	Readdir(count int) ([]os.FileInfo, error)
+	Truncate(size int64) error
+}
+
+// LinkStorage is implemented by the backends having symlinks and owners,
+// the ln and chown rpcs failing on the others
+type LinkStorage interface {
+	Symlink(oldname string, newname string) error
+	Readlink(name string) (string, error)
+	EvalSymlinks(name string) (string, error)
+	Lchown(name string, uid int, gid int) error
+}
+
+// SpaceStorage is implemented by the backends knowing how much room they have
+type SpaceStorage interface {
+	DiskSpace(name string) (free uint64, total uint64, err error)
+}
+
+var errUnsupported = errors.New("not supported by this storage")
+
+// osStorage is the local filesystem
+type osStorage struct{}
+
+func (osStorage) Open(name string) (File, error) { return openOS(os.Open(name)) }
+func (osStorage) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return openOS(os.OpenFile(name, flag, perm))
+}
+func (osStorage) Create(name string) (File, error)             { return openOS(os.Create(name)) }
+func (osStorage) Stat(name string) (os.FileInfo, error)        { return os.Stat(name) }
+func (osStorage) Lstat(name string) (os.FileInfo, error)       { return os.Lstat(name) }
+func (osStorage) ReadDir(name string) ([]os.DirEntry, error)   { return os.ReadDir(name) }
+func (osStorage) Mkdir(name string, perm os.FileMode) error    { return os.Mkdir(name, perm) }
+func (osStorage) MkdirAll(name string, perm os.FileMode) error { return os.MkdirAll(name, perm) }
+func (osStorage) Rename(oldname string, newname string) error  { return os.Rename(oldname, newname) }
+func (osStorage) Remove(name string) error                     { return os.Remove(name) }
+func (osStorage) RemoveAll(name string) error                  { return os.RemoveAll(name) }
+func (osStorage) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+func (osStorage) Chmod(name string, mode os.FileMode) error { return os.Chmod(name, mode) }
+func (osStorage) Symlink(oldname string, newname string) error {
+	return os.Symlink(oldname, newname)
+}
+func (osStorage) Readlink(name string) (string, error)          { return os.Readlink(name) }
+func (osStorage) EvalSymlinks(name string) (string, error)      { return filepath.EvalSymlinks(name) }
+func (osStorage) Lchown(name string, uid int, gid int) error    { return os.Lchown(name, uid, gid) }
+func (osStorage) DiskSpace(name string) (uint64, uint64, error) { return diskSpace(name) }
+
+// openOS keeps failed opens a nil File, rather than a File holding a nil *os.File
+func openOS(f *os.File, err error) (File, error) {
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// the storage served, set by New
+var store Storage = osStorage{}
+
+// configStorage sets the storage of a config up from its flags, along with
+// the root it implies, unless one was plugged in
+func configStorage(c *Config) error {
+	if c.Storage != nil {
+		return nil
+	}
+	var err error
+	switch {
+	case c.S3Backend != "":
+		if c.Root == "" {
+			c.Root = "/" // the bucket, below which paths are keys
+		}
+		c.Storage, err = newS3Storage(c.S3Backend, c.S3BackendAccessKey, c.S3BackendSecretKey, c.S3BackendRegion)
+		return err
+	case c.DAVBackend != "":
+		if c.Root == "" {
+			c.Root = "/" // the remote folder
+		}
+		c.Storage, err = newDavStorage(c.DAVBackend, c.DAVBackendUser, c.DAVBackendPassword, c.DAVBackendConns)
+		return err
+	case c.Tmpfs && c.Root == "":
+		c.Root = "/" // only standing for the root of the memory
+	case c.Mounts != "" && c.Root == "": // the root only lists the mounts, gossa's state kept aside
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			return err
+		}
+		c.Root = filepath.Join(dir, "gossa", "root")
+		if err = os.MkdirAll(c.Root, 0700); err != nil {
+			return err
+		}
+		c.Storage, err = newMountStorage(c.Root, c.Mounts, true)
+		return err
+	}
+	if c.Root == "" {
+		return nil
+	}
+	root, err := filepath.Abs(c.Root)
+	if err != nil {
+		return err
+	}
+	stat, serr := os.Stat(root)
+	switch {
+	case c.Tmpfs:
+		c.Storage = newMemStorage(root, c.TmpfsMaxSize)
+	case serr == nil && stat.Mode().IsRegular() && isBrowsableArchive(root):
+		c.Storage, err = newArchiveStorage(root)
+		c.ReadOnly = true
+	case c.Overlay != "":
+		c.Storage, err = newOverlayStorage(root, c.Overlay)
+	case c.Mounts != "":
+		c.Storage, err = newMountStorage(root, c.Mounts, false)
+	}
+	return err
+}
+
+func linkStore() (LinkStorage, error) {
+	if ls, ok := store.(LinkStorage); ok {
+		return ls, nil
+	}
+	return nil, badCall("links and owners are %s", errUnsupported)
+}
+
+// storeSpace returns the free and total bytes of the storage where a path lives
+func storeSpace(name string) (uint64, uint64, error) {
+	if ss, ok := store.(SpaceStorage); ok {
+		return ss.DiskSpace(name)
+	}
+	return 0, 0, errUnsupported
+}
+
+func readlink(name string) (string, error) {
+	ls, err := linkStore()
+	if err != nil {
+		return "", err
+	}
+	return ls.Readlink(name)
+}
+
+func readFile(name string) ([]byte, error) {
+	f, err := store.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func writeFile(name string, b []byte, perm os.FileMode) error {
+	f, err := store.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// createTemp makes a new file in dir, its name being pattern with its last
+// * replaced by a random string, as os.CreateTemp
+func createTemp(dir string, pattern string) (File, error) {
+	prefix, suffix := pattern, ""
+	if i := strings.LastIndexByte(pattern, '*'); i >= 0 {
+		prefix, suffix = pattern[:i], pattern[i+1:]
+	}
+	for try := 0; ; try++ {
+		name := filepath.Join(dir, prefix+strconv.FormatUint(uint64(rand.Uint32()), 10)+suffix)
+		f, err := store.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if errors.Is(err, os.ErrExist) && try < 10000 {
+			continue
+		}
+		return f, err
+	}
+}
+
+// walk walks a tree of the storage, as filepath.Walk
+func walk(root string, fn filepath.WalkFunc) error {
+	info, err := store.Lstat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walkInfo(root, info, fn)
+	}
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+func walkInfo(p string, info os.FileInfo, fn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return fn(p, info, nil)
+	}
+	entries, err := store.ReadDir(p)
+	err1 := fn(p, info, err)
+	if err != nil || err1 != nil {
+		return err1
+	}
+	for _, el := range entries {
+		name := filepath.Join(p, el.Name())
+		info, err := store.Lstat(name)
+		if err != nil {
+			if err := fn(name, info, err); err != nil && err != filepath.SkipDir {
+				return err
+			}
+		} else if err = walkInfo(name, info, fn); err != nil && (!info.IsDir() || err != filepath.SkipDir) {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkDir walks a tree of the storage, as filepath.WalkDir
+func walkDir(root string, fn fs.WalkDirFunc) error {
+	info, err := store.Lstat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walkDirEntry(root, fs.FileInfoToDirEntry(info), fn)
+	}
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+func walkDirEntry(p string, d fs.DirEntry, fn fs.WalkDirFunc) error {
+	if err := fn(p, d, nil); err != nil || !d.IsDir() {
+		if err == filepath.SkipDir && d.IsDir() {
+			err = nil
+		}
+		return err
+	}
+	entries, err := store.ReadDir(p)
+	if err != nil {
+		if err = fn(p, d, err); err != nil {
+			if err == filepath.SkipDir {
+				err = nil
+			}
+			return err
+		}
+	}
+	for _, el := range entries {
+		if err := walkDirEntry(filepath.Join(p, el.Name()), el, fn); err != nil {
+			if err == filepath.SkipDir {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// rangedReader reads a remote file with ranged gets, keeping the last one
+// open while the reads go on sequentially
+type rangedReader struct {
+	get     func(off int64, end int64) (io.ReadCloser, error) // end included, -1 for up to the end
+	size    int64
+	off     int64
+	body    io.ReadCloser // of the current get, at bodyOff
+	bodyOff int64
+}
+
+func (r *rangedReader) Read(p []byte) (int, error) {
+	if r.off >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil || r.bodyOff != r.off {
+		r.closeBody()
+		body, err := r.get(r.off, -1)
+		if err != nil {
+			return 0, err
+		}
+		r.body, r.bodyOff = body, r.off
+	}
+	n, err := r.body.Read(p)
+	r.off += int64(n)
+	r.bodyOff += int64(n)
+	if err == io.EOF && r.off < r.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (r *rangedReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	body, err := r.get(off, min(off+int64(len(p)), r.size)-1)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+	n, err := io.ReadFull(body, p[:min(int64(len(p)), r.size-off)])
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (r *rangedReader) closeBody() {
+	if r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+}
+
+// dirLister pages the entries of a folder out to Readdir, as *os.File does,
+// listing it on the first call
+type dirLister struct {
+	entries []os.FileInfo // left to Readdir
+	listed  bool
+}
+
+func (d *dirLister) readdir(list func() ([]os.DirEntry, error), count int) ([]os.FileInfo, error) {
+	if !d.listed {
+		entries, err := list()
+		if err != nil {
+			return nil, err
+		}
+		for _, el := range entries {
+			info, _ := el.Info()
+			d.entries = append(d.entries, info)
+		}
+		d.listed = true
+	}
+	if count <= 0 {
+		out := d.entries
+		d.entries = nil
+		return out, nil
+	} else if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	n := min(count, len(d.entries))
+	out := d.entries[:n]
+	d.entries = d.entries[n:]
+	return out, nil
+}
+
+// OSFiler is implemented by the files of backends wrapping a local file, so
+// downloads can skip the wrapper and go through sendfile
+type OSFiler interface {
+	OSFile() *os.File
+}
+
+// sendable returns the local file below f if there's one: copied to a tcp
+// connection unwrapped, it's sent by the kernel rather than through userspace
+func sendable(f File) File {
+	if of, ok := f.(OSFiler); ok {
+		if raw := of.OSFile(); raw != nil {
+			return raw
+		}
+	}
+	return f
+}
+
+// httpStorage serves the storage to http.FileServer, as http.Dir(rootPath)
+type httpStorage struct{}
+
+func (httpStorage) Open(name string) (http.File, error) {
+	f, err := store.Open(filepath.Join(rootPath, filepath.FromSlash(path.Clean("/"+name))))
+	if err != nil {
+		return nil, err
+	}
+	if stat, err := f.Stat(); err == nil && stat.Mode().IsRegular() {
+		return sendable(f), nil // folders are left to the backend, listing them its own way
+	}
+	return f, nil
+}
@@ -0,0 +1,132 @@
+package gossa
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"lukechampine.com/blake3"
+)
+
+type sumEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	Sum     string    `json:"sum"`
+}
+
+// computed checksums, keyed by algorithm and full path. Entries are only
+// reused while size and mtime of the file are unchanged
+var sumCache = map[string]sumEntry{}
+var sumCacheLock sync.Mutex
+
+// sumCacheDirty tells new sums are yet to be written to -sum-cache, which is
+// done every sumCacheFlush rather than rewriting the whole file for every sum
+var sumCacheDirty bool
+var sumCacheFlush = 10 * time.Second
+
+func newHash(algo string) (hash.Hash, error) {
+	switch algo {
+	case "md5":
+		return md5.New(), nil
+	case "sha1":
+		return sha1.New(), nil
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	case "blake3":
+		return blake3.New(32, nil), nil
+	}
+	return nil, badCall("unsupported hash %s", algo)
+}
+
+// fileSum returns the hex encoded checksum of a file, from cache if possible
+func fileSum(fullPath string, algo string) ([]byte, error) {
+	stat, err := store.Stat(fullPath)
+	if err != nil {
+		return nil, err
+	} else if stat.IsDir() {
+		return nil, badCall("cant checksum a directory")
+	}
+
+	key := algo + ":" + fullPath
+	sumCacheLock.Lock()
+	cached, ok := sumCache[key]
+	sumCacheLock.Unlock()
+	if ok && cached.Size == stat.Size() && cached.ModTime.Equal(stat.ModTime()) {
+		return []byte(cached.Sum), nil
+	}
+
+	h, err := newHash(algo)
+	if err != nil {
+		return nil, err
+	}
+	file, err := store.Open(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	_, err = copyBuffer(h, file)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := hex.EncodeToString(h.Sum(nil))
+	sumCacheLock.Lock()
+	sumCache[key] = sumEntry{stat.Size(), stat.ModTime(), sum}
+	sumCacheDirty = true
+	sumCacheLock.Unlock()
+	return []byte(sum), nil
+}
+
+func loadSumCache() {
+	if *sumCachePath == "" {
+		return
+	}
+	b, err := os.ReadFile(*sumCachePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	check(err)
+	check(json.Unmarshal(b, &sumCache))
+}
+
+// saveSumCache writes the sums to -sum-cache, if any was computed since last time
+func saveSumCache() error {
+	sumCacheLock.Lock()
+	defer sumCacheLock.Unlock()
+	if *sumCachePath == "" || !sumCacheDirty {
+		return nil
+	}
+	b, err := json.Marshal(sumCache)
+	if err != nil {
+		return err
+	}
+	tmp := *sumCachePath + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, *sumCachePath); err != nil {
+		return err
+	}
+	sumCacheDirty = false
+	return nil
+}
+
+// sumCacheFlusher persists the new sums every sumCacheFlush, forever
+func sumCacheFlusher() {
+	for range time.Tick(sumCacheFlush) {
+		if err := saveSumCache(); err != nil {
+			log.Println("error - cant save sum cache", err)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package gossa
+
+import (
+	"archive/tar"
+	"context"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// tarRPC streams a plain tar of a folder, keeping modes and mtimes, e.g. for `curl ... | tar x`
+func tarRPC(w http.ResponseWriter, r *http.Request) {
+	tarPath := r.URL.Query().Get("path")
+	withLinks := r.URL.Query().Get("symlinks") == "1"
+	defer exitPath(w, "tar", tarPath)
+	excludes := splitList(*zipExclude)
+	for _, el := range r.URL.Query()["exclude"] {
+		excludes = append(excludes, splitList(el)...)
+	}
+	tarFullPath := enforcePath(tarPath)
+	stat, err := store.Stat(tarFullPath)
+	check(err)
+	defer archiveSlot(w, r)()
+	capTar(r.Context(), tarFullPath, excludes, withLinks)
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": stat.Name() + ".tar"}))
+	tarWriter := tar.NewWriter(rateLimit(w)) // not closed on failures, so aborted tars don't look complete
+
+	err = tarTree(r.Context(), tarFullPath, excludes, withLinks, func(path string, rel string, f fs.FileInfo, link string) {
+		header, err := tar.FileInfoHeader(f, link)
+		check(err)
+		header.Name = rel
+		check(tarWriter.WriteHeader(header))
+		if !f.Mode().IsRegular() {
+			return
+		}
+
+		file, err := store.Open(path)
+		check(err)
+		defer file.Close()
+		_, err = copyBuffer(tarWriter, ctxReader{r.Context(), file})
+		check(err)
+	})
+	check(err)
+	check(tarWriter.Close())
+}
+
+// tarTree walks what's below a path, handing emit the folders, regular files
+// and, withLinks, symlinks a tar of it holds, named by their slash separated
+// path, with a trailing slash for folders
+func tarTree(ctx context.Context, tarFullPath string, excludes []string, withLinks bool, emit func(path string, rel string, f fs.FileInfo, link string)) error {
+	ig := ignores{}
+	return walk(tarFullPath, func(path string, f fs.FileInfo, err error) error {
+		check(err)
+		check(ctx.Err())
+		rel, err := filepath.Rel(tarFullPath, path)
+		check(err)
+		if rel == "." {
+			return nil
+		}
+		if globMatchAny(excludes, filepath.ToSlash(rel)) || isInternal(path) || isHiddenPath(path) || ig.match(path, f.IsDir()) {
+			if f.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil // excluded, or hidden files not allowed
+		}
+
+		link := ""
+		if f.Mode()&os.ModeSymlink != 0 {
+			if !withLinks {
+				return nil
+			}
+			link, err = readlink(path)
+			check(err)
+		} else if !f.Mode().IsRegular() && !f.IsDir() {
+			return nil // devices, sockets, pipes...
+		}
+
+		rel = filepath.ToSlash(rel) // make the paths consistent between OSes
+		if f.IsDir() {
+			rel += "/"
+		}
+		emit(path, rel, f, link)
+		return nil
+	})
+}
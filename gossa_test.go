@@ -1,22 +1,92 @@
-package main
+package gossa
 
 import (
+	"archive/tar"
 	"archive/zip"
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
 	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/text/language"
 )
 
+const errForbidden = `{"error":"forbidden","message":"invalid path"}`
+
+var inProcess http.Handler // New being once per process, the other backends swap its storage
+
+// statCounter counts the stats of the storage it wraps
+type statCounter struct {
+	Storage
+	stats int
+}
+
+func (s *statCounter) Stat(name string) (os.FileInfo, error) {
+	s.stats++
+	return s.Storage.Stat(name)
+}
+
 func dieMaybe(t *testing.T, err error) {
 	if err != nil {
 		t.Fatal(err)
 	}
 }
 
+// setFor sets a global, e.g. a flag, until the test ends
+func setFor[T any](t *testing.T, p *T, v T) {
+	prev := *p
+	*p = v
+	t.Cleanup(func() { *p = prev })
+}
+
+// serveStore serves s with the gossa of this process until the test ends
+func serveStore(t *testing.T, s Storage) string {
+	setFor(t, &store, s)
+	srv := httptest.NewServer(inProcess)
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// memServer serves a fresh tmpfs of max bytes, 0 for no limit
+func memServer(t *testing.T, max int64) string {
+	return serveStore(t, newMemStorage(rootPath, max))
+}
+
+func abs(n int) int {
+	return max(n, -n)
+}
+
 func trimSpaces(str string) string {
 	space := regexp.MustCompile(`\s+`)
 	return space.ReplaceAllString(str, " ")
@@ -30,6 +100,13 @@ func getRaw(t *testing.T, url string) []byte {
 	return body
 }
 
+func getHeader(t *testing.T, url string, header string) string {
+	resp, err := http.Get(url)
+	dieMaybe(t, err)
+	resp.Body.Close()
+	return resp.Header.Get(header)
+}
+
 func getZip(t *testing.T, needle string, dest string) (int, bool) {
 	b := getRaw(t, dest)
 	unzipped, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
@@ -43,6 +120,19 @@ func getZip(t *testing.T, needle string, dest string) (int, bool) {
 	return length, false
 }
 
+func makeZip(t *testing.T, files map[string]string) string {
+	buf := new(bytes.Buffer)
+	zipWriter := zip.NewWriter(buf)
+	for name, content := range files {
+		f, err := zipWriter.Create(name)
+		dieMaybe(t, err)
+		_, err = f.Write([]byte(content))
+		dieMaybe(t, err)
+	}
+	dieMaybe(t, zipWriter.Close())
+	return buf.String()
+}
+
 func get(t *testing.T, url string) string {
 	body := getRaw(t, url)
 	return trimSpaces(string(body))
@@ -72,6 +162,106 @@ func postJSON(t *testing.T, url string, what string) string {
 	return trimSpaces(string(body))
 }
 
+func putText(t *testing.T, url string, what string, header string, value string) (int, string) {
+	req, err := http.NewRequest(http.MethodPut, url, strings.NewReader(what))
+	dieMaybe(t, err)
+	if header != "" {
+		req.Header.Set(header, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	dieMaybe(t, err)
+	resp.Body.Close()
+	return resp.StatusCode, resp.Header.Get("ETag")
+}
+
+func davDo(t *testing.T, method string, url string, what string, headers ...string) (int, string) {
+	req, err := http.NewRequest(method, url, strings.NewReader(what))
+	dieMaybe(t, err)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	dieMaybe(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	dieMaybe(t, err)
+	return resp.StatusCode, string(body)
+}
+
+func sftpDial(t *testing.T, user string) *sftp.Client {
+	conf := &ssh.ClientConfig{User: user, Auth: []ssh.AuthMethod{ssh.Password("hunter2")}, HostKeyCallback: ssh.InsecureIgnoreHostKey()}
+	conn, err := ssh.Dial("tcp", "127.0.0.1:2022", conf)
+	dieMaybe(t, err)
+	client, err := sftp.NewClient(conn)
+	dieMaybe(t, err)
+	return client
+}
+
+// ftpDo sends a ftp command, and for transfers sends or reads the data
+// connection through passive mode
+func ftpDo(t *testing.T, c *textproto.Conn, cmd string, upload string) (int, string) {
+	var data net.Conn
+	if strings.HasPrefix(cmd, "LIST") || strings.HasPrefix(cmd, "NLST") || strings.HasPrefix(cmd, "RETR") || strings.HasPrefix(cmd, "STOR") {
+		dieMaybe(t, c.PrintfLine("EPSV"))
+		_, msg, err := c.ReadResponse(229)
+		dieMaybe(t, err)
+		port := strings.Trim(msg[strings.Index(msg, "(|||"):], "(|)")
+		data, err = net.Dial("tcp", "127.0.0.1:"+port)
+		dieMaybe(t, err)
+	}
+	dieMaybe(t, c.PrintfLine("%s", cmd))
+	code, msg, _ := c.ReadResponse(0)
+	if data == nil || code != 150 {
+		return code, msg
+	}
+	if upload != "" {
+		data.Write([]byte(upload))
+	} else {
+		b, _ := io.ReadAll(data)
+		msg = string(b)
+	}
+	data.Close()
+	code, _, _ = c.ReadResponse(226)
+	return code, msg
+}
+
+// s3Do sends a request to the s3 gateway, sigv4 signed with the test keys.
+// Streaming signed bodies are sent as signed chunks of 3 bytes
+func s3Do(t *testing.T, method string, url string, what string, headers ...string) (int, string) {
+	req, err := http.NewRequest(method, url, strings.NewReader(what))
+	dieMaybe(t, err)
+	req.Host = req.URL.Host
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	req.Header.Set("X-Amz-Date", time.Now().UTC().Format("20060102T150405Z"))
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	date := req.Header.Get("X-Amz-Date")
+	scope := date[:8] + "/us-east-1/s3/aws4_request"
+	signed := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	signature := s3Signature(req, signed, scope, "s3cret")
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=gossa/"+scope+", SignedHeaders="+strings.Join(signed, ";")+", Signature="+signature)
+	if req.Header.Get("X-Amz-Content-Sha256") == "STREAMING-AWS4-HMAC-SHA256-PAYLOAD" {
+		var body strings.Builder
+		sign := &s3ChunkSign{s3SigningKey(scope, "s3cret"), date, scope, signature}
+		for rest := what; ; rest = rest[min(3, len(rest)):] {
+			chunk := rest[:min(3, len(rest))]
+			sign.check("", []byte(chunk)) // chains the signature, to sign the chunk with
+			fmt.Fprintf(&body, "%x;chunk-signature=%s\r\n%s\r\n", len(chunk), sign.prev, chunk)
+			if chunk == "" {
+				break
+			}
+		}
+		req.Body, req.ContentLength = io.NopCloser(strings.NewReader(body.String())), int64(body.Len())
+	}
+	resp, err := http.DefaultClient.Do(req)
+	dieMaybe(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	dieMaybe(t, err)
+	return resp.StatusCode, string(body)
+}
+
 func fetchAndTestDefault(t *testing.T, url string) string {
 	body0 := get(t, url)
 
@@ -79,10 +269,14 @@ func fetchAndTestDefault(t *testing.T, url string) string {
 		t.Fatal("error title")
 	}
 
-	if !strings.Contains(body0, `<h1 onclick="return titleClick(event)">./</h1>`) {
+	if !strings.Contains(body0, `<h1 onclick="return titleClick(event)"><a href="`) {
 		t.Fatal("error header")
 	}
 
+	if !strings.Contains(body0, `">./</a></h1>`) {
+		t.Fatal("error header crumbs")
+	}
+
 	if !strings.Contains(body0, `href="hols">hols/</a>`) {
 		t.Fatal("error hols folder")
 	}
@@ -116,11 +310,82 @@ func doTestRegular(t *testing.T, url string, testExtra bool) {
 		t.Fatal("fetching a subfolder failed")
 	}
 
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test folder metadata on HEAD")
+	resp, err := http.Head(url + "hols/")
+	dieMaybe(t, err)
+	size, _ := strconv.Atoi(resp.Header.Get("Gossa-Total-Size"))
+	entries := resp.Header.Get("Gossa-Entries")
+	if size < 1000000 || resp.Header.Get("Last-Modified") == "" || !testExtra && entries != "4" || testExtra && entries != "5" {
+		t.Fatal("folder metadata on HEAD errored")
+	}
+
 	// ~~~~~~~~~~~~~~~~~
 	fmt.Println("\r\n~~~~~~~~~~ test fetching an invalid path - redirected to root")
 	fetchAndTestDefault(t, url+"../../")
 	fetchAndTestDefault(t, url+"hols/../../")
 
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test listing encodings")
+	for enc, decoder := range map[string]func(io.Reader) io.Reader{
+		"br":   func(r io.Reader) io.Reader { return brotli.NewReader(r) },
+		"zstd": func(r io.Reader) io.Reader { d, _ := zstd.NewReader(r); return d },
+		"gzip": func(r io.Reader) io.Reader { d, _ := gzip.NewReader(r); return d },
+	} {
+		req, err := http.NewRequest("GET", url+"hols/", nil)
+		dieMaybe(t, err)
+		req.Header.Set("Accept-Encoding", enc)
+		resp, err := http.DefaultClient.Do(req)
+		dieMaybe(t, err)
+		b, err := ioutil.ReadAll(decoder(resp.Body))
+		dieMaybe(t, err)
+		if resp.Header.Get("Content-Encoding") != enc || !strings.Contains(string(b), "glasgow.jpg") {
+			t.Fatal("listing encoding errored", enc)
+		}
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test translations")
+	for el := range catalogs {
+		b, err := i18nFiles.ReadFile("ui/i18n/" + el + ".json")
+		dieMaybe(t, err)
+		var texts map[string]string
+		dieMaybe(t, json.Unmarshal(b, &texts))
+		if len(texts) != len(catalogs["en"]) {
+			t.Fatal("translations errored, incomplete catalog", el, len(texts), len(catalogs["en"]))
+		}
+	}
+	localized := func(accept string) (string, string, string) {
+		req, err := http.NewRequest("GET", url+"hols/", nil)
+		dieMaybe(t, err)
+		req.Header.Set("Accept-Language", accept)
+		resp, err := http.DefaultClient.Do(req)
+		dieMaybe(t, err)
+		b, err := io.ReadAll(resp.Body)
+		dieMaybe(t, err)
+		resp.Body.Close()
+		return string(b), resp.Header.Get("ETag"), strings.Join(resp.Header.Values("Vary"), ",")
+	}
+	fr, frTag, vary := localized("fr-CH, fr;q=0.9, en;q=0.8")
+	en, enTag, _ := localized("xx, pt;q=0.5")
+	if !strings.Contains(fr, `<html lang="fr">`) || !strings.Contains(fr, "Aide : Ctrl/Cmd") || !strings.Contains(fr, "copier la somme sha256") ||
+		!strings.Contains(en, `<html lang="en">`) || !strings.Contains(en, "Help: Ctrl/Cmd") || frTag == enTag || !strings.Contains(vary, "Accept-Language") {
+		t.Fatal("translations errored", frTag, enTag, vary)
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test conditional listing")
+	body0 = getHeader(t, url+"hols/", "ETag")
+	req, err := http.NewRequest("GET", url+"hols/", nil)
+	dieMaybe(t, err)
+	req.Header.Set("If-None-Match", body0)
+	resp, err = http.DefaultClient.Do(req)
+	dieMaybe(t, err)
+	body1 = getHeader(t, url+"subdir/", "ETag")
+	if !strings.HasPrefix(body0, `W/"`) || resp.StatusCode != 304 || body0 == body1 {
+		t.Fatal("conditional listing errored")
+	}
+
 	// ~~~~~~~~~~~~~~~~~
 	fmt.Println("\r\n~~~~~~~~~~ test fetching regular files")
 	body0 = get(t, url+"subdir_with%20space/file_with%20space.html")
@@ -129,10 +394,98 @@ func doTestRegular(t *testing.T, url string, testExtra bool) {
 		t.Fatal("fetching a regular file errored")
 	}
 
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test checksum endpoint")
+	body0 = get(t, url+"fancy-path/a?hash=sha256")
+	body1 = get(t, url+"fancy-path/a?hash=sha256") // cached
+	body2 = get(t, url+"fancy-path/a?hash=blake3")
+	if body0 != fmt.Sprintf("%x", sha256.Sum256([]byte("fancy!\n"))) || body0 != body1 || len(body2) != 64 {
+		t.Fatal("checksum endpoint errored")
+	}
+	if get(t, url+"fancy-path/a?hash=crc0") != `{"error":"bad_request","message":"unsupported hash crc0"}` {
+		t.Fatal("checksum endpoint didnt error on invalid hash")
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test torrent generation")
+	body0 = get(t, url+"hols/glasgow.jpg?torrent")
+	body1 = get(t, url+"hols/?torrent")
+	if !strings.HasPrefix(body0, "d10:created by5:gossa4:infod6:lengthi") || !strings.Contains(body0, "8:url-listl") {
+		t.Fatal("torrent generation errored for file")
+	}
+	if !strings.Contains(body1, "5:filesld6:lengthi") || !strings.Contains(body1, "4:name4:hols") || strings.Contains(body1, "hidden-folder") != testExtra {
+		t.Fatal("torrent generation errored for folder")
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test forced download")
+	body0 = getHeader(t, url+"subdir_with%20space/file_with%20space.html?download=1", "Content-Disposition")
+	body1 = getHeader(t, url+"subdir_with%20space/file_with%20space.html", "Content-Disposition")
+	if body0 != `attachment; filename="file_with space.html"` || body1 != "" {
+		t.Fatal("forced download errored")
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test mime policy, should apply: ", testExtra)
+	body0 = getHeader(t, url+"ext/somefile.opml", "Content-Type")
+	body1 = getHeader(t, url+"ext/somefile.opml", "Content-Disposition")
+	if testExtra && (body0 != `application/foo` || body1 != `attachment; filename=somefile.opml`) {
+		t.Fatal("mime policy not applied")
+	} else if !testExtra && (body0 == `application/foo` || body1 != "") {
+		t.Fatal("mime policy applied where it shouldnt")
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test precompressed siblings, should apply: ", testExtra)
+	for _, el := range []struct{ path, encoding, body string }{
+		{"compress/foo.js", "gzip", "\x1f\x8b"},
+		{"compress/foo_2.js", "", "exports.foo = 'baz'\n"},
+		{"gzip/fake_ecstatic", "", "ecstatic"},
+	} {
+		if !testExtra {
+			el.encoding = ""
+			if el.path == "compress/foo.js" {
+				el.body = "exports.foo = 'baz'\n"
+			}
+		}
+		req, err := http.NewRequest("GET", url+el.path, nil)
+		dieMaybe(t, err)
+		req.Header.Set("Accept-Encoding", "gzip, br")
+		resp, err := http.DefaultClient.Do(req)
+		dieMaybe(t, err)
+		b, err := ioutil.ReadAll(resp.Body)
+		dieMaybe(t, err)
+		if resp.Header.Get("Content-Encoding") != el.encoding || !strings.HasPrefix(string(b), el.body) {
+			t.Fatal("precompressed sibling errored", el.path)
+		}
+	}
+	for path, vary := range map[string]bool{"compress/foo.js": testExtra, "compress/foo_2.js": false} {
+		req, err := http.NewRequest("GET", url+path, nil)
+		dieMaybe(t, err)
+		req.Header.Set("Accept-Encoding", "identity")
+		resp, err := http.DefaultClient.Do(req)
+		dieMaybe(t, err)
+		resp.Body.Close()
+		if resp.Header.Get("Content-Encoding") != "" || slices.Contains(resp.Header.Values("Vary"), "Accept-Encoding") != vary {
+			t.Fatal("precompressed fallback errored", path, resp.Header)
+		}
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test cache-control policy, should apply: ", testExtra)
+	body0 = getHeader(t, url+"hols/c.js", "Cache-Control")
+	body1 = getHeader(t, url+"fancy-path/a", "Cache-Control")
+	body2 = getHeader(t, url+"hols/", "Cache-Control")
+	if testExtra && (body0 != "max-age=60" || body1 != "no-cache" || body2 != "no-store") {
+		t.Fatal("cache-control policy not applied")
+	} else if !testExtra && body0+body1+body2 != "" {
+		t.Fatal("cache-control policy applied where it shouldnt")
+	}
+
 	// ~~~~~~~~~~~~~~~~~
 	fmt.Println("\r\n~~~~~~~~~~ test fetching a invalid file")
 	path = "../../../../../../../../../../etc/passwd"
-	if !testExtra && get(t, url+path) != `error` {
+	if !testExtra && get(t, url+path) != `{"error":"not_found","message":"stat /etc/passwd: no such file or directory"}` {
 		t.Fatal("fetching a invalid file didnt errored")
 	} else if testExtra {
 		fetchAndTestDefault(t, url+path) // extra path will just redirect to root dir
@@ -140,8 +493,8 @@ func doTestRegular(t *testing.T, url string, testExtra bool) {
 
 	// ~~~~~~~~~~~~~~~~~
 	fmt.Println("\r\n~~~~~~~~~~ test zipping of folder 中文")
-	len, foundFile := getZip(t, "檔案.html", url+"zip?zipPath=%2F%E4%B8%AD%E6%96%87%2F&zipName=%E4%B8%AD%E6%96%87")
-	if len != 1 || !foundFile {
+	count, foundFile := getZip(t, "檔案.html", url+"zip?zipPath=%2F%E4%B8%AD%E6%96%87%2F&zipName=%E4%B8%AD%E6%96%87")
+	if count != 1 || !foundFile {
 		t.Fatal("invalid zip generated")
 	}
 
@@ -154,11 +507,55 @@ func doTestRegular(t *testing.T, url string, testExtra bool) {
 		t.Fatal("invalid zip generated - should contain hidden folder")
 	}
 
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test zipping with exclude patterns")
+	_, foundJs := getZip(t, "c.js", url+"zip?zipPath=%2fhols%2f&zipName=hols&exclude=*.jpg")
+	_, foundJpg := getZip(t, "glasgow.jpg", url+"zip?zipPath=%2fhols%2f&zipName=hols&exclude=*.jpg")
+	_, foundSub := getZip(t, ".hidden-folder/some-file", url+"zip?zipPath=%2fhols%2f&zipName=hols&exclude=.hidden-folder/**")
+	if !foundJs || foundJpg || foundSub {
+		t.Fatal("invalid zip generated - exclude patterns not honored")
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	if testExtra {
+		fmt.Println("\r\n~~~~~~~~~~ test resuming cached zip")
+		req, err := http.NewRequest("GET", url+"zip?zipPath=%2fhols%2f&zipName=hols", nil)
+		dieMaybe(t, err)
+		req.Header.Set("Range", "bytes=0-3")
+		resp, err := http.DefaultClient.Do(req)
+		dieMaybe(t, err)
+		b, err := ioutil.ReadAll(resp.Body)
+		dieMaybe(t, err)
+		if resp.StatusCode != 206 || string(b) != "PK\x03\x04" || resp.Header.Get("ETag") == "" {
+			t.Fatal("cached zip range request errored")
+		}
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test streaming tar")
+	tarReader := tar.NewReader(bytes.NewReader(getRaw(t, url+"tar?path=%2fhols%2f&exclude=*.jpg")))
+	var tarNames []string
+	for {
+		h, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		dieMaybe(t, err)
+		if h.ModTime.IsZero() || h.Mode == 0 {
+			t.Fatal("tar lost mode or mtime")
+		}
+		tarNames = append(tarNames, h.Name)
+	}
+	body0 = strings.Join(tarNames, ",")
+	if !testExtra && body0 != "c.js" || testExtra && body0 != ".hidden-folder/,.hidden-folder/some-file,c.js" {
+		t.Fatal("streaming tar errored", body0)
+	}
+
 	// ~~~~~~~~~~~~~~~~~
 	fmt.Println("\r\n~~~~~~~~~~ test zip invalid path")
 	body0 = get(t, url+"zip?zipPath=%2Ftmp&zipName=subdir")
 	println(body0)
-	if body0 != `error` {
+	if body0 != `{"error":"not_found","message":"lstat /tmp: no such file or directory"}` {
 		t.Fatal("zip passed for invalid path")
 	}
 
@@ -177,15 +574,33 @@ func doTestRegular(t *testing.T, url string, testExtra bool) {
 	// ~~~~~~~~~~~~~~~~~
 	fmt.Println("\r\n~~~~~~~~~~ test invalid mkdir rpc")
 	body0 = postJSON(t, url+"rpc", `{"call":"mkdirp","args":["../BBB"]}`)
-	if body0 != `error` {
+	if body0 != errForbidden {
 		t.Fatal("invalid mkdir rpc didnt errored #0")
 	}
 
 	body0 = postJSON(t, url+"rpc", `{"call":"mkdirp","args":["/../BBB"]}`)
-	if body0 != `error` {
+	if body0 != errForbidden {
 		t.Fatal("invalid mkdir rpc didnt errored #1")
 	}
 
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test malformed rpc status codes")
+	for _, call := range []string{`{"call":"mkdirp"}`, `{"call":"nope","args":[]}`, `{"call":`} {
+		resp, err := http.Post(url+"rpc", "application/json", strings.NewReader(call))
+		dieMaybe(t, err)
+		if resp.StatusCode != http.StatusBadRequest || resp.Header.Get("Content-Type") != "application/json" {
+			t.Fatal("malformed rpc didnt 400", call, resp.StatusCode)
+		}
+	}
+
+	resp, err = http.Post(url+"rpc", "application/json", strings.NewReader(`{"call":"rm","args":["/../x"]}`))
+	dieMaybe(t, err)
+	resp2, err := http.Get(url + "hols/nothere")
+	dieMaybe(t, err)
+	if resp.StatusCode != http.StatusForbidden || resp2.StatusCode != http.StatusNotFound {
+		t.Fatal("rpc error codes errored", resp.StatusCode, resp2.StatusCode)
+	}
+
 	// ~~~~~~~~~~~~~~~~~
 	fmt.Println("\r\n~~~~~~~~~~ test post file")
 	path = "%2F%E1%84%92%E1%85%A1%20%E1%84%92%E1%85%A1" // "하 하" encoded
@@ -232,6 +647,138 @@ func doTestRegular(t *testing.T, url string, testExtra bool) {
 		t.Fatal("upload in new folder errored")
 	}
 
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test cp rpc")
+	body0 = postJSON(t, url+"rpc", `{"call":"cp","args":["/hols/AAA", "/hols/AAA-copy"]}`)
+	body1 = get(t, url+"hols/AAA-copy/abcdef")
+	body2 = postJSON(t, url+"rpc", `{"call":"rm","args":["/hols/AAA-copy"]}`)
+	if body0 != `ok` || body1 != payload || body2 != `ok` {
+		t.Fatal("cp rpc errored")
+	}
+
+	body0 = postJSON(t, url+"rpc", `{"call":"cp","args":["/hols/AAA", "/hols/AAA/inception"]}`)
+	body1 = postJSON(t, url+"rpc", `{"call":"cp","args":["/hols/AAA", "/../AAA"]}`)
+	if body0 != `{"error":"bad_request","message":"cant copy a folder into itself"}` || body1 != errForbidden {
+		t.Fatal("invalid cp rpc didnt errored")
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test batch rpc")
+	body0 = postJSON(t, url+"rpc", `[{"call":"mkdirp","args":["/hols/AAA/b1"]}, {"call":"mkdirp","args":["/../b2"]}, {"call":"mv","args":["/hols/AAA/b1", "/hols/AAA/b3"]}]`)
+	body1 = postJSON(t, url+"rpc?stopOnError=true", `[{"call":"rm","args":["/hols/AAA/b3"]}, {"call":"rm","args":["/../b2"]}, {"call":"rm","args":["/hols/AAA/abcdef"]}]`)
+	body2 = get(t, url+"hols/AAA/abcdef")
+	if body0 != `[{"ok":true,"result":"ok"},{"ok":false,"error":"invalid path","code":"forbidden"},{"ok":true,"result":"ok"}]` ||
+		body1 != `[{"ok":true,"result":"ok"},{"ok":false,"error":"invalid path","code":"forbidden"}]` || body2 != payload {
+		t.Fatal("batch rpc errored")
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test touch rpc")
+	body0 = postJSON(t, url+"rpc", `{"call":"touch","args":["/hols/AAA/touched"]}`)
+	body1 = postJSON(t, url+"rpc", `{"call":"touch","args":["/hols/AAA/abcdef"]}`)
+	body2 = get(t, url+"hols/AAA/")
+	if body0 != `ok` || body1 != `ok` || !strings.Contains(body2, `href="touched">touched</a>`) || get(t, url+"hols/AAA/abcdef") != payload {
+		t.Fatal("touch rpc errored")
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test chmod rpc")
+	body0 = postJSON(t, url+"rpc", `{"call":"chmod","args":["/hols/AAA/touched", "750"]}`)
+	body1 = postJSON(t, url+"rpc", `{"call":"chmod","args":["/hols/AAA/touched", "4755"]}`)
+	body2 = postJSON(t, url+"rpc", `{"call":"chmod","args":["/hols/AAA/touched", "rwx"]}`)
+	if body0 != `ok` || body1 != `{"error":"bad_request","message":"invalid mode 4755"}` || body2 != `{"error":"bad_request","message":"invalid mode rwx"}` {
+		t.Fatal("chmod rpc errored")
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test stat rpc")
+	body0 = postJSON(t, url+"rpc", `{"call":"stat","args":["/hols/AAA/touched"]}`)
+	body1 = postJSON(t, url+"rpc", `{"call":"stat","args":["/hols/glasgow.jpg"]}`)
+	if !strings.Contains(body0, `"name":"touched","size":0,"mode":"-rwxr-x---","perm":"0750"`) || !strings.Contains(body1, `"mime":"image/jpeg"`) {
+		t.Fatal("stat rpc errored")
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test du rpc & endpoint")
+	body0 = get(t, url+"hols/?du")
+	body1 = postJSON(t, url+"rpc", `{"call":"du","args":["/hols"]}`)
+	if !strings.Contains(body0, `{"name":"AAA","isDir":true,"size":4,"files":2,"human":"4.0B"}`) || body0 != body1 {
+		t.Fatal("du errored")
+	}
+	postDummyFile(t, url, "%2Fhols%2FAAA%2Fdu", "12345")
+	body0 = get(t, url+"hols/?du")
+	if !strings.Contains(body0, `{"name":"AAA","isDir":true,"size":9,"files":3,"human":"9.0B"}`) {
+		t.Fatal("du cache not invalidated on write")
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test extract rpc")
+	payload = makeZip(t, map[string]string{"x/hello.txt": "hello"})
+	body0 = postDummyFile(t, url, "%2Fhols%2FAAA%2Fa.zip", payload)
+	body1 = postJSON(t, url+"rpc", `{"call":"extract","args":["/hols/AAA/a.zip", "/hols/AAA/unzipped"]}`)
+	body2 = get(t, url+"hols/AAA/unzipped/x/hello.txt")
+	if body0 != `ok` || body1 != `ok` || body2 != `hello` {
+		t.Fatal("extract rpc errored")
+	}
+
+	body0 = postJSON(t, url+"rpc", `{"call":"extractProgress","args":["/hols/AAA/a.zip"]}`)
+	if body0 != `{"bytes":5,"done":true,"entries":1}` {
+		t.Fatal("extract progress errored")
+	}
+
+	payload = makeZip(t, map[string]string{"../../evil.txt": "evil"})
+	body0 = postDummyFile(t, url, "%2Fhols%2FAAA%2Fevil.zip", payload)
+	body1 = postJSON(t, url+"rpc", `{"call":"extract","args":["/hols/AAA/evil.zip", "/hols/AAA/unzipped"]}`)
+	if body0 != `ok` || body1 != `{"error":"forbidden","message":"invalid path in archive: ../../evil.txt"}` {
+		t.Fatal("extract rpc zip-slip didnt errored", body1)
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test browse archives")
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	zf, err := zw.CreateHeader(&zip.FileHeader{Name: "docs/stored.txt", Method: zip.Store})
+	dieMaybe(t, err)
+	zf.Write([]byte("stored in the zip"))
+	zf, err = zw.Create("docs/deep/deflated.txt")
+	dieMaybe(t, err)
+	zf.Write([]byte(strings.Repeat("deflated ", 100)))
+	dieMaybe(t, zw.Close())
+	postDummyFile(t, url, "%2Fhols%2FAAA%2Fbrowse.zip", buf.String())
+	body0 = get(t, url+"hols/AAA/browse.zip/")
+	body1 = get(t, url+"hols/AAA/browse.zip/docs?format=json") // redirected to docs/
+	ranged, body2 := davDo(t, http.MethodGet, url+"hols/AAA/browse.zip/docs/stored.txt", "", "Range", "bytes=10-")
+	deflated := string(getRaw(t, url+"hols/AAA/browse.zip/docs/deep/deflated.txt"))
+	missing, _ := davDo(t, http.MethodGet, url+"hols/AAA/browse.zip/docs/nope.txt", "")
+	if !strings.Contains(body0, `href="docs">docs/</a>`) || !strings.Contains(body1, `"name":"deep","href":"/`) || !strings.Contains(body1, `"name":"stored.txt"`) ||
+		ranged != 206 || body2 != "the zip" || deflated != strings.Repeat("deflated ", 100) || missing != 404 {
+		t.Fatal("browse zip errored", body0, body1, ranged, body2, missing)
+	}
+
+	tarball := new(bytes.Buffer)
+	tw := tar.NewWriter(tarball)
+	for _, el := range [][2]string{{"readme.txt", "hi from the tarball"}, {"sub/a.txt", "aaa"}, {"sub/b.txt", strings.Repeat("b", 2000)}} {
+		dieMaybe(t, tw.WriteHeader(&tar.Header{Name: el[0], Mode: 0644, Size: int64(len(el[1])), Typeflag: tar.TypeReg}))
+		tw.Write([]byte(el[1]))
+	}
+	dieMaybe(t, tw.Close())
+	buf = new(bytes.Buffer)
+	gz := gzip.NewWriter(buf)
+	gz.Write(tarball.Bytes())
+	dieMaybe(t, gz.Close())
+	postDummyFile(t, url, "%2Fhols%2FAAA%2Fbrowse.tar.gz", buf.String())
+	postDummyFile(t, url, "%2Fhols%2FAAA%2Fbrowse.tar", tarball.String())
+	body0 = get(t, url+"hols/AAA/browse.tar.gz/sub/")
+	body1 = get(t, url+"hols/AAA/browse.tar.gz/sub/a.txt")
+	body2 = get(t, url+"hols/AAA/browse.tar.gz/readme.txt")
+	ranged, tail := davDo(t, http.MethodGet, url+"hols/AAA/browse.tar/sub/b.txt", "", "Range", "bytes=1990-")
+	if !strings.Contains(body0, `href="b.txt">b.txt</a>`) || body1 != "aaa" || body2 != "hi from the tarball" || ranged != 206 || tail != strings.Repeat("b", 10) {
+		t.Fatal("browse tar errored", body1, body2, ranged, tail)
+	}
+	for _, el := range []string{"zip", "tar.gz", "tar"} {
+		postJSON(t, url+"rpc", `{"call":"rm","args":["/hols/AAA/browse.`+el+`"]}`)
+	}
+
 	// ~~~~~~~~~~~~~~~~~
 	fmt.Println("\r\n~~~~~~~~~~ test symlink, should succeed: ", testExtra)
 	body0 = get(t, url+"/support/")
@@ -289,93 +836,2053 @@ func doTestRegular(t *testing.T, url string, testExtra bool) {
 	}
 
 	// ~~~~~~~~~~~~~~~~~
-	fmt.Println("\r\n~~~~~~~~~~ test rm rpc & cleanup")
-	body0 = postJSON(t, url+"rpc", `{"call":"rm","args":["/hols/AAA"]}`)
-	if body0 != `ok` {
-		t.Fatal("cleanup errored #0")
+	fmt.Println("\r\n~~~~~~~~~~ test versions on overwrite")
+	if testExtra {
+		var list []struct {
+			ID string `json:"id"`
+		}
+		postDummyFile(t, url, "%2Fhols%2FAAA%2Fversioned", "v1")
+		postDummyFile(t, url, "%2Fhols%2FAAA%2Fversioned", "v2")
+		body0 = postJSON(t, url+"rpc", `{"call":"versionsList","args":["/hols/AAA/versioned"]}`)
+		dieMaybe(t, json.Unmarshal([]byte(body0), &list))
+		if body0 == `[]` || get(t, url+"hols/AAA/versioned") != "v2" {
+			t.Fatal("versions list errored", body0)
+		}
+
+		body0 = postJSON(t, url+"rpc", `{"call":"versionRestore","args":["/hols/AAA/versioned", "`+list[0].ID+`"]}`)
+		body1 = postJSON(t, url+"rpc", `{"call":"versionsList","args":["/hols/AAA/versioned"]}`)
+		if body0 != `ok` || get(t, url+"hols/AAA/versioned") != "v1" || strings.Contains(body1, list[0].ID) {
+			t.Fatal("version restore errored", body0)
+		}
+
+		body0 = get(t, url+".versions/hols/AAA/versioned/")
+		if !strings.Contains(body0, `error`) {
+			t.Fatal("versions folder should not be served")
+		}
 	}
 
-	body0 = get(t, url+"hols/AAA")
-	if !strings.Contains(body0, `error`) {
-		t.Fatal("cleanup errored #1")
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test mv doesnt clobber unless asked")
+	postDummyFile(t, url, "%2Fhols%2FAAA%2Fclobber1", "one")
+	postDummyFile(t, url, "%2Fhols%2FAAA%2Fclobber2", "two")
+	resp, err = http.Post(url+"rpc", "application/json", strings.NewReader(`{"call":"mv","args":["/hols/AAA/clobber1", "/hols/AAA/clobber2"]}`))
+	dieMaybe(t, err)
+	if resp.StatusCode != http.StatusConflict || get(t, url+"hols/AAA/clobber2") != "two" {
+		t.Fatal("mv should not overwrite", resp.StatusCode)
+	}
+
+	body0 = postJSON(t, url+"rpc", `{"call":"mv","args":["/hols/AAA/clobber1", "/hols/AAA/clobber2", "overwrite"]}`)
+	body1 = postJSON(t, url+"rpc", `{"call":"rm","args":["/hols/AAA/clobber2"]}`)
+	if body0 != `ok` || body1 != `ok` || !strings.Contains(get(t, url+"hols/AAA/clobber1"), `error`) {
+		t.Fatal("mv overwrite errored", body0)
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test ln rpc")
+	body0 = postJSON(t, url+"rpc", `{"call":"ln","args":["/hols/AAA/abcdef", "/hols/AAA/link"]}`)
+	body1 = get(t, url+"hols/AAA/link")
+	if body0 != `ok` || body1 != payload {
+		t.Fatal("ln rpc errored", body0)
+	}
+
+	body0 = postJSON(t, url+"rpc", `{"call":"ln","args":["/support", "/hols/AAA/escape"]}`)
+	body1 = postJSON(t, url+"rpc", `{"call":"ln","args":["/hols/../../", "/hols/AAA/escape"]}`)
+	if body0 == `ok` || body1 == `ok` {
+		t.Fatal("ln rpc should refuse targets out of root")
+	}
+
+	body0 = postJSON(t, url+"rpc", `{"call":"rm","args":["/hols/AAA/link"]}`)
+	if body0 != `ok` || get(t, url+"hols/AAA/abcdef") != payload {
+		t.Fatal("rm of link errored", body0)
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test text file api")
+	code, etag := putText(t, url+"api/file?path=%2Fhols%2FAAA%2Fnotes.txt", "hello", "If-None-Match", "*")
+	if code != 200 || etag == "" || get(t, url+"api/file?path=%2Fhols%2FAAA%2Fnotes.txt") != "hello" {
+		t.Fatal("file api create errored", code)
+	}
+
+	code, _ = putText(t, url+"api/file?path=%2Fhols%2FAAA%2Fnotes.txt", "again", "If-None-Match", "*")
+	if code != http.StatusPreconditionFailed {
+		t.Fatal("file api should not recreate existing file", code)
+	}
+
+	code, _ = putText(t, url+"api/file?path=%2Fhols%2FAAA%2Fnotes.txt", "edited", "If-Match", etag)
+	code2, _ := putText(t, url+"api/file?path=%2Fhols%2FAAA%2Fnotes.txt", "stale", "If-Match", etag)
+	if code != 200 || code2 != http.StatusPreconditionFailed || get(t, url+"api/file?path=%2Fhols%2FAAA%2Fnotes.txt") != "edited" {
+		t.Fatal("file api edit errored", code, code2)
 	}
 
-	body0 = postJSON(t, url+"rpc", `{"call":"rm","args":["/하 하"]}`)
+	code, _ = putText(t, url+"api/file?path=%2Fhols%2FAAA%2Fnotes.txt", strings.Repeat("a", 2<<20), "", "")
+	if code != http.StatusRequestEntityTooLarge || getHeader(t, url+"api/file?path=%2Fhols%2FAAA%2Fnotes.txt", "ETag") == "" {
+		t.Fatal("file api should cap sizes", code)
+	}
+
+	body0 = postJSON(t, url+"rpc", `{"call":"rm","args":["/hols/AAA/notes.txt"]}`)
 	if body0 != `ok` {
-		t.Fatal("cleanup errored #2")
+		t.Fatal("file api cleanup errored", body0)
 	}
 
-	fmt.Printf("\r\n=========\r\n")
-}
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test webhooks")
+	if testExtra {
+		hooks := make(chan string, 64)
+		hookServer := &http.Server{Addr: "127.0.0.1:8002", Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			mac := hmac.New(sha256.New, []byte("s3cret"))
+			mac.Write(body)
+			if r.Header.Get("X-Gossa-Signature") == "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+				hooks <- string(body)
+			}
+		})}
+		go hookServer.ListenAndServe()
+		defer hookServer.Close()
 
-func doTestReadonly(t *testing.T, url string) {
-	var payload, path, body0, body1 string
+		postJSON(t, url+"rpc", `{"call":"mkdirp","args":["/hols/AAA/hooked"]}`)
+		found := false
+		for !found {
+			select {
+			case body0 = <-hooks:
+				found = strings.Contains(body0, `"event":"mkdir","path":"/hols/AAA/hooked"`)
+			case <-time.After(2 * time.Second):
+				t.Fatal("webhook not received")
+			}
+		}
+		postJSON(t, url+"rpc", `{"call":"rm","args":["/hols/AAA/hooked"]}`)
+	}
 
 	// ~~~~~~~~~~~~~~~~~
-	fmt.Println("\r\n~~~~~~~~~~ test fetching default path")
-	fetchAndTestDefault(t, url)
+	fmt.Println("\r\n~~~~~~~~~~ test find rpc")
+	body0 = postJSON(t, url+"rpc", `{"call":"find","args":["/", "GLASGOW"]}`)
+	body1 = postJSON(t, url+"rpc", `{"call":"find","args":["/hols", "*.JPG", "2"]}`)
+	body2 = postJSON(t, url+"rpc", `{"call":"find","args":["/", "[x"]}`)
+	if body0 != `{"results":[{"path":"/hols/glasgow.jpg","isDir":false,"size":`+strconv.Itoa(len(getRaw(t, url+"hols/glasgow.jpg")))+`}],"truncated":false}` ||
+		strings.Count(body1, `.jpg"`) != 2 || !strings.Contains(body1, `"truncated":true`) || !strings.Contains(body2, `bad_request`) {
+		t.Fatal("find rpc errored", body0, body1, body2)
+	}
 
 	// ~~~~~~~~~~~~~~~~~
-	fmt.Println("\r\n~~~~~~~~~~ test fetching an invalid path - redirected to root")
-	fetchAndTestDefault(t, url+"../../")
-	fetchAndTestDefault(t, url+"hols/../../")
+	fmt.Println("\r\n~~~~~~~~~~ test full text search")
+	if testExtra {
+		searchFor := func(q string, want bool) string {
+			for i := 0; i < 30; i++ {
+				body := get(t, url+"search?q="+q)
+				if strings.Contains(body, `"path":"/hols/AAA/notes-index.txt"`) == want {
+					return body
+				}
+				time.Sleep(100 * time.Millisecond)
+			}
+			t.Fatal("search didnt catch up", q, want)
+			return ""
+		}
+		postDummyFile(t, url, "%2Fhols%2FAAA%2Fnotes-index.txt", "the quokka met a Zebra, then another zebra")
+		body0 = searchFor("ZEBRA+quokka", true)
+		body1 = get(t, url+"search?q=quokka+penguin")
+		if !strings.Contains(body0, `"ready":true`) || strings.Contains(body1, `notes-index`) {
+			t.Fatal("search errored", body0, body1)
+		}
+
+		postJSON(t, url+"rpc", `{"call":"rm","args":["/hols/AAA/notes-index.txt"]}`)
+		searchFor("quokka", false)
+	}
 
 	// ~~~~~~~~~~~~~~~~~
-	fmt.Println("\r\n~~~~~~~~~~ test fetching regular files")
-	body0 = get(t, url+"subdir_with%20space/file_with%20space.html")
-	body1 = get(t, url+"fancy-path/a")
-	if body0 != `<b>spacious!!</b> ` || body1 != `fancy! ` {
-		t.Fatal("fetching a regular file errored")
+	fmt.Println("\r\n~~~~~~~~~~ test dupes rpc")
+	postDummyFile(t, url, "%2Fhols%2FAAA%2Fdupe1", "same same")
+	postDummyFile(t, url, "%2Fhols%2FAAA%2Fdupe2", "same same")
+	postDummyFile(t, url, "%2Fhols%2FAAA%2Fnotdupe", "same sane")
+	body0 = postJSON(t, url+"rpc", `{"call":"dupes","args":["/hols/AAA"]}`)
+	if !strings.HasPrefix(body0, `{"groups":[{"size":9,"sum":"`) || !strings.HasSuffix(body0, `","paths":["/hols/AAA/dupe1","/hols/AAA/dupe2"]}],"wasted":9}`) {
+		t.Fatal("dupes rpc errored", body0)
 	}
+	body0 = postJSON(t, url+"rpc", `[{"call":"rm","args":["/hols/AAA/dupe1"]}, {"call":"rm","args":["/hols/AAA/dupe2"]}, {"call":"rm","args":["/hols/AAA/notdupe"]}]`)
 
 	// ~~~~~~~~~~~~~~~~~
-	fmt.Println("\r\n~~~~~~~~~~ test fetching a invalid file")
-	path = "../../../../../../../../../../etc/passwd"
-	if get(t, url+path) != `error` {
-		t.Fatal("fetching a invalid file didnt errored")
+	fmt.Println("\r\n~~~~~~~~~~ test tree api")
+	postJSON(t, url+"rpc", `{"call":"mkdirp","args":["/hols/AAA/t1/t2/t3"]}`)
+	body0 = get(t, url+"api/tree?path=%2Fhols%2FAAA%2Ft1&depth=2")
+	body1 = get(t, url+"api/tree?path=%2Fhols%2FAAA%2Ft1%2Ft2")
+	body2 = get(t, url+"api/tree?path=%2Fhols%2Fglasgow.jpg")
+	if body0 != `{"name":"t1","path":"/hols/AAA/t1","hasChildren":true,"children":[{"name":"t2","path":"/hols/AAA/t1/t2","hasChildren":true,"children":[{"name":"t3","path":"/hols/AAA/t1/t2/t3","hasChildren":false}]}]}` ||
+		body1 != `{"name":"t2","path":"/hols/AAA/t1/t2","hasChildren":true,"children":[{"name":"t3","path":"/hols/AAA/t1/t2/t3","hasChildren":false}]}` ||
+		!strings.Contains(body2, `bad_request`) {
+		t.Fatal("tree api errored", body0, body1, body2)
 	}
+	postJSON(t, url+"rpc", `{"call":"rm","args":["/hols/AAA/t1"]}`)
 
 	// ~~~~~~~~~~~~~~~~~
-	fmt.Println("\r\n~~~~~~~~~~ test mkdir rpc")
-	body0 = postJSON(t, url+"rpc", `{"call":"mkdirp","args":["/AAA"]}`)
-	if body0 == `ok` {
-		t.Fatal("mkdir rpc passed - should not be allowed")
+	fmt.Println("\r\n~~~~~~~~~~ test walk api")
+	postJSON(t, url+"rpc", `{"call":"mkdirp","args":["/hols/AAA/w1/w2"]}`)
+	postDummyFile(t, url, "%2Fhols%2FAAA%2Fw1%2Fa.txt", "aa")
+	postDummyFile(t, url, "%2Fhols%2FAAA%2Fw1%2Fw2%2Fb.txt", "bbb")
+	postDummyFile(t, url, "%2Fhols%2FAAA%2Fw1%2Fw2%2Fc.md", "c")
+	body0 = get(t, url+"api/walk?path=%2Fhols%2FAAA%2Fw1")
+	body1 = get(t, url+"api/walk?path=%2Fhols%2FAAA%2Fw1&glob=*.txt&depth=1")
+	body2 = get(t, url+"api/v1/walk/hols/AAA/w1?glob=w2/*&limit=1")
+	if !regexp.MustCompile(`^{"entries":\[{"path":"/hols/AAA/w1/a.txt","size":2,"mtime":"[^"]+"},{"path":"/hols/AAA/w1/w2/b.txt","size":3,[^]]+"/hols/AAA/w1/w2/c.md","size":1,"mtime":"[^"]+"}\],"truncated":false}$`).MatchString(body0) ||
+		!regexp.MustCompile(`^{"entries":\[{"path":"/hols/AAA/w1/a.txt"[^]]+\],"truncated":false}$`).MatchString(body1) ||
+		!regexp.MustCompile(`^{"entries":\[{"path":"/hols/AAA/w1/w2/b.txt"[^]]+\],"truncated":true}$`).MatchString(body2) {
+		t.Fatal("walk api errored", body0, body1, body2)
 	}
+	postJSON(t, url+"rpc", `{"call":"rm","args":["/hols/AAA/w1"]}`)
 
 	// ~~~~~~~~~~~~~~~~~
-	fmt.Println("\r\n~~~~~~~~~~ test post file")
-	path = "%2F%E1%84%92%E1%85%A1%20%E1%84%92%E1%85%A1" // "하 하" encoded
-	payload = "123 하"
-	body0 = postDummyFile(t, url, path, payload)
-	get(t, url+path)
-	if body0 == `ok` {
-		t.Fatal("post file passed - should not be allowed")
+	fmt.Println("\r\n~~~~~~~~~~ test disk free")
+	var df struct{ Free, Total uint64 }
+	body0 = get(t, url+"api/df?path=%2Fhols")
+	dieMaybe(t, json.Unmarshal([]byte(body0), &df))
+	body1 = get(t, url)
+	if df.Total == 0 || df.Free > df.Total || !strings.Contains(body1, "free of") || !strings.Contains(body1, "window.diskFree = ") {
+		t.Fatal("disk free errored", body0)
 	}
 
 	// ~~~~~~~~~~~~~~~~~
-	fmt.Println("\r\n~~~~~~~~~~ test mv rpc")
-	body0 = postJSON(t, url+"rpc", `{"call":"mv","args":["/AAA", "/hols/AAA"]}`)
-	fetchAndTestDefault(t, url)
-	if body0 == `ok` {
-		t.Fatal("mv rpc passed - should not be allowed")
+	fmt.Println("\r\n~~~~~~~~~~ test json listing")
+	var list struct {
+		Path    string
+		Entries []struct{ Name, Href, Type string }
+	}
+	body0 = get(t, url+"subdir_with%20space/?format=json")
+	dieMaybe(t, json.Unmarshal([]byte(body0), &list))
+	req, err = http.NewRequest("GET", url+"hols/", nil)
+	dieMaybe(t, err)
+	req.Header.Set("Accept", "application/json")
+	resp, err = http.DefaultClient.Do(req)
+	dieMaybe(t, err)
+	b, err := io.ReadAll(resp.Body)
+	dieMaybe(t, err)
+	body1 = string(b)
+	if list.Path != "/subdir_with space/" || len(list.Entries) != 1 || list.Entries[0].Name != "file_with space.html" || list.Entries[0].Type != "file" ||
+		!strings.HasSuffix(list.Entries[0].Href, "/subdir_with%20space/file_with%20space.html") ||
+		resp.Header.Get("Content-Type") != "application/json" || !strings.Contains(body1, `"name":"AAA","href":"`) {
+		t.Fatal("json listing errored", body0, body1)
 	}
 
 	// ~~~~~~~~~~~~~~~~~
-	fmt.Println("\r\n~~~~~~~~~~ test rm rpc & cleanup")
-	body0 = postJSON(t, url+"rpc", `{"call":"rm","args":["/hols/AAA"]}`)
-	if body0 == `ok` {
-		t.Fatal("cleanup passed - should not be allowed")
+	fmt.Println("\r\n~~~~~~~~~~ test api v1")
+	var spec struct {
+		Servers []struct{ URL string }
+		Paths   map[string]any
+	}
+	dieMaybe(t, json.Unmarshal(getRaw(t, url+"api/v1/openapi.json"), &spec))
+	if len(spec.Servers) != 1 || !strings.HasSuffix(spec.Servers[0].URL, "/api/v1") || spec.Paths["/files/{path}"] == nil {
+		t.Fatal("openapi spec errored", spec)
 	}
 
-	fmt.Printf("\r\n=========\r\n")
-}
+	code, _ = putText(t, url+"api/v1/files/hols/AAA/api.txt", "from api", "", "")
+	code2, _ = putText(t, url+"api/v1/files/hols/AAA/api.txt", "again", "", "")
+	body0 = get(t, url+"api/v1/files/hols/AAA/api.txt")
+	body1 = get(t, url+"api/v1/list/hols/AAA/")
+	if code != http.StatusCreated || code2 != http.StatusConflict || body0 != "from api" || !strings.Contains(body1, `"name":"api.txt","href":"`) {
+		t.Fatal("api v1 files errored", code, code2, body0, body1)
+	}
 
-func TestNormal(t *testing.T) {
-	fmt.Println("========== testing normal path ============")
-	doTestRegular(t, "http://127.0.0.1:8001/", false)
-}
+	code, _ = putText(t, url+"api/v1/files/hols/AAA/api.txt?overwrite=true", "replaced", "", "")
+	body0 = get(t, url+"api/v1/files/hols/AAA/api.txt")
+	body1 = postJSON(t, url+"api/v1/rpc", `{"call":"stat","args":["/hols/AAA/api.txt"]}`)
+	body2 = get(t, url+"api/v1/nope/")
+	if code != http.StatusCreated || body0 != "replaced" || !strings.Contains(body1, `"size":8`) || !strings.Contains(body2, `not_found`) {
+		t.Fatal("api v1 overwrite errored", code, body0, body1, body2)
+	}
 
-func TestExtra(t *testing.T) {
+	req, err = http.NewRequest(http.MethodDelete, url+"api/v1/files/hols/AAA/api.txt", nil)
+	dieMaybe(t, err)
+	resp, err = http.DefaultClient.Do(req)
+	dieMaybe(t, err)
+	if resp.StatusCode != 200 || !strings.Contains(get(t, url+"api/v1/files/hols/AAA/api.txt"), `not_found`) {
+		t.Fatal("api v1 delete errored", resp.StatusCode)
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test listing sort")
+	body0 = get(t, url+"hols/?sort=size&order=desc")
+	body1 = get(t, url+"hols/?format=json&sort=size")
+	body2 = get(t, url+"hols/?sort=color")
+	i0, i1, i2, i3 := strings.Index(body0, ">scotland"), strings.Index(body0, ">glasgow"), strings.Index(body0, ">elephant"), strings.Index(body0, ">c.js")
+	if i0 < 0 || i0 > i1 || i1 > i2 || i2 > i3 || strings.Index(body1, `"c.js"`) > strings.Index(body1, `"elephant`) || !strings.Contains(body2, `bad_request`) {
+		t.Fatal("listing sort errored", body2)
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test natural sort")
+	postJSON(t, url+"rpc", `[{"call":"mkdirp","args":["/hols/AAA/nat"]}, {"call":"touch","args":["/hols/AAA/nat/IMG_10.jpg"]}, {"call":"touch","args":["/hols/AAA/nat/img_9.jpg"]}, {"call":"touch","args":["/hols/AAA/nat/IMG_009b.jpg"]}]`)
+	body0 = get(t, url+"hols/AAA/nat/?sort=natural&format=json")
+	body1 = get(t, url+"hols/AAA/nat/?format=json")
+	if !regexp.MustCompile(`img_9.jpg.*IMG_009b.jpg.*IMG_10.jpg`).MatchString(body0) || !regexp.MustCompile(`IMG_009b.jpg.*IMG_10.jpg.*img_9.jpg`).MatchString(body1) {
+		t.Fatal("natural sort errored", body0, body1)
+	}
+	postJSON(t, url+"rpc", `{"call":"rm","args":["/hols/AAA/nat"]}`)
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test listing pagination")
+	type page struct {
+		Total   int `json:"total"`
+		Offset  int `json:"offset"`
+		Entries []struct {
+			Name string `json:"name"`
+		} `json:"entries"`
+	}
+	var all, some page
+	dieMaybe(t, json.Unmarshal(getRaw(t, url+"hols/?format=json"), &all))
+	dieMaybe(t, json.Unmarshal(getRaw(t, url+"hols/?format=json&limit=2&offset=1"), &some))
+	body0 = get(t, url+"hols/?limit=1&offset=1")
+	if all.Total != len(all.Entries) || some.Total != all.Total || some.Offset != 1 || len(some.Entries) != 2 ||
+		some.Entries[0].Name != all.Entries[1].Name || some.Entries[1].Name != all.Entries[2].Name ||
+		!strings.Contains(body0, `<a href="?limit=1&amp;offset=0">&larr; previous</a> <code>`+fmt.Sprint(all.Total)+` entries</code> <a href="?limit=1&amp;offset=2">next &rarr;</a>`) {
+		t.Fatal("listing pagination errored", all, some, body0)
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test listing mtime and perms")
+	body0 = get(t, url)
+	body1 = get(t, url+"?format=json")
+	mtime := regexp.MustCompile(`<td class="file-mtime"><code>\d{4}-\d\d-\d\d \d\d:\d\d</code></td>`)
+	if !mtime.MatchString(body0) || !regexp.MustCompile(`"modified":"\d{4}-\d\d-\d\d \d\d:\d\d"`).MatchString(body1) {
+		t.Fatal("listing mtime errored", body0, body1)
+	}
+	perms := regexp.MustCompile(`<td class="file-perms"><code>-[-rwx]{9} \S+:\S+</code></td>`)
+	if perms.MatchString(body0) != testExtra || strings.Contains(body1, `"mode":`) != testExtra {
+		t.Fatal("listing perms errored", body0, body1)
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test listing filter")
+	body0 = get(t, url+"hols/?filter=*.JPG&format=json")
+	body1 = get(t, url+"hols/?filter=g*")
+	body2 = get(t, url+"hols/?filter=[")
+	if !regexp.MustCompile(`^{"path":"/hols/","total":3,"offset":0,"entries":\[{"name":"elephant-1822636_1920.jpg".*"glasgow.jpg".*"scotland-1761292_1920.jpg"[^{]*}\]}$`).MatchString(body0) ||
+		!strings.Contains(body1, `>glasgow.jpg</a>`) || strings.Contains(body1, `>c.js</a>`) || !strings.Contains(body2, `bad_request`) {
+		t.Fatal("listing filter errored", body0, body1, body2)
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test atom feed")
+	body0 = get(t, url+"hols/?feed=atom")
+	body1 = get(t, url+"hols/?feed=rss")
+	if !strings.HasPrefix(body0, `<?xml version="1.0" encoding="UTF-8"?> <feed xmlns="http://www.w3.org/2005/Atom"><title>/hols/</title><id>`+url+`hols/</id>`) ||
+		!strings.Contains(body0, `<entry><title>glasgow.jpg</title><id>`+url+`hols/glasgow.jpg</id>`) ||
+		!strings.Contains(body0, `<link href="`+url+`hols/glasgow.jpg" rel="enclosure" type="image/jpeg" length="`) ||
+		!strings.Contains(body1, `bad_request`) {
+		t.Fatal("atom feed errored", body0, body1)
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test webdav")
+	if testExtra {
+		code, body := davDo(t, "PROPFIND", url+"dav/hols/", "", "Depth", "1")
+		if code != 207 || !strings.Contains(body, `<D:href>/fancy-path/dav/hols/glasgow.jpg</D:href>`) || strings.Contains(body, `.versions`) {
+			t.Fatal("webdav propfind errored", code, body)
+		}
+		code0, _ := davDo(t, "MKCOL", url+"dav/hols/AAA/dav", "")
+		code1, _ := davDo(t, http.MethodPut, url+"dav/hols/AAA/dav/a.txt", "over dav")
+		code2, _ := davDo(t, "COPY", url+"dav/hols/AAA/dav/a.txt", "", "Destination", url+"dav/hols/AAA/dav/b.txt")
+		code3, _ := davDo(t, "MOVE", url+"dav/hols/AAA/dav/b.txt", "", "Destination", url+"dav/hols/AAA/dav/c.txt")
+		code4, _ := davDo(t, "PROPFIND", url+"dav/.versions/", "", "Depth", "0")
+		if code0 != 201 || code1 != 201 || code2 != 201 || code3 != 201 || code4 != 404 ||
+			get(t, url+"hols/AAA/dav/c.txt") != "over dav" || !strings.Contains(get(t, url+"hols/AAA/dav/b.txt"), `not_found`) {
+			t.Fatal("webdav writes errored", code0, code1, code2, code3, code4)
+		}
+		code0, _ = davDo(t, http.MethodPut, url+"dav/hols/AAA/dav/undone.txt", "oops")
+		body0 = postJSON(t, url+"rpc", `{"call":"undo","args":[]}`)
+		if code0 != 201 || body0 != `ok` || !strings.Contains(get(t, url+"hols/AAA/dav/undone.txt"), `not_found`) {
+			t.Fatal("webdav undo upload errored", code0, body0)
+		}
+		code0, _ = davDo(t, http.MethodDelete, url+"dav/hols/AAA/dav", "")
+		if code0 != 204 || !strings.Contains(get(t, url+"hols/AAA/dav/a.txt"), `not_found`) {
+			t.Fatal("webdav delete errored", code0)
+		}
+	} else if code, _ := davDo(t, "PROPFIND", url+"dav/", "", "Depth", "0"); code == 207 {
+		t.Fatal("webdav served when disabled")
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test sftp")
+	if testExtra {
+		alice, bob := sftpDial(t, "alice"), sftpDial(t, "bob")
+		f, err := alice.Create("/hols/AAA/sftp.txt")
+		dieMaybe(t, err)
+		f.Write([]byte("over sftp, cut"))
+		dieMaybe(t, f.Close())
+		dieMaybe(t, alice.Truncate("/hols/AAA/sftp.txt", 9))
+		dieMaybe(t, bob.Rename("/AAA/sftp.txt", "/AAA/sftp2.txt"))
+		infos, err := bob.ReadDir("/")
+		dieMaybe(t, err)
+		names := []string{}
+		for _, el := range infos {
+			names = append(names, el.Name())
+		}
+		_, errEscape := bob.Stat("/../b.txt")
+		_, errInternal := alice.Stat("/.versions")
+		if get(t, url+"hols/AAA/sftp2.txt") != "over sftp" || !strings.Contains(strings.Join(names, ","), "AAA,c.js") || errEscape == nil || errInternal == nil {
+			t.Fatal("sftp errored", names, errEscape, errInternal)
+		}
+		dieMaybe(t, alice.Remove("/hols/AAA/sftp2.txt"))
+		if _, err := ssh.Dial("tcp", "127.0.0.1:2022", &ssh.ClientConfig{User: "alice", Auth: []ssh.AuthMethod{ssh.Password("nope")}, HostKeyCallback: ssh.InsecureIgnoreHostKey()}); err == nil {
+			t.Fatal("sftp accepted a wrong password")
+		}
+		alice.Close()
+		bob.Close()
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test ftp")
+	if testExtra {
+		c, err := textproto.Dial("tcp", "127.0.0.1:2121")
+		dieMaybe(t, err)
+		_, _, err = c.ReadResponse(220)
+		dieMaybe(t, err)
+		code0, _ := ftpDo(t, c, "PWD", "")
+		ftpDo(t, c, "USER bob", "")
+		code1, _ := ftpDo(t, c, "PASS nope", "")
+		ftpDo(t, c, "USER bob", "")
+		code2, _ := ftpDo(t, c, "PASS hunter2", "")
+		code3, _ := ftpDo(t, c, "CWD AAA", "")
+		code4, _ := ftpDo(t, c, "STOR ftp.txt", "over ftp")
+		code5, list := ftpDo(t, c, "LIST", "")
+		code6, file := ftpDo(t, c, "RETR /AAA/ftp.txt", "")
+		code7, _ := ftpDo(t, c, "CWD ../../..", "")
+		code8, pwd := ftpDo(t, c, "PWD", "")
+		code9, _ := ftpDo(t, c, "DELE /AAA/ftp.txt", "")
+		if code0 != 530 || code1 != 530 || code2 != 230 || code3 != 250 || code4 != 226 || code5 != 226 || code6 != 226 || code7 != 250 || code8 != 257 || code9 != 250 ||
+			!regexp.MustCompile(`-rw[-rwx]{7} 1 gossa gossa +8 \w{3} [ \d]\d \d\d:\d\d ftp.txt\r\n`).MatchString(list) || file != "over ftp" || pwd != `"/"` ||
+			!strings.Contains(get(t, url+"hols/AAA/ftp.txt"), "not_found") {
+			t.Fatal("ftp errored", code0, code1, code2, code3, code4, code5, code6, code7, code8, code9, list, file, pwd)
+		}
+
+		dieMaybe(t, c.PrintfLine("EPSV"))
+		_, msg, err := c.ReadResponse(229)
+		dieMaybe(t, err)
+		port := strings.Trim(msg[strings.Index(msg, "(|||"):], "(|)")
+		stranger, err := (&net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP("127.0.0.2")}}).Dial("tcp", "127.0.0.1:"+port)
+		dieMaybe(t, err)
+		dieMaybe(t, c.PrintfLine("NLST"))
+		data, err := net.Dial("tcp", "127.0.0.1:"+port)
+		dieMaybe(t, err)
+		code0, _, _ = c.ReadResponse(150)
+		stolen, _ := io.ReadAll(stranger)
+		listed, _ := io.ReadAll(data)
+		code1, _, _ = c.ReadResponse(226)
+
+		dieMaybe(t, c.PrintfLine("EPSV"))
+		_, msg, err = c.ReadResponse(229)
+		dieMaybe(t, err)
+		data, err = net.Dial("tcp", "127.0.0.1:"+strings.Trim(msg[strings.Index(msg, "(|||"):], "(|)"))
+		dieMaybe(t, err)
+		dieMaybe(t, c.PrintfLine("STOR cut.txt"))
+		code2, _, _ = c.ReadResponse(150)
+		data.Write([]byte("half"))
+		data.(*net.TCPConn).SetLinger(0) // reset, as a dropped connection
+		data.Close()
+		code3, _, _ = c.ReadResponse(0)
+
+		long, err := textproto.Dial("tcp", "127.0.0.1:2121")
+		dieMaybe(t, err)
+		long.ReadResponse(220)
+		long.W.WriteString(strings.Repeat("A", 2*ftpMaxLine))
+		long.W.Flush()
+		code4, _, _ = long.ReadResponse(0)
+		long.Close()
+		if code0 != 150 || len(stolen) != 0 || !strings.Contains(string(listed), "AAA\r\n") || code1 != 226 || code2 != 150 || code3 != 426 ||
+			!strings.Contains(get(t, url+"hols/cut.txt"), "not_found") || code4 != 500 {
+			t.Fatal("ftp hardening errored", code0, string(stolen), string(listed), code1, code2, code3, code4)
+		}
+		c.Close()
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test s3 gateway")
+	if testExtra {
+		s3 := "http://127.0.0.1:9002/gossa/"
+		unsigned := get(t, s3+"hols/glasgow.jpg")
+		code0, _ := s3Do(t, http.MethodPut, s3+"hols/AAA/s3/a.txt", "over s3")
+		code1, _ := s3Do(t, http.MethodPut, s3+"hols/AAA/s3/deep/b.txt", "hello", "X-Amz-Content-Sha256", "STREAMING-AWS4-HMAC-SHA256-PAYLOAD")
+		code2, list := s3Do(t, http.MethodGet, s3+"?list-type=2&prefix=hols/AAA/s3/", "")
+		code3, dirs := s3Do(t, http.MethodGet, s3+"?list-type=2&prefix=hols/AAA/s3/&delimiter=/", "")
+		code4, part := s3Do(t, http.MethodGet, s3+"hols/AAA/s3/a.txt", "", "Range", "bytes=5-")
+		if !strings.Contains(unsigned, `<Code>SignatureDoesNotMatch</Code>`) || code0 != 200 || code1 != 200 || code2 != 200 || code3 != 200 || code4 != 206 ||
+			get(t, url+"hols/AAA/s3/a.txt") != "over s3" || get(t, url+"hols/AAA/s3/deep/b.txt") != "hello" || part != "s3" ||
+			!regexp.MustCompile(`<KeyCount>2</KeyCount>.*<Contents><Key>hols/AAA/s3/a.txt</Key>.*<Size>7</Size>.*<Contents><Key>hols/AAA/s3/deep/b.txt</Key>`).MatchString(list) ||
+			!regexp.MustCompile(`<Contents><Key>hols/AAA/s3/a.txt</Key>.*</Contents><CommonPrefixes><Prefix>hols/AAA/s3/deep/</Prefix></CommonPrefixes>`).MatchString(dirs) {
+			t.Fatal("s3 errored", unsigned, code0, code1, code2, code3, code4, list, dirs, part)
+		}
+
+		good := sha256.Sum256([]byte("good"))
+		code0, _ = s3Do(t, http.MethodPut, s3+"hols/AAA/s3/hashed.txt", "good", "X-Amz-Content-Sha256", hex.EncodeToString(good[:]))
+		code1, tampered := s3Do(t, http.MethodPut, s3+"hols/AAA/s3/tampered.txt", "evil", "X-Amz-Content-Sha256", hex.EncodeToString(good[:]))
+		code2, stale := s3Do(t, http.MethodGet, s3+"hols/AAA/s3/hashed.txt", "", "X-Amz-Date", time.Now().UTC().Add(-time.Hour).Format("20060102T150405Z"))
+		forged := &s3Chunked{r: bufio.NewReader(strings.NewReader("5;chunk-signature=x\r\nhello\r\n0;chunk-signature=y\r\n\r\n")), sign: &s3ChunkSign{[]byte("k"), "d", "s", "p"}}
+		_, errForged := io.ReadAll(forged)
+		if code0 != 200 || code1 != 400 || !strings.Contains(tampered, "<Code>XAmzContentSHA256Mismatch</Code>") || !strings.Contains(get(t, url+"hols/AAA/s3/tampered.txt"), "not_found") ||
+			code2 != 403 || !strings.Contains(stale, "<Code>RequestTimeTooSkewed</Code>") || !errors.Is(errForged, errS3Payload) {
+			t.Fatal("s3 payload and date checks errored", code0, code1, tampered, code2, stale, errForged)
+		}
+		postJSON(t, url+"rpc", `{"call":"rm","args":["/hols/AAA/s3/hashed.txt"]}`)
+
+		s3Do(t, http.MethodPut, s3+"hols/AAA/s3/p/a/", "")
+		s3Do(t, http.MethodPut, s3+"hols/AAA/s3/p/a-b/", "")
+		_, page0 := s3Do(t, http.MethodGet, s3+"?list-type=2&prefix=hols/AAA/s3/p/&delimiter=/&max-keys=1", "")
+		_, page1 := s3Do(t, http.MethodGet, s3+"?list-type=2&prefix=hols/AAA/s3/p/&delimiter=/&max-keys=1&continuation-token=hols/AAA/s3/p/a-b/", "")
+		if !strings.Contains(page0, `<Prefix>hols/AAA/s3/p/a-b/</Prefix>`) || !strings.Contains(page0, `<NextContinuationToken>hols/AAA/s3/p/a-b/</NextContinuationToken>`) ||
+			!strings.Contains(page1, `<Prefix>hols/AAA/s3/p/a/</Prefix>`) {
+			t.Fatal("s3 prefix paging errored", page0, page1)
+		}
+		postJSON(t, url+"rpc", `{"call":"rm","args":["/hols/AAA/s3/p"]}`)
+
+		_, body0 := s3Do(t, http.MethodPost, s3+"hols/AAA/s3/multi.txt?uploads", "")
+		upload := regexp.MustCompile(`<UploadId>(\w+)</UploadId>`).FindStringSubmatch(body0)
+		if len(upload) != 2 {
+			t.Fatal("s3 multipart init errored", body0)
+		}
+		s3Do(t, http.MethodPut, s3+"hols/AAA/s3/multi.txt?partNumber=2&uploadId="+upload[1], " world")
+		s3Do(t, http.MethodPut, s3+"hols/AAA/s3/multi.txt?partNumber=1&uploadId="+upload[1], "hello")
+		code0, body0 = s3Do(t, http.MethodPost, s3+"hols/AAA/s3/multi.txt?uploadId="+upload[1], `<CompleteMultipartUpload><Part><PartNumber>1</PartNumber></Part><Part><PartNumber>2</PartNumber></Part></CompleteMultipartUpload>`)
+		if code0 != 200 || get(t, url+"hols/AAA/s3/multi.txt") != "hello world" || !strings.Contains(body0, `-2&#34;</ETag>`) {
+			t.Fatal("s3 multipart errored", code0, body0)
+		}
+
+		code0, body0 = s3Do(t, http.MethodPost, s3+"?delete", `<Delete><Object><Key>hols/AAA/s3/a.txt</Key></Object><Object><Key>hols/AAA/s3/multi.txt</Key></Object><Object><Key>hols/AAA/s3/deep/b.txt</Key></Object></Delete>`)
+		code1, _ = s3Do(t, http.MethodDelete, s3+"hols/AAA/s3/deep/", "")
+		code2, _ = s3Do(t, http.MethodDelete, s3+"hols/AAA/s3", "")
+		_, list = s3Do(t, http.MethodGet, s3+"?prefix=hols/AAA/s3/", "")
+		if code0 != 200 || strings.Count(body0, "<Deleted>") != 3 || code1 != 204 || code2 != 204 || strings.Contains(list, "<Contents>") || !strings.Contains(list, "<Marker></Marker>") {
+			t.Fatal("s3 delete errored", code0, body0, code1, code2, list)
+		}
+		postJSON(t, url+"rpc", `{"call":"rm","args":["/hols/AAA/s3"]}`)
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test s3 backend")
+	if testExtra { // a gossa in this process, serving the bucket of the one tested
+		cfg := DefaultConfig()
+		cfg.S3Backend, cfg.S3BackendAccessKey, cfg.S3BackendSecretKey, cfg.Trash = "http://127.0.0.1:9002/gossa/hols/AAA/s3back", "gossa", "s3cret", false
+		bad := cfg
+		bad.Sort = "nope"
+		if _, err := New(bad); err == nil {
+			t.Fatal("new with a bad config errored")
+		}
+		free, err := net.Listen("tcp", "127.0.0.1:0")
+		dieMaybe(t, err)
+		free.Close()
+		late := cfg // fails on the ftp cert, once sftp is up
+		late.SFTPAddr, late.SFTPUsers, late.FTPAddr, late.FTPTLSCert, late.FTPTLSKey = free.Addr().String(), "support/sftp-users", "127.0.0.1:0", "missing.pem", "missing.pem"
+		_, errLate := New(late)
+		rebound, errRebound := net.Listen("tcp", free.Addr().String())
+		if errLate == nil || !strings.Contains(errLate.Error(), "missing.pem") || errRebound != nil {
+			t.Fatal("new failing late errored", errLate, errRebound)
+		}
+		rebound.Close()
+		h, err := New(cfg)
+		dieMaybe(t, err)
+		inProcess = h
+		srv := httptest.NewServer(h)
+		defer srv.Close()
+		back := srv.URL + "/"
+
+		big := strings.Repeat("0123456789abcdef", 600_000) // over a part, so uploaded multipart
+		postJSON(t, back+"rpc", `{"call":"mkdirp","args":["/deep"]}`)
+		up0 := postDummyFile(t, back, "%2Fdeep%2Fa.txt", "over the backend")
+		up1 := postDummyFile(t, back, "%2Fbig.bin", big)
+		listing := get(t, back+"deep/?format=json")
+		code0, ranged := davDo(t, http.MethodGet, back+"deep/a.txt", "", "Range", "bytes=5-")
+		code1, _ := davDo(t, http.MethodGet, back+"deep/nope.txt", "")
+		if up0 != "ok" || up1 != "ok" || get(t, url+"hols/AAA/s3back/deep/a.txt") != "over the backend" || string(getRaw(t, url+"hols/AAA/s3back/big.bin")) != big ||
+			!strings.Contains(listing, `"name":"a.txt"`) || code0 != 206 || ranged != "the backend" || code1 != 404 {
+			t.Fatal("s3 backend errored", up0, up1, listing, code0, ranged, code1)
+		}
+
+		mv := postJSON(t, back+"rpc", `{"call":"mv","args":["/deep","/moved"]}`)
+		rm := postJSON(t, back+"rpc", `{"call":"rm","args":["/big.bin"]}`)
+		root := get(t, back+"?format=json")
+		if mv != "ok" || rm != "ok" || get(t, url+"hols/AAA/s3back/moved/a.txt") != "over the backend" || strings.Contains(root, "big.bin") || strings.Contains(root, `"deep/"`) || !strings.Contains(root, "moved") {
+			t.Fatal("s3 backend writes errored", mv, rm, root)
+		}
+		postJSON(t, url+"rpc", `{"call":"rm","args":["/hols/AAA/s3back"]}`)
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test webdav backend")
+	if testExtra { // serving a folder of the one tested, over its -dav
+		t.Run("webdav backend", func(t *testing.T) {
+			postJSON(t, url+"rpc", `{"call":"mkdirp","args":["/hols/AAA/davback"]}`)
+			dav, err := newDavStorage(url+"dav/hols/AAA/davback/", "", "", 4)
+			dieMaybe(t, err)
+			back := serveStore(t, dav) + "/"
+
+			big := strings.Repeat("0123456789abcdef", 100_000)
+			postJSON(t, back+"rpc", `{"call":"mkdirp","args":["/deep/er"]}`)
+			up0 := postDummyFile(t, back, "%2Fdeep%2Fa.txt", "over the backend")
+			up1 := postDummyFile(t, back, "%2Fbig.bin", big)
+			listing := get(t, back+"deep/?format=json")
+			code0, ranged := davDo(t, http.MethodGet, back+"deep/a.txt", "", "Range", "bytes=5-")
+			code1, _ := davDo(t, http.MethodGet, back+"deep/nope.txt", "")
+			if up0 != "ok" || up1 != "ok" || get(t, url+"hols/AAA/davback/deep/a.txt") != "over the backend" || string(getRaw(t, back+"big.bin")) != big ||
+				!strings.Contains(listing, `"name":"a.txt"`) || !strings.Contains(listing, `"name":"er","href":"/deep/er/"`) || code0 != 206 || ranged != "the backend" || code1 != 404 {
+				t.Fatal("webdav backend errored", up0, up1, listing, code0, ranged, code1)
+			}
+
+			mv := postJSON(t, back+"rpc", `{"call":"mv","args":["/deep","/moved"]}`)
+			rm := postJSON(t, back+"rpc", `{"call":"rm","args":["/big.bin"]}`)
+			root := get(t, back+"?format=json")
+			if mv != "ok" || rm != "ok" || get(t, url+"hols/AAA/davback/moved/a.txt") != "over the backend" || strings.Contains(root, "big.bin") || strings.Contains(root, `"deep/"`) || !strings.Contains(root, "moved") {
+				t.Fatal("webdav backend writes errored", mv, rm, root)
+			}
+			postJSON(t, url+"rpc", `{"call":"rm","args":["/hols/AAA/davback"]}`)
+		})
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test mounts")
+	if testExtra {
+		t.Run("mounts", func(t *testing.T) {
+			media, docs := t.TempDir(), t.TempDir()
+			dieMaybe(t, os.WriteFile(filepath.Join(media, "a.txt"), []byte("on media"), 0644))
+			dieMaybe(t, os.Symlink(filepath.Join(media, "a.txt"), filepath.Join(docs, "ln.txt")))
+			mounts, err := newMountStorage(rootPath, "media="+media+",docs="+docs, true)
+			dieMaybe(t, err)
+			back := serveStore(t, mounts) + "/"
+
+			root := get(t, back+"?format=json")
+			up := postDummyFile(t, back, "%2Fdocs%2Fb.txt", "on docs")
+			linked := get(t, back+"docs/ln.txt")
+			mv := postJSON(t, back+"rpc", `{"call":"mv","args":["/media/a.txt","/docs/a.txt"]}`)
+			b, _ := os.ReadFile(filepath.Join(docs, "a.txt"))
+			if !strings.Contains(root, `"total":2,`) || !strings.Contains(root, `"name":"docs","href":"/docs/"`) || !strings.Contains(root, `"name":"media","href":"/media/"`) ||
+				up != "ok" || get(t, back+"docs/b.txt") != "on docs" || linked != "on media" || mv != "ok" || string(b) != "on media" {
+				t.Fatal("mounts errored", root, up, linked, mv)
+			}
+
+			mkdir := postJSON(t, back+"rpc", `{"call":"mkdirp","args":["/other"]}`)
+			rm := postJSON(t, back+"rpc", `{"call":"rm","args":["/media"]}`)
+			code, _ := davDo(t, http.MethodGet, back+"etc/hostname", "")
+			if _, err := os.Stat(media); mkdir == "ok" || rm == "ok" || err != nil || code != 404 {
+				t.Fatal("mounts let the root be written", mkdir, rm, err, code)
+			}
+		})
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test archive root")
+	if testExtra {
+		t.Run("archive root", func(t *testing.T) {
+			snapshot := filepath.Join(t.TempDir(), "snapshot.zip")
+			buf := new(bytes.Buffer)
+			zw := zip.NewWriter(buf)
+			zf, err := zw.CreateHeader(&zip.FileHeader{Name: "data/stored.csv", Method: zip.Store})
+			dieMaybe(t, err)
+			zf.Write([]byte("a,b\n1,2\n"))
+			zf, err = zw.Create("data/deep/deflated.txt")
+			dieMaybe(t, err)
+			zf.Write([]byte(strings.Repeat("deflated ", 100)))
+			dieMaybe(t, zw.Close())
+			dieMaybe(t, os.WriteFile(snapshot, buf.Bytes(), 0644))
+			archive, err := newArchiveStorage(snapshot)
+			dieMaybe(t, err)
+			setFor(t, &rootPath, snapshot)
+			back := serveStore(t, archive) + "/"
+
+			root := get(t, back+"?format=json")
+			listing := get(t, back+"data/?format=json")
+			code0, ranged := davDo(t, http.MethodGet, back+"data/deep/deflated.txt", "", "Range", "bytes=891-")
+			code1, _ := davDo(t, http.MethodGet, back+"data/nope.txt", "")
+			mkdir := postJSON(t, back+"rpc", `{"call":"mkdirp","args":["/other"]}`)
+			if !strings.Contains(root, `"name":"data","href":"/data/"`) || !strings.Contains(listing, `"name":"deep","href":"/data/deep/"`) || !strings.Contains(listing, `"name":"stored.csv"`) ||
+				string(getRaw(t, back+"data/stored.csv")) != "a,b\n1,2\n" || code0 != 206 || ranged != "deflated " || code1 != 404 || mkdir == "ok" {
+				t.Fatal("archive root errored", root, listing, code0, ranged, code1, mkdir)
+			}
+		})
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test tmpfs")
+	if testExtra {
+		t.Run("tmpfs", func(t *testing.T) {
+			back := memServer(t, 64) + "/"
+
+			mkdir := postJSON(t, back+"rpc", `{"call":"mkdirp","args":["/a/b"]}`)
+			up0 := postDummyFile(t, back, "%2Fa%2Fb%2Fnote.txt", "kept in memory")
+			mv := postJSON(t, back+"rpc", `{"call":"mv","args":["/a/b","/a/c"]}`)
+			listing := get(t, back+"a/c/?format=json")
+			code0, ranged := davDo(t, http.MethodGet, back+"a/c/note.txt", "", "Range", "bytes=8-")
+			df := get(t, back+"api/df?path=/")
+			full := postDummyFile(t, back, "%2Fbig.txt", strings.Repeat("x", 100))
+			if mkdir != "ok" || up0 != "ok" || mv != "ok" || !strings.Contains(listing, `"name":"note.txt"`) || code0 != 206 || ranged != "memory" ||
+				df != `{"free":50,"total":64}` || !strings.Contains(full, "disk_full") || strings.Contains(get(t, back+"?format=json"), "big.txt") {
+				t.Fatal("tmpfs errored", mkdir, up0, mv, listing, code0, ranged, df, full)
+			}
+		})
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test overlay")
+	if testExtra {
+		t.Run("overlay", func(t *testing.T) {
+			lower, upper := t.TempDir(), t.TempDir()
+			dieMaybe(t, os.MkdirAll(filepath.Join(lower, "dir"), 0755))
+			dieMaybe(t, os.WriteFile(filepath.Join(lower, "a.txt"), []byte("original"), 0644))
+			dieMaybe(t, os.WriteFile(filepath.Join(lower, "dir", "b.txt"), []byte("bbb"), 0644))
+			overlay, err := newOverlayStorage(lower, upper)
+			dieMaybe(t, err)
+			setFor(t, &rootPath, lower)
+			back := serveStore(t, overlay) + "/"
+
+			listing := get(t, back+"?format=json")
+			up0 := postDummyFile(t, back, "%2Fa.txt", "changed")
+			read0 := get(t, back+"a.txt")
+			rm := postJSON(t, back+"rpc", `{"call":"rm","args":["/dir/b.txt"]}`)
+			mv := postJSON(t, back+"rpc", `{"call":"mv","args":["/dir","/moved"]}`)
+			mkdir := postJSON(t, back+"rpc", `{"call":"mkdirp","args":["/dir/new"]}`)
+			after := get(t, back+"?format=json")
+			code0, _ := davDo(t, http.MethodGet, back+"dir/b.txt", "")
+			orig, _ := os.ReadFile(filepath.Join(lower, "a.txt"))
+			_, errB := os.Stat(filepath.Join(lower, "dir", "b.txt"))
+			code1, ranged := davDo(t, http.MethodGet, back+"a.txt", "", "Range", "bytes=2-")
+			f, err := overlay.Open(filepath.Join(lower, "a.txt"))
+			dieMaybe(t, err)
+			_, raw := sendable(f).(*os.File)
+			f.Close()
+			if !strings.Contains(listing, `"name":"a.txt"`) || !strings.Contains(listing, `"name":"dir"`) || up0 != "ok" || read0 != "changed" ||
+				rm != "ok" || mv != "ok" || mkdir != "ok" || !strings.Contains(after, `"name":"moved"`) || code0 != 404 ||
+				string(orig) != "original" || errB != nil || strings.Contains(after, whiteoutPrefix) || code1 != 206 || ranged != "anged" || !raw {
+				t.Fatal("overlay errored", listing, up0, read0, rm, mv, mkdir, after, code0, string(orig), errB, code1, ranged, raw)
+			}
+		})
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test gossaignore")
+	if testExtra {
+		t.Run("gossaignore", func(t *testing.T) {
+			back := memServer(t, 0) + "/"
+			for name, content := range map[string]string{"/.gossaignore": "# junk\n*.db\nThumbs/\n!keep.db\n", "/a.db": "", "/keep.db": "",
+				"/Thumbs/x.jpg": "", "/pics/.gossaignore": "/raw\n", "/pics/raw/r.jpg": "", "/pics/sub/raw/ok.jpg": ""} {
+				dieMaybe(t, store.MkdirAll(filepath.Dir(name), 0755))
+				f, err := store.Create(name)
+				dieMaybe(t, err)
+				_, err = f.Write([]byte(content))
+				dieMaybe(t, err)
+				dieMaybe(t, f.Close())
+			}
+
+			root := get(t, back+"?format=json")
+			pics := get(t, back+"pics/?format=json")
+			_, zipKeep := getZip(t, "keep.db", back+"zip?zipPath=%2f&zipName=all")
+			_, zipDB := getZip(t, "a.db", back+"zip?zipPath=%2f&zipName=all")
+			_, zipRaw := getZip(t, "pics/raw/r.jpg", back+"zip?zipPath=%2f&zipName=all")
+			_, zipSub := getZip(t, "pics/sub/raw/ok.jpg", back+"zip?zipPath=%2f&zipName=all")
+			found := postJSON(t, back+"rpc", `{"call":"find","args":["/", "*.db"]}`)
+			if strings.Contains(root, `"name":"a.db"`) || strings.Contains(root, `"name":"Thumbs"`) || !strings.Contains(root, `"name":"keep.db"`) ||
+				strings.Contains(pics, `"name":"raw"`) || !strings.Contains(pics, `"name":"sub"`) || !zipKeep || zipDB || zipRaw || !zipSub ||
+				!strings.Contains(found, "/keep.db") || strings.Contains(found, "/a.db") {
+				t.Fatal("gossaignore errored", root, pics, zipKeep, zipDB, zipRaw, zipSub, found)
+			}
+		})
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test hide globs")
+	if testExtra {
+		t.Run("hide globs", func(t *testing.T) {
+			back := memServer(t, 0) + "/"
+			setFor(t, hide, "*.tmp,Thumbs.db,~$*")
+			for _, name := range []string{"/doc.txt", "/doc.tmp", "/~$doc.docx", "/pics/Thumbs.db", "/pics/a.jpg"} {
+				dieMaybe(t, store.MkdirAll(filepath.Dir(name), 0755))
+				f, err := store.Create(name)
+				dieMaybe(t, err)
+				dieMaybe(t, f.Close())
+			}
+
+			root := get(t, back+"?format=json")
+			count, zipThumbs := getZip(t, "pics/Thumbs.db", back+"zip?zipPath=%2f&zipName=all")
+			code0, _ := davDo(t, http.MethodGet, back+"pics/Thumbs.db", "")
+			code1, _ := davDo(t, http.MethodGet, back+"doc.txt", "")
+			if strings.Contains(root, "doc.tmp") || strings.Contains(root, "~$doc") || !strings.Contains(root, `"name":"doc.txt"`) ||
+				zipThumbs || count != 2 || code0 != 403 || code1 != 200 {
+				t.Fatal("hide errored", root, count, zipThumbs, code0, code1)
+			}
+		})
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test extract on tmpfs")
+	if testExtra {
+		t.Run("extract on tmpfs", func(t *testing.T) {
+			back := memServer(t, 0) + "/"
+			f, err := store.Create("/t.zip")
+			dieMaybe(t, err)
+			f.Write([]byte(makeZip(t, map[string]string{"x/hello.txt": "hello"})))
+			dieMaybe(t, f.Close())
+			setFor(t, &extractKeep, 300*time.Millisecond)
+			body0 := postJSON(t, back+"rpc", `{"call":"extract","args":["/t.zip", "/out"]}`)
+			body1 := get(t, back+"out/x/hello.txt")
+			body2 := postJSON(t, back+"rpc", `{"call":"extract","args":["/nope.zip", "/out2"]}`)
+			_, errOut := store.Stat("/out")
+			_, errLeftover := store.Stat("/out2")
+			kept := postJSON(t, back+"rpc", `{"call":"extractProgress","args":["/t.zip"]}`)
+			time.Sleep(600 * time.Millisecond)
+			dropped := postJSON(t, back+"rpc", `{"call":"extractProgress","args":["/t.zip"]}`)
+			if errOut != nil || body0 != `ok` || body1 != "hello" || !strings.Contains(body2, `"error":"not_found"`) || !errors.Is(errLeftover, os.ErrNotExist) ||
+				!strings.Contains(kept, `"done":true`) || !strings.Contains(dropped, `"error":"not_found"`) {
+				t.Fatal("extract on tmpfs errored", errOut, body0, body1, body2, errLeftover, kept, dropped)
+			}
+		})
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test ffmpeg and index on tmpfs")
+	if testExtra {
+		t.Run("ffmpeg and index on tmpfs", func(t *testing.T) {
+			setFor[Storage](t, &store, newMemStorage(rootPath, 0))
+			f, err := store.Create("/x.mp4")
+			dieMaybe(t, err)
+			f.Write([]byte("not on the host"))
+			dieMaybe(t, f.Close())
+			piped, stdin, closeInput, err := ffmpegInput("/x.mp4")
+			dieMaybe(t, err)
+			fed, err := io.ReadAll(stdin)
+			dieMaybe(t, err)
+			dieMaybe(t, closeInput())
+
+			index.Lock()
+			index.dirty = true
+			index.Unlock()
+			dieMaybe(t, saveIndex())
+			_, errIndex := store.Stat(indexFile())
+			_, errHost := os.Stat(indexFile())
+
+			store = osStorage{}
+			local := filepath.Join(t.TempDir(), "y.mp4")
+			dieMaybe(t, os.WriteFile(local, []byte("on the host"), 0644))
+			direct, stdin, closeInput, err := ffmpegInput(local)
+			dieMaybe(t, err)
+			dieMaybe(t, closeInput())
+			if piped != "pipe:0" || string(fed) != "not on the host" || direct != local || stdin != nil || errIndex != nil || !errors.Is(errHost, os.ErrNotExist) {
+				t.Fatal("ffmpeg and index on tmpfs errored", piped, string(fed), direct, errIndex, errHost)
+			}
+		})
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test sum cache flush")
+	if testExtra {
+		t.Run("sum cache flush", func(t *testing.T) {
+			setFor[Storage](t, &store, newMemStorage(rootPath, 0))
+			setFor(t, sumCachePath, filepath.Join(t.TempDir(), "sums.json"))
+			for _, name := range []string{"/a.txt", "/b.txt"} {
+				f, err := store.Create(name)
+				dieMaybe(t, err)
+				f.Write([]byte(name))
+				dieMaybe(t, f.Close())
+				_, err = fileSum(name, "sha256")
+				dieMaybe(t, err)
+			}
+			_, errBefore := os.Stat(*sumCachePath)
+			dieMaybe(t, saveSumCache())
+			saved, err := os.ReadFile(*sumCachePath)
+			dieMaybe(t, err)
+			if !errors.Is(errBefore, os.ErrNotExist) || !strings.Contains(string(saved), "sha256:/a.txt") || !strings.Contains(string(saved), "sha256:/b.txt") || sumCacheDirty {
+				t.Fatal("sum cache flush errored", errBefore, string(saved))
+			}
+
+			f, err := store.Create("/c.txt")
+			dieMaybe(t, err)
+			f.Write([]byte("/a.txt"))
+			dieMaybe(t, f.Close())
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			_, errCancelled := dupes(ctx, rootPath)
+			found, err := dupes(context.Background(), rootPath)
+			dieMaybe(t, err)
+			saved, err = os.ReadFile(*sumCachePath)
+			dieMaybe(t, err)
+			if !errors.Is(errCancelled, context.Canceled) || !strings.Contains(string(found), `"paths":["/a.txt","/c.txt"]`) || !strings.Contains(string(saved), "blake3:") {
+				t.Fatal("dupes sum cache errored", errCancelled, string(found), string(saved))
+			}
+		})
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test hide path globs")
+	if testExtra {
+		t.Run("hide path globs", func(t *testing.T) {
+			back := memServer(t, 0) + "/"
+			setFor(t, hide, "docs/secret.txt,backups/**")
+			for _, name := range []string{"/docs/secret.txt", "/docs/open.txt", "/other/docs/secret.txt", "/backups/old.txt", "/other/backups/keep.txt"} {
+				dieMaybe(t, store.MkdirAll(filepath.Dir(name), 0755))
+				f, err := store.Create(name)
+				dieMaybe(t, err)
+				dieMaybe(t, f.Close())
+			}
+
+			docs := get(t, back+"docs/?format=json")
+			other := get(t, back+"other/backups/?format=json")
+			count, zipSecret := getZip(t, "secret.txt", back+"zip?zipPath=%2fdocs%2f&zipName=docs")
+			tarReader := tar.NewReader(bytes.NewReader(getRaw(t, back+"tar?path=%2f")))
+			var tarNames []string
+			for {
+				h, err := tarReader.Next()
+				if err == io.EOF {
+					break
+				}
+				dieMaybe(t, err)
+				tarNames = append(tarNames, h.Name)
+			}
+			names := strings.Join(tarNames, ",")
+			code0, _ := davDo(t, http.MethodGet, back+"docs/secret.txt", "")
+			code1, _ := davDo(t, http.MethodGet, back+"other/docs/secret.txt", "")
+			if strings.Contains(docs, "secret.txt") || !strings.Contains(docs, `"name":"open.txt"`) || !strings.Contains(other, `"name":"keep.txt"`) ||
+				zipSecret || count != 1 || names != "docs/,docs/open.txt,other/,other/backups/,other/backups/keep.txt,other/docs/,other/docs/secret.txt" || code0 != 403 || code1 != 200 {
+				t.Fatal("hide path globs errored", docs, other, count, zipSecret, names, code0, code1)
+			}
+		})
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test pooled copy buffers")
+	if testExtra {
+		payload := strings.Repeat("0123456789abcdef", 100_000)
+		var dst strings.Builder
+		copyBuffer(struct{ io.Writer }{&dst}, struct{ io.Reader }{strings.NewReader(payload)}) // warms the pool up
+		allocs := testing.AllocsPerRun(10, func() {
+			copyBuffer(struct{ io.Writer }{io.Discard}, struct{ io.Reader }{strings.NewReader(payload)})
+		})
+		if dst.String() != payload || allocs > 3 {
+			t.Fatal("pooled copy buffers errored", dst.Len(), allocs)
+		}
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test pooled listing encoders")
+	if testExtra {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		encode := func() *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			out, done := encodeListing(rec, req)
+			io.WriteString(out, "glasgow.jpg")
+			done()
+			return rec
+		}
+		encode() // warms the pool up
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		for i := 0; i < 10; i++ {
+			encode()
+		}
+		runtime.ReadMemStats(&after)
+		gz, err := gzip.NewReader(encode().Body)
+		dieMaybe(t, err)
+		b, err := ioutil.ReadAll(gz)
+		dieMaybe(t, err)
+		perCall := (after.TotalAlloc - before.TotalAlloc) / 10
+		if string(b) != "glasgow.jpg" || perCall > 64<<10 { // a fresh gzip writer takes ~800kB
+			t.Fatal("pooled listing encoders errored", string(b), perCall)
+		}
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test listing stats")
+	if testExtra {
+		t.Run("listing stats", func(t *testing.T) {
+			counted := &statCounter{Storage: newMemStorage(rootPath, 0)}
+			back := serveStore(t, counted) + "/"
+			for i := 0; i < 50; i++ {
+				f, err := store.Create(fmt.Sprintf("/%02d.txt", i))
+				dieMaybe(t, err)
+				_, err = f.Write([]byte("abc"))
+				dieMaybe(t, err)
+				dieMaybe(t, f.Close())
+			}
+			listing := get(t, back+"?format=json&sort=size")
+			if !strings.Contains(listing, `"name":"49.txt","href":"/49.txt","size":3`) || counted.stats > 3 {
+				t.Fatal("listing stats errored", counted.stats, listing)
+			}
+		})
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test zip pipeline")
+	if testExtra {
+		t.Run("zip pipeline", func(t *testing.T) {
+			back := memServer(t, 0) + "/"
+			setFor(t, zipCompress, true)
+			mtime := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+			files := map[string]string{"/big.bin": strings.Repeat("0123456789abcdef", 100_000), "/中文.txt": "unicode", "/noise.bin": "\x8f\x01\xfe",
+				"/pic.JPG": strings.Repeat("not a jpeg", 100), "/sniffed": "\x1f\x8b\x08" + strings.Repeat("gz", 100), "/clip": "\x89PNG\x0d\x0a\x1a\x0a" + strings.Repeat("png", 500_000)}
+			for i := 0; i < 300; i++ {
+				files[fmt.Sprintf("/small/%03d.txt", i)] = strings.Repeat(fmt.Sprintf("line %d\n", i), 20)
+			}
+			for name, content := range files {
+				dieMaybe(t, store.MkdirAll(filepath.Dir(name), 0755))
+				f, err := store.Create(name)
+				dieMaybe(t, err)
+				_, err = f.Write([]byte(content))
+				dieMaybe(t, err)
+				dieMaybe(t, f.Close())
+				dieMaybe(t, store.Chtimes(name, mtime, mtime))
+			}
+
+			b := getRaw(t, back+"zip?zipPath=%2F&zipName=all")
+			unzipped, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+			dieMaybe(t, err)
+			var names []string
+			for _, f := range unzipped.File {
+				rc, err := f.Open()
+				dieMaybe(t, err)
+				content, err := io.ReadAll(rc)
+				dieMaybe(t, err)
+				rc.Close()
+				want := files["/"+f.Name]
+				if string(content) != want || !f.Modified.Equal(mtime) || f.Name == "noise.bin" && f.Method != zip.Store || strings.HasPrefix(f.Name, "small/") && f.Method != zip.Deflate ||
+					(f.Name == "pic.JPG" || f.Name == "sniffed" || f.Name == "clip") && f.Method != zip.Store || f.Name == "big.bin" && f.Method != zip.Deflate {
+					t.Fatal("zip pipeline errored on", f.Name, len(content), len(want), f.Modified, f.Method)
+				}
+				names = append(names, f.Name)
+			}
+			if len(names) != len(files) || names[0] != "big.bin" || names[4] != "small/000.txt" || names[len(names)-1] != "中文.txt" {
+				t.Fatal("zip pipeline errored", len(names), names[:3])
+			}
+		})
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test zip procs")
+	if testExtra {
+		t.Run("zip procs", func(t *testing.T) {
+			back := memServer(t, 0) + "/"
+			f, err := store.Create("/a.txt")
+			dieMaybe(t, err)
+			dieMaybe(t, f.Close())
+			setFor(t, &archiveSlots, make(chan struct{}, 1))
+			setFor(t, zipQueue, 10*time.Millisecond)
+			archiveSlots <- struct{}{} // a zip being made
+			code0, body := davDo(t, http.MethodGet, back+"zip?zipPath=%2F&zipName=all", "")
+			code1, _ := davDo(t, http.MethodGet, back+"tar?path=%2F", "")
+			<-archiveSlots
+			_, zipped := getZip(t, "a.txt", back+"zip?zipPath=%2F&zipName=all")
+			if code0 != 429 || !strings.Contains(body, `"error":"busy"`) || code1 != 429 || !zipped || len(archiveSlots) != 0 {
+				t.Fatal("zip procs errored", code0, body, code1, zipped)
+			}
+		})
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test theme")
+	if testExtra {
+		prev := ui.Load()
+		dir := t.TempDir()
+		dieMaybe(t, os.WriteFile(filepath.Join(dir, "style.css"), []byte("body{color:hotpink}"), 0644))
+		*theme, *themeReload = dir, true
+		themeSetup()
+		go themeWatcher(*theme)
+		srv := httptest.NewServer(inProcess)
+		defer srv.Close()
+		themed := func(marker string) bool {
+			for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+				_, body := davDo(t, http.MethodGet, srv.URL+"/", "")
+				if strings.Contains(body, marker) {
+					return true
+				}
+			}
+			return false
+		}
+		styled := themed("hotpink")
+		dieMaybe(t, os.WriteFile(filepath.Join(dir, "ui.tmpl"), []byte("{{ broken"), 0644))
+		time.Sleep(100 * time.Millisecond)
+		kept := themed("hotpink")
+		dieMaybe(t, os.Remove(filepath.Join(dir, "ui.tmpl")))
+		dieMaybe(t, os.WriteFile(filepath.Join(dir, "script.js"), []byte("window.reloadedTheme = 1"), 0644))
+		reloaded := themed("window.reloadedTheme = 1")
+		errMissing := loadTheme(filepath.Join(dir, "missing"))
+		if !styled || !kept || !reloaded || errMissing == nil || ui.Load().digest == prev.digest {
+			t.Fatal("theme errored", styled, kept, reloaded, errMissing)
+		}
+		themeWatch.Close()
+		*theme, *themeReload = "", false
+		ui.Store(prev)
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test theme names")
+	if testExtra {
+		prev := ui.Load()
+		dir := t.TempDir()
+		dieMaybe(t, os.WriteFile(filepath.Join(dir, "solarized.css"), []byte("html{color:#586e75}"), 0644))
+		srv := httptest.NewServer(inProcess)
+		defer srv.Close()
+		styled := func(name string, dir string, marker string) bool {
+			*themeName = name
+			if loadTheme(dir) != nil {
+				return false
+			}
+			_, body := davDo(t, http.MethodGet, srv.URL+"/", "")
+			return strings.Contains(body, marker)
+		}
+		light := styled("light", "", "background-color: #f5f6fa")
+		auto := styled("auto", "", "@media (prefers-color-scheme: light) {")
+		custom := styled("solarized", dir, "html{color:#586e75}")
+		dark := !styled("dark", "", "#f5f6fa")
+		*themeName = "solarized"
+		errNoDir := loadTheme("")
+		if !light || !auto || !custom || !dark || errNoDir == nil {
+			t.Fatal("theme names errored", light, auto, custom, dark, errNoDir)
+		}
+		*themeName = "dark"
+		ui.Store(prev)
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test branding")
+	if testExtra {
+		prev := ui.Load()
+		var icon bytes.Buffer
+		dieMaybe(t, png.Encode(&icon, image.NewRGBA(image.Rect(0, 0, 16, 16))))
+		*favicon = filepath.Join(t.TempDir(), "icon.png")
+		dieMaybe(t, os.WriteFile(*favicon, icon.Bytes(), 0644))
+		dieMaybe(t, loadTheme(""))
+		*titlePrefix, logoURI = "Acme - ", dataURI([]byte(`<svg xmlns="http://www.w3.org/2000/svg"/>`))
+		srv := httptest.NewServer(inProcess)
+		defer srv.Close()
+		_, body := davDo(t, http.MethodGet, srv.URL+"/", "")
+		if !strings.Contains(body, "<title>Acme - /</title>") || !strings.Contains(body, `<img id="logo" src="data:image/svg&#43;xml;base64,`) ||
+			!strings.Contains(body, `href="data:image/png;base64,`+base64.StdEncoding.EncodeToString(icon.Bytes())+`" rel="icon" type="image/png"`) {
+			t.Fatal("branding errored", body[:2000])
+		}
+		*favicon, *titlePrefix, logoURI = "", "", ""
+		ui.Store(prev)
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test collation")
+	if testExtra {
+		sorted := func(by string) string {
+			var stats []os.FileInfo
+			for _, name := range []string{"Zebra", "école", "zoo", "Äpfel", "Eagle", "apple"} {
+				stats = append(stats, memInfo{name: name, mode: 0644})
+			}
+			dieMaybe(t, sortListing(stats, by, ""))
+			var names []string
+			for _, el := range stats {
+				names = append(names, el.Name())
+			}
+			return strings.Join(names, " ")
+		}
+		byName, root := sorted("name"), sorted("collate")
+		*sortLocale = "sv"
+		dieMaybe(t, checkSortLocale())
+		swedish := sorted("collate")
+		*sortLocale = "not a locale!"
+		errLocale := checkSortLocale()
+		if byName != "apple Eagle Zebra zoo Äpfel école" || root != "Äpfel apple Eagle école Zebra zoo" || swedish != "apple Eagle école Zebra zoo Äpfel" || errLocale == nil {
+			t.Fatal("collation errored", byName, "|", root, "|", swedish, errLocale)
+		}
+		*sortLocale, sortCollation = "", language.Und
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test icons")
+	if testExtra {
+		t.Run("icons", func(t *testing.T) {
+			back := memServer(t, 0) + "/"
+			t.Cleanup(func() { iconClasses, iconCSS = map[string]string{}, "" })
+			for _, name := range []string{"/a.DNG", "/b.fits", "/c.txt"} {
+				f, err := store.Create(name)
+				dieMaybe(t, err)
+				dieMaybe(t, f.Close())
+			}
+			dir := t.TempDir()
+			write := func(icons string) error {
+				dieMaybe(t, os.WriteFile(filepath.Join(dir, "icons.json"), []byte(icons), 0644))
+				return loadIcons(filepath.Join(dir, "icons.json"))
+			}
+			errURL := write(`{"x": "url(javascript:alert(1))"}`)
+			errData := write(`{"x": "data:image/svg+xml,\");}body{display:none"}`)
+			dieMaybe(t, write(`{".dng": "jpg", "fits": "data:image/png;base64,iVBORw0KGgo="}`))
+			_, body := davDo(t, http.MethodGet, back+"", "")
+			if errURL == nil || errData == nil || !strings.Contains(body, `.icon-custom-fits { background-image: url("data:image/png;base64,iVBORw0KGgo="); }`) ||
+				!strings.Contains(body, "icon-jpg icon-blank") || !strings.Contains(body, "icon-custom-fits icon-blank") || !strings.Contains(body, "icon-txt icon-blank") {
+				t.Fatal("icons errored", errURL, errData, body)
+			}
+		})
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test error pages")
+	if testExtra {
+		prev := ui.Load()
+		srv := httptest.NewServer(inProcess)
+		defer srv.Close()
+		code0, page := davDo(t, http.MethodGet, srv.URL+"/nope/", "", "Accept", "text/html,application/xhtml+xml", "Accept-Language", "fr")
+		code1, body := davDo(t, http.MethodGet, srv.URL+"/nope/", "", "Accept", "*/*")
+		denied := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept", "text/html")
+		replyErrorPage(denied, req, errInvalidPath)
+		if code0 != 404 || !strings.Contains(page, "<title>404 rien ici</title>") || !strings.Contains(page, `<a id="error-back" href="`+*extraPath+`">&larr; retour à la racine</a>`) ||
+			code1 != 404 || !strings.HasPrefix(body, `{"error":"not_found"`) || denied.Code != 403 || !strings.Contains(denied.Body.String(), "<title>403 access denied</title>") {
+			t.Fatal("error pages errored", code0, page, code1, body, denied.Code, denied.Body)
+		}
+		dir := t.TempDir()
+		dieMaybe(t, os.WriteFile(filepath.Join(dir, "error.tmpl"), []byte(`<p>oops {{.Status}}, <a href="{{.Root}}">home</a></p>`), 0644))
+		dieMaybe(t, loadTheme(dir))
+		_, page = davDo(t, http.MethodGet, srv.URL+"/nope/", "", "Accept", "text/html")
+		if page != `<p>oops 404, <a href="`+*extraPath+`">home</a></p>` {
+			t.Fatal("themed error page errored", page)
+		}
+		ui.Store(prev)
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test size units")
+	if testExtra {
+		short := humanize(1536) + " " + humanize(0) + " " + humanize(5<<20)
+		*sizeUnits, *sizePrecision = "si", 2
+		si := humanize(1536) + " " + humanize(999) + " " + humanize(5<<20)
+		*sizeUnits, *sizePrecision = "binary", 0
+		binary := humanize(1536) + " " + humanize(5<<20)
+		prevFormat := *dateFormat
+		*dateFormat = "02/01/2006"
+		e := newListEntry("/", memInfo{name: "a.bin", size: 2048, mode: 0644, mtime: time.Date(2024, 5, 17, 10, 0, 0, 0, time.UTC)})
+		*sizeUnits = "metric"
+		errUnits := checkSizeUnits()
+		if short != "1.5k 0.0B 5.0M" || si != "1.54kB 999.00B 5.24MB" || binary != "2KiB 5MiB" || e.Human != "2KiB" || e.Modified != "17/05/2024" || errUnits == nil {
+			t.Fatal("size units errored", short, si, binary, e, errUnits)
+		}
+		*sizeUnits, *sizePrecision, *dateFormat = "short", 1, prevFormat
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test breadcrumbs")
+	if testExtra {
+		t.Run("breadcrumbs", func(t *testing.T) {
+			back := memServer(t, 0) + "/"
+			dieMaybe(t, store.MkdirAll("/a b/c#d/<e>", 0755))
+			_, body := davDo(t, http.MethodGet, back+"a%20b/c%23d/%3Ce%3E/", "")
+			want := `<h1 onclick="return titleClick(event)"><a href="` + *extraPath + `">./</a><a href="` + *extraPath + `a%20b/">a b/</a>` +
+				`<a href="` + *extraPath + `a%20b/c%23d/">c#d/</a><a href="` + *extraPath + `a%20b/c%23d/%3Ce%3E/">&lt;e&gt;/</a></h1>`
+			listing := get(t, back+"a%20b/c%23d/%3Ce%3E/?format=json")
+			if !strings.Contains(body, want) || listing != `{"path":"/a b/c#d/\u003ce\u003e/","total":0,"offset":0,"entries":[]}` {
+				t.Fatal("breadcrumbs errored", body, listing)
+			}
+		})
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test qr codes")
+	if testExtra {
+		t.Run("qr codes", func(t *testing.T) {
+			data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+			ecc := qrRemainder(data, qrDivisor(10))
+			formats := []int{0b101010000010010, 0b101000100100101, 0b101111001111100, 0b101101101001011, 0b100010111111001, 0b100000011001110, 0b100111110010111, 0b100101010100000}
+			for mask, want := range formats {
+				if qrFormatBits(mask) != want {
+					t.Fatal("qr format bits errored", mask, qrFormatBits(mask))
+				}
+			}
+			if !bytes.Equal(ecc, []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}) || qrVersionBits(7) != 0b000111110010010100 {
+				t.Fatal("qr error correction errored", ecc, qrVersionBits(7))
+			}
+			if _, err := newQR(strings.Repeat("a", 3000)); !errors.Is(err, errTooLarge) {
+				t.Fatal("qr capacity errored", err)
+			}
+
+			back := memServer(t, 0) + "/"
+			dieMaybe(t, store.MkdirAll("/hols", 0755))
+			f, err := store.Create("/b.txt")
+			dieMaybe(t, err)
+			dieMaybe(t, f.Close())
+			req, err := http.NewRequest(http.MethodGet, back+"qr?path=%2Fb.txt&scale=2", nil)
+			dieMaybe(t, err)
+			req.Header.Set("X-Forwarded-Proto", "https")
+			res, err := http.DefaultClient.Do(req)
+			dieMaybe(t, err)
+			img, err := png.Decode(res.Body)
+			res.Body.Close()
+			dieMaybe(t, err)
+			want, err := newQR("https://" + strings.TrimPrefix(back, "http://") + "b.txt")
+			dieMaybe(t, err)
+			if img.Bounds().Dx() != (want.size+2*qrBorder)*2 {
+				t.Fatal("qr png size errored", img.Bounds())
+			}
+			for y := range want.dark {
+				for x, dark := range want.dark[y] {
+					if r, _, _, _ := img.At((x+qrBorder)*2, (y+qrBorder)*2).RGBA(); (r == 0) != dark {
+						t.Fatal("qr png errored at", x, y)
+					}
+				}
+			}
+			code0, svg := davDo(t, http.MethodGet, back+"qr?path=%2Fhols%2F&format=svg", "")
+			code1, _ := davDo(t, http.MethodGet, back+"qr?path=%2Fnope.txt", "")
+			code2, _ := davDo(t, http.MethodGet, back+"qr?path=%2Fb.txt&format=gif", "")
+			if code0 != 200 || !strings.HasPrefix(svg, "<svg") || !strings.Contains(svg, "M4,4h1v1h-1z") || code1 != 404 || code2 != 400 {
+				t.Fatal("qr endpoint errored", code0, code1, code2, svg)
+			}
+		})
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test header and footer")
+	if testExtra {
+		dir := t.TempDir()
+		header := `<p class="policy" onclick="steal()">downloads are <b>logged</b><!-- internal --></p><script>alert(1)</script>` +
+			`<a href="javascript:alert(1)">x</a><a href="/about" data-x="1">about</a><iframe src="https://evil.example"></iframe><style>body{}</style>`
+		footer := `<script async src="https://stats.example/script.js" data-domain="files.example">alert(1)</script><img src=x onerror=alert(1)>`
+		dieMaybe(t, os.WriteFile(filepath.Join(dir, "header.html"), []byte(header), 0644))
+		dieMaybe(t, os.WriteFile(filepath.Join(dir, "footer.html"), []byte(footer), 0644))
+		var err error
+		headerHTML, err = injectFile(filepath.Join(dir, "header.html"))
+		dieMaybe(t, err)
+		footerHTML, err = injectFile(filepath.Join(dir, "footer.html"))
+		dieMaybe(t, err)
+		srv := httptest.NewServer(inProcess)
+		defer srv.Close()
+		_, body := davDo(t, http.MethodGet, srv.URL+"/", "")
+		wantHeader := `<header id="header"><p class="policy">downloads are <b>logged</b></p><a>x</a><a href="/about" data-x="1">about</a></header>`
+		wantFooter := `<footer id="footer"><script async="" src="https://stats.example/script.js" data-domain="files.example"></script><img src="x"/></footer>`
+		if !strings.Contains(body, wantHeader) || !strings.Contains(body, wantFooter) || strings.Contains(body, "alert(1)") || strings.Contains(body, "evil") {
+			t.Fatal("header and footer errored", body)
+		}
+		headerHTML, footerHTML = "", ""
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test archive caps")
+	if testExtra {
+		t.Run("archive caps", func(t *testing.T) {
+			back := memServer(t, 0) + "/"
+			for _, name := range []string{"/a.txt", "/b.txt", "/sub/deep/c.txt"} {
+				dieMaybe(t, store.MkdirAll(filepath.Dir(name), 0755))
+				f, err := store.Create(name)
+				dieMaybe(t, err)
+				f.Write([]byte("hello"))
+				dieMaybe(t, f.Close())
+			}
+			archives := func() (int, string, int) {
+				code0, body := davDo(t, http.MethodGet, back+"zip?zipPath=%2F&zipName=all", "")
+				code1, _ := davDo(t, http.MethodGet, back+"tar?path=%2F", "")
+				return code0, body, code1
+			}
+			setFor(t, zipMaxFiles, 2)
+			files0, filesBody, files1 := archives()
+			*zipMaxFiles = 3
+			setFor(t, zipMaxSize, 14)
+			size0, _, size1 := archives()
+			*zipMaxSize = 0
+			setFor(t, zipMaxDepth, 1)
+			depth0, depthBody, depth1 := archives()
+			*zipMaxFiles, *zipMaxSize, *zipMaxDepth = 3, 15, 2
+			ok0, _, ok1 := archives()
+			if files0 != 413 || !strings.Contains(filesBody, "more than 2 files") || files1 != 413 || size0 != 413 || size1 != 413 ||
+				depth0 != 413 || !strings.Contains(depthBody, `"error":"too_large"`) || depth1 != 413 || ok0 != 200 || ok1 != 200 {
+				t.Fatal("archive caps errored", files0, filesBody, files1, size0, size1, depth0, depthBody, depth1, ok0, ok1)
+			}
+		})
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test client gone")
+	if testExtra {
+		t.Run("client gone", func(t *testing.T) {
+			setFor[Storage](t, &store, newMemStorage(rootPath, 0))
+			dieMaybe(t, store.Mkdir(rootPath+"/d", 0755))
+			f, err := store.Create(rootPath + "/d/a.txt")
+			dieMaybe(t, err)
+			f.Write([]byte("hello"))
+			dieMaybe(t, f.Close())
+			gone, cancel := context.WithCancel(context.Background())
+			cancel()
+			serve := func(method string, target string, body string) *httptest.ResponseRecorder {
+				rec := httptest.NewRecorder()
+				inProcess.ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)).WithContext(gone))
+				return rec
+			}
+			zipped := serve("GET", "/zip?zipPath=%2Fd&zipName=d", "")
+			tarred := serve("GET", "/tar?path=%2Fd", "")
+			copied := serve("POST", "/rpc", `{"call":"cp","args":["/d","/e"]}`)
+			found := serve("POST", "/rpc", `{"call":"find","args":["/","a"]}`)
+			_, errCopy := store.Stat(rootPath + "/e")
+			errUpload := saveFile(gone, rootPath+"/up.txt", strings.NewReader("hello"))
+			_, errUploaded := store.Stat(rootPath + "/up.txt")
+
+			cut := httptest.NewRequest("POST", "/post", io.MultiReader(strings.NewReader("------b\r\nContent-Disposition: form-data; name=\"f\"; filename=\"f\"\r\n\r\nhalf"), iotest.ErrReader(io.ErrUnexpectedEOF)))
+			cut.Header.Set("Content-Type", "multipart/form-data; boundary=----b")
+			cut.Header.Set("Gossa-Path", "%2Fcut.txt")
+			posted := httptest.NewRecorder()
+			inProcess.ServeHTTP(posted, cut)
+			_, errCut := store.Stat(rootPath + "/cut.txt")
+			if zipped.Code != 499 || tarred.Code != 499 || copied.Code != 499 || found.Code != 499 ||
+				!strings.Contains(copied.Body.String(), `"error":"canceled"`) || errCopy == nil ||
+				!errors.Is(errUpload, context.Canceled) || errUploaded == nil || posted.Code == 200 || errCut == nil {
+				t.Fatal("client gone errored", zipped.Code, tarred.Code, copied.Code, found.Code, errCopy, errUpload, errUploaded, posted.Code, errCut)
+			}
+		})
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test load shedding")
+	if testExtra {
+		t.Run("load shedding", func(t *testing.T) {
+			setFor[Storage](t, &store, newMemStorage(rootPath, 0))
+			setFor(t, maxRequests, 1)
+			setFor(t, &requestSlots, nil)
+			shed := httptest.NewServer(loadShed(inProcess))
+			defer shed.Close()
+			requestSlots <- struct{}{} // a request being served
+			code0, body := davDo(t, http.MethodGet, shed.URL+"/?format=json", "")
+			<-requestSlots
+			code1, _ := davDo(t, http.MethodGet, shed.URL+"/?format=json", "")
+
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			dieMaybe(t, err)
+			limited := httptest.NewUnstartedServer(inProcess)
+			limited.Listener = &connLimiter{Listener: ln, limit: 1}
+			limited.Start()
+			defer limited.Close()
+			held, err := net.Dial("tcp", ln.Addr().String())
+			dieMaybe(t, err)
+			noReuse := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+			resp, err := noReuse.Get(limited.URL + "/?format=json")
+			dieMaybe(t, err)
+			resp.Body.Close()
+			held.Close()
+			code3 := 0
+			for i := 0; i < 50 && code3 != 200; i++ {
+				time.Sleep(10 * time.Millisecond)
+				if resp, err := noReuse.Get(limited.URL + "/?format=json"); err == nil {
+					code3 = resp.StatusCode
+					resp.Body.Close()
+				}
+			}
+			if code0 != 503 || !strings.Contains(body, `"error":"overloaded"`) || code1 != 200 || resp.StatusCode != 503 || resp.Header.Get("Retry-After") != "1" || code3 != 200 {
+				t.Fatal("load shedding errored", code0, body, code1, resp.StatusCode, code3)
+			}
+		})
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test symlinks allowlist")
+	if testExtra {
+		t.Run("symlinks allowlist", func(t *testing.T) {
+			root, media, other := t.TempDir(), t.TempDir(), t.TempDir()
+			dieMaybe(t, os.WriteFile(filepath.Join(media, "song.mp3"), []byte("la la"), 0644))
+			dieMaybe(t, os.WriteFile(filepath.Join(other, "secret.txt"), []byte("nope"), 0644))
+			dieMaybe(t, os.Symlink(media, filepath.Join(root, "media")))
+			resolved, err := filepath.EvalSymlinks(media)
+			dieMaybe(t, err)
+			setFor(t, &rootPath, root)
+			setFor(t, symlinks, false)
+			setFor(t, &allowedLinks, []string{resolved})
+			back := serveStore(t, osStorage{}) + "/"
+
+			count, zipSong := getZip(t, "media/song.mp3", back+"zip?zipPath=%2f&zipName=all")
+			dieMaybe(t, os.Symlink(other, filepath.Join(root, "other")))
+			listing := get(t, back+"?format=json")
+			song := get(t, back+"media/song.mp3")
+			secret := get(t, back+"other/secret.txt")
+			if !strings.Contains(listing, `"name":"media"`) || strings.Contains(listing, `"name":"other"`) || song != "la la" || secret != errForbidden || !zipSong || count != 1 {
+				t.Fatal("symlinks allowlist errored", listing, song, secret, zipSong, count)
+			}
+		})
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test listing cache")
+	if testExtra { // -list-cache is on, writes of gossa and of others drop the listings
+		postJSON(t, url+"rpc", `{"call":"mkdirp","args":["/hols/AAA/cached"]}`)
+		before := get(t, url+"hols/AAA/cached/?format=json")
+		up := postDummyFile(t, url, "%2Fhols%2FAAA%2Fcached%2Fown.txt", "by gossa")
+		afterOwn := get(t, url+"hols/AAA/cached/?format=json")
+		dieMaybe(t, os.WriteFile("test-fixture/hols/AAA/cached/other.txt", []byte("by others"), 0644))
+		afterOther := ""
+		for i := 0; i < 50 && !strings.Contains(afterOther, "other.txt"); i++ {
+			time.Sleep(20 * time.Millisecond)
+			afterOther = get(t, url+"hols/AAA/cached/?format=json")
+		}
+		if strings.Contains(before, "own.txt") || up != "ok" || !strings.Contains(afterOwn, `"name":"own.txt"`) || !strings.Contains(afterOther, `"name":"other.txt"`) {
+			t.Fatal("listing cache errored", before, up, afterOwn, afterOther)
+		}
+		postJSON(t, url+"rpc", `{"call":"rm","args":["/hols/AAA/cached"]}`)
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test live updates")
+	if testExtra {
+		postJSON(t, url+"rpc", `{"call":"mkdirp","args":["/hols/AAA/live"]}`)
+		resp, err := http.Get(url + "events?path=%2Fhols%2FAAA%2Flive%2F")
+		dieMaybe(t, err)
+		timer := time.AfterFunc(5*time.Second, func() { resp.Body.Close() })
+		events := bufio.NewReader(resp.Body)
+		first, _ := events.ReadString('\n')
+		up := postDummyFile(t, url, "%2Fhols%2FAAA%2Flive%2Fnew.txt", "live")
+		got := ""
+		for !strings.Contains(got, "new.txt") {
+			line, err := events.ReadString('\n')
+			if err != nil {
+				break
+			}
+			got += line
+		}
+		timer.Stop()
+		resp.Body.Close()
+		code, _ := davDo(t, http.MethodGet, url+"events?path=%2Fhols%2FAAA%2Flive%2Fnew.txt", "")
+		if resp.Header.Get("Content-Type") != "text/event-stream" || first != ": watching\n" || up != "ok" ||
+			!strings.Contains(got, "event: created\ndata: {\"type\":\"created\",\"path\":\"/hols/AAA/live/new.txt\"}") || code != 400 {
+			t.Fatal("live updates errored", resp.Header, first, up, got, code)
+		}
+		postJSON(t, url+"rpc", `{"call":"rm","args":["/hols/AAA/live"]}`)
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test delta sync")
+	var deltaOrig, deltaNew strings.Builder
+	for i := 0; i < 1500; i++ {
+		fmt.Fprintf(&deltaOrig, "line %d\n", i)
+		if i == 700 {
+			deltaNew.WriteString("edited\n")
+		} else {
+			fmt.Fprintf(&deltaNew, "line %d\n", i)
+		}
+	}
+	deltaNew.WriteString("appended\n")
+	postDummyFile(t, url, "%2Fhols%2FAAA%2Fdelta.txt", deltaOrig.String())
+	var sig deltaSignature
+	dieMaybe(t, json.Unmarshal(getRaw(t, url+"api/delta?path=%2Fhols%2FAAA%2Fdelta.txt&block=1024"), &sig))
+	etag = getHeader(t, url+"api/delta?path=%2Fhols%2FAAA%2Fdelta.txt", "ETag")
+	var patch bytes.Buffer
+	dieMaybe(t, writeDelta(&sig, strings.NewReader(deltaNew.String()), &patch))
+	code0, _ := davDo(t, http.MethodPut, url+"api/delta?path=%2Fhols%2FAAA%2Fdelta.txt", patch.String(), "If-Match", etag)
+	code1, _ := davDo(t, http.MethodPut, url+"api/delta?path=%2Fhols%2FAAA%2Fdelta.txt", patch.String(), "If-Match", etag)
+	code2, _ = davDo(t, http.MethodPut, url+"api/delta?path=%2Fhols%2FAAA%2Fdelta.txt", patch.String()[:patch.Len()-1], "")
+	if code0 != 200 || code1 != 412 || code2 != 400 || patch.Len() > 2*sig.Block || len(sig.Blocks) != 14 ||
+		string(getRaw(t, url+"hols/AAA/delta.txt")) != deltaNew.String() {
+		t.Fatal("delta put errored", code0, code1, code2, patch.Len(), len(sig.Blocks))
+	}
+	stale, err := signature(strings.NewReader(deltaOrig.String()), int64(deltaOrig.Len()), 1024)
+	dieMaybe(t, err)
+	staleJSON, err := json.Marshal(stale)
+	dieMaybe(t, err)
+	code0, body0 = davDo(t, http.MethodPost, url+"api/v1/delta/hols/AAA/delta.txt", string(staleJSON))
+	var synced bytes.Buffer
+	if code0 != 200 || len(body0) > 2*stale.Block || applyDelta(strings.NewReader(deltaOrig.String()), strings.NewReader(body0), &synced) != nil ||
+		synced.String() != deltaNew.String() {
+		t.Fatal("delta post errored", code0, len(body0))
+	}
+	code0, body0 = davDo(t, http.MethodPost, url+"api/v1/delta/hols/AAA/delta.txt", `{"size":1,"block":1099511627776,"blocks":[]}`)
+	code1, _ = davDo(t, http.MethodPost, url+"api/v1/delta/hols/AAA/delta.txt", `{"size":1,"block":1,"blocks":[]}`)
+	if code0 != 400 || code1 != 400 || !strings.Contains(body0, "invalid block size") {
+		t.Fatal("delta block size errored", code0, code1, body0)
+	}
+	postJSON(t, url+"rpc", `{"call":"rm","args":["/hols/AAA/delta.txt"]}`)
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test client subcommands")
+	local := filepath.Join(t.TempDir(), "client.txt")
+	dieMaybe(t, os.WriteFile(local, []byte("from the client"), 0644))
+	var lsOut, getOut bytes.Buffer
+	dieMaybe(t, clientMain([]string{"put", url, "/hols/AAA/client.txt", local}, io.Discard))
+	dieMaybe(t, clientMain([]string{"get", url, "/hols/AAA/client.txt", "-"}, &getOut))
+	dieMaybe(t, clientMain([]string{"mv", url, "/hols/AAA/client.txt", "/hols/AAA/client2.txt"}, io.Discard))
+	dieMaybe(t, clientMain([]string{"ls", url, "/hols/AAA"}, &lsOut))
+	err = clientMain([]string{"get", url, "/hols/AAA/client.txt", "-"}, io.Discard)
+	dieMaybe(t, clientMain([]string{"rm", url, "/hols/AAA/client2.txt"}, io.Discard))
+	if getOut.String() != "from the client" || err == nil || !strings.Contains(err.Error(), "404") ||
+		!regexp.MustCompile(`(?m)^ +15.0B  [^ ]+ [^ ]+  client2.txt$`).MatchString(lsOut.String()) ||
+		clientMain([]string{"cp", url, "/a", "/b"}, io.Discard) == nil {
+		t.Fatal("client errored", getOut.String(), err, lsOut.String())
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test streamed listing")
+	var streamed listing
+	dieMaybe(t, json.Unmarshal(getRaw(t, url+"hols/?format=json"), &streamed))
+	body0 = get(t, url+"hols/")
+	rowNames := regexp.MustCompile(`class="list-links"[^>]+>([^<]+)</a>`).FindAllStringSubmatch(body0, -1)
+	if len(rowNames) != len(streamed.Entries)+1 || rowNames[0][1] != "../" || !strings.Contains(body0, "</html>") {
+		t.Fatal("streamed listing errored", len(rowNames), len(streamed.Entries))
+	}
+	for i, el := range rowNames[1:] {
+		if isDir := strings.HasSuffix(el[1], "/"); i > 0 && isDir && !strings.HasSuffix(rowNames[i][1], "/") {
+			t.Fatal("streamed listing doesnt list folders first", el[1])
+		}
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test readme rendering")
+	postDummyFile(t, url, "%2Fhols%2FAAA%2FREADME.md", "# Welcome\n\nsee **the docs** <script>alert(1)</script> [now](javascript:alert(2))\n")
+	body0 = get(t, url+"hols/AAA/")
+	body1 = get(t, url+"hols/")
+	if !strings.Contains(body0, `<article id="readme"><h1>Welcome</h1> <p>see <strong>the docs</strong>`) ||
+		strings.Contains(body0, "<script>alert") || strings.Contains(body0, "javascript:") || strings.Contains(body1, `id="readme"`) {
+		t.Fatal("readme errored", body0)
+	}
+	postJSON(t, url+"rpc", `{"call":"rm","args":["/hols/AAA/README.md"]}`)
+	postDummyFile(t, url, "%2Fhols%2FAAA%2Fguide.md", "## Guide\n\nback to [the readme](README.md#top), or [elsewhere](https://example.com/a.md)\n")
+	body0 = get(t, url+"hols/AAA/guide.md?render=1")
+	body1 = get(t, url+"hols/AAA/guide.md")
+	if !strings.Contains(body0, `<article id="readme"><h2>Guide</h2>`) || !strings.Contains(body0, `href="README.md?render=1#top"`) ||
+		!strings.Contains(body0, `href="https://example.com/a.md"`) || !strings.Contains(body0, `href="./">../</a>`) || !strings.HasPrefix(body1, "## Guide") ||
+		!strings.Contains(body0, `hols/AAA/">AAA/</a><a href="`+strings.TrimPrefix(url, "http://127.0.0.1:8001")+`hols/AAA/guide.md">guide.md</a></h1>`) {
+		t.Fatal("markdown rendering errored", body0, body1)
+	}
+	postJSON(t, url+"rpc", `{"call":"rm","args":["/hols/AAA/guide.md"]}`)
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test syntax highlighted preview")
+	postDummyFile(t, url, "%2Fhols%2FAAA%2Fmain.go", "package main\n\n// <b>hi</b>\nfunc main() {}\n")
+	body0 = get(t, url+"api/v1/preview/hols/AAA/main.go")
+	code1, _ = davDo(t, http.MethodGet, url+"preview?path=%2Fhols%2Fglasgow.jpg", "")
+	if !strings.Contains(body0, `<span class="kn">package</span>`) || !strings.Contains(body0, `id="L4"`) || !strings.Contains(body0, "&lt;b&gt;hi&lt;/b&gt;") || code1 != 415 {
+		t.Fatal("preview errored", code1, body0)
+	}
+	postJSON(t, url+"rpc", `{"call":"rm","args":["/hols/AAA/main.go"]}`)
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test text preview charsets")
+	for _, el := range [][3]string{
+		{"sjis.txt", "\x82\xb1\x82\xf1\x82\xc9\x82\xbf\x82\xcd\x81A\x90\xa2\x8aE\x81B\x93\xfa\x96{\x8c\xea\x82\xcc\x83e\x83L\x83X\x83g\x82\xc5\x82\xb7\n", "shift_jis こんにちは、世界。日本語のテキストです"},
+		{"gbk.txt", "\xc4\xe3\xba\xc3\xa3\xac\xca\xc0\xbd\xe7\xa1\xa3\xd5\xe2\xca\xc7\xd6\xd0\xce\xc4\xce\xc4\xb1\xbe\n", "gbk 你好，世界。这是中文文本"},
+		{"latin1.txt", "r\xe9sum\xe9 \xe0 la carte, na\xefve caf\xe9\n", "windows-1252 résumé à la carte, naïve café"},
+		{"utf8.txt", "déjà vu\n", "utf-8 déjà vu"},
+	} {
+		postDummyFile(t, url, "%2Fhols%2FAAA%2F"+el[0], el[1])
+		resp, err := http.Get(url + "api/v1/text/hols/AAA/" + el[0])
+		dieMaybe(t, err)
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if got := resp.Header.Get("Gossa-Charset") + " " + strings.TrimSpace(string(b)); got != el[2] {
+			t.Fatal("text preview errored", got)
+		}
+		postJSON(t, url+"rpc", `{"call":"rm","args":["/hols/AAA/`+el[0]+`"]}`)
+	}
+	code0, body0 = davDo(t, http.MethodGet, url+"api/text?path=%2Fhols%2Fglasgow.jpg", "")
+	code1, body1 = davDo(t, http.MethodGet, url+"api/text?path=%2Fb.txt&charset=nope", "")
+	if code0 != 415 || code1 != 400 {
+		t.Fatal("text preview of binary or unknown charset errored", code0, body0, code1, body1)
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test serve index")
+	postJSON(t, url+"rpc", `{"call":"mkdirp","args":["/hols/AAA/site"]}`)
+	postDummyFile(t, url, "%2Fhols%2FAAA%2Fsite%2Findex.html", "<h1>a static site</h1>")
+	body0 = get(t, url+"hols/AAA/site/")
+	body1 = get(t, url+"hols/AAA/site")
+	body2 = get(t, url+"hols/AAA/site/?list")
+	if testExtra && (body0 != "<h1>a static site</h1>" || body1 != body0 || !strings.Contains(body2, `href="index.html">index.html</a>`)) {
+		t.Fatal("serve index errored", body0, body1)
+	} else if !testExtra && !strings.Contains(body0, `href="index.html">index.html</a>`) {
+		t.Fatal("index served without -serve-index", body0)
+	}
+	postJSON(t, url+"rpc", `{"call":"rm","args":["/hols/AAA/site"]}`)
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test spa fallback")
+	postJSON(t, url+"rpc", `{"call":"mkdirp","args":["/hols/AAA/app"]}`)
+	postDummyFile(t, url, "%2Fhols%2FAAA%2Fapp%2Findex.html", "<div id=app></div>")
+	code0, body0 = davDo(t, http.MethodGet, url+"hols/AAA/app/users/42", "")
+	code1, body1 = davDo(t, http.MethodGet, url+"hols/AAA/app/missing.js", "")
+	code2, body2 = davDo(t, http.MethodGet, url+"hols/AAA/app/users/jo.doe", "", "Accept", "text/html,*/*")
+	cc := getHeader(t, url+"hols/AAA/app/users/42", "Cache-Control")
+	if testExtra && (code0 != 200 || body0 != "<div id=app></div>" || code1 != 404 || code2 != 200 || body2 != body0 || cc != "no-cache" ||
+		get(t, url+"hols/AAA/app/") != body0) {
+		t.Fatal("spa fallback errored", code0, body0, code1, code2, cc)
+	} else if !testExtra && (code0 != 404 || code2 != 404) {
+		t.Fatal("spa fallback without -spa", code0, code2)
+	}
+	postJSON(t, url+"rpc", `{"call":"rm","args":["/hols/AAA/app"]}`)
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test thumbnails")
+	thumb0 := getRaw(t, url+"thumb?path=%2Fhols%2Fglasgow.jpg&w=100&h=80")
+	thumb1 := getRaw(t, url+"api/v1/thumb/hols/glasgow.jpg?w=100&h=80")
+	thumbImg, err := jpeg.Decode(bytes.NewReader(thumb0))
+	dieMaybe(t, err)
+	orig, err := jpeg.DecodeConfig(bytes.NewReader(getRaw(t, url+"hols/glasgow.jpg")))
+	dieMaybe(t, err)
+	tsize := thumbImg.Bounds().Size()
+	code0, body0 = davDo(t, http.MethodGet, url+"thumb?path=%2Fhols%2Fc.js", "")
+	if tsize.X > 100 || tsize.Y > 80 || tsize.X != 100 && tsize.Y != 80 || !bytes.Equal(thumb0, thumb1) ||
+		abs(tsize.X*orig.Height-tsize.Y*orig.Width) > orig.Width+orig.Height || code0 != 415 || strings.Contains(get(t, url), cacheDirName) {
+		t.Fatal("thumbnails errored", tsize, orig.Width, orig.Height, code0, body0)
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test batch thumbnails")
+	var thumbs struct {
+		Thumbs map[string]string
+		Errors map[string]struct{ Error string }
+	}
+	dieMaybe(t, json.Unmarshal([]byte(postJSON(t, url+"api/v1/thumbs", `{"paths":["/hols/glasgow.jpg","/hols/c.js","/../../etc/passwd","/nope.jpg"],"w":100,"h":80}`)), &thumbs))
+	code0, _ = davDo(t, http.MethodGet, url+"api/v1/thumbs", "")
+	if thumbs.Thumbs["/hols/glasgow.jpg"] != "data:image/jpeg;base64,"+base64.StdEncoding.EncodeToString(thumb0) || len(thumbs.Thumbs) != 1 ||
+		thumbs.Errors["/hols/c.js"].Error != "unsupported_media_type" || thumbs.Errors["/../../etc/passwd"].Error != "forbidden" || thumbs.Errors["/nope.jpg"].Error != "not_found" || code0 != 400 {
+		t.Fatal("batch thumbnails errored", thumbs, code0)
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test image resizing")
+	resized, err := png.Decode(bytes.NewReader(getRaw(t, url+"img?path=%2Fhols%2Fglasgow.jpg&w=300&format=png")))
+	dieMaybe(t, err)
+	code0, _ = davDo(t, http.MethodGet, url+"api/v1/img/hols/glasgow.jpg?format=gif", "")
+	code1, body1 = davDo(t, http.MethodGet, url+"api/v1/img/hols/glasgow.jpg?w=99999&h=99999", "")
+	full, err := jpeg.DecodeConfig(strings.NewReader(body1))
+	dieMaybe(t, err)
+	webpType := getHeader(t, url+"img?path=%2Fhols%2Fglasgow.jpg&w=64&format=webp", "Content-Type")
+	autoType := getHeader(t, url+"img?path=%2Fhols%2Fglasgow.jpg&w=64", "Content-Type")
+	if resized.Bounds().Dx() != 300 || code0 != 400 || code1 != 200 || full.Width != orig.Width || full.Height != orig.Height {
+		t.Fatal("image resizing errored", resized.Bounds(), code0, code1, full)
+	} else if testExtra && (webpType != "image/webp" || autoType != "image/jpeg") {
+		t.Fatal("image conversion errored", webpType, autoType)
+	}
+	if testExtra {
+		req, _ := http.NewRequest(http.MethodGet, url+"img?path=%2Fhols%2Fglasgow.jpg&w=64&format=auto", nil)
+		req.Header.Set("Accept", "image/avif,image/webp,*/*")
+		resp, err := http.DefaultClient.Do(req)
+		dieMaybe(t, err)
+		resp.Body.Close()
+		if resp.Header.Get("Content-Type") != "image/avif" || resp.Header.Get("Vary") != "Accept" {
+			t.Fatal("image negotiation errored", resp.Header)
+		}
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test video thumbnails")
+	postDummyFile(t, url, "%2Fhols%2FAAA%2Fclip.mp4", "not much of a video")
+	code0, body0 = davDo(t, http.MethodGet, url+"thumb?path=%2Fhols%2FAAA%2Fclip.mp4&w=32", "")
+	if testExtra {
+		poster, err := jpeg.Decode(strings.NewReader(body0))
+		if code0 != 200 || err != nil || poster.Bounds().Size() != image.Pt(32, 18) {
+			t.Fatal("video thumbnail errored", code0, err)
+		}
+	} else if code0 != 415 { // no ffmpeg, or one failing on the dummy video
+		t.Fatal("video thumbnail without ffmpeg errored", code0, body0)
+	}
+	postJSON(t, url+"rpc", `{"call":"rm","args":["/hols/AAA/clip.mp4"]}`)
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test hls")
+	postDummyFile(t, url, "%2Fhols%2FAAA%2Fclip.mkv", "not much of a video")
+	code0, body0 = davDo(t, http.MethodGet, url+"hls?path=%2Fhols%2FAAA%2Fclip.mkv", "")
+	if testExtra {
+		lines := strings.Split(strings.TrimSpace(body0), "\n")
+		if code0 != 200 || len(lines) != 5 || lines[0] != "#EXTM3U" || !strings.Contains(lines[1], "RESOLUTION=640x360") || !strings.HasPrefix(lines[2], "hls?") {
+			t.Fatal("hls master playlist errored", code0, body0)
+		}
+		body1 = get(t, url+lines[2])
+		if strings.Count(body1, "#EXTINF:6.000,") != 2 || strings.Count(body1, "#EXTINF:1.500,") != 1 || !strings.Contains(body1, "#EXT-X-ENDLIST") {
+			t.Fatal("hls media playlist errored", body1)
+		}
+		code1, body1 = davDo(t, http.MethodGet, url+lines[2]+"&seg=2", "")
+		if code1 != 200 || !strings.Contains(body1, "fake segment") || !strings.Contains(body1, "-ss 12.000") || !strings.Contains(body1, "scale=640:360") {
+			t.Fatal("hls segment errored", code1, body1)
+		}
+		code1, _ = davDo(t, http.MethodGet, url+lines[2]+"&seg=3", "")
+		code2, _ = davDo(t, http.MethodGet, url+"hls?session=nope&profile=360p", "")
+		if code1 != 400 || code2 != 404 {
+			t.Fatal("hls bad segment or session errored", code1, code2)
+		}
+	} else if code0 != 404 { // disabled
+		t.Fatal("hls disabled errored", code0, body0)
+	}
+	postJSON(t, url+"rpc", `{"call":"rm","args":["/hols/AAA/clip.mkv"]}`)
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test media info")
+	id3Frame := func(id string, text string) string {
+		return id + string([]byte{0, 0, 0, byte(len(text))}) + "\x00\x00" + text
+	}
+	frames := id3Frame("TIT2", "\x00Song") + id3Frame("TPE1", "\x01\xff\xfeZ\x00o\x00\xeb\x00") + id3Frame("TALB", "\x03Álbum")
+	postDummyFile(t, url, "%2Fhols%2FAAA%2Fsong.mp3", "ID3\x03\x00\x00\x00\x00\x00"+string(rune(len(frames)))+frames+"not much of a song")
+	postDummyFile(t, url, "%2Fhols%2FAAA%2Fclip.mkv", "not much of a video")
+	body0 = get(t, url+"hols/AAA/?format=json&media=1")
+	body1 = get(t, url+"hols/AAA/?format=json")
+	if testExtra && (!strings.Contains(body0, `"media":{"duration":13.5,"videoCodec":"h264","audioCodec":"opus","title":"Fake Title"}`) || strings.Count(body0, `"media"`) != 2) {
+		t.Fatal("media info errored", body0)
+	} else if !testExtra && (!strings.Contains(body0, `"media":{"title":"Song","artist":"Zoë","album":"Álbum"}`) || !strings.Contains(body0, `"media":{}`)) {
+		t.Fatal("media info without ffmpeg errored", body0)
+	} else if strings.Contains(body1, `"media"`) {
+		t.Fatal("media info not asked for errored", body1)
+	}
+	postJSON(t, url+"rpc", `{"call":"rm","args":["/hols/AAA/song.mp3"]}`)
+	postJSON(t, url+"rpc", `{"call":"rm","args":["/hols/AAA/clip.mkv"]}`)
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test exif")
+	rotated, err := os.ReadFile("support/exif-rotated.jpg") // 40x20, red then blue, to be turned a quarter clockwise
+	dieMaybe(t, err)
+	postDummyFile(t, url, "%2Fhols%2FAAA%2Frotated.jpg", string(rotated))
+	body0 = get(t, url+"api/v1/exif/hols/AAA/rotated.jpg")
+	body1 = get(t, url+"api/exif?path=%2Fhols%2Fc.js")
+	if body0 != `{"taken":"2024-05-06T07:08:09+02:00","make":"Gossa","model":"Fixture","orientation":6,"gps":{"lat":48.8581667,"lon":-2.2945,"alt":35}}` || body1 != `{}` {
+		t.Fatal("exif errored", body0, body1)
+	}
+	upright, err := jpeg.Decode(bytes.NewReader(getRaw(t, url+"thumb?path=%2Fhols%2FAAA%2Frotated.jpg")))
+	dieMaybe(t, err)
+	top, _, _, _ := upright.At(10, 5).RGBA()
+	bottom, _, _, _ := upright.At(10, 35).RGBA()
+	if upright.Bounds().Size() != image.Pt(20, 40) || top < 0x8000 || bottom > 0x8000 {
+		t.Fatal("thumbnail not turned upright", upright.Bounds(), top, bottom)
+	}
+	postJSON(t, url+"rpc", `{"call":"rm","args":["/hols/AAA/rotated.jpg"]}`)
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test photo timeline")
+	postJSON(t, url+"rpc", `{"call":"mkdirp","args":["/hols/AAA/timeline/deep"]}`)
+	postDummyFile(t, url, "%2Fhols%2FAAA%2Ftimeline%2Fdeep%2Frotated.jpg", string(rotated))
+	postJSON(t, url+"rpc", `{"call":"cp","args":["/hols/glasgow.jpg","/hols/AAA/timeline/glasgow.jpg"]}`)
+	body0 = get(t, url+"api/v1/timeline/hols/AAA/timeline/?samples=1")
+	body1 = get(t, url+"api/timeline?path=%2Fhols%2FAAA%2Ftimeline&month=2024-05")
+	_, err = os.Stat("test-fixture/.gossa-cache/timeline.json")
+	prefix := strings.TrimPrefix(url, "http://127.0.0.1:8001")
+	if !strings.HasPrefix(body0, `{"path":"/hols/AAA/timeline","total":2,"buckets":[{"month":"2024-05","count":1,"samples":[{"name":"rotated.jpg","path":"/hols/AAA/timeline/deep/rotated.jpg","href":"`+prefix+`hols/AAA/timeline/deep/rotated.jpg","thumb":"`+prefix+`thumb?path=%2Fhols%2FAAA%2Ftimeline%2Fdeep%2Frotated.jpg","taken":"2024-05-06T07:08:09+02:00"}]},`) ||
+		!strings.Contains(body0, `{"month":"2019-06","count":1,"samples":[{"name":"glasgow.jpg"`) ||
+		!strings.Contains(body1, `"total":1,"entries":[{"name":"rotated.jpg"`) || strings.Contains(body1, "glasgow") || err != nil {
+		t.Fatal("timeline errored", body0, body1, err)
+	}
+	postJSON(t, url+"rpc", `{"call":"rm","args":["/hols/AAA/timeline"]}`)
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test gallery api")
+	var gal, galPage gallery
+	dieMaybe(t, json.Unmarshal(getRaw(t, url+"api/gallery?path=%2Fhols"), &gal))
+	dieMaybe(t, json.Unmarshal(getRaw(t, url+"api/v1/gallery/hols/?offset=1&limit=1"), &galPage))
+	var glasgow *galleryEntry
+	for i, el := range gal.Entries {
+		if el.Type != "image" {
+			t.Fatal("gallery lists non media", el.Name)
+		} else if el.Name == "glasgow.jpg" {
+			glasgow = &gal.Entries[i]
+		}
+	}
+	if glasgow == nil || glasgow.Width != orig.Width || glasgow.Height != orig.Height || glasgow.Taken != "2019-06-28T16:41:14" ||
+		!strings.HasSuffix(glasgow.Thumb, "thumb?path=%2Fhols%2Fglasgow.jpg") || !strings.HasSuffix(glasgow.Href, "hols/glasgow.jpg") ||
+		gal.Total != len(gal.Entries) || gal.Total < 3 || galPage.Total != gal.Total || len(galPage.Entries) != 1 || galPage.Entries[0].Name != gal.Entries[1].Name {
+		t.Fatal("gallery api errored", gal, galPage)
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test undo rpc")
+	body0 = postJSON(t, url+"rpc", `{"call":"mv","args":["/hols/AAA/abcdef", "/hols/AAA/moved"]}`)
+	body1 = postJSON(t, url+"rpc", `{"call":"undo","args":[]}`)
+	if body0 != `ok` || body1 != `ok` || get(t, url+"hols/AAA/abcdef") != payload {
+		t.Fatal("undo mv errored", body1)
+	}
+
+	body0 = postJSON(t, url+"rpc", `{"call":"rm","args":["/hols/AAA/abcdef"]}`)
+	body1 = postJSON(t, url+"rpc", `{"call":"undo","args":[]}`)
+	if body0 != `ok` || body1 != `ok` || get(t, url+"hols/AAA/abcdef") != payload {
+		t.Fatal("undo rm errored", body1)
+	}
+
+	body0 = postDummyFile(t, url, "%2Fhols%2FAAA%2Fundone", "oops")
+	body1 = postJSON(t, url+"rpc", `{"call":"undo","args":[]}`)
+	if body0 != `ok` || body1 != `ok` || !strings.Contains(get(t, url+"hols/AAA/undone"), `error`) {
+		t.Fatal("undo upload errored", body1)
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test rm rpc & cleanup")
+	body0 = postJSON(t, url+"rpc", `{"call":"rm","args":["/hols/AAA"]}`)
+	if body0 != `ok` {
+		t.Fatal("cleanup errored #0")
+	}
+
+	body0 = get(t, url+"hols/AAA")
+	if !strings.Contains(body0, `error`) {
+		t.Fatal("cleanup errored #1")
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test trash list, restore & purge")
+	var items []struct {
+		ID     string `json:"id"`
+		Origin string `json:"origin"`
+	}
+	body0 = postJSON(t, url+"rpc", `{"call":"trashList","args":[]}`)
+	dieMaybe(t, json.Unmarshal([]byte(body0), &items))
+	if len(items) == 0 || items[0].Origin != "hols/AAA" {
+		t.Fatal("trash list errored", body0)
+	}
+
+	body0 = get(t, url+".gossa-trash/")
+	body1 = get(t, url+".gossa-trash/"+items[0].ID+"/info.json")
+	if !strings.Contains(body0, `error`) || !strings.Contains(body1, `error`) {
+		t.Fatal("trash folder should not be served")
+	}
+
+	body0 = postJSON(t, url+"rpc", `{"call":"trashRestore","args":["`+items[0].ID+`"]}`)
+	body1 = get(t, url+"hols/AAA/abcdef")
+	if body0 != `ok` || body1 != payload {
+		t.Fatal("trash restore errored", body0)
+	}
+
+	body0 = postJSON(t, url+"rpc", `{"call":"trashRestore","args":["../hols"]}`)
+	if body0 == `ok` {
+		t.Fatal("trash restore should reject invalid ids")
+	}
+
+	body0 = postJSON(t, url+"rpc", `{"call":"rm","args":["/hols/AAA"]}`)
+	body1 = postJSON(t, url+"rpc", `{"call":"rm","args":["/하 하"]}`)
+	if body0 != `ok` || body1 != `ok` {
+		t.Fatal("cleanup errored #2")
+	}
+
+	body0 = postJSON(t, url+"rpc", `{"call":"trashPurge","args":[]}`)
+	body1 = postJSON(t, url+"rpc", `{"call":"trashList","args":[]}`)
+	if body0 != `ok` || body1 != `[]` {
+		t.Fatal("trash purge errored", body1)
+	}
+
+	fmt.Printf("\r\n=========\r\n")
+}
+
+func doTestReadonly(t *testing.T, url string) {
+	var payload, path, body0, body1 string
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test fetching default path")
+	fetchAndTestDefault(t, url)
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test fetching an invalid path - redirected to root")
+	fetchAndTestDefault(t, url+"../../")
+	fetchAndTestDefault(t, url+"hols/../../")
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test fetching regular files")
+	body0 = get(t, url+"subdir_with%20space/file_with%20space.html")
+	body1 = get(t, url+"fancy-path/a")
+	if body0 != `<b>spacious!!</b> ` || body1 != `fancy! ` {
+		t.Fatal("fetching a regular file errored")
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test fetching a invalid file")
+	path = "../../../../../../../../../../etc/passwd"
+	if get(t, url+path) != `{"error":"not_found","message":"stat /etc/passwd: no such file or directory"}` {
+		t.Fatal("fetching a invalid file didnt errored")
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test download rate limit")
+	start := time.Now()
+	body0 = string(getRaw(t, url+"hols/elephant-1822636_1920.jpg"))
+	if len(body0) < 400000 || time.Since(start) < 300*time.Millisecond {
+		t.Fatal("download rate limit not applied")
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test mkdir rpc")
+	body0 = postJSON(t, url+"rpc", `{"call":"mkdirp","args":["/AAA"]}`)
+	if body0 == `ok` {
+		t.Fatal("mkdir rpc passed - should not be allowed")
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test post file")
+	path = "%2F%E1%84%92%E1%85%A1%20%E1%84%92%E1%85%A1" // "하 하" encoded
+	payload = "123 하"
+	body0 = postDummyFile(t, url, path, payload)
+	get(t, url+path)
+	if body0 == `ok` {
+		t.Fatal("post file passed - should not be allowed")
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test mv rpc")
+	body0 = postJSON(t, url+"rpc", `{"call":"mv","args":["/AAA", "/hols/AAA"]}`)
+	fetchAndTestDefault(t, url)
+	if body0 == `ok` {
+		t.Fatal("mv rpc passed - should not be allowed")
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test text file api")
+	code, _ := putText(t, url+"api/file?path=%2Fb.txt", "nope", "", "")
+	if code != http.StatusForbidden || get(t, url+"api/file?path=%2Fb.txt") != get(t, url+"b.txt") {
+		t.Fatal("file api should be read only", code)
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test api v1 read only")
+	code, _ = putText(t, url+"api/v1/files/nope.txt", "nope", "", "")
+	body0 = get(t, url+"api/v1/files/b.txt")
+	if code != http.StatusForbidden || body0 != get(t, url+"b.txt") {
+		t.Fatal("api v1 should be read only", code, body0)
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test webdav read only")
+	code, body0 = davDo(t, "PROPFIND", url+"dav/hols/", "", "Depth", "1")
+	code0, _ := davDo(t, http.MethodPut, url+"dav/hols/dav.txt", "nope")
+	code1, _ := davDo(t, "MKCOL", url+"dav/hols/nope", "")
+	if code != 207 || !strings.Contains(body0, `glasgow.jpg`) || code0 != 403 || code1 != 403 {
+		t.Fatal("webdav read only errored", code, code0, code1)
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test sftp read only")
+	client := sftpDial(t, "alice")
+	_, err := client.Create("/nope.txt")
+	infos, errList := client.ReadDir("/hols")
+	if err == nil || errList != nil || len(infos) == 0 {
+		t.Fatal("sftp read only errored", err, errList)
+	}
+	client.Close()
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test ftp read only")
+	c, err := textproto.Dial("tcp", "127.0.0.1:2121")
+	dieMaybe(t, err)
+	c.ReadResponse(220)
+	ftpDo(t, c, "USER alice", "")
+	ftpDo(t, c, "PASS hunter2", "")
+	code0, _ = ftpDo(t, c, "MKD /nope", "")
+	code1, _ = ftpDo(t, c, "STOR /nope.txt", "nope")
+	code2, list := ftpDo(t, c, "NLST hols", "")
+	if code0 != 550 || code1 != 550 || code2 != 226 || !strings.Contains(list, "glasgow.jpg\r\n") {
+		t.Fatal("ftp read only errored", code0, code1, code2, list)
+	}
+	c.Close()
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test s3 read only")
+	code0, _ = s3Do(t, http.MethodPut, "http://127.0.0.1:9002/gossa/nope.txt", "nope")
+	code1, body0 = s3Do(t, http.MethodGet, "http://127.0.0.1:9002/gossa/?list-type=2&delimiter=/&max-keys=2", "")
+	if code0 != 403 || code1 != 200 || !strings.Contains(body0, `<KeyCount>2</KeyCount><NextContinuationToken>`) {
+		t.Fatal("s3 read only errored", code0, code1, body0)
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test delta sync ro")
+	code0, _ = davDo(t, http.MethodPut, url+"api/delta?path=%2Fhols%2Fdelta.txt", "GDLT", "")
+	code1, body0 = davDo(t, http.MethodPost, url+"api/v1/delta/hols/c.js", `{"size":0,"block":1024,"blocks":[]}`)
+	if code0 != 403 || code1 != 200 || !strings.HasPrefix(body0, "GDLT") {
+		t.Fatal("delta ro errored", code0, code1)
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test cp rpc")
+	body0 = postJSON(t, url+"rpc", `{"call":"cp","args":["/hols", "/hols-copy"]}`)
+	if body0 == `ok` {
+		t.Fatal("cp rpc passed - should not be allowed")
+	}
+
+	// ~~~~~~~~~~~~~~~~~
+	fmt.Println("\r\n~~~~~~~~~~ test rm rpc & cleanup")
+	body0 = postJSON(t, url+"rpc", `{"call":"rm","args":["/hols/AAA"]}`)
+	if body0 == `ok` {
+		t.Fatal("cleanup passed - should not be allowed")
+	}
+
+	fmt.Printf("\r\n=========\r\n")
+}
+
+func TestNormal(t *testing.T) {
+	fmt.Println("========== testing normal path ============")
+	doTestRegular(t, "http://127.0.0.1:8001/", false)
+}
+
+func TestExtra(t *testing.T) {
 	fmt.Println("========== testing extras options ============")
 	doTestRegular(t, "http://127.0.0.1:8001/fancy-path/", true)
 }
@@ -385,6 +2892,15 @@ func TestRo(t *testing.T) {
 	doTestReadonly(t, "http://127.0.0.1:8001/")
 }
 
+// the flags of the server the tests run against, as the gossa command has them
+var testConfig = DefaultConfig()
+
+func init() {
+	testConfig.Flags(flag.CommandLine)
+}
+
 func TestRunMain(t *testing.T) {
-	main()
+	flag.Parse()
+	testConfig.Root = flag.Arg(0)
+	dieMaybe(t, Run(testConfig))
 }
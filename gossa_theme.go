@@ -0,0 +1,138 @@
+package gossa
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// the -logo shown above listings, inlined
+var logoURI template.URL
+
+// themeFiles are the files a -theme folder may override
+var themeFiles = map[string]bool{"ui.tmpl": true, "error.tmpl": true, "style.css": true, "script.js": true, "favicon.svg": true}
+
+// themeNameCSS is the style -theme-name adds over style.css: the light
+// palette, the light palette when the browser prefers it, or a css file of
+// the theme folder
+func themeNameCSS(dir string) (string, error) {
+	switch *themeName {
+	case "", "dark":
+		return "", nil
+	case "light":
+		return lightCss, nil
+	case "auto":
+		return "@media (prefers-color-scheme: light) {\n" + lightCss + "}\n", nil
+	}
+	if dir == "" {
+		return "", fmt.Errorf("-theme-name %s is not light, dark or auto, and no -theme folder holds %s.css", *themeName, *themeName)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, filepath.Base(*themeName)+".css"))
+	return string(b), err
+}
+
+// loadTheme builds the page from the files of a theme folder, the ones it
+// lacks being the embedded ones, in the palette of -theme-name and with the
+// -favicon. An empty dir uses the embedded files only
+func loadTheme(dir string) error {
+	if dir != "" {
+		stat, err := os.Stat(dir)
+		if err != nil {
+			return err
+		} else if !stat.IsDir() {
+			return fmt.Errorf("theme %s is not a folder", dir)
+		}
+	}
+	read := func(name string, embedded string) (string, error) {
+		if dir == "" {
+			return embedded, nil
+		}
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			return embedded, nil
+		}
+		return string(b), err
+	}
+	t, err := read("ui.tmpl", uiTmpl)
+	if err != nil {
+		return err
+	}
+	errT, err := read("error.tmpl", errorTmpl)
+	if err != nil {
+		return err
+	}
+	css, err := read("style.css", styleCss)
+	if err != nil {
+		return err
+	}
+	palette, err := themeNameCSS(dir)
+	if err != nil {
+		return err
+	}
+	js, err := read("script.js", scriptJs)
+	if err != nil {
+		return err
+	}
+	icon, err := read("favicon.svg", string(faviconSvg))
+	if err != nil {
+		return err
+	}
+	if *favicon != "" {
+		b, err := os.ReadFile(*favicon)
+		if err != nil {
+			return err
+		}
+		icon = string(b)
+	}
+	page, err := buildUI(t, errT, css+"\n"+palette, js, []byte(icon))
+	if err != nil {
+		return err
+	}
+	ui.Store(page)
+	return nil
+}
+
+// reloads the theme with -theme-reload
+var themeWatch *fsnotify.Watcher
+
+// themeWatcher reloads the theme as its files change, keeping the page in use
+// when they don't make a valid one, e.g. while a template is being edited
+func themeWatcher(dir string) {
+	for {
+		select {
+		case e, ok := <-themeWatch.Events:
+			if !ok {
+				return
+			}
+			if name := filepath.Base(e.Name); !themeFiles[name] && name != *themeName+".css" {
+				continue
+			}
+			if err := loadTheme(dir); err != nil {
+				log.Println("error - cant reload theme", err)
+			} else if *verb {
+				log.Println("theme reloaded", e.Name)
+			}
+		case err, ok := <-themeWatch.Errors:
+			if !ok {
+				return
+			}
+			log.Println("error - theme watcher", err)
+		}
+	}
+}
+
+func themeSetup() {
+	check(loadTheme(*theme))
+	if *theme == "" || !*themeReload {
+		return
+	}
+	var err error
+	themeWatch, err = fsnotify.NewWatcher()
+	check(err)
+	check(themeWatch.Add(*theme))
+}
@@ -0,0 +1,315 @@
+package gossa
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	_ "golang.org/x/image/bmp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+const cacheDirName = ".gossa-cache"
+
+// bumped when thumbnails are made differently, so the cached ones are redone
+const thumbVersion = 2
+
+// images above this are refused, their decoding taking too much memory
+const thumbMaxPixels = 50_000_000
+
+// caps how many thumbnails are made at once, it's cpu bound
+var thumbSlots = make(chan struct{}, runtime.NumCPU())
+
+var imageTypes = map[string]string{"jpeg": "image/jpeg", "png": "image/png", "webp": "image/webp", "avif": "image/avif"}
+
+// imageVariant is a rendition of an image, fitting in w x h and encoded as format
+type imageVariant struct {
+	w, h    int
+	format  string // jpeg, png, webp or avif
+	quality int
+}
+
+func cacheDir() string {
+	return filepath.Join(rootPath, cacheDirName)
+}
+
+// boundParam parses an image dimension, clamped to sane values
+func boundParam(s string, fallback int, limit int) int {
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return fallback
+	}
+	return min(max(n, 16), limit)
+}
+
+// variantCachePath is where a variant of a file is kept, fresh as long as
+// its mtime is the one of the file
+func variantCachePath(fullPath string, v imageVariant) string {
+	key := sha1.Sum([]byte(fmt.Sprintf("%s\x00%dx%d\x00%s\x00%d\x00%d", relPath(fullPath), v.w, v.h, v.format, v.quality, thumbVersion)))
+	return filepath.Join(cacheDir(), "thumbs", fmt.Sprintf("%x.%s", key, v.format))
+}
+
+// fitIn scales a size down to fit in w x h, keeping its ratio
+func fitIn(size image.Point, w int, h int) image.Point {
+	if size.X <= w && size.Y <= h {
+		return size
+	}
+	if size.X*h > size.Y*w {
+		return image.Pt(w, max(1, size.Y*w/size.X))
+	}
+	return image.Pt(max(1, size.X*h/size.Y), h)
+}
+
+// decodeImage decodes an image, refusing the ones too large to be handled
+func decodeImage(fullPath string) (image.Image, error) {
+	f, err := store.Open(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	config, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errNotImage, err)
+	} else if config.Width*config.Height > thumbMaxPixels {
+		return nil, fmt.Errorf("image of %dx%d: %w", config.Width, config.Height, errTooLarge)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errNotImage, err)
+	}
+	return img, nil
+}
+
+// renderVariant renders a variant of an image or video, transparency over
+// white for jpegs
+func renderVariant(fullPath string, v imageVariant) ([]byte, error) {
+	thumbSlots <- struct{}{}
+	defer func() { <-thumbSlots }()
+	decode := decodeImage
+	if isVideo(fullPath) {
+		decode = videoFrame
+	}
+	src, err := decode(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	orientation := 1
+	if info, err := readExif(fullPath); err == nil {
+		orientation = info.Orientation
+	}
+	size := src.Bounds().Size()
+	if orientation >= 5 { // shown rotated by a quarter, fitted as such
+		size = fitIn(image.Pt(size.Y, size.X), v.w, v.h)
+		size = image.Pt(size.Y, size.X)
+	} else {
+		size = fitIn(size, v.w, v.h)
+	}
+	dst := image.NewRGBA(image.Rectangle{Max: size})
+	if v.format == "jpeg" {
+		draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	}
+	draw.BiLinear.Scale(dst, dst.Bounds(), src, src.Bounds(), draw.Over, nil)
+	return encodeImage(orientImage(dst, orientation), v)
+}
+
+func encodeImage(img image.Image, v imageVariant) ([]byte, error) {
+	var out bytes.Buffer
+	var err error
+	switch v.format {
+	case "jpeg":
+		err = jpeg.Encode(&out, img, &jpeg.Options{Quality: v.quality})
+	case "png":
+		err = png.Encode(&out, img)
+	default:
+		return ffmpegEncode(img, v.format, v.quality)
+	}
+	return out.Bytes(), err
+}
+
+// variant returns a variant of a file, from the cache when still fresh
+func variant(fullPath string, stat os.FileInfo, v imageVariant) ([]byte, error) {
+	cached := variantCachePath(fullPath, v)
+	if cs, err := store.Stat(cached); err == nil && cs.ModTime().Equal(stat.ModTime()) {
+		if b, err := readFile(cached); err == nil {
+			return b, nil
+		}
+	}
+	b, err := renderVariant(fullPath, v)
+	if err != nil {
+		return nil, err
+	}
+	storeCached(cached, b, stat.ModTime())
+	return b, nil
+}
+
+// storeCached atomically writes a cache entry, dated as what it was made of.
+// Failures only cost a regeneration, e.g. on a read only share
+func storeCached(cached string, b []byte, mtime time.Time) {
+	if store.MkdirAll(filepath.Dir(cached), 0755) != nil {
+		return
+	}
+	tmp, err := createTemp(filepath.Dir(cached), ".tmp-")
+	if err != nil {
+		return
+	}
+	defer store.Remove(tmp.Name())
+	_, err = tmp.Write(b)
+	if cerr := tmp.Close(); err == nil && cerr == nil && store.Chtimes(tmp.Name(), mtime, mtime) == nil {
+		store.Rename(tmp.Name(), cached)
+	}
+}
+
+// replyVariant serves a variant of the image at ?path=
+func replyVariant(w http.ResponseWriter, r *http.Request, fullPath string, v imageVariant) {
+	stat, err := store.Stat(fullPath)
+	check(err)
+	if !stat.Mode().IsRegular() {
+		check(badCall("not a file"))
+	}
+	b, err := variant(fullPath, stat, v)
+	check(err)
+	w.Header().Set("Content-Type", imageTypes[v.format])
+	w.Header().Set("ETag", fmt.Sprintf(`"%x-%x-%dx%d-%s%d"`, stat.Size(), stat.ModTime().UnixNano(), v.w, v.h, v.format, v.quality))
+	http.ServeContent(w, r, "", stat.ModTime(), bytes.NewReader(b))
+}
+
+// thumbHandler serves thumb?path=&w=&h=, a jpeg thumbnail of an image
+func thumbHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	defer exitPath(w, "thumb", q.Get("path"))
+	fullPath := enforcePath(q.Get("path"))
+	replyVariant(w, r, fullPath, imageVariant{boundParam(q.Get("w"), 256, 1024), boundParam(q.Get("h"), 256, 1024), "jpeg", 80})
+}
+
+// imageFormat picks the format of ?format=, auto picking the best one the
+// client accepts, else png for png and gif sources, else jpeg
+func imageFormat(r *http.Request, fullPath string) (string, error) {
+	format := r.URL.Query().Get("format")
+	if format == "" || format == "auto" {
+		accept := r.Header.Get("Accept")
+		for _, el := range []string{"avif", "webp"} {
+			if ffmpegEncoders[el] != "" && strings.Contains(accept, "image/"+el) {
+				return el, nil
+			}
+		}
+		if ext := strings.ToLower(filepath.Ext(fullPath)); ext == ".png" || ext == ".gif" {
+			return "png", nil
+		}
+		return "jpeg", nil
+	} else if format == "jpg" {
+		return "jpeg", nil
+	} else if imageTypes[format] == "" {
+		return "", badCall("invalid format %s, expected jpeg, png, webp, avif or auto", format)
+	}
+	return format, nil
+}
+
+// imgHandler serves img?path=&w=&h=&format=&q=, an image resized to fit in
+// w x h and converted, e.g. for galleries on slow links
+func imgHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	defer exitPath(w, "img", q.Get("path"))
+	fullPath := enforcePath(q.Get("path"))
+	format, err := imageFormat(r, fullPath)
+	check(err)
+	if f := q.Get("format"); f == "" || f == "auto" {
+		w.Header().Add("Vary", "Accept")
+	}
+	quality := 80
+	if n, err := strconv.Atoi(q.Get("q")); err == nil {
+		quality = min(max(n, 1), 100)
+	}
+	limit := *imgMaxSize
+	replyVariant(w, r, fullPath, imageVariant{boundParam(q.Get("w"), limit, limit), boundParam(q.Get("h"), limit, limit), format, quality})
+}
+
+// most thumbnails asked in a single thumbs call
+const thumbsMax = 1000
+
+type thumbsCall struct {
+	Paths []string `json:"paths"`
+	W     int      `json:"w"`
+	H     int      `json:"h"`
+}
+
+type thumbsReply struct {
+	Thumbs map[string]string   `json:"thumbs"` // data uris, by path
+	Errors map[string]apiError `json:"errors,omitempty"`
+}
+
+// thumbsAPI serves a POST of {"paths": [...], "w": 256, "h": 256} with the
+// jpeg thumbnails of all those images as data uris, so a gallery page
+// doesn't need a request per photo. Failures are reported by path
+func thumbsAPI(w http.ResponseWriter, r *http.Request) {
+	defer exitPath(w, "thumbs")
+	if r.Method != http.MethodPost {
+		check(badCall("thumbs expects a POST"))
+	}
+	var call thumbsCall
+	check(json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&call))
+	if len(call.Paths) > thumbsMax {
+		check(badCall("too many paths, at most %d per call", thumbsMax))
+	}
+	v := imageVariant{boundParam(strconv.Itoa(call.W), 256, 1024), boundParam(strconv.Itoa(call.H), 256, 1024), "jpeg", 80}
+
+	res := thumbsReply{Thumbs: map[string]string{}, Errors: map[string]apiError{}}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, p := range call.Paths {
+		wg.Add(1)
+		go func() { // rendering is capped by thumbSlots, cached ones are only read
+			defer wg.Done()
+			b, err := thumbOf(p, v)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				_, code := errorStatus(err)
+				res.Errors[p] = apiError{code, errorMessage(err)}
+			} else {
+				res.Thumbs[p] = "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(b)
+			}
+		}()
+	}
+	wg.Wait()
+
+	b, err := json.Marshal(res)
+	check(err)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// thumbOf makes the thumbnail of a path of the share
+func thumbOf(p string, v imageVariant) ([]byte, error) {
+	fullPath, err := safePath(strings.TrimPrefix(p, *extraPath))
+	if err != nil {
+		return nil, err
+	}
+	stat, err := store.Stat(fullPath)
+	if err != nil {
+		return nil, err
+	} else if !stat.Mode().IsRegular() {
+		return nil, badCall("not a file")
+	}
+	return variant(fullPath, stat, v)
+}
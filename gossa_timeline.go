@@ -0,0 +1,175 @@
+package gossa
+
+import (
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// timelinePhoto is what's kept of a photo between scans
+type timelinePhoto struct {
+	Size  int64  `json:"size"`
+	Mtime int64  `json:"mtime"` // unix nano
+	Taken string `json:"taken"` // from exif, else the mtime
+}
+
+type timelineEntry struct {
+	Name  string `json:"name"`
+	Path  string `json:"path"`
+	Href  string `json:"href"`
+	Thumb string `json:"thumb"`
+	Taken string `json:"taken"`
+}
+
+type timelineBucket struct {
+	Month   string          `json:"month"` // e.g. 2024-05
+	Count   int             `json:"count"`
+	Samples []timelineEntry `json:"samples"`
+}
+
+type timeline struct {
+	Path    string           `json:"path"`
+	Total   int              `json:"total"` // photos, of the month with ?month=
+	Buckets []timelineBucket `json:"buckets,omitempty"`
+	Offset  int              `json:"offset,omitempty"`
+	Entries []timelineEntry  `json:"entries,omitempty"`
+}
+
+// photos scanned, by path, persisted so only new and changed photos have their exif read
+var timelineScan = struct {
+	sync.Mutex
+	loaded bool
+	m      map[string]timelinePhoto
+}{m: map[string]timelinePhoto{}}
+
+var monthRe = regexp.MustCompile(`^\d{4}-\d\d$`)
+
+func timelineFile() string {
+	return filepath.Join(cacheDir(), "timeline.json")
+}
+
+// scanPhotos lists the photos below a folder with their capture date,
+// reading the exif of the ones unknown to the last scan
+func scanPhotos(fullPath string) map[string]timelinePhoto {
+	timelineScan.Lock()
+	defer timelineScan.Unlock()
+	if !timelineScan.loaded {
+		if b, err := readFile(timelineFile()); err == nil {
+			json.Unmarshal(b, &timelineScan.m)
+		}
+		timelineScan.loaded = true
+	}
+
+	photos := map[string]timelinePhoto{}
+	dirty := false
+	walkDir(fullPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // unreadable folders are skipped
+		} else if p != fullPath && (isHiddenPath(p) || isInternal(p)) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		} else if d.IsDir() || mediaType(d.Name()) != "image" {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		rel := relPath(p)
+		photo, ok := timelineScan.m[rel]
+		if !ok || photo.Size != info.Size() || photo.Mtime != info.ModTime().UnixNano() {
+			photo = timelinePhoto{Size: info.Size(), Mtime: info.ModTime().UnixNano(), Taken: info.ModTime().Format("2006-01-02T15:04:05")}
+			if exif, err := readExif(p); err == nil && exif.Taken != "" {
+				photo.Taken = exif.Taken
+			}
+			timelineScan.m[rel], dirty = photo, true
+		}
+		photos[rel] = photo
+		return nil
+	})
+
+	prefix := strings.TrimSuffix(relPath(fullPath), "/") + "/"
+	for rel := range timelineScan.m {
+		if _, ok := photos[rel]; !ok && strings.HasPrefix(rel, prefix) {
+			delete(timelineScan.m, rel) // gone since the last scan
+			dirty = true
+		}
+	}
+	if dirty {
+		if b, err := json.Marshal(timelineScan.m); err == nil {
+			storeCached(timelineFile(), b, time.Now())
+		}
+	}
+	return photos
+}
+
+func newTimelineEntry(rel string, photo timelinePhoto) timelineEntry {
+	href := &url.URL{Path: *extraPath + strings.TrimPrefix(rel, "/")}
+	return timelineEntry{Name: filepath.Base(rel), Path: rel, Href: href.EscapedPath(), Thumb: *extraPath + "thumb?" + url.Values{"path": {rel}}.Encode(), Taken: photo.Taken}
+}
+
+// timelineAPI serves api/timeline?path=, the photos below a folder by month
+// of capture, newest first, with a few samples each. &month=2024-05 lists
+// the photos of a month, paged with offset & limit
+func timelineAPI(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	defer exitPath(w, "timeline", q.Get("path"))
+	fullPath := enforcePath(q.Get("path"))
+	stat, err := store.Stat(fullPath)
+	check(err)
+	if !stat.IsDir() {
+		check(badCall("not a folder"))
+	}
+	month := q.Get("month")
+	if month != "" && !monthRe.MatchString(month) {
+		check(badCall("invalid month %s, expected e.g. 2024-05", month))
+	}
+	samples := 4
+	if n, err := strconv.Atoi(q.Get("samples")); err == nil {
+		samples = min(max(n, 0), 20)
+	}
+
+	var entries []timelineEntry
+	for rel, photo := range scanPhotos(fullPath) {
+		if month == "" || strings.HasPrefix(photo.Taken, month) {
+			entries = append(entries, newTimelineEntry(rel, photo))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Taken != entries[j].Taken {
+			return entries[i].Taken > entries[j].Taken
+		}
+		return entries[i].Path < entries[j].Path
+	})
+
+	res := timeline{Path: relPath(fullPath), Total: len(entries)}
+	if month != "" {
+		offset, limit := pageBounds(q)
+		res.Offset = offset
+		res.Entries = page(entries, offset, limit)
+	} else {
+		for _, el := range entries {
+			if n := len(res.Buckets); n == 0 || res.Buckets[n-1].Month != el.Taken[:7] {
+				res.Buckets = append(res.Buckets, timelineBucket{Month: el.Taken[:7], Samples: []timelineEntry{}})
+			}
+			b := &res.Buckets[len(res.Buckets)-1]
+			if b.Count++; len(b.Samples) < samples {
+				b.Samples = append(b.Samples, el)
+			}
+		}
+	}
+	b, err := json.Marshal(res)
+	check(err)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
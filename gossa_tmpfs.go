@@ -0,0 +1,433 @@
+package gossa
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// memNode is a file or folder of a memStorage
+type memNode struct {
+	name     string
+	mode     os.FileMode
+	mtime    time.Time
+	data     []byte
+	children map[string]*memNode // of folders
+}
+
+// memInfo is a snapshot of a node, as stat would see it
+type memInfo struct {
+	name  string
+	size  int64
+	mode  os.FileMode
+	mtime time.Time
+}
+
+func (i memInfo) Name() string       { return i.name }
+func (i memInfo) Size() int64        { return i.size }
+func (i memInfo) Mode() os.FileMode  { return i.mode }
+func (i memInfo) ModTime() time.Time { return i.mtime }
+func (i memInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i memInfo) Sys() any           { return nil }
+
+// memStorage is a filesystem in memory for -tmpfs, starting empty and gone
+// on exit. Files are capped to max bytes in total when max is over 0
+type memStorage struct {
+	sync.RWMutex
+	root *memNode
+	base string // the root path names are below
+	max  int64
+	used int64
+}
+
+func newMemStorage(base string, max int64) *memStorage {
+	return &memStorage{root: &memNode{name: filepath.Base(base), mode: os.ModeDir | 0755, mtime: time.Now(), children: map[string]*memNode{}}, base: base, max: max}
+}
+
+func (n *memNode) info() memInfo {
+	return memInfo{n.name, int64(len(n.data)), n.mode, n.mtime}
+}
+
+// split is the parts of a name below the root, nil for the root itself
+func (s *memStorage) split(op string, name string) ([]string, error) {
+	rel, err := filepath.Rel(s.base, name)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	} else if rel == "." {
+		return nil, nil
+	}
+	return strings.Split(rel, string(filepath.Separator)), nil
+}
+
+// lookup finds the node of a name, to be called locked
+func (s *memStorage) lookup(op string, name string) (*memNode, error) {
+	parts, err := s.split(op, name)
+	if err != nil {
+		return nil, err
+	}
+	n := s.root
+	for _, el := range parts {
+		if n.children == nil {
+			return nil, &os.PathError{Op: op, Path: name, Err: syscall.ENOTDIR}
+		} else if n = n.children[el]; n == nil {
+			return nil, &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+		}
+	}
+	return n, nil
+}
+
+// parent finds the folder a name goes in, to be called locked
+func (s *memStorage) parent(op string, name string) (*memNode, string, error) {
+	parts, err := s.split(op, name)
+	if err != nil {
+		return nil, "", err
+	} else if len(parts) == 0 {
+		return nil, "", &os.PathError{Op: op, Path: name, Err: os.ErrExist} // the root
+	}
+	dir, err := s.lookup(op, filepath.Dir(name))
+	if err != nil {
+		return nil, "", err
+	} else if dir.children == nil {
+		return nil, "", &os.PathError{Op: op, Path: name, Err: syscall.ENOTDIR}
+	}
+	return dir, parts[len(parts)-1], nil
+}
+
+// DiskSpace tells the room left below the cap, if any
+func (s *memStorage) DiskSpace(name string) (uint64, uint64, error) {
+	if s.max <= 0 {
+		return 0, 0, errUnsupported
+	}
+	s.RLock()
+	defer s.RUnlock()
+	return uint64(max(s.max-s.used, 0)), uint64(s.max), nil
+}
+
+func (s *memStorage) Stat(name string) (os.FileInfo, error) {
+	s.RLock()
+	defer s.RUnlock()
+	n, err := s.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return n.info(), nil
+}
+
+func (s *memStorage) Lstat(name string) (os.FileInfo, error) {
+	return s.Stat(name)
+}
+
+func (s *memStorage) ReadDir(name string) ([]os.DirEntry, error) {
+	s.RLock()
+	defer s.RUnlock()
+	n, err := s.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	} else if n.children == nil {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: syscall.ENOTDIR}
+	}
+	entries := make([]os.DirEntry, 0, len(n.children))
+	for _, el := range n.children {
+		entries = append(entries, fs.FileInfoToDirEntry(el.info()))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (s *memStorage) Open(name string) (File, error) {
+	return s.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (s *memStorage) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	s.Lock()
+	defer s.Unlock()
+	n, err := s.lookup("open", name)
+	switch {
+	case err == nil && flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	case err == nil && n.children != nil && flag&(os.O_WRONLY|os.O_RDWR) != 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	case err != nil && (flag&os.O_CREATE == 0 || !os.IsNotExist(err)):
+		return nil, err
+	case err != nil:
+		dir, base, err := s.parent("open", name)
+		if err != nil {
+			return nil, err
+		}
+		n = &memNode{name: base, mode: perm & os.ModePerm, mtime: time.Now()}
+		dir.children[base] = n
+		dir.mtime = n.mtime
+	}
+	if flag&os.O_TRUNC != 0 && flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		s.used -= int64(len(n.data))
+		n.data, n.mtime = nil, time.Now()
+	}
+	return &memFile{s: s, n: n, name: name, flag: flag}, nil
+}
+
+func (s *memStorage) Create(name string) (File, error) {
+	return s.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (s *memStorage) Mkdir(name string, perm os.FileMode) error {
+	s.Lock()
+	defer s.Unlock()
+	return s.mkdir(name, perm)
+}
+
+func (s *memStorage) mkdir(name string, perm os.FileMode) error {
+	dir, base, err := s.parent("mkdir", name)
+	if err != nil {
+		return err
+	} else if dir.children[base] != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	}
+	dir.children[base] = &memNode{name: base, mode: os.ModeDir | perm&os.ModePerm, mtime: time.Now(), children: map[string]*memNode{}}
+	dir.mtime = time.Now()
+	return nil
+}
+
+func (s *memStorage) MkdirAll(name string, perm os.FileMode) error {
+	s.Lock()
+	defer s.Unlock()
+	parts, err := s.split("mkdir", name)
+	if err != nil {
+		return err
+	}
+	p := s.base
+	for _, el := range parts {
+		p = filepath.Join(p, el)
+		if err = s.mkdir(p, perm); err != nil && !os.IsExist(err) {
+			return err
+		} else if n, _ := s.lookup("mkdir", p); n.children == nil {
+			return &os.PathError{Op: "mkdir", Path: p, Err: syscall.ENOTDIR}
+		}
+	}
+	return nil
+}
+
+func (n *memNode) size() int64 {
+	size := int64(len(n.data))
+	for _, el := range n.children {
+		size += el.size()
+	}
+	return size
+}
+
+func (s *memStorage) Rename(oldname string, newname string) error {
+	s.Lock()
+	defer s.Unlock()
+	n, err := s.lookup("rename", oldname)
+	if err != nil {
+		return err
+	} else if n == s.root || strings.HasPrefix(newname, oldname+string(filepath.Separator)) {
+		return &os.PathError{Op: "rename", Path: oldname, Err: syscall.EINVAL}
+	}
+	dir, base, err := s.parent("rename", newname)
+	if err != nil {
+		return err
+	}
+	if old := dir.children[base]; old == n {
+		return nil
+	} else if old != nil && old.children != nil && (n.children == nil || len(old.children) > 0) {
+		return &os.PathError{Op: "rename", Path: newname, Err: syscall.EEXIST}
+	} else if old != nil && old.children == nil && n.children != nil {
+		return &os.PathError{Op: "rename", Path: newname, Err: syscall.ENOTDIR}
+	} else if old != nil {
+		s.used -= old.size()
+	}
+	from, _, _ := s.parent("rename", oldname)
+	delete(from.children, n.name)
+	n.name = base
+	dir.children[base] = n
+	from.mtime, dir.mtime = time.Now(), time.Now()
+	return nil
+}
+
+func (s *memStorage) Remove(name string) error {
+	s.Lock()
+	defer s.Unlock()
+	n, err := s.lookup("remove", name)
+	if err != nil {
+		return err
+	} else if len(n.children) > 0 {
+		return &os.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+	}
+	return s.remove(name, n)
+}
+
+func (s *memStorage) remove(name string, n *memNode) error {
+	dir, base, err := s.parent("remove", name)
+	if err != nil {
+		return err
+	}
+	s.used -= n.size()
+	delete(dir.children, base)
+	dir.mtime = time.Now()
+	return nil
+}
+
+func (s *memStorage) RemoveAll(name string) error {
+	s.Lock()
+	defer s.Unlock()
+	n, err := s.lookup("remove", name)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return s.remove(name, n)
+}
+
+func (s *memStorage) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	s.Lock()
+	defer s.Unlock()
+	n, err := s.lookup("chtimes", name)
+	if err == nil {
+		n.mtime = mtime
+	}
+	return err
+}
+
+func (s *memStorage) Chmod(name string, mode os.FileMode) error {
+	s.Lock()
+	defer s.Unlock()
+	n, err := s.lookup("chmod", name)
+	if err == nil {
+		n.mode = n.mode&os.ModeType | mode&os.ModePerm
+	}
+	return err
+}
+
+// memFile is a node opened, reading and writing it under the lock of its storage
+type memFile struct {
+	s    *memStorage
+	n    *memNode
+	name string
+	flag int
+	off  int64
+	dirLister
+}
+
+func (f *memFile) Name() string { return f.name }
+
+func (f *memFile) Stat() (os.FileInfo, error) {
+	f.s.RLock()
+	defer f.s.RUnlock()
+	return f.n.info(), nil
+}
+
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	if f.n.children != nil {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: syscall.EISDIR}
+	} else if f.flag&os.O_WRONLY != 0 {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: os.ErrPermission}
+	}
+	f.s.RLock()
+	defer f.s.RUnlock()
+	if off >= int64(len(f.n.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.n.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.off)
+	f.off += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (f *memFile) WriteAt(p []byte, off int64) (int, error) {
+	if f.flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: os.ErrPermission}
+	}
+	f.s.Lock()
+	defer f.s.Unlock()
+	if err := f.resize(max(off+int64(len(p)), int64(len(f.n.data)))); err != nil {
+		return 0, err
+	}
+	copy(f.n.data[off:], p)
+	f.n.mtime = time.Now()
+	return len(p), nil
+}
+
+// resize grows or shrinks the data of the file, within the cap, to be called locked
+func (f *memFile) resize(size int64) error {
+	grow := size - int64(len(f.n.data))
+	if f.s.max > 0 && grow > 0 && f.s.used+grow > f.s.max {
+		return &os.PathError{Op: "write", Path: f.name, Err: syscall.ENOSPC}
+	}
+	if size <= int64(cap(f.n.data)) {
+		f.n.data = f.n.data[:size]
+	} else {
+		data := make([]byte, size, max(size, 2*int64(cap(f.n.data))))
+		copy(data, f.n.data)
+		f.n.data = data
+	}
+	for i := size - grow; i < size && grow > 0; i++ {
+		f.n.data[i] = 0 // what a previous shrink left in the backing array
+	}
+	f.s.used += grow
+	return nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	if f.flag&os.O_APPEND != 0 {
+		f.s.RLock()
+		f.off = int64(len(f.n.data))
+		f.s.RUnlock()
+	}
+	n, err := f.WriteAt(p, f.off)
+	f.off += int64(n)
+	return n, err
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		f.s.RLock()
+		offset += int64(len(f.n.data))
+		f.s.RUnlock()
+	}
+	if offset < 0 {
+		return f.off, &os.PathError{Op: "seek", Path: f.name, Err: syscall.EINVAL}
+	}
+	f.off = offset
+	return offset, nil
+}
+
+func (f *memFile) Truncate(size int64) error {
+	if f.flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: os.ErrPermission}
+	}
+	f.s.Lock()
+	defer f.s.Unlock()
+	f.n.mtime = time.Now()
+	return f.resize(size)
+}
+
+func (f *memFile) Close() error {
+	return nil
+}
+
+func (f *memFile) Readdir(count int) ([]os.FileInfo, error) {
+	if f.n.children == nil {
+		return nil, &os.PathError{Op: "readdir", Path: f.name, Err: syscall.ENOTDIR}
+	}
+	return f.readdir(func() ([]os.DirEntry, error) { return f.s.ReadDir(f.name) }, count)
+}
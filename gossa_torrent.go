@@ -0,0 +1,196 @@
+package gossa
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// bencode encodes strings, ints, lists and dicts as found in .torrent files
+func bencode(buf *bytes.Buffer, v any) {
+	switch v := v.(type) {
+	case string:
+		fmt.Fprintf(buf, "%d:%s", len(v), v)
+	case []byte:
+		fmt.Fprintf(buf, "%d:%s", len(v), v)
+	case int64:
+		fmt.Fprintf(buf, "i%de", v)
+	case int:
+		fmt.Fprintf(buf, "i%de", v)
+	case []any:
+		buf.WriteByte('l')
+		for _, el := range v {
+			bencode(buf, el)
+		}
+		buf.WriteByte('e')
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys) // dict keys must be sorted
+		buf.WriteByte('d')
+		for _, k := range keys {
+			bencode(buf, k)
+			bencode(buf, v[k])
+		}
+		buf.WriteByte('e')
+	default:
+		panic(fmt.Errorf("cant bencode %T", v))
+	}
+}
+
+type torrentFile struct {
+	fullPath string
+	segs     []string
+	size     int64
+}
+
+// torrentPieceLength aims for ~1500 pieces, between 256KiB and 16MiB
+func torrentPieceLength(total int64) int64 {
+	l := int64(256 << 10)
+	for l < 16<<20 && total/l > 1500 {
+		l *= 2
+	}
+	return l
+}
+
+// torrentFiles lists the files that go in a torrent, following the rules of zip downloads
+func torrentFiles(fullPath string) ([]torrentFile, error) {
+	var files []torrentFile
+	err := walk(fullPath, func(path string, f fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(fullPath, path)
+		if err != nil {
+			return err
+		}
+		if rel != "." && (isHiddenPath(path) || isInternal(path)) {
+			if f.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil // hidden files not allowed
+		}
+		if !f.Mode().IsRegular() {
+			return nil // folders, symlinks and devices
+		}
+		files = append(files, torrentFile{path, strings.Split(filepath.ToSlash(rel), "/"), f.Size()})
+		return nil
+	})
+	return files, err
+}
+
+// makeTorrent builds the metainfo of a file or folder, webseeded from seedURL
+func makeTorrent(fullPath string, seedURL string) ([]byte, error) {
+	if fullPath == rootPath {
+		return nil, badCall("cant share the root as a torrent, its name isnt part of the urls") // see BEP 19
+	}
+	stat, err := store.Stat(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	files, err := torrentFiles(fullPath)
+	if err != nil {
+		return nil, err
+	} else if len(files) == 0 {
+		return nil, badCall("nothing to share in %s", fullPath)
+	}
+
+	total := int64(0)
+	for _, f := range files {
+		total += f.size
+	}
+	pieceLength := torrentPieceLength(total)
+
+	// pieces span files, as if all files were concatenated
+	var pieces bytes.Buffer
+	piece := sha1.New()
+	inPiece := int64(0)
+	for _, f := range files {
+		file, err := store.Open(f.fullPath)
+		if err != nil {
+			return nil, err
+		}
+		for {
+			n, err := io.CopyN(piece, file, pieceLength-inPiece)
+			inPiece += n
+			if inPiece == pieceLength {
+				pieces.Write(piece.Sum(nil))
+				piece.Reset()
+				inPiece = 0
+			}
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				file.Close()
+				return nil, err
+			}
+		}
+		file.Close()
+	}
+	if inPiece > 0 {
+		pieces.Write(piece.Sum(nil))
+	}
+
+	info := map[string]any{
+		"name":         stat.Name(),
+		"piece length": pieceLength,
+		"pieces":       pieces.Bytes(),
+	}
+	if stat.IsDir() {
+		list := []any{}
+		for _, f := range files {
+			segs := []any{}
+			for _, s := range f.segs {
+				segs = append(segs, s)
+			}
+			list = append(list, map[string]any{"length": f.size, "path": segs})
+		}
+		info["files"] = list
+	} else {
+		info["length"] = stat.Size()
+	}
+
+	meta := map[string]any{
+		"info":       info,
+		"url-list":   []any{seedURL},
+		"created by": "gossa",
+	}
+	if trackers := splitList(*torrentTrackers); len(trackers) > 0 {
+		meta["announce"] = trackers[0]
+		list := []any{}
+		for _, el := range trackers {
+			list = append(list, []any{el})
+		}
+		meta["announce-list"] = list
+	}
+
+	var buf bytes.Buffer
+	bencode(&buf, meta)
+	return buf.Bytes(), nil
+}
+
+// replyTorrent answers ?torrent requests, for both files and folders
+func replyTorrent(w http.ResponseWriter, r *http.Request, fullPath string, isDir bool) {
+	// per BEP 19, clients append the torrent name (and the file path for folders) to the webseed
+	seedPath := r.URL.Path
+	if isDir {
+		seedPath = strings.TrimSuffix(seedPath, "/")
+	}
+	seedPath = seedPath[:strings.LastIndex(seedPath, "/")+1]
+	seed := publicURL(r, seedPath)
+
+	torrent, err := makeTorrent(fullPath, seed.String())
+	check(err)
+	w.Header().Set("Content-Type", "application/x-bittorrent")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filepath.Base(fullPath) + ".torrent"}))
+	w.Write(torrent)
+}
@@ -0,0 +1,184 @@
+package gossa
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+)
+
+const trashDirName = ".gossa-trash"
+
+type trashInfo struct {
+	ID      string    `json:"id"`
+	Origin  string    `json:"origin"` // relative to the root
+	Deleted time.Time `json:"deleted"`
+	IsDir   bool      `json:"isDir"`
+}
+
+func trashDir() string {
+	return filepath.Join(rootPath, trashDirName)
+}
+
+// moveAny renames, falling back to copy & delete across devices. Like a
+// rename, an existing file at dst is replaced
+func moveAny(src string, dst string) error {
+	err := store.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	rnd := make([]byte, 4)
+	rand.Read(rnd)
+	tmp := filepath.Join(filepath.Dir(dst), ".gossa-mv-"+hex.EncodeToString(rnd))
+	if err = copyPath(context.Background(), src, tmp); err != nil {
+		store.RemoveAll(tmp)
+		return err
+	}
+	if err = store.Rename(tmp, dst); err != nil {
+		store.RemoveAll(tmp)
+		return err
+	}
+	return store.RemoveAll(src)
+}
+
+// discard deletes a path for the file protocols, through the trash when enabled
+func discard(fullPath string) error {
+	var err error
+	if *trash {
+		_, err = trashPut(fullPath)
+	} else {
+		err = store.RemoveAll(fullPath)
+	}
+	if err == nil {
+		notify("delete", fullPath, "")
+		changed(fullPath)
+	}
+	return err
+}
+
+// trashPut moves a path into the trash, and records where it came from.
+// Items are stored as .gossa-trash/<id>/<name>, along with .gossa-trash/<id>/info.json
+func trashPut(fullPath string) (string, error) {
+	stat, err := store.Lstat(fullPath)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(rootPath, fullPath)
+	if err != nil {
+		return "", err
+	}
+
+	rnd := make([]byte, 4)
+	rand.Read(rnd)
+	info := trashInfo{fmt.Sprintf("%d-%s", time.Now().UnixNano(), hex.EncodeToString(rnd)), filepath.ToSlash(rel), time.Now(), stat.IsDir()}
+	item := filepath.Join(trashDir(), info.ID)
+	if err = store.MkdirAll(item, 0700); err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(info)
+	if err != nil {
+		return "", err
+	}
+	if err = writeFile(filepath.Join(item, "info.json"), b, 0600); err != nil {
+		return "", err
+	}
+	if err = moveAny(fullPath, filepath.Join(item, stat.Name())); err != nil {
+		store.RemoveAll(item)
+		return "", err
+	}
+	return info.ID, nil
+}
+
+func trashGet(id string) (*trashInfo, error) {
+	if id != filepath.Base(id) {
+		return nil, badCall("invalid trash id")
+	}
+	b, err := readFile(filepath.Join(trashDir(), id, "info.json"))
+	if err != nil {
+		return nil, err
+	}
+	info := &trashInfo{}
+	return info, json.Unmarshal(b, info)
+}
+
+func trashItems() ([]*trashInfo, error) {
+	entries, err := store.ReadDir(trashDir())
+	if errors.Is(err, os.ErrNotExist) {
+		return []*trashInfo{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	items := []*trashInfo{}
+	for _, el := range entries {
+		if info, err := trashGet(el.Name()); err == nil {
+			items = append(items, info)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Deleted.After(items[j].Deleted) })
+	return items, nil
+}
+
+// trashList lists the trash content as json, most recent first
+func trashList() ([]byte, error) {
+	items, err := trashItems()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(items)
+}
+
+// trashRestore moves an item back where it came from, if nothing took its place since
+func trashRestore(id string) error {
+	info, err := trashGet(id)
+	if err != nil {
+		return err
+	}
+	dst := enforcePath("/" + info.Origin)
+	if _, err := store.Lstat(dst); err == nil {
+		return fmt.Errorf("cant restore, %s: %w", info.Origin, os.ErrExist)
+	}
+	if err = store.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	item := filepath.Join(trashDir(), id)
+	if err = moveAny(filepath.Join(item, filepath.Base(dst)), dst); err != nil {
+		return err
+	}
+	changed(dst)
+	return store.RemoveAll(item)
+}
+
+// trashPurge deletes an item for good, or the whole trash if no id is given
+func trashPurge(id string) error {
+	if id == "" {
+		return store.RemoveAll(trashDir())
+	}
+	if _, err := trashGet(id); err != nil {
+		return err
+	}
+	return store.RemoveAll(filepath.Join(trashDir(), id))
+}
+
+// trashJanitor purges items older than the retention period, forever
+func trashJanitor() {
+	for {
+		items, err := trashItems()
+		if err != nil {
+			log.Println("error - cant list trash", err)
+		}
+		for _, el := range items {
+			if time.Since(el.Deleted) > *trashRetention {
+				trashPurge(el.ID)
+			}
+		}
+		time.Sleep(time.Hour)
+	}
+}
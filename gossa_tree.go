@@ -0,0 +1,71 @@
+package gossa
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strconv"
+)
+
+type treeNode struct {
+	Name        string      `json:"name"`
+	Path        string      `json:"path"`
+	HasChildren bool        `json:"hasChildren"`
+	Children    []*treeNode `json:"children,omitempty"` // absent past the requested depth, fetch again from this node to expand
+}
+
+const treeMaxDepth = 8
+
+// subdirs lists the folders of a folder, hidden, ignored and internal ones excepted
+func subdirs(fullPath string) []string {
+	entries, err := store.ReadDir(fullPath)
+	if err != nil {
+		return nil
+	}
+	var dirs []string
+	ig := ignores{}
+	for _, el := range entries {
+		p := filepath.Join(fullPath, el.Name())
+		if !el.IsDir() || isHiddenPath(p) || isInternal(p) || ig.match(p, true) {
+			continue
+		}
+		dirs = append(dirs, p)
+	}
+	return dirs // already sorted by name
+}
+
+func buildTree(fullPath string, depth int) *treeNode {
+	node := &treeNode{Name: filepath.Base(fullPath), Path: relPath(fullPath)}
+	if fullPath == rootPath {
+		node.Name = ""
+	}
+	dirs := subdirs(fullPath)
+	node.HasChildren = len(dirs) > 0
+	if depth > 0 && node.HasChildren {
+		node.Children = []*treeNode{}
+		for _, el := range dirs {
+			node.Children = append(node.Children, buildTree(el, depth-1))
+		}
+	}
+	return node
+}
+
+// treeAPI answers api/tree?path=/some/folder&depth=2 with the folders below a path
+func treeAPI(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	defer exitPath(w, "tree", q.Get("path"))
+	fullPath := enforcePath(q.Get("path"))
+	stat, err := store.Stat(fullPath)
+	check(err)
+	if !stat.IsDir() {
+		check(badCall("not a folder"))
+	}
+	depth, err := strconv.Atoi(q.Get("depth"))
+	if err != nil || depth < 1 {
+		depth = 1
+	}
+	b, err := json.Marshal(buildTree(fullPath, min(depth, treeMaxDepth)))
+	check(err)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
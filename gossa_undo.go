@@ -0,0 +1,70 @@
+package gossa
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+type journalEntry struct {
+	op      string // mv, rm or upload
+	src     string
+	dst     string
+	trashID string
+}
+
+// recent operations, most recent last
+var journal []journalEntry
+var journalLock sync.Mutex
+
+const journalSize = 32
+
+func journalPush(e journalEntry) {
+	journalLock.Lock()
+	defer journalLock.Unlock()
+	journal = append(journal, e)
+	if len(journal) > journalSize {
+		journal = journal[len(journal)-journalSize:]
+	}
+}
+
+func journalPop() (journalEntry, bool) {
+	journalLock.Lock()
+	defer journalLock.Unlock()
+	if len(journal) == 0 {
+		return journalEntry{}, false
+	}
+	e := journal[len(journal)-1]
+	journal = journal[:len(journal)-1]
+	return e, true
+}
+
+// undo reverses the last journaled operation. A failed undo is dropped from
+// the journal, so the one before can still be undone.
+func undo() error {
+	e, ok := journalPop()
+	if !ok {
+		return badCall("nothing to undo")
+	}
+
+	switch e.op {
+	case "mv":
+		if _, err := store.Lstat(e.src); err == nil {
+			return fmt.Errorf("cant undo mv, %s: %w", e.src, os.ErrExist)
+		}
+		defer changed(e.src)
+		defer changed(e.dst)
+		return moveAny(e.dst, e.src)
+	case "rm":
+		return trashRestore(e.trashID)
+	case "upload":
+		defer changed(e.dst)
+		if *trash {
+			_, err := trashPut(e.dst)
+			return err
+		}
+		return store.Remove(e.dst)
+	}
+	return errors.New("cant undo " + e.op)
+}
@@ -0,0 +1,102 @@
+package gossa
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const versionsDirName = ".versions"
+
+type fileVersion struct {
+	ID      string    `json:"id"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// versionsOf returns where the previous versions of a file are kept, e.g.
+// .versions/some/file.txt/<id>
+func versionsOf(fullPath string) string {
+	rel, err := filepath.Rel(rootPath, fullPath)
+	check(err)
+	return filepath.Join(rootPath, versionsDirName, rel)
+}
+
+// keepVersion moves a file about to be replaced along its previous versions,
+// and drops the oldest ones past the retention count
+func keepVersion(fullPath string) error {
+	stat, err := store.Lstat(fullPath)
+	if *versions <= 0 || err != nil || !stat.Mode().IsRegular() {
+		return nil // nothing replaced, or not a file
+	}
+	dir := versionsOf(fullPath)
+	if err = store.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if err = moveAny(fullPath, filepath.Join(dir, strconv.FormatInt(time.Now().UnixNano(), 10))); err != nil {
+		return err
+	}
+
+	list, err := versionsList(fullPath)
+	if err != nil {
+		return err
+	}
+	for _, el := range list[min(len(list), *versions):] {
+		store.Remove(filepath.Join(dir, el.ID))
+	}
+	return nil
+}
+
+// versionsList lists the kept versions of a file, most recent first
+func versionsList(fullPath string) ([]fileVersion, error) {
+	entries, err := store.ReadDir(versionsOf(fullPath))
+	if errors.Is(err, os.ErrNotExist) {
+		return []fileVersion{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	list := []fileVersion{}
+	for _, el := range entries {
+		info, err := el.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		list = append(list, fileVersion{el.Name(), info.Size(), info.ModTime()})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID > list[j].ID })
+	return list, nil
+}
+
+func versionsListJSON(fullPath string) ([]byte, error) {
+	list, err := versionsList(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(list)
+}
+
+// versionRestore puts back a previous version, the current one becoming a version itself
+func versionRestore(fullPath string, id string) error {
+	if id != filepath.Base(id) {
+		return badCall("invalid version id")
+	}
+	src := filepath.Join(versionsOf(fullPath), id)
+	if _, err := store.Lstat(src); err != nil {
+		return err
+	}
+	// keep a copy aside first, restoring may prune the version we restore
+	tmp := src + ".restoring"
+	if err := store.Rename(src, tmp); err != nil {
+		return err
+	}
+	if err := keepVersion(fullPath); err != nil {
+		store.Rename(tmp, src)
+		return err
+	}
+	defer changed(fullPath)
+	return store.Rename(tmp, fullPath)
+}
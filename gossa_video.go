@@ -0,0 +1,139 @@
+package gossa
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var videoExts = map[string]bool{"mp4": true, "m4v": true, "mkv": true, "webm": true, "mov": true, "avi": true, "wmv": true, "flv": true, "mpg": true, "mpeg": true, "ts": true, "ogv": true, "3gp": true}
+
+// ffmpegBin is the ffmpeg found at startup, empty if none
+var ffmpegBin string
+
+// caps how many ffmpeg run at once, sized by -ffmpeg-procs
+var ffmpegSlots chan struct{}
+
+// ffmpegEncoders are the encoders of the found ffmpeg for the formats go can't
+// encode, e.g. avif: libaom-av1
+var ffmpegEncoders = map[string]string{}
+
+func ffmpegSetup() {
+	ffmpegSlots = make(chan struct{}, max(1, *ffmpegProcs))
+	if *ffmpeg == "" {
+		return
+	}
+	bin, err := exec.LookPath(*ffmpeg)
+	if err != nil {
+		if *verb {
+			fmt.Println("no ffmpeg, video thumbnails, webp and avif disabled:", err)
+		}
+		return
+	}
+	ffmpegBin = bin
+	ctx, cancel := context.WithTimeout(context.Background(), *ffmpegTimeout)
+	defer cancel()
+	encoders, _ := exec.CommandContext(ctx, bin, "-hide_banner", "-encoders").Output()
+	for _, el := range [][2]string{{"webp", "libwebp"}, {"avif", "libaom-av1"}, {"avif", "libsvtav1"}} {
+		if ffmpegEncoders[el[0]] == "" && bytes.Contains(encoders, []byte(" "+el[1]+" ")) {
+			ffmpegEncoders[el[0]] = el[1]
+		}
+	}
+}
+
+func isVideo(fullPath string) bool {
+	return videoExts[strings.ToLower(strings.TrimPrefix(filepath.Ext(fullPath), "."))]
+}
+
+// videoFrame grabs a poster frame of a video with ffmpeg, a few seconds in
+// to skip black intros, or the first frame of shorter videos
+func videoFrame(fullPath string) (image.Image, error) {
+	if ffmpegBin == "" {
+		return nil, fmt.Errorf("%w: no ffmpeg for video thumbnails", errNotImage)
+	}
+	ffmpegSlots <- struct{}{}
+	defer func() { <-ffmpegSlots }()
+
+	var err error
+	for _, at := range []string{"3", "0"} {
+		var img image.Image
+		if img, err = ffmpegFrame(fullPath, at); err == nil {
+			return img, nil
+		}
+	}
+	return nil, err
+}
+
+// ffmpegInput opens a file through the storage for ffmpeg, which is given the
+// local file read by the storage, or the file piped in on stdin for the
+// backends with no local file, e.g. -tmpfs or -s3-backend
+func ffmpegInput(fullPath string) (input string, stdin io.Reader, close func() error, err error) {
+	f, err := store.Open(fullPath)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if raw, ok := sendable(f).(*os.File); ok {
+		return raw.Name(), nil, f.Close, nil
+	}
+	return "pipe:0", f, f.Close, nil
+}
+
+func ffmpegFrame(fullPath string, at string) (image.Image, error) {
+	input, stdin, closeInput, err := ffmpegInput(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	defer closeInput()
+	ctx, cancel := context.WithTimeout(context.Background(), *ffmpegTimeout)
+	defer cancel()
+	var out, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, ffmpegBin, "-nostdin", "-loglevel", "error", "-ss", at, "-i", input,
+		"-frames:v", "1", "-f", "image2pipe", "-vcodec", "png", "-")
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdin, &out, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%w: ffmpeg %v %s", errNotImage, err, strings.TrimSpace(stderr.String()))
+	}
+	img, _, err := image.Decode(&out)
+	if err != nil {
+		return nil, fmt.Errorf("%w: no frame at %ss", errNotImage, at)
+	}
+	return img, nil
+}
+
+// ffmpegEncode encodes an image as webp or avif, which go has no encoders for
+func ffmpegEncode(img image.Image, format string, quality int) ([]byte, error) {
+	codec := ffmpegEncoders[format]
+	if codec == "" {
+		return nil, fmt.Errorf("%w: no ffmpeg encoder for %s", errNotImage, format)
+	}
+	var in bytes.Buffer
+	if err := png.Encode(&in, img); err != nil {
+		return nil, err
+	}
+	ffmpegSlots <- struct{}{}
+	defer func() { <-ffmpegSlots }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *ffmpegTimeout)
+	defer cancel()
+	args := []string{"-loglevel", "error", "-f", "png_pipe", "-i", "-", "-c:v", codec}
+	if format == "webp" {
+		args = append(args, "-quality", strconv.Itoa(quality))
+	} else {
+		args = append(args, "-still-picture", "1", "-crf", strconv.Itoa((100-quality)*63/100))
+	}
+	var out, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, ffmpegBin, append(args, "-f", format, "-")...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = &in, &out, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg %s: %v %s", format, err, strings.TrimSpace(stderr.String()))
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,104 @@
+package gossa
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type walkEntry struct {
+	Path  string    `json:"path"`
+	Size  int64     `json:"size"`
+	Mtime time.Time `json:"mtime"`
+}
+
+type walkResult struct {
+	Entries   []walkEntry `json:"entries"`
+	Truncated bool        `json:"truncated"` // more files past the limit
+}
+
+var errWalkDone = errors.New("walk done")
+
+// walkFiles lists the files below fullPath, down to depth folders (0 for no
+// limit), keeping those matching glob. Globs with a slash are matched against
+// the path relative to fullPath, others against the file name
+func walkFiles(fullPath string, depth int, glob string, limit int) (*walkResult, error) {
+	if _, err := path.Match(glob, ""); err != nil {
+		return nil, badCall("invalid pattern %s", glob)
+	}
+	res := &walkResult{Entries: []walkEntry{}}
+	ig := ignores{}
+	err := walkDir(fullPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // unreadable folders are skipped
+		} else if p == fullPath {
+			return nil
+		}
+		if isHiddenPath(p) || isInternal(p) || ig.match(p, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel := filepath.ToSlash(strings.TrimPrefix(p, fullPath+string(filepath.Separator)))
+		if d.IsDir() {
+			if depth > 0 && strings.Count(rel, "/")+1 >= depth {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		name := d.Name()
+		if strings.Contains(glob, "/") {
+			name = rel
+		}
+		if glob != "" {
+			if ok, _ := path.Match(glob, name); !ok {
+				return nil
+			}
+		}
+		if len(res.Entries) == limit {
+			res.Truncated = true
+			return errWalkDone
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		res.Entries = append(res.Entries, walkEntry{Path: relPath(p), Size: info.Size(), Mtime: info.ModTime()})
+		return nil
+	})
+	if err != nil && err != errWalkDone {
+		return nil, err
+	}
+	return res, nil
+}
+
+// walkAPI answers api/walk?path=/some/folder&depth=2&glob=*.jpg&limit=100 with
+// every file below a path, in one go
+func walkAPI(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	defer exitPath(w, "walk", q.Get("path"))
+	fullPath := enforcePath(q.Get("path"))
+	stat, err := store.Stat(fullPath)
+	check(err)
+	if !stat.IsDir() {
+		check(badCall("not a folder"))
+	}
+	depth, _ := strconv.Atoi(q.Get("depth"))
+	limit := *walkMaxResults
+	if n, err := strconv.Atoi(q.Get("limit")); err == nil && n > 0 && n < limit {
+		limit = n
+	}
+	res, err := walkFiles(fullPath, max(depth, 0), q.Get("glob"), limit)
+	check(err)
+	b, err := json.Marshal(res)
+	check(err)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
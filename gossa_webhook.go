@@ -0,0 +1,121 @@
+package gossa
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"time"
+)
+
+type webhookEvent struct {
+	Event string    `json:"event"` // upload, delete, rename or mkdir
+	Path  string    `json:"path"`
+	Dest  string    `json:"dest,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+const webhookRetries = 5
+
+var webhookQueue = make(chan webhookEvent, 256)
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// relPath turns a full path back into the path as seen by clients
+func relPath(fullPath string) string {
+	rel, err := filepath.Rel(rootPath, fullPath)
+	if err != nil || rel == "." {
+		return "/"
+	}
+	return "/" + filepath.ToSlash(rel)
+}
+
+// notify queues an event for the configured webhooks, never blocking the caller
+func notify(event string, fullPath string, dest string) {
+	if *webhooks == "" {
+		return
+	}
+	e := webhookEvent{Event: event, Path: relPath(fullPath), Time: time.Now()}
+	if dest != "" {
+		e.Dest = relPath(dest)
+	}
+	select {
+	case webhookQueue <- e:
+	default:
+		log.Println("error - webhook queue full, dropping", e)
+	}
+}
+
+// webhookSign returns the hex encoded HMAC-SHA256 of a body
+func webhookSign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(*webhookSecret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func webhookPost(url string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "gossa")
+	if *webhookSecret != "" {
+		req.Header.Set("X-Gossa-Signature", "sha256="+webhookSign(body))
+	}
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s replied %s", url, resp.Status)
+	}
+	return nil
+}
+
+// webhookDeliver posts an event to a url, retrying with an exponential backoff
+func webhookDeliver(url string, body []byte) {
+	backoff := time.Second
+	for i := 0; ; i++ {
+		err := webhookPost(url, body)
+		if err == nil {
+			return
+		} else if i == webhookRetries {
+			log.Println("error - webhook dropped after retries", url, err)
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// webhookWorker sends queued events, in order for each url
+func webhookWorker() {
+	urls := splitList(*webhooks)
+	queues := make([]chan []byte, len(urls))
+	for i, url := range urls {
+		queues[i] = make(chan []byte, 64)
+		go func(url string, q chan []byte) {
+			for body := range q {
+				webhookDeliver(url, body)
+			}
+		}(url, queues[i])
+	}
+
+	for e := range webhookQueue {
+		body, err := json.Marshal(e)
+		check(err)
+		for _, q := range queues {
+			select {
+			case q <- body:
+			default:
+				log.Println("error - webhook backlog full, dropping", e)
+			}
+		}
+	}
+}
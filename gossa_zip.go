@@ -0,0 +1,240 @@
+package gossa
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"context"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// zipInlineMax is the size up to which files are read, and compressed, ahead
+// by the workers. Larger ones are streamed by the writer once their turn comes
+const zipInlineMax = 1 << 20
+
+// zipAhead is how many files may be walked past the one being written
+const zipAhead = 64
+
+// zipJob is a file of a zip, made ahead when small
+type zipJob struct {
+	header *zip.FileHeader
+	path   string
+	inline bool   // read ahead by the workers
+	data   []byte // of inline files, compressed as header.Method says
+	err    error
+	done   chan struct{}
+}
+
+// storedExts are the formats compressed already, stored as is even with -zip-compress
+var storedExts = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".avif": true, ".heic": true, ".heif": true, ".jxl": true,
+	".mp4": true, ".m4v": true, ".mkv": true, ".webm": true, ".mov": true, ".mp3": true, ".m4a": true, ".aac": true, ".ogg": true, ".opus": true, ".flac": true,
+	".zip": true, ".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".zst": true, ".br": true, ".lz4": true, ".7z": true, ".rar": true,
+	".jar": true, ".apk": true, ".docx": true, ".xlsx": true, ".pptx": true, ".odt": true, ".ods": true, ".epub": true, ".woff": true, ".woff2": true,
+}
+
+// storedTypes are the sniffed types compressed already, for files named without their extension
+var storedTypes = map[string]bool{
+	"image/jpeg": true, "image/png": true, "image/gif": true, "image/webp": true, "video/mp4": true, "video/webm": true, "audio/mpeg": true,
+	"application/ogg": true, "application/zip": true, "application/x-gzip": true, "application/x-rar-compressed": true, "font/woff": true, "font/woff2": true,
+}
+
+// sniffMethod stores files found compressed already from their first bytes
+func sniffMethod(h *zip.FileHeader, head []byte) {
+	if h.Method == zip.Deflate && storedTypes[http.DetectContentType(head)] {
+		h.Method = zip.Store
+	}
+}
+
+var flateWriters = sync.Pool{New: func() any {
+	w, _ := flate.NewWriter(nil, flate.BestSpeed)
+	return w
+}}
+
+// writeZip zips a folder, walking it, reading and compressing its files in a
+// pipeline of goroutines while the zip is written in the walk order. It stops
+// as soon as ctx is done
+func writeZip(ctx context.Context, w io.Writer, zipFullPath string, excludes []string) {
+	zipWriter := zip.NewWriter(w) // not closed on failures, so aborted zips don't look complete
+
+	ordered := make(chan *zipJob, zipAhead)
+	work := make(chan *zipJob, zipAhead)
+	stop := make(chan struct{})
+	defer close(stop) // the walk stops with the writer, e.g. once the client is gone
+	var walkErr error
+	go func() {
+		defer close(ordered)
+		defer close(work)
+		defer func() {
+			if r := recover(); r != nil {
+				walkErr = asError(r)
+			}
+		}()
+		walkErr = zipTree(ctx, zipFullPath, zipFullPath, "", excludes, ignores{}, true, func(j *zipJob) bool {
+			select {
+			case ordered <- j:
+			case <-stop:
+				return false
+			}
+			if !j.inline {
+				close(j.done)
+				return true
+			}
+			select {
+			case work <- j:
+				return true
+			case <-stop:
+				return false
+			}
+		})
+	}()
+	for i := 0; i < runtime.NumCPU(); i++ {
+		go func() {
+			for j := range work {
+				j.read()
+				close(j.done)
+			}
+		}()
+	}
+
+	for j := range ordered {
+		<-j.done
+		check(ctx.Err())
+		check(j.err)
+		if j.inline {
+			fw, err := zipWriter.CreateRaw(j.header)
+			check(err)
+			_, err = fw.Write(j.data)
+			check(err)
+			continue
+		}
+		file, err := store.Open(j.path)
+		check(err)
+		head := make([]byte, 512)
+		n, err := io.ReadFull(file, head)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			file.Close()
+			check(err)
+		}
+		sniffMethod(j.header, head[:n])
+		fw, err := zipWriter.CreateHeader(j.header)
+		check(err)
+		_, err = fw.Write(head[:n])
+		if err == nil {
+			_, err = copyBuffer(fw, ctxReader{ctx, file})
+		}
+		file.Close()
+		check(err)
+	}
+	check(walkErr)
+	check(zipWriter.Close())
+}
+
+// read reads a small file ahead, compressed if it's worth it
+func (j *zipJob) read() {
+	file, err := store.Open(j.path)
+	if err != nil {
+		j.err = err
+		return
+	}
+	defer file.Close()
+	if j.data, j.err = io.ReadAll(file); j.err != nil {
+		return
+	}
+	h := j.header
+	sniffMethod(h, j.data)
+	h.CRC32 = crc32.ChecksumIEEE(j.data)
+	h.UncompressedSize64 = uint64(len(j.data))
+	if h.Method == zip.Deflate {
+		var buf bytes.Buffer
+		fw := flateWriters.Get().(*flate.Writer)
+		fw.Reset(&buf)
+		fw.Write(j.data)
+		fw.Close()
+		flateWriters.Put(fw)
+		if buf.Len() < len(j.data) {
+			j.data = buf.Bytes()
+		} else {
+			h.Method = zip.Store // incompressible
+		}
+	}
+	h.CompressedSize64 = uint64(len(j.data))
+	rawHeader(h)
+}
+
+// rawHeader sets up what zip.Writer.CreateHeader would, but CreateRaw doesn't:
+// utf-8 names, and mtimes to the second in the extended timestamp format
+func rawHeader(h *zip.FileHeader) {
+	if !isASCII(h.Name) && utf8.ValidString(h.Name) {
+		h.Flags |= 0x800
+	}
+	if !h.Modified.IsZero() {
+		mt := uint32(h.Modified.Unix())
+		h.Extra = append(h.Extra, 0x55, 0x54, 5, 0, 1, byte(mt), byte(mt>>8), byte(mt>>16), byte(mt>>24)) // id 0x5455, 5 bytes, mtime only
+	}
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// zipTree walks what's below a path, handing each file to emit, named from prefix,
+// until it returns false. Symlinks into the folders of -symlinks-allow are
+// followed, but not the ones met past them. Names are relative to the base
+// folder being zipped, which tells what's hidden past symlinks
+func zipTree(ctx context.Context, base string, zipFullPath string, prefix string, excludes []string, ig ignores, follow bool, emit func(*zipJob) bool) error {
+	return walk(zipFullPath, func(path string, f fs.FileInfo, err error) error {
+		check(err)
+		check(ctx.Err())
+		rel, err := filepath.Rel(zipFullPath, path)
+		check(err)
+		rel = filepath.Join(prefix, rel)
+		if rel != "." && (globMatchAny(excludes, filepath.ToSlash(rel)) || ig.match(path, f.IsDir())) || isInternal(path) {
+			if f.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil // excluded by request, by the folder or globally
+		}
+		if f.IsDir() {
+			return nil
+		}
+
+		if isHiddenPath(filepath.Join(base, rel)) {
+			return nil // hidden files not allowed
+		}
+		if f.Mode()&os.ModeSymlink != 0 {
+			if target := resolveLink(path); follow && target != "" && linkListed(target) {
+				return zipTree(ctx, base, target, rel, excludes, ig, false, emit)
+			}
+			panic(fmt.Errorf("%w, symlink not allowed in zip downloads", errInvalidPath)) // filepath.Walk doesnt support symlinks
+		}
+
+		header, err := zip.FileInfoHeader(f)
+		check(err)
+		header.Name = filepath.ToSlash(rel) // make the paths consistent between OSes
+		header.Method = zip.Store
+		if *zipCompress && !storedExts[strings.ToLower(filepath.Ext(path))] {
+			header.Method = zip.Deflate
+		}
+		j := &zipJob{header: header, path: path, inline: f.Size() <= zipInlineMax, done: make(chan struct{})}
+		if !emit(j) {
+			return filepath.SkipAll
+		}
+		return nil
+	})
+}
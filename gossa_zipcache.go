@@ -0,0 +1,57 @@
+package gossa
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// zipFingerprint hashes the layout of a folder, so any added, removed or modified file invalidates cached zips
+func zipFingerprint(zipFullPath string) string {
+	h := sha256.New()
+	err := walk(zipFullPath, func(path string, f fs.FileInfo, err error) error {
+		check(err)
+		fmt.Fprintf(h, "%s\x00%d\x00%d\x00%d\n", path, f.Size(), f.ModTime().UnixNano(), f.Mode())
+		return nil
+	})
+	check(err)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// serveCachedZip serves a zip from the cache directory, building it on first request.
+// Serving from a file on disk gives us Range and If-Range support for free.
+func serveCachedZip(w http.ResponseWriter, r *http.Request, zipFullPath string, excludes []string) {
+	key := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%t\x00%s\x00%t", zipFullPath, strings.Join(excludes, ","), *skipHidden, *hide, *zipCompress)))
+	prefix := filepath.Join(*zipCache, hex.EncodeToString(key[:16]))
+	fingerprint := zipFingerprint(zipFullPath)
+	cached := prefix + "-" + fingerprint[:32] + ".zip"
+
+	if _, err := os.Stat(cached); err != nil {
+		stale, _ := filepath.Glob(prefix + "-*.zip")
+		for _, el := range stale {
+			os.Remove(el)
+		}
+
+		check(os.MkdirAll(*zipCache, os.ModePerm))
+		tmp, err := os.CreateTemp(*zipCache, "building-*")
+		check(err)
+		defer os.Remove(tmp.Name()) // noop once renamed
+		defer tmp.Close()           // left open by an aborted build
+		writeZip(r.Context(), tmp, zipFullPath, excludes)
+		check(tmp.Close())
+		check(os.Rename(tmp.Name(), cached))
+	}
+
+	file, err := os.Open(cached)
+	check(err)
+	defer file.Close()
+	stat, err := file.Stat()
+	check(err)
+	w.Header().Set("ETag", `"`+fingerprint[:32]+`"`)
+	http.ServeContent(rateLimit(w), r, "archive.zip", stat.ModTime(), file)
+}
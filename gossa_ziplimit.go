@@ -0,0 +1,100 @@
+package gossa
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// caps how many zips and tars are built at once, sized by -zip-procs, nil when unlimited
+var archiveSlots chan struct{}
+
+func archiveLimitSetup() {
+	if *zipProcs > 0 {
+		archiveSlots = make(chan struct{}, *zipProcs)
+	}
+}
+
+// archiveSlot waits up to -zip-queue for an archive to be built, and returns
+// what frees the slot once done. Past the wait, it panics with errBusy
+func archiveSlot(w http.ResponseWriter, r *http.Request) func() {
+	if archiveSlots == nil {
+		return func() {}
+	}
+	select {
+	case archiveSlots <- struct{}{}:
+		return func() { <-archiveSlots }
+	default:
+	}
+
+	wait := time.NewTimer(*zipQueue)
+	defer wait.Stop()
+	select {
+	case archiveSlots <- struct{}{}:
+		return func() { <-archiveSlots }
+	case <-r.Context().Done():
+		panic(r.Context().Err())
+	case <-wait.C:
+		w.Header().Set("Retry-After", strconv.Itoa(max(1, int(*zipQueue/time.Second))))
+		panic(errBusy)
+	}
+}
+
+var errArchiveLimit = errors.New("folder too large to download")
+
+// archiveCaps counts what a zip or tar holds, against -zip-max-files, -zip-max-size and -zip-max-depth
+type archiveCaps struct{ files, bytes int64 }
+
+func archiveCapped() bool {
+	return *zipMaxFiles > 0 || *zipMaxSize > 0 || *zipMaxDepth > 0
+}
+
+// add counts an entry of an archive, named by its slash separated path, and
+// panics with errArchiveLimit once past a cap. Folders only count for depth
+func (c *archiveCaps) add(name string, size int64, dir bool) {
+	if !dir {
+		c.files++
+		c.bytes += size
+	}
+	switch {
+	case *zipMaxFiles > 0 && c.files > *zipMaxFiles:
+		panic(fmt.Errorf("%w, more than %d files", errArchiveLimit, *zipMaxFiles))
+	case *zipMaxSize > 0 && c.bytes > *zipMaxSize:
+		panic(fmt.Errorf("%w, more than %d bytes", errArchiveLimit, *zipMaxSize))
+	case *zipMaxDepth > 0 && strings.Count(strings.Trim(name, "/"), "/") > *zipMaxDepth:
+		panic(fmt.Errorf("%w, more than %d nested folders", errArchiveLimit, *zipMaxDepth))
+	}
+}
+
+// capZip walks what a zip would hold, before anything is sent, so the
+// folders past the caps are replied a 413 rather than a truncated zip
+func capZip(ctx context.Context, zipFullPath string, excludes []string) {
+	if !archiveCapped() {
+		return
+	}
+	caps := archiveCaps{}
+	check(zipTree(ctx, zipFullPath, zipFullPath, "", excludes, ignores{}, true, func(j *zipJob) bool {
+		caps.add(j.header.Name, int64(j.header.UncompressedSize64), false)
+		return true
+	}))
+}
+
+// capTar is capZip for tars
+func capTar(ctx context.Context, tarFullPath string, excludes []string, withLinks bool) {
+	if !archiveCapped() {
+		return
+	}
+	caps := archiveCaps{}
+	check(tarTree(ctx, tarFullPath, excludes, withLinks, func(path string, rel string, f fs.FileInfo, link string) {
+		size := int64(0)
+		if f.Mode().IsRegular() {
+			size = f.Size()
+		}
+		caps.add(rel, size, f.IsDir())
+	}))
+}
@@ -66,8 +66,7 @@ async function browseTo (href, flickerDone, skipHistory) {
         history.pushState({}, '', escaped)
       }
       pageTitle.innerText = title
-      pageH1.innerText = '.' + title
-      setTitle()
+      pageH1.innerHTML = parsed.body.querySelector('h1').innerHTML
     }
 
     init()
@@ -98,6 +97,10 @@ window.onClickLink = e => {
   } else if (!window.ro && isTextFile(a.innerText) && !isEditorMode()) {
     padOn(a)
     return false
+  // read markdown rendered when it can't be edited
+  } else if (isMarkdown(a.innerText)) {
+    window.location.href = a.href + '?render=1'
+    return false
   // toggle picture carousel
   } else if (isPic(a.href) && !isPicMode()) {
     picsOn(a.href)
@@ -106,6 +109,10 @@ window.onClickLink = e => {
   } else if (isVideo(a.href) && !isVideoMode()) {
     videoOn(a.href)
     return false
+  // browse into archives, ctrl + click still downloads them
+  } else if (isArchive(a.innerText)) {
+    browseTo(a.href + '/')
+    return false
   // let html be displayed naturally
   } else if (a.innerText.endsWith('.html')) {
     return true
@@ -147,20 +154,27 @@ function upload (id, what, path, cbDone, cbErr, cbUpdate) {
   xhr.send(what)
 }
 
-function rpc (call, args, cb) {
-  console.log('RPC', call, args)
+function rpcSend (payload, cb) {
   const xhr = new XMLHttpRequest()
   xhr.open('POST', location.origin + window.extraPath + '/rpc')
   xhr.setRequestHeader('Content-Type', 'application/json;charset=UTF-8')
-  xhr.send(JSON.stringify({ call, args }))
+  xhr.send(JSON.stringify(payload))
   xhr.onload = cb
   xhr.onerror = () => flicker(sadBadge)
 }
 
+function rpc (call, args, cb) {
+  console.log('RPC', call, args)
+  rpcSend({ call, args }, cb)
+}
+
+const batchCall = (calls, cb) => console.log('RPC batch', calls) || rpcSend(calls, cb)
+
 const mkdirCall = (path, cb) => rpc('mkdirp', [prependPath(path)], cb)
 const rmCall = (path1, cb) => rpc('rm', [prependPath(path1)], cb)
 const mvCall = (path1, path2, cb) => rpc('mv', [path1, path2], cb)
 const sumCall = (path, type, cb) => rpc('sum', [prependPath(path), type], cb)
+const undoCall = cb => rpc('undo', [], cb)
 
 // File upload
 let totalDone = 0
@@ -196,6 +210,9 @@ function updatePercent (ev) {
 
 function postFile (file, path) {
   if (window.ro) return
+  if (window.diskFree && totalUploadsSize + file.size > window.diskFree) {
+    return alert('not enough disk space left for ' + file.name)
+  }
   path = decodeURI(location.pathname).slice(0, -1) + path
   window.onbeforeunload = warningMsg
 
@@ -236,11 +253,9 @@ function pushEntry (entry) {
 }
 
 window.titleClick = function (e) {
-  const p = Array.from(document.querySelector('h1').childNodes).map(k => k.innerText)
-  const i = p.findIndex(s => s === e.target.innerText)
-  const dst = p.slice(0, i + 1).join('').slice(1)
-  const target = location.origin + window.extraPath + encodeURIHash(dst)
-  browseTo(target, false)
+  if (e.target.tagName !== 'A' || e.ctrlKey || e.metaKey) return true
+  browseTo(e.target.href, false)
+  return false
 }
 
 // Move files and folders
@@ -314,6 +329,8 @@ document.ondrop = e => {
 const isEditorMode = () => editor.style.display === 'block'
 const textTypes = ['.txt', '.rtf', '.md', '.markdown', '.log', '.yaml', '.yml']
 const isTextFile = src => src && textTypes.find(type => src.toLocaleLowerCase().includes(type))
+const isMarkdown = src => src && /\.(md|markdown)$/i.test(src)
+const isArchive = src => src && /\.(zip|tar|tar\.gz|tgz)$/i.test(src)
 let fileEdited
 
 function saveText (quitting) {
@@ -667,8 +684,9 @@ function getSum (type) {
   upBarPc.style.width = '100%'
   sumsOff()
   sumCall(getASelected().innerText, type, loaded => {
-    navigator.clipboard.writeText(loaded.target.responseText)
     upBarPc.style.display = 'none'
+    if (loaded.target.status !== 200) return flicker(sadBadge)
+    navigator.clipboard.writeText(loaded.target.responseText)
     flicker(okBadge)
   })
 }
@@ -699,11 +717,10 @@ const cuts = []
 function onPaste () {
   if (!cuts.length) { return refresh() }
   const a = getASelected()
-  const root = cuts.pop()
-  const filename = root.split('/').pop()
   const pwd = decodeURIComponent(location.pathname)
   const dest = isFolder(a) ? pwd + a.innerHTML : pwd
-  mvCall(root, dest + filename, onPaste)
+  const calls = cuts.splice(0).map(root => ({ call: 'mv', args: [root, dest + root.split('/').pop()] }))
+  batchCall(calls, refresh)
 }
 
 function onCut () {
@@ -833,6 +850,9 @@ document.body.addEventListener('keydown', e => {
           case 'Digit3':
             return prevent(e) || isRo() || getSum('sha512')
 
+          case 'Digit4':
+            return prevent(e) || isRo() || getSum('blake3')
+
           case 'Digit5':
             return prevent(e) || isRo() || getSum('md5')
         }
@@ -847,6 +867,10 @@ document.body.addEventListener('keydown', e => {
       if (e.code == 'KeyU') {
           return prevent(e) || isRo() || manualUpload.click()
       }
+
+      if (e.code == 'KeyZ' && (e.ctrlKey || e.metaKey)) {
+          return prevent(e) || isRo() || undoCall(refresh)
+      }
   }
 
   switch (e.code) {
@@ -884,8 +908,36 @@ document.body.addEventListener('keydown', e => {
   }
 }, false)
 
-function setTitle () {
-  pageH1.innerHTML = '<span>' + pageH1.innerText.split('/').join('/</span><span>') + '</span>'
+// Folder sizes
+async function fillFolderSizes () {
+  if (!window.folderSizes) return
+  try {
+    const r = await fetch(location.pathname + '?du', { credentials: 'include' })
+    const sizes = await r.json()
+    sizes.filter(s => s.isDir).forEach(s => {
+      const a = allA.find(a => a.innerText === s.name + '/')
+      if (a) a.parentElement.parentElement.querySelector('.file-size code').innerText = s.human
+    })
+  } catch (error) {
+    console.log('cant fetch folder sizes', error)
+  }
+}
+
+// Live updates of the folder viewed
+let liveSource
+let liveToken
+function liveFollow () {
+  if (!window.live) return
+  const path = decodeURI(location.pathname)
+  if (liveSource && liveSource.path === path) return
+  if (liveSource) liveSource.close()
+  liveSource = new EventSource(window.extraPath + '/events?path=' + encodeURIComponent(path), { withCredentials: true })
+  liveSource.path = path
+  const changed = () => {
+    clearTimeout(liveToken)
+    liveToken = setTimeout(() => isEditorMode() || browseTo(location.href), 300)
+  }
+  ['created', 'modified', 'deleted'].forEach(t => liveSource.addEventListener(t, changed))
 }
 
 function init () {
@@ -899,8 +951,9 @@ function init () {
     entries.length === 1 ? entries[0].classList.add('arrow-selected') : entries[1].classList.add('arrow-selected')
   }
 
-  setTitle()
   scrollToArrow()
+  fillFolderSizes()
+  liveFollow()
   console.log('browsed to ' + location.href)
 
   if (cuts.length) {